@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const defaultDDoSDuration = time.Hour
+
+// Admin endpoints require "Authorization: Bearer <key>" header with adminKey or key of operator
+// with one of roles, admin role is always allowed. Separate admin listener without key relies
+// on verified client certificates.
+func (s *ApiServer) adminAuth(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		o := s.authenticate(r)
+		if o == nil {
+			log.Printf("Unauthorized admin API request from %v to %v", r.RemoteAddr, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !o.allowed(roles) {
+			s.audit(o, r, http.StatusForbidden)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, withOperator(r, o))
+		s.audit(o, r, rec.status)
+	}
+}
+
+type ddosModeRequest struct {
+	Enabled bool `json:"enabled"`
+	// Mode expires automatically after this duration, one hour by default
+	Duration string `json:"duration"`
+}
+
+func (s *ApiServer) DDoSModeIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if r.Method == "POST" {
+		var req ddosModeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := s.setDDoSMode(requestOperator(r), &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	ttl, err := s.backend.GetDDoSMode()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get DDoS mode from backend: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	reply := map[string]interface{}{
+		"enabled":   ttl > 0,
+		"expiresIn": int64(ttl / time.Second),
+		"now":       util.MakeTimestamp(),
+	}
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+func (s *ApiServer) setDDoSMode(o *Operator, req *ddosModeRequest) error {
+	if !req.Enabled {
+		log.Printf("DDoS mode disabled by %v", o.Name)
+		return s.backend.ClearDDoSMode()
+	}
+	duration := defaultDDoSDuration
+	if len(req.Duration) > 0 {
+		var err error
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil {
+			return err
+		}
+	}
+	if duration < time.Second {
+		duration = time.Second
+	}
+	log.Printf("DDoS mode enabled by %v for %v", o.Name, duration)
+	return s.backend.SetDDoSMode(duration)
+}
+
+// Payments to contracts held for review until they are added to payouts.contracts.allow
+func (s *ApiServer) HeldPaymentsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	held, err := s.storage.GetHeldPayments()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get held payments from backend: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(held)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+// Discrepancies found by the last payment verification, null if it never ran
+func (s *ApiServer) PaymentsReportIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	report, err := s.storage.GetPaymentsReport()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get payments report from backend: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(report)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
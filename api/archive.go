@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Max size of archive accepted for import
+const maxArchiveSize = 256 << 20
+
+// Exports (GET) or imports (POST) balances, payments and blocks of pool or tenant given with tenant param.
+// Export is JSON, or one section of it as CSV with format=csv&section=accounts|payments|blocks.
+func (s *ApiServer) ArchiveIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	store := s.storage
+	if tenant := r.URL.Query().Get("tenant"); len(tenant) > 0 {
+		store = store.ForTenant(tenant)
+	}
+
+	if r.Method == "POST" {
+		var a storage.Archive
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxArchiveSize)).Decode(&a); err != nil {
+			http.Error(w, "Malformed archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		control, err := s.backend.GetPayoutsControl()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Failed to get payouts control from backend: %v", err)
+			return
+		}
+		result, err := storage.ImportPaused(store, control, &a)
+		if err != nil {
+			log.Printf("Archive import by admin failed: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Imported %v accounts, %v payments and %v blocks by admin", result.Accounts, result.Payments, result.Blocks)
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Println("Error serializing API response: ", err)
+		}
+		return
+	}
+
+	csv := r.URL.Query().Get("format") == "csv"
+	section := r.URL.Query().Get("section")
+	if csv && section != "accounts" && section != "payments" && section != "blocks" {
+		http.Error(w, "Section must be accounts, payments or blocks", http.StatusBadRequest)
+		return
+	}
+	a, err := store.ExportArchive()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to export archive from backend: %v", err)
+		return
+	}
+	if csv {
+		w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+section+".csv\"")
+		if err := a.WriteCSV(w, section); err != nil {
+			log.Println("Error writing CSV archive: ", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"archive.json\"")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
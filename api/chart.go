@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Hourly hashrate of account for charts, range is given with from and to unix timestamps
+func (s *ApiServer) ChartIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	now := time.Now().Unix()
+	to := parseUnix(r.URL.Query().Get("to"), now)
+	from := parseUnix(r.URL.Query().Get("from"), to-int64(defaultExportRange/time.Second))
+	if from > to || to-from > int64(maxExportRange/time.Second) {
+		http.Error(w, "Range must be positive and at most "+maxExportRange.String(), http.StatusBadRequest)
+		return
+	}
+
+	points, err := s.backend.GetHashrateChart(login, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch hashrate chart from backend: %v", err)
+		return
+	}
+
+	if points == nil {
+		points = []*storage.ChartPoint{}
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"login": login, "chart": points})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+// Daily credited difficulty of account for lifetime contribution graph, whole retention by default
+func (s *ApiServer) LifetimeIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	retention := int64(storage.DailyHistoryRetention / time.Second)
+	now := time.Now().Unix()
+	to := parseUnix(r.URL.Query().Get("to"), now)
+	from := parseUnix(r.URL.Query().Get("from"), to-retention)
+	if from > to || to-from > retention {
+		http.Error(w, "Range must be positive and at most "+storage.DailyHistoryRetention.String(), http.StatusBadRequest)
+		return
+	}
+
+	days, err := s.storage.GetDailyDifficulty(login, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch daily difficulty from backend: %v", err)
+		return
+	}
+
+	var total int64
+	for _, day := range days {
+		total += day.Difficulty
+	}
+	if days == nil {
+		days = []*storage.DailyDifficulty{}
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"login": login, "days": days, "totalDifficulty": total})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultExportRange = 24 * time.Hour
+	maxExportRange     = 31 * 24 * time.Hour
+)
+
+func tokenDigest(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+// Issues (POST) or revokes (DELETE) export token of account, only digest is stored
+func (s *ApiServer) ExportTokenIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == "DELETE" {
+		if err := s.backend.DeleteExportToken(login); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Failed to delete export token: %v", err)
+			return
+		}
+		log.Printf("Export token of %v revoked by admin", login)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(buf)
+	if err := s.backend.SetExportToken(login, tokenDigest(token)); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to store export token: %v", err)
+		return
+	}
+	log.Printf("Export token of %v issued by admin", login)
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(map[string]string{"login": login, "token": token})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+// Accepts account export token or key of operator in one of roles
+func (s *ApiServer) exportAllowed(r *http.Request, login string, roles ...string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 {
+		return false
+	}
+	if s.operatorAllowed(r, roles...) {
+		return true
+	}
+	digest, err := s.backend.GetExportToken(login)
+	if err != nil {
+		log.Printf("Failed to get export token from backend: %v", err)
+		return false
+	}
+	return len(digest) > 0 && util.SecureCompare(tokenDigest(token), digest)
+}
+
+// Hourly per worker shares and hashrate as CSV, range is given with from and to unix timestamps
+func (s *ApiServer) WorkersHistoryIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !s.exportAllowed(r, login, RoleViewer, RoleSupport, RoleFinance) {
+		log.Printf("Unauthorized export request from %v for %v", r.RemoteAddr, login)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now().Unix()
+	to := parseUnix(r.URL.Query().Get("to"), now)
+	from := parseUnix(r.URL.Query().Get("from"), to-int64(defaultExportRange/time.Second))
+	if from > to || to-from > int64(maxExportRange/time.Second) {
+		http.Error(w, "Range must be positive and at most "+maxExportRange.String(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := s.backend.GetWorkerHistory(login, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch worker history from backend: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=UTF-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+login+".csv\"")
+	w.WriteHeader(http.StatusOK)
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "worker", "shares", "difficulty", "hashrate"})
+	for _, row := range rows {
+		out.Write([]string{
+			time.Unix(row.Hour, 0).UTC().Format(time.RFC3339),
+			csvSafe(row.Worker),
+			strconv.FormatInt(row.Shares, 10),
+			strconv.FormatInt(row.Difficulty, 10),
+			strconv.FormatInt(row.Hashrate(), 10),
+		})
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		log.Println("Error writing CSV export: ", err)
+	}
+}
+
+// Worker names are user input, keep spreadsheets from treating them as formulas
+func csvSafe(s string) string {
+	if len(s) > 0 && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func parseUnix(s string, fallback int64) int64 {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	return fallback
+}
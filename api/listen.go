@@ -0,0 +1,111 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// Server certificate of listener, clients must present certificate signed by
+// ClientCAFile if it is set
+type ListenerTLS struct {
+	CertFile     string `json:"certFile"`
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCaFile"`
+}
+
+// Separate listener of /api/admin/ endpoints, they are not routed on public listener then
+type AdminConfig struct {
+	Listen string `json:"listen"`
+	// Overrides adminKey, may be blank if client certificates are required
+	Key string      `json:"key"`
+	TLS ListenerTLS `json:"tls"`
+	// Accounts with roles scoping endpoints they may use, on public listener too
+	Operators []Operator `json:"operators"`
+}
+
+func (c *ListenerTLS) enabled() bool {
+	return len(c.CertFile) > 0
+}
+
+func (c *ListenerTLS) load() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(c.ClientCAFile) > 0 {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.ClientCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+func serve(name, addr string, handler http.Handler, cfg *ListenerTLS) {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	var err error
+	if cfg.enabled() {
+		srv.TLSConfig, err = cfg.load()
+		if err != nil {
+			log.Fatalf("Invalid TLS config of %v: %v", name, err)
+		}
+		log.Printf("Starting %v on %v with TLS", name, addr)
+		err = srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+	} else {
+		log.Printf("Starting %v on %v", name, addr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		log.Fatalf("Failed to start %v: %v", name, err)
+	}
+}
+
+func (s *ApiServer) adminKey() string {
+	if s.separateAdmin() && len(s.config.Admin.Key) > 0 {
+		return s.config.Admin.Key
+	}
+	return s.config.AdminKey
+}
+
+// Admin endpoints are served either on separate listener or on public one with adminKey
+func (s *ApiServer) separateAdmin() bool {
+	return len(s.config.Admin.Listen) > 0
+}
+
+func (s *ApiServer) adminRouter() *mux.Router {
+	r := mux.NewRouter()
+	s.adminRoutes(r)
+	r.NotFoundHandler = http.HandlerFunc(notFound)
+	r.Use(s.limitBody)
+	return r
+}
+
+// Admin API is on public listener with adminKey or operators
+func (s *ApiServer) publicAdmin() bool {
+	return !s.separateAdmin() && (len(s.config.AdminKey) > 0 || len(s.config.Admin.Operators) > 0)
+}
+
+// Roles besides admin allowed to use each endpoint
+func (s *ApiServer) adminRoutes(r *mux.Router) {
+	r.HandleFunc("/api/admin/ddos", s.adminAuth(s.DDoSModeIndex, RoleViewer, RoleSupport, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/ddos", s.adminAuth(s.DDoSModeIndex, RoleSupport)).Methods("POST")
+	r.HandleFunc("/api/admin/exports/{login:0x[0-9a-fA-F]{40}}", s.adminAuth(s.ExportTokenIndex, RoleSupport)).Methods("POST", "DELETE")
+	r.HandleFunc("/api/admin/tail/{login:0x[0-9a-fA-F]{40}}", s.adminAuth(s.TailIndex, RoleViewer, RoleSupport)).Methods("GET")
+	r.HandleFunc("/api/admin/upstreams", s.adminAuth(s.UpstreamsIndex, RoleViewer, RoleSupport, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/payments/report", s.adminAuth(s.PaymentsReportIndex, RoleViewer, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/payments/held", s.adminAuth(s.HeldPaymentsIndex, RoleViewer, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/payouts", s.adminAuth(s.PayoutsControlIndex, RoleViewer, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/payouts", s.adminAuth(s.PayoutsControlIndex, RoleFinance)).Methods("POST")
+	r.HandleFunc("/api/admin/blockraces", s.adminAuth(s.BlockRacesIndex, RoleViewer, RoleSupport, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/archive", s.adminAuth(s.ArchiveIndex, RoleFinance)).Methods("GET")
+	r.HandleFunc("/api/admin/archive", s.adminAuth(s.ArchiveIndex)).Methods("POST")
+	r.HandleFunc("/api/admin/audit", s.adminAuth(s.AuditIndex)).Methods("GET")
+}
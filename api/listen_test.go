@@ -0,0 +1,78 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestAdminListener(t *testing.T) {
+	s := &ApiServer{config: &ApiConfig{AdminKey: "public", Admin: AdminConfig{Listen: "127.0.0.1:8081", Key: "secret"}}}
+
+	w := httptest.NewRecorder()
+	s.router(true).ServeHTTP(w, httptest.NewRequest("GET", "/api/admin/upstreams", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Must not route admin endpoints on public listener, got %v", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/upstreams", nil)
+	req.Header.Set("Authorization", "Bearer public")
+	w = httptest.NewRecorder()
+	s.adminRouter().ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Must require admin listener key, got %v", w.Code)
+	}
+
+	s.config.Admin.Listen = ""
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	s.router(true).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Must serve admin endpoints on public listener with adminKey only, got %v", w.Code)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	s := &ApiServer{config: &ApiConfig{AdminKey: "shared", Admin: AdminConfig{Operators: []Operator{
+		{Name: "alice", Key: "viewer-key", Role: RoleViewer},
+		{Name: "bob", Key: "support-key", Role: RoleSupport},
+	}}}}
+	var operator *Operator
+	handler := s.adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		operator = requestOperator(r)
+	}, RoleSupport)
+
+	for key, code := range map[string]int{"viewer-key": http.StatusForbidden, "support-key": http.StatusOK,
+		"shared": http.StatusOK, "other": http.StatusUnauthorized} {
+		req := httptest.NewRequest("POST", "/api/admin/ddos", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		operator = nil
+		handler(w, req)
+		if w.Code != code {
+			t.Errorf("Must reply %v to key %v, got %v", code, key, w.Code)
+		}
+		if code == http.StatusOK && operator == nil {
+			t.Errorf("Must pass operator of key %v to handler", key)
+		}
+	}
+	if !s.publicAdmin() {
+		t.Error("Must serve admin endpoints on public listener with operators")
+	}
+
+	for key, allowed := range map[string]bool{"viewer-key": false, "support-key": true, "shared": true, "other": false} {
+		req := httptest.NewRequest("POST", "/api/accounts/0x0000000000000000000000000000000000000001/settings", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if s.operatorAllowed(req, RoleSupport) != allowed {
+			t.Errorf("Must override account authorization only for operator in role, got %v for key %v", !allowed, key)
+		}
+	}
+
+	var errs util.ConfigErrors
+	validateOperators([]Operator{{Name: "a", Key: "k", Role: RoleAdmin}, {Name: "a", Key: "k", Role: "root"}}, &errs)
+	if len(errs) != 3 {
+		t.Errorf("Must reject duplicate name, shared key and unknown role, got %v", errs)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Pool description for frontends and aggregator sites
+type Meta struct {
+	Name        string `json:"name"`
+	Coin        string `json:"coin"`
+	Description string `json:"description"`
+	Website     string `json:"website"`
+	Logo        string `json:"logo"`
+	// Frontend colors and other theme settings
+	Theme map[string]string `json:"theme"`
+	// Pool fee in percent and minimum payout in Shannon, taken from unlocker and payouts if zero,
+	// payout scheme is taken from rewards
+	Fee          float64           `json:"fee"`
+	MinPayout    int64             `json:"minPayout"`
+	PayoutScheme string            `json:"payoutScheme"`
+	Servers      []MetaServer      `json:"servers"`
+	Social       map[string]string `json:"social"`
+	// Window of PPLNS rewards
+	PPLNS *storage.PPLNSWindow `json:"pplns,omitempty"`
+}
+
+type MetaServer struct {
+	Region string     `json:"region"`
+	Host   string     `json:"host"`
+	Ports  []MetaPort `json:"ports"`
+}
+
+type MetaPort struct {
+	Port       int    `json:"port"`
+	Difficulty int64  `json:"difficulty"`
+	Protocol   string `json:"protocol"`
+	TLS        bool   `json:"tls"`
+}
+
+// Rewards are split in proportion to round shares
+const defaultPayoutScheme = "PROP"
+
+func (s *ApiServer) MetaIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=600")
+	w.WriteHeader(http.StatusOK)
+
+	meta := s.meta
+	if len(meta.PayoutScheme) == 0 {
+		meta.PayoutScheme = defaultPayoutScheme
+	}
+	err := json.NewEncoder(w).Encode(&meta)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
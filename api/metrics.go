@@ -0,0 +1,31 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/etclabscore/open-etc-pool/metrics"
+)
+
+// Run metrics of unlocker and payouts stored in redis, in Prometheus text format
+func (s *ApiServer) MetricsIndex(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.storage.GetJobMetrics()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch job metrics from backend: %v", err)
+		return
+	}
+	names := make([]string, 0, len(jobs))
+	for job := range jobs {
+		names = append(names, job)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, job := range names {
+		metrics.Write(w, job, jobs[job])
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Roles of admin API operators, admin may use every endpoint
+const (
+	RoleViewer  = "viewer"
+	RoleSupport = "support"
+	RoleFinance = "finance"
+	RoleAdmin   = "admin"
+)
+
+const defaultAuditLimit = 100
+
+// Admin API account, authenticated by Bearer key or by CN of client certificate matching name
+type Operator struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	Role string `json:"role"`
+}
+
+func validateOperators(operators []Operator, errs *util.ConfigErrors) {
+	names := make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, o := range operators {
+		field := fmt.Sprintf("api.admin.operators[%v]", i)
+		if len(o.Name) == 0 {
+			errs.Addf("%s.name: required", field)
+		} else if names[o.Name] {
+			errs.Addf("%s.name: duplicate operator %q", field, o.Name)
+		}
+		if len(o.Key) > 0 && keys[o.Key] {
+			errs.Addf("%s.key: shared with another operator", field)
+		}
+		switch o.Role {
+		case RoleViewer, RoleSupport, RoleFinance, RoleAdmin:
+		default:
+			errs.Addf("%s.role: unknown role %q", field, o.Role)
+		}
+		names[o.Name] = true
+		keys[o.Key] = true
+	}
+}
+
+type operatorKey struct{}
+
+// Operator of admin request, set by adminAuth
+func requestOperator(r *http.Request) *Operator {
+	o, _ := r.Context().Value(operatorKey{}).(*Operator)
+	if o == nil {
+		return &Operator{Name: "admin", Role: RoleAdmin}
+	}
+	return o
+}
+
+// Operator presenting key or verified client certificate, nil if request is not authenticated.
+// Shared admin key acts as operator "admin" with admin role.
+func (s *ApiServer) authenticate(r *http.Request) *Operator {
+	if key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); len(key) > 0 {
+		for i := range s.config.Admin.Operators {
+			if o := &s.config.Admin.Operators[i]; len(o.Key) > 0 && util.SecureCompare(key, o.Key) {
+				return o
+			}
+		}
+		if adminKey := s.adminKey(); len(adminKey) > 0 && util.SecureCompare(key, adminKey) {
+			return &Operator{Name: "admin", Role: RoleAdmin}
+		}
+		return nil
+	}
+	// Certificate was verified by listener already
+	if !s.separateAdmin() || len(s.adminKey()) > 0 || len(s.config.Admin.TLS.ClientCAFile) == 0 {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	name := r.TLS.PeerCertificates[0].Subject.CommonName
+	for i := range s.config.Admin.Operators {
+		if o := &s.config.Admin.Operators[i]; o.Name == name {
+			return o
+		}
+	}
+	// Without operators every trusted certificate has full access
+	if len(s.config.Admin.Operators) == 0 {
+		return &Operator{Name: name, Role: RoleAdmin}
+	}
+	return nil
+}
+
+func (o *Operator) allowed(roles []string) bool {
+	return o.Role == RoleAdmin || util.StringInSlice(o.Role, roles)
+}
+
+// Operator key in one of roles overrides account authorization of public endpoints, use of it is audited
+func (s *ApiServer) operatorAllowed(r *http.Request, roles ...string) bool {
+	o := s.authenticate(r)
+	if o == nil {
+		return false
+	}
+	if !o.allowed(roles) {
+		s.audit(o, r, http.StatusForbidden)
+		return false
+	}
+	s.audit(o, r, http.StatusOK)
+	return true
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Tail streams or upgrades to WebSocket through recorder
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection can't be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+// Logs request with acting operator and keeps it in audit trail
+func (s *ApiServer) audit(o *Operator, r *http.Request, status int) {
+	log.Printf("Admin API %v %v by %v (%v) from %v: %v", r.Method, r.URL.Path, o.Name, o.Role, r.RemoteAddr, status)
+	if s.backend == nil {
+		return
+	}
+	a := &storage.AdminAction{Timestamp: util.MakeTimestamp(), Operator: o.Name, Role: o.Role, Method: r.Method,
+		Path: r.URL.Path, Remote: r.RemoteAddr, Status: status}
+	if err := s.backend.WriteAdminAction(a); err != nil {
+		log.Printf("Failed to write admin audit trail: %v", err)
+	}
+}
+
+// Admin API requests, newest first
+func (s *ApiServer) AuditIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	limit := int64(defaultAuditLimit)
+	if v, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && v > 0 {
+		limit = v
+	}
+	actions, err := s.backend.GetAdminActions(limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get admin audit trail from backend: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(actions)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+func withOperator(r *http.Request, o *Operator) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), operatorKey{}, o))
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+type payoutsRequest struct {
+	Action string `json:"action"`
+}
+
+// Pause state and last manual run request of payouts. POST pauses, resumes or requests
+// immediate run, payouts processors pick it up within 10 seconds.
+func (s *ApiServer) PayoutsControlIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if r.Method == "POST" {
+		var req payoutsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		operator := requestOperator(r).Name
+		log.Printf("Payouts %v by %v", req.Action, operator)
+		if err := s.backend.ControlPayouts(req.Action, operator); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	control, err := s.backend.GetPayoutsControl()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get payouts control from backend: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(control)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
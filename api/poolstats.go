@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Flat schema polled by pool aggregation sites
+type poolStats struct {
+	Name          string         `json:"pool_name"`
+	Coin          string         `json:"coin"`
+	Hashrate      int64          `json:"hashrate"`
+	Miners        int            `json:"miners"`
+	Workers       int            `json:"workers"`
+	Fee           float64        `json:"fee"`
+	MinimumPayout float64        `json:"minimum_payout"`
+	PayoutScheme  string         `json:"payout_scheme"`
+	BlocksFound   int64          `json:"blocks_found"`
+	LastBlock     *poolLastBlock `json:"last_block"`
+	Height        int64          `json:"network_height"`
+	Difficulty    int64          `json:"network_difficulty"`
+	Updated       int64          `json:"updated"`
+}
+
+type poolLastBlock struct {
+	Height    int64  `json:"height"`
+	Hash      string `json:"hash,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (s *ApiServer) PoolStatsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	stats := s.getStats()
+	if stats == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	reply := &poolStats{
+		Name:          s.meta.Name,
+		Coin:          s.meta.Coin,
+		Hashrate:      stats["hashrate"].(int64),
+		Miners:        stats["minersTotal"].(int),
+		Workers:       stats["workersTotal"].(int),
+		Fee:           s.meta.Fee,
+		MinimumPayout: float64(s.meta.MinPayout) / 1e9,
+		PayoutScheme:  s.meta.PayoutScheme,
+		BlocksFound:   stats["candidatesTotal"].(int64) + stats["immatureTotal"].(int64) + stats["maturedTotal"].(int64),
+		Updated:       util.MakeTimestamp() / 1000,
+	}
+	if len(reply.PayoutScheme) == 0 {
+		reply.PayoutScheme = defaultPayoutScheme
+	}
+	for _, key := range []string{"candidates", "immature", "matured"} {
+		blocks, _ := stats[key].([]*storage.BlockData)
+		if len(blocks) > 0 && (reply.LastBlock == nil || blocks[0].Height > reply.LastBlock.Height) {
+			reply.LastBlock = &poolLastBlock{Height: blocks[0].Height, Hash: blocks[0].Hash, Timestamp: blocks[0].Timestamp}
+		}
+	}
+
+	nodes, err := s.backend.GetNodeStates()
+	if err != nil {
+		log.Printf("Failed to get nodes stats from backend: %v", err)
+	}
+	for _, node := range nodes {
+		height, _ := strconv.ParseInt(fmt.Sprint(node["height"]), 10, 64)
+		if height > reply.Height {
+			reply.Height = height
+			reply.Difficulty, _ = strconv.ParseInt(fmt.Sprint(node["difficulty"]), 10, 64)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+const defaultBlockRaces = 100
+
+// Timings of rejected block solutions with share of stale ones and average delays
+func (s *ApiServer) BlockRacesIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	limit, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64)
+	if err != nil || limit <= 0 {
+		limit = defaultBlockRaces
+	}
+	races, err := s.backend.GetBlockRaces(limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch block races from backend: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"races": races, "summary": summarizeRaces(races)})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+func summarizeRaces(races []*storage.BlockRace) map[string]interface{} {
+	var stale int
+	var templateAge, verifyDelay, submitLatency float64
+	for _, race := range races {
+		if race.Stale {
+			stale++
+		}
+		templateAge += race.TemplateAge
+		verifyDelay += race.VerifyDelay
+		// Fastest answer, block had to reach at least one node in time
+		fastest := 0.0
+		for i, u := range race.Upstreams {
+			if i == 0 || u.Latency < fastest {
+				fastest = u.Latency
+			}
+		}
+		submitLatency += fastest
+	}
+	summary := map[string]interface{}{"total": len(races), "stale": stale}
+	if n := float64(len(races)); n > 0 {
+		summary["avgTemplateAge"] = templateAge / n
+		summary["avgVerifyDelay"] = verifyDelay / n
+		summary["avgSubmitLatency"] = submitLatency / n
+	}
+	return summary
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"log"
+	"strings"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Block explorer URL templates, {tx}, {address} and {block} are replaced with hashes
+type Explorer struct {
+	Tx      string `json:"tx"`
+	Address string `json:"address"`
+	Block   string `json:"block"`
+}
+
+// Blockscout of known networks, blank for others
+func DefaultExplorer(network string) Explorer {
+	var base string
+	switch network {
+	case "classic":
+		base = "https://etc.blockscout.com"
+	case "mordor":
+		base = "https://etc-mordor.blockscout.com"
+	default:
+		return Explorer{}
+	}
+	return Explorer{Tx: base + "/tx/{tx}", Address: base + "/address/{address}", Block: base + "/block/{block}"}
+}
+
+func (e *Explorer) link(template, key, value string) string {
+	if len(template) == 0 || len(value) == 0 {
+		return ""
+	}
+	return strings.Replace(template, key, value, -1)
+}
+
+func (e *Explorer) TxUrl(hash string) string {
+	return e.link(e.Tx, "{tx}", hash)
+}
+
+func (e *Explorer) AddressUrl(address string) string {
+	return e.link(e.Address, "{address}", address)
+}
+
+func (e *Explorer) BlockUrl(hash string) string {
+	return e.link(e.Block, "{block}", hash)
+}
+
+// Adds explorer links and mined receipt, if there is one, to payments rows
+func (s *ApiServer) withReceipts(payments interface{}) {
+	rows, ok := payments.([]map[string]interface{})
+	if !ok || len(rows) == 0 {
+		return
+	}
+	hashes := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if tx, ok := row["tx"].(string); ok {
+			hashes = append(hashes, tx)
+		}
+	}
+	receipts, err := s.storage.GetPaymentReceipts(hashes)
+	if err != nil {
+		log.Printf("Failed to fetch payment receipts from backend: %v", err)
+	}
+	explorer := &s.config.Explorer
+	for _, row := range rows {
+		tx, _ := row["tx"].(string)
+		if url := explorer.TxUrl(tx); len(url) > 0 {
+			row["url"] = url
+		}
+		if address, ok := row["address"].(string); ok {
+			if url := explorer.AddressUrl(address); len(url) > 0 {
+				row["addressUrl"] = url
+			}
+		}
+		if receipt, ok := receipts[tx]; ok {
+			row["gasUsed"] = receipt.GasUsed
+			row["gasPrice"] = receipt.GasPrice
+			row["fee"] = receipt.Fee
+			row["success"] = receipt.Success
+			if url := explorer.BlockUrl(receipt.BlockHash); len(url) > 0 {
+				row["blockUrl"] = url
+			}
+		}
+	}
+}
+
+// Link of transaction in notification sent to watcher
+func (s *ApiServer) withExplorerUrl(n *storage.WatchNotification) {
+	if len(n.TxHash) > 0 {
+		n.Url = s.config.Explorer.TxUrl(n.TxHash)
+	}
+}
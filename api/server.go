@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -27,17 +29,68 @@ type ApiConfig struct {
 	Blocks               int64  `json:"blocks"`
 	PurgeOnly            bool   `json:"purgeOnly"`
 	PurgeInterval        string `json:"purgeInterval"`
+	// Node heartbeats history kept for uptime stats
+	UptimeWindow string `json:"uptimeWindow"`
+	// Default request body limit and overrides by path prefix, longest prefix wins
+	LimitBodySize util.Size            `json:"limitBodySize"`
+	BodyLimits    map[string]util.Size `json:"bodyLimits"`
+	// Enables /api/admin/ endpoints protected by this key
+	AdminKey string `json:"adminKey"`
+	// TLS of public listener
+	TLS   ListenerTLS `json:"tls"`
+	Admin AdminConfig `json:"admin"`
+	// Served on /api/meta
+	Meta Meta `json:"meta"`
+	// Webhooks of addresses registered on /api/accounts/{login}/watch
+	Watch WatchConfig `json:"watch"`
+	// Links of payments, blockscout of classic and mordor if not set
+	Explorer Explorer `json:"explorer"`
+}
+
+const defaultLimitBodySize = 64 * 1024
+
+func (c *ApiConfig) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	errs.Duration("api.statsCollectInterval", c.StatsCollectInterval, false)
+	errs.Duration("api.hashrateWindow", c.HashrateWindow, false)
+	errs.Duration("api.hashrateLargeWindow", c.HashrateLargeWindow, false)
+	errs.Duration("api.purgeInterval", c.PurgeInterval, false)
+	errs.Duration("api.uptimeWindow", c.UptimeWindow, true)
+	if c.Watch.Enabled {
+		errs.Duration("api.watch.timeout", c.Watch.Timeout, false)
+	}
+	validateOperators(c.Admin.Operators, errs)
+	if len(c.Admin.Listen) > 0 {
+		if c.Admin.Listen == c.Listen {
+			errs.Addf("api.admin.listen: must differ from api.listen")
+		}
+		if len(c.Admin.Key) == 0 && len(c.AdminKey) == 0 && len(c.Admin.TLS.ClientCAFile) == 0 && len(c.Admin.Operators) == 0 {
+			errs.Addf("api.admin: key, operators or tls.clientCaFile is required")
+		}
+		if len(c.Admin.TLS.ClientCAFile) > 0 && !c.Admin.TLS.enabled() {
+			errs.Addf("api.admin.tls: clientCaFile requires certFile and keyFile")
+		}
+	}
 }
 
 type ApiServer struct {
 	config              *ApiConfig
 	backend             *storage.RedisClient
+	storage             storage.Storage
 	hashrateWindow      time.Duration
 	hashrateLargeWindow time.Duration
 	stats               atomic.Value
 	miners              map[string]*Entry
 	minersMu            sync.RWMutex
 	statsIntv           time.Duration
+	uptimeWindow        time.Duration
+	uptime              *Entry
+	uptimeMu            sync.Mutex
+	// Servers of tenant pools by frontend domain
+	tenants map[string]*ApiServer
+	meta    Meta
 }
 
 type Entry struct {
@@ -45,25 +98,60 @@ type Entry struct {
 	updatedAt int64
 }
 
-func NewApiServer(cfg *ApiConfig, backend *storage.RedisClient) *ApiServer {
+// Stats are read from accounting storage, which is redis backend itself unless configured otherwise
+func NewApiServer(cfg *ApiConfig, backend *storage.RedisClient, store storage.Storage) *ApiServer {
 	hashrateWindow := util.MustParseDuration(cfg.HashrateWindow)
 	hashrateLargeWindow := util.MustParseDuration(cfg.HashrateLargeWindow)
-	return &ApiServer{
+	s := &ApiServer{
 		config:              cfg,
 		backend:             backend,
+		storage:             store,
 		hashrateWindow:      hashrateWindow,
 		hashrateLargeWindow: hashrateLargeWindow,
 		miners:              make(map[string]*Entry),
+		meta:                cfg.Meta,
+	}
+	if len(cfg.UptimeWindow) > 0 {
+		s.uptimeWindow = util.MustParseDuration(cfg.UptimeWindow)
+	}
+	return s
+}
+
+// Serves stats and meta of tenant backend to requests with this Host
+func (s *ApiServer) AddTenant(domain string, backend *storage.RedisClient, meta Meta) {
+	if s.tenants == nil {
+		s.tenants = make(map[string]*ApiServer)
 	}
+	t := NewApiServer(s.config, backend, s.storage.ForTenant(backend.Tenant()))
+	t.meta = meta
+	s.tenants[strings.ToLower(domain)] = t
 }
 
 func (s *ApiServer) Start() {
 	if s.config.PurgeOnly {
 		log.Printf("Starting API in purge-only mode")
-	} else {
-		log.Printf("Starting API on %v", s.config.Listen)
 	}
 
+	for domain, t := range s.tenants {
+		log.Printf("Collecting stats for %v", domain)
+		t.run()
+	}
+	s.run()
+
+	if s.config.Watch.Enabled && !s.config.PurgeOnly {
+		for _, t := range s.tenants {
+			go t.notifyWatchers()
+		}
+		go s.notifyWatchers()
+	}
+
+	if !s.config.PurgeOnly {
+		s.listen()
+	}
+}
+
+// Runs stats collection and purge timers
+func (s *ApiServer) run() {
 	s.statsIntv = util.MustParseDuration(s.config.StatsCollectInterval)
 	statsTimer := time.NewTimer(s.statsIntv)
 	log.Printf("Set stats collect interval to %v", s.statsIntv)
@@ -95,24 +183,88 @@ func (s *ApiServer) Start() {
 			}
 		}
 	}()
+}
 
-	if !s.config.PurgeOnly {
-		s.listen()
+func (s *ApiServer) listen() {
+	r := s.router(true)
+	tenants := make(map[string]http.Handler)
+	for domain, t := range s.tenants {
+		tenants[domain] = t.router(false)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.Host)
+		if err != nil {
+			host = req.Host
+		}
+		if t, ok := tenants[strings.ToLower(host)]; ok {
+			t.ServeHTTP(w, req)
+			return
+		}
+		r.ServeHTTP(w, req)
+	})
+	if s.separateAdmin() {
+		go serve("admin API", s.config.Admin.Listen, s.adminRouter(), &s.config.Admin.TLS)
+	} else if s.publicAdmin() {
+		log.Println("Admin API is served on public listener, set api.admin.listen to separate it")
 	}
+	serve("API", s.config.Listen, handler, &s.config.TLS)
 }
 
-func (s *ApiServer) listen() {
+// Admin endpoints manage whole deployment and are only routed for main pool
+func (s *ApiServer) router(admin bool) *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/api/stats", s.StatsIndex)
 	r.HandleFunc("/api/miners", s.MinersIndex)
 	r.HandleFunc("/api/blocks", s.BlocksIndex)
 	r.HandleFunc("/api/payments", s.PaymentsIndex)
+	r.HandleFunc("/api/meta", s.MetaIndex)
+	r.HandleFunc("/api/poolstats", s.PoolStatsIndex)
+	r.HandleFunc("/api/transparency", s.TransparencyIndex)
 	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}", s.AccountIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/history.csv", s.WorkersHistoryIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/chart", s.ChartIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/lifetime", s.LifetimeIndex)
+	r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/settings", s.SettingsIndex).Methods("GET", "POST")
+	r.HandleFunc("/metrics", s.MetricsIndex)
+	if s.config.Watch.Enabled {
+		r.HandleFunc("/api/accounts/{login:0x[0-9a-fA-F]{40}}/watch", s.WatchIndex).Methods("POST", "DELETE")
+	}
+	if s.uptimeWindow > 0 {
+		r.HandleFunc("/api/uptime", s.UptimeIndex)
+	}
+	if admin && s.publicAdmin() {
+		s.adminRoutes(r)
+	}
 	r.NotFoundHandler = http.HandlerFunc(notFound)
-	err := http.ListenAndServe(s.config.Listen, r)
-	if err != nil {
-		log.Fatalf("Failed to start API: %v", err)
+	r.Use(s.limitBody)
+	return r
+}
+
+// Rejects oversized bodies by Content-Length upfront or as soon as limit is read
+func (s *ApiServer) limitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := s.bodyLimit(r.URL.Path)
+		if r.ContentLength > limit {
+			http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *ApiServer) bodyLimit(path string) int64 {
+	limit := int64(s.config.LimitBodySize)
+	if limit <= 0 {
+		limit = defaultLimitBodySize
+	}
+	matched := -1
+	for prefix, v := range s.config.BodyLimits {
+		if strings.HasPrefix(path, prefix) && len(prefix) > matched {
+			limit, matched = int64(v), len(prefix)
+		}
 	}
+	return limit
 }
 
 func notFound(w http.ResponseWriter, r *http.Request) {
@@ -124,32 +276,63 @@ func notFound(w http.ResponseWriter, r *http.Request) {
 
 func (s *ApiServer) purgeStale() {
 	start := time.Now()
-	total, err := s.backend.FlushStaleStats(s.hashrateWindow, s.hashrateLargeWindow)
+	total, err := s.storage.FlushStaleStats(s.hashrateWindow, s.hashrateLargeWindow)
 	if err != nil {
 		log.Println("Failed to purge stale data from backend:", err)
 	} else {
 		log.Printf("Purged stale stats from backend, %v shares affected, elapsed time %v", total, time.Since(start))
 	}
+	if s.uptimeWindow > 0 {
+		total, err = s.backend.FlushNodeHistory(s.uptimeWindow)
+		if err != nil {
+			log.Println("Failed to purge node history from backend:", err)
+		} else {
+			log.Printf("Purged %v stale node heartbeats from backend", total)
+		}
+	}
 }
 
 func (s *ApiServer) collectStats() {
 	start := time.Now()
-	stats, err := s.backend.CollectStats(s.hashrateWindow, s.config.Blocks, s.config.Payments)
+	stats, err := s.storage.CollectStats(s.hashrateWindow, s.config.Blocks, s.config.Payments)
 	if err != nil {
 		log.Printf("Failed to fetch stats from backend: %v", err)
 		return
 	}
 	if len(s.config.LuckWindow) > 0 {
-		stats["luck"], err = s.backend.CollectLuckStats(s.config.LuckWindow)
+		stats["luck"], err = s.storage.CollectLuckStats(s.config.LuckWindow)
 		if err != nil {
 			log.Printf("Failed to fetch luck stats from backend: %v", err)
 			return
 		}
 	}
+	nodes, err := s.backend.GetNodeStates()
+	if err != nil {
+		log.Printf("Failed to get nodes stats from backend: %v", err)
+	}
+	stats["roundEffort"] = roundEffort(stats, nodes)
+	s.withReceipts(stats["payments"])
 	s.stats.Store(stats)
 	log.Printf("Stats collection finished %s", time.Since(start))
 }
 
+// Shares of current round relative to network difficulty reported by nodes
+func roundEffort(stats map[string]interface{}, nodes []map[string]interface{}) float64 {
+	var difficulty int64
+	for _, node := range nodes {
+		v, _ := node["difficulty"].(string)
+		if n, _ := strconv.ParseInt(v, 10, 64); n > difficulty {
+			difficulty = n
+		}
+	}
+	pool, _ := stats["stats"].(map[string]interface{})
+	shares, _ := pool["roundShares"].(int64)
+	if difficulty == 0 {
+		return 0
+	}
+	return float64(shares) / float64(difficulty)
+}
+
 func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -172,6 +355,9 @@ func (s *ApiServer) StatsIndex(w http.ResponseWriter, r *http.Request) {
 		reply["maturedTotal"] = stats["maturedTotal"]
 		reply["immatureTotal"] = stats["immatureTotal"]
 		reply["candidatesTotal"] = stats["candidatesTotal"]
+		reply["roundEffort"] = stats["roundEffort"]
+		reply["roundBestShares"] = stats["roundBestShares"]
+		reply["bestShares"] = stats["bestShares"]
 	}
 
 	err = json.NewEncoder(w).Encode(reply)
@@ -249,7 +435,11 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	login := strings.ToLower(mux.Vars(r)["login"])
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 	s.minersMu.Lock()
 	defer s.minersMu.Unlock()
 
@@ -258,7 +448,13 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 	cacheIntv := int64(s.statsIntv / time.Millisecond)
 	// Refresh stats if stale
 	if !ok || reply.updatedAt < now-cacheIntv {
-		exist, err := s.backend.IsMinerExists(login)
+		exist, err := s.storage.IsMinerExists(login)
+		// Watched address is served before it has any shares
+		if !exist && err == nil && s.config.Watch.Enabled {
+			var watch *storage.Watch
+			watch, err = s.backend.GetWatch(login)
+			exist = watch != nil
+		}
 		if !exist {
 			w.WriteHeader(http.StatusNotFound)
 			return
@@ -269,13 +465,13 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		stats, err := s.backend.GetMinerStats(login, s.config.Payments)
+		stats, err := s.storage.GetMinerStats(login, s.config.Payments)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			log.Printf("Failed to fetch stats from backend: %v", err)
 			return
 		}
-		workers, err := s.backend.CollectWorkersStats(s.hashrateWindow, s.hashrateLargeWindow, login)
+		workers, err := s.storage.CollectWorkersStats(s.hashrateWindow, s.hashrateLargeWindow, login)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			log.Printf("Failed to fetch stats from backend: %v", err)
@@ -284,7 +480,15 @@ func (s *ApiServer) AccountIndex(w http.ResponseWriter, r *http.Request) {
 		for key, value := range workers {
 			stats[key] = value
 		}
+		donation, err := s.storage.GetDonation(login)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Failed to fetch stats from backend: %v", err)
+			return
+		}
+		stats["donation"] = donation
 		stats["pageSize"] = s.config.Payments
+		s.withReceipts(stats["payments"])
 		reply = &Entry{stats: stats, updatedAt: now}
 		s.miners[login] = reply
 	}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Signed settings are accepted this long around their timestamp, so they can't be replayed later
+const settingsSignatureWindow = 10 * time.Minute
+
+type settingsRequest struct {
+	// Percent of round reward donated, 0 stops donating
+	Donation *float64 `json:"donation"`
+	// personal_sign signature of settingsMessage by address, unless export token is given
+	Signature string `json:"signature"`
+	// Unix time signature was made at
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Message signed by address to change its settings, bound to values and time of the change
+func settingsMessage(login string, req *settingsRequest) string {
+	var donation float64
+	if req.Donation != nil {
+		donation = *req.Donation
+	}
+	return "Set donation of " + login + " to " + strconv.FormatFloat(donation, 'f', -1, 64) + "% at " + strconv.FormatInt(req.Timestamp, 10)
+}
+
+func settingsSigned(login string, req *settingsRequest, now time.Time) bool {
+	if req.Donation == nil || len(req.Signature) == 0 {
+		return false
+	}
+	age := now.Sub(time.Unix(req.Timestamp, 0))
+	if age > settingsSignatureWindow || age < -settingsSignatureWindow {
+		return false
+	}
+	return addressSigned(login, settingsMessage(login, req), req.Signature)
+}
+
+// Settings of account, GET is public, POST requires signature by address, export token of account or admin key
+func (s *ApiServer) SettingsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if r.Method == "POST" {
+		var req settingsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Malformed JSON", http.StatusBadRequest)
+			return
+		}
+		if !s.exportAllowed(r, login, RoleSupport) && !settingsSigned(login, &req, time.Now()) {
+			log.Printf("Unauthorized settings request from %v for %v", r.RemoteAddr, login)
+			http.Error(w, "Sign \""+settingsMessage(login, &req)+"\" with address or use account token", http.StatusUnauthorized)
+			return
+		}
+		if req.Donation != nil {
+			if *req.Donation < 0 || *req.Donation > 100 {
+				http.Error(w, "Donation must be between 0 and 100 percent", http.StatusBadRequest)
+				return
+			}
+			if err := s.storage.SetDonation(login, *req.Donation); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Printf("Failed to store donation: %v", err)
+				return
+			}
+			log.Printf("Donation of %v set to %v%%", login, *req.Donation)
+		}
+	}
+
+	donation, err := s.storage.GetDonation(login)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get donation from backend: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"login": login, "donation": donation})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+type donationStorage struct {
+	storage.Storage
+	percents map[string]float64
+}
+
+func (d *donationStorage) SetDonation(login string, percent float64) error {
+	d.percents[login] = percent
+	return nil
+}
+
+func (d *donationStorage) GetDonation(login string) (*storage.Donation, error) {
+	return &storage.Donation{Percent: d.percents[login]}, nil
+}
+
+func postSettings(s *ApiServer, login string, req *settingsRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	r := mux.SetURLVars(httptest.NewRequest("POST", "/", bytes.NewReader(body)), map[string]string{"login": login})
+	w := httptest.NewRecorder()
+	s.SettingsIndex(w, r)
+	return w
+}
+
+func TestSignedSettings(t *testing.T) {
+	st := &donationStorage{percents: make(map[string]float64)}
+	s := &ApiServer{config: &ApiConfig{}, storage: st}
+	key, _ := crypto.GenerateKey()
+	login := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).Hex())
+
+	donation := 1.5
+	req := &settingsRequest{Donation: &donation, Timestamp: time.Now().Unix()}
+	sig, _ := crypto.Sign(accounts.TextHash([]byte(settingsMessage(login, req))), key)
+	sig[crypto.RecoveryIDOffset] += 27
+	req.Signature = hexutil.Encode(sig)
+
+	if w := postSettings(s, login, req); w.Code != http.StatusOK || st.percents[login] != 1.5 {
+		t.Errorf("Must accept donation signed by address, got %v %q", w.Code, w.Body.String())
+	}
+
+	other := 5.0
+	if w := postSettings(s, login, &settingsRequest{Donation: &other, Timestamp: req.Timestamp, Signature: req.Signature}); w.Code != http.StatusUnauthorized || st.percents[login] != 1.5 {
+		t.Errorf("Must bind signature to donation, got %v", w.Code)
+	}
+	if w := postSettings(s, "0x0000000000000000000000000000000000000001", req); w.Code != http.StatusUnauthorized {
+		t.Errorf("Must reject signature by another address, got %v", w.Code)
+	}
+	if settingsSigned(login, req, time.Now().Add(time.Hour)) {
+		t.Error("Must reject stale signature")
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultTailDuration = 5 * time.Minute
+	maxTailDuration     = 30 * time.Minute
+	tailWriteTimeout    = 10 * time.Second
+)
+
+var tailUpgrader = websocket.Upgrader{}
+
+// Streams stratum messages of login sessions (jobs, submissions and replies) for bounded
+// duration, as WebSocket text frames if upgrade is requested or newline delimited JSON
+func (s *ApiServer) TailIndex(w http.ResponseWriter, r *http.Request) {
+	login := strings.ToLower(mux.Vars(r)["login"])
+	duration := defaultTailDuration
+	if v := r.URL.Query().Get("duration"); len(v) > 0 {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+	if duration > maxTailDuration {
+		duration = maxTailDuration
+	}
+
+	pubsub, err := s.backend.SubscribeTail(login)
+	if err != nil {
+		log.Printf("Failed to subscribe to live tail of %v: %v", login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer pubsub.Close()
+	if err := s.backend.StartTail(login, duration); err != nil {
+		log.Printf("Failed to start live tail of %v: %v", login, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Live tail of %v started by admin for %v", login, duration)
+
+	var send func(data []byte) error
+	done := r.Context().Done()
+	if websocket.IsWebSocketUpgrade(r) {
+		conn, err := tailUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Reads process control frames, tail ends once client closes connection
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+		done = closed
+		send = func(data []byte) error {
+			conn.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+			return conn.WriteMessage(websocket.TextMessage, data)
+		}
+	} else {
+		flusher, _ := w.(http.Flusher)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		send = func(data []byte) error {
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		}
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	messages := make(chan string)
+	go func() {
+		for {
+			m, err := pubsub.ReceiveMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case messages <- m.Payload:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	deadline := time.NewTimer(duration)
+	defer deadline.Stop()
+	for {
+		select {
+		case payload := <-messages:
+			if err := send([]byte(payload)); err != nil {
+				return
+			}
+		case <-deadline.C:
+			return
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Fee history, reward parameters of unlocker and result of last payments verification,
+// so miners can check claims of pool programmatically
+func (s *ApiServer) TransparencyIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "max-age=60")
+
+	params, err := s.storage.GetUnlockerParams()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch unlocker params from backend: %v", err)
+		return
+	}
+	fees, err := s.storage.GetFeeHistory()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch fee history from backend: %v", err)
+		return
+	}
+	report, err := s.storage.GetPaymentsReport()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch payments report from backend: %v", err)
+		return
+	}
+
+	donated, err := s.storage.GetDonationsTotal()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch donations from backend: %v", err)
+		return
+	}
+
+	scheme := s.meta.PayoutScheme
+	if len(scheme) == 0 {
+		scheme = defaultPayoutScheme
+	}
+	reply := map[string]interface{}{
+		"payoutScheme": scheme,
+		"pplns":        s.meta.PPLNS,
+		"minPayout":    s.meta.MinPayout,
+		"unlocker":     params,
+		"feeHistory":   fees,
+		"audit":        report,
+		"donated":      donated,
+	}
+	// Block rewards received less share credits paid, so miners can judge solvency of PPS pool
+	if scheme == storage.SchemePPS || scheme == storage.SchemePPSPlus {
+		buffer, err := s.storage.GetPPSBuffer()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Failed to fetch PPS buffer from backend: %v", err)
+			return
+		}
+		reply["ppsBuffer"] = buffer
+	}
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(reply)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// History of upstream stats is written for the last hour
+const upstreamStatsRange = time.Hour
+
+// Latest health of upstream nodes of every proxy instance with history of last hour
+func (s *ApiServer) UpstreamsIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	from := time.Now().Add(-upstreamStatsRange).Unix()
+	stats, history, err := s.backend.GetUpstreamStats(from)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to fetch upstream stats from backend: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]interface{}{"upstreams": stats, "history": history})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+type Incident struct {
+	Start    int64 `json:"start"`
+	End      int64 `json:"end,omitempty"`
+	Duration int64 `json:"duration"`
+	Ongoing  bool  `json:"ongoing,omitempty"`
+}
+
+type Uptime struct {
+	Uptime    float64    `json:"uptime"`
+	Since     int64      `json:"since"`
+	Incidents []Incident `json:"incidents"`
+}
+
+// Computes uptime percentage and downtime incidents from sorted minute heartbeats.
+// Tracking starts at the first heartbeat within window, so fresh nodes are not penalized.
+func calcUptime(minutes []int64, now int64) Uptime {
+	result := Uptime{Incidents: []Incident{}}
+	if len(minutes) == 0 {
+		return result
+	}
+	result.Since = minutes[0]
+	total := (now-minutes[0])/60 + 1
+	down := int64(0)
+
+	for i := 1; i < len(minutes); i++ {
+		gap := minutes[i] - minutes[i-1]
+		if gap > 60 {
+			start := minutes[i-1] + 60
+			result.Incidents = append(result.Incidents, Incident{Start: start, End: minutes[i], Duration: minutes[i] - start})
+			down += gap/60 - 1
+		}
+	}
+	// Allow current minute and previous one to be not written yet
+	last := minutes[len(minutes)-1]
+	if now-last >= 120 {
+		start := last + 60
+		result.Incidents = append(result.Incidents, Incident{Start: start, Duration: now - start, Ongoing: true})
+		down += (now-last)/60 - 1
+	}
+	result.Uptime = float64(total-down) / float64(total) * 100
+	return result
+}
+
+// Pool is up while at least one node sends heartbeats
+func mergeHistory(history map[string][]int64) []int64 {
+	set := make(map[int64]struct{})
+	for _, minutes := range history {
+		for _, m := range minutes {
+			set[m] = struct{}{}
+		}
+	}
+	result := make([]int64, 0, len(set))
+	for m := range set {
+		result = append(result, m)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+func (s *ApiServer) collectUptime() (map[string]interface{}, error) {
+	now := util.MakeTimestamp() / 1000
+	since := now - int64(s.uptimeWindow/time.Second)
+	history, err := s.backend.GetNodeHistory(since)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make(map[string]Uptime)
+	for name, minutes := range history {
+		nodes[name] = calcUptime(minutes, now)
+	}
+	reply := map[string]interface{}{
+		"now":    now,
+		"window": int64(s.uptimeWindow / time.Second),
+		"pool":   calcUptime(mergeHistory(history), now),
+		"nodes":  nodes,
+	}
+	return reply, nil
+}
+
+func (s *ApiServer) UptimeIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.uptimeMu.Lock()
+	defer s.uptimeMu.Unlock()
+
+	now := util.MakeTimestamp()
+	cacheIntv := int64(s.statsIntv / time.Millisecond)
+	if s.uptime == nil || s.uptime.updatedAt < now-cacheIntv {
+		reply, err := s.collectUptime()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Failed to fetch uptime history from backend: %v", err)
+			return
+		}
+		s.uptime = &Entry{stats: reply, updatedAt: now}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	err := json.NewEncoder(w).Encode(s.uptime.stats)
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
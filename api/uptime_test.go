@@ -0,0 +1,47 @@
+package api
+
+import "testing"
+
+func TestCalcUptime(t *testing.T) {
+	minutes := []int64{0, 60, 120, 300, 360}
+	uptime := calcUptime(minutes, 390)
+
+	if len(uptime.Incidents) != 1 {
+		t.Fatalf("Must find single incident, got %v", len(uptime.Incidents))
+	}
+	incident := uptime.Incidents[0]
+	if incident.Start != 180 || incident.End != 300 || incident.Duration != 120 {
+		t.Errorf("Must report incident bounds, got %+v", incident)
+	}
+	// 7 minutes tracked, 2 missing
+	if int(uptime.Uptime*100) != 7142 {
+		t.Errorf("Must calculate uptime, got %v", uptime.Uptime)
+	}
+}
+
+func TestCalcUptimeOngoing(t *testing.T) {
+	uptime := calcUptime([]int64{0, 60}, 600)
+	if len(uptime.Incidents) != 1 || !uptime.Incidents[0].Ongoing {
+		t.Fatal("Must report ongoing incident")
+	}
+	if uptime.Incidents[0].Start != 120 {
+		t.Errorf("Must start incident after last heartbeat, got %v", uptime.Incidents[0].Start)
+	}
+	if len(calcUptime(nil, 600).Incidents) != 0 {
+		t.Error("Must not report incidents without history")
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	history := map[string][]int64{"a": {0, 60}, "b": {60, 180}}
+	merged := mergeHistory(history)
+	expected := []int64{0, 60, 180}
+	if len(merged) != len(expected) {
+		t.Fatalf("Must merge heartbeats, got %v", merged)
+	}
+	for i := range expected {
+		if merged[i] != expected[i] {
+			t.Errorf("Must sort merged heartbeats, got %v", merged)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+type WatchConfig struct {
+	Enabled bool `json:"enabled"`
+	// Timeout of a single webhook request
+	Timeout string `json:"timeout"`
+}
+
+const watchAttempts = 3
+
+type watchRequest struct {
+	Url string `json:"url"`
+	// personal_sign signature of watchMessage by address, unless export token is given
+	Signature string `json:"signature"`
+}
+
+// Registers (POST) or removes (DELETE) webhook of address, it doesn't have to mine on pool.
+// New watch requires signature by address, account export token or admin key.
+// Replacing or removing existing watch requires its token or admin key.
+func (s *ApiServer) WatchIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	watch, err := s.backend.GetWatch(login)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to get watch from backend: %v", err)
+		return
+	}
+	if watch != nil && !s.watchAllowed(r, watch) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		if watch != nil {
+			if err := s.backend.DeleteWatch(login); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Printf("Failed to delete watch: %v", err)
+				return
+			}
+			log.Printf("Stopped watching %v", login)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var req watchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Malformed JSON", http.StatusBadRequest)
+		return
+	}
+	if err := checkWebhookUrl(req.Url); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if watch == nil && !s.exportAllowed(r, login, RoleSupport) && !watchSigned(login, req.Url, req.Signature) {
+		http.Error(w, "Sign \""+watchMessage(login, req.Url)+"\" with address or use account token", http.StatusUnauthorized)
+		return
+	}
+	if watch == nil {
+		buf := make([]byte, 24)
+		if _, err := rand.Read(buf); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		watch = &storage.Watch{Token: hex.EncodeToString(buf), CreatedAt: util.MakeTimestamp()}
+	}
+	watch.Url = req.Url
+	if err := s.backend.SetWatch(login, watch); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Failed to store watch: %v", err)
+		return
+	}
+	log.Printf("Watching %v", login)
+	w.WriteHeader(http.StatusOK)
+	err = json.NewEncoder(w).Encode(map[string]string{"login": login, "url": watch.Url, "token": watch.Token})
+	if err != nil {
+		log.Println("Error serializing API response: ", err)
+	}
+}
+
+func (s *ApiServer) watchAllowed(r *http.Request, watch *storage.Watch) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if len(token) == 0 {
+		return false
+	}
+	if s.operatorAllowed(r, RoleSupport) {
+		return true
+	}
+	return util.SecureCompare(token, watch.Token)
+}
+
+// Message signed by address to prove ownership, bound to webhook so signature can't be replayed for another one
+func watchMessage(login, url string) string {
+	return "Watch " + login + " with " + url
+}
+
+func watchSigned(login, url, signature string) bool {
+	return addressSigned(login, watchMessage(login, url), signature)
+}
+
+// Checks personal_sign signature of message by login address
+func addressSigned(login, message, signature string) bool {
+	sig, err := hexutil.Decode(signature)
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return false
+	}
+	// Wallets set recovery id to 27 or 28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return false
+	}
+	return strings.ToLower(crypto.PubkeyToAddress(*pub).Hex()) == login
+}
+
+func checkWebhookUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || len(u.Hostname()) == 0 {
+		return errors.New("Webhook must be an https URL")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return errors.New("Webhook must point to a public address")
+	}
+	return nil
+}
+
+// Webhooks are user input, pool internals must not be reachable through them
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// Checks address after DNS resolution, so hostnames can't point inside either
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("webhook address %v is not public", host)
+	}
+	return nil
+}
+
+func webhookSignature(token string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Delivers queued notifications of watched addresses one by one
+func (s *ApiServer) notifyWatchers() {
+	timeout := util.MustParseDuration(s.config.Watch.Timeout)
+	dialer := &net.Dialer{Timeout: timeout, Control: dialPublic}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for {
+		n, err := s.backend.PopWatchNotification(5 * time.Second)
+		if err != nil {
+			log.Printf("Failed to get notification from backend: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		if n == nil {
+			continue
+		}
+		watch, err := s.backend.GetWatch(n.Login)
+		if err != nil {
+			log.Printf("Failed to get watch from backend: %v", err)
+			continue
+		}
+		// Removed after notification was queued
+		if watch == nil {
+			continue
+		}
+		s.deliver(client, watch, n)
+	}
+}
+
+func (s *ApiServer) deliver(client *http.Client, watch *storage.Watch, n *storage.WatchNotification) {
+	s.withExplorerUrl(n)
+	body, _ := json.Marshal(n)
+	for attempt := 1; attempt <= watchAttempts; attempt++ {
+		req, err := http.NewRequest("POST", watch.Url, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Invalid webhook of %v: %v", n.Login, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Pool-Signature", webhookSignature(watch.Token, body))
+		resp, err := client.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			if resp.StatusCode/100 == 2 {
+				return
+			}
+			err = fmt.Errorf("status %v", resp.StatusCode)
+		}
+		log.Printf("Failed to deliver %s notification to %v (attempt %v/%v): %v", n.Type, n.Login, attempt, watchAttempts, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
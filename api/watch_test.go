@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestCheckWebhookUrl(t *testing.T) {
+	for _, u := range []string{"https://example.com/hook", "https://8.8.8.8:8443/"} {
+		if err := checkWebhookUrl(u); err != nil {
+			t.Errorf("Must accept %v, got %v", u, err)
+		}
+	}
+	for _, u := range []string{"http://example.com/hook", "https:///hook", "https://127.0.0.1/", "https://10.0.0.1/",
+		"https://[::1]/", "https://169.254.169.254/latest", "ftp://example.com"} {
+		if err := checkWebhookUrl(u); err == nil {
+			t.Errorf("Must reject %v", u)
+		}
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	if err := dialPublic("tcp", "1.1.1.1:443", nil); err != nil {
+		t.Errorf("Must allow public address, got %v", err)
+	}
+	for _, addr := range []string{"127.0.0.1:443", "192.168.1.1:443", "[fe80::1]:443", "0.0.0.0:443"} {
+		if err := dialPublic("tcp", addr, nil); err == nil {
+			t.Errorf("Must refuse %v", addr)
+		}
+	}
+	if !publicIP(net.ParseIP("2001:4860:4860::8888")) {
+		t.Error("Must treat global IPv6 as public")
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	sig := webhookSignature("token", []byte(`{"type":"payment"}`))
+	if sig != webhookSignature("token", []byte(`{"type":"payment"}`)) || len(sig) != 71 {
+		t.Errorf("Must be stable sha256 HMAC, got %v", sig)
+	}
+	if sig == webhookSignature("other", []byte(`{"type":"payment"}`)) {
+		t.Error("Must depend on token")
+	}
+}
+
+func TestExplorer(t *testing.T) {
+	e := DefaultExplorer("mordor")
+	if url := e.TxUrl("0x1"); url != "https://etc-mordor.blockscout.com/tx/0x1" {
+		t.Errorf("Must substitute tx hash, got %v", url)
+	}
+	if url := e.AddressUrl(""); url != "" {
+		t.Errorf("Must not link blank address, got %v", url)
+	}
+	e = DefaultExplorer("private")
+	if url := e.BlockUrl("0x1"); url != "" {
+		t.Errorf("Must not link blocks of unknown network, got %v", url)
+	}
+}
+
+func TestWatchSigned(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	login := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).Hex())
+	sig, _ := crypto.Sign(accounts.TextHash([]byte(watchMessage(login, "https://example.com/hook"))), key)
+	sig[crypto.RecoveryIDOffset] += 27
+
+	if !watchSigned(login, "https://example.com/hook", hexutil.Encode(sig)) {
+		t.Error("Must accept personal_sign signature of address")
+	}
+	if watchSigned(login, "https://example.com/other", hexutil.Encode(sig)) {
+		t.Error("Must bind signature to webhook")
+	}
+	if watchSigned("0x0000000000000000000000000000000000000001", "https://example.com/hook", hexutil.Encode(sig)) {
+		t.Error("Must reject signature by another address")
+	}
+	if watchSigned(login, "https://example.com/hook", "") {
+		t.Error("Must reject missing signature")
+	}
+}
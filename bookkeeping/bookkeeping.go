@@ -0,0 +1,252 @@
+package bookkeeping
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultInterval = time.Minute
+	defaultTimeout  = 10 * time.Second
+)
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Entries are POSTed as JSON to this url
+	Url string `json:"url"`
+	// Extra request headers, e.g. Authorization
+	Headers map[string]string `json:"headers"`
+	// Undelivered entries are retried in this interval, 1m if not set
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+	// Entry kind => body field => template with {placeholders}, entry is sent as is if its kind is not mapped
+	Mapping map[string]map[string]string `json:"mapping"`
+}
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if u, err := url.Parse(c.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs.Addf("bookkeeping.url: must be http or https url")
+	}
+	errs.Duration("bookkeeping.interval", c.Interval, true)
+	errs.Duration("bookkeeping.timeout", c.Timeout, true)
+	for kind := range c.Mapping {
+		if kind != Payment && kind != Fee {
+			errs.Addf("bookkeeping.mapping: unknown entry kind %q", kind)
+		}
+	}
+}
+
+// Entry kinds
+const (
+	// Finished payment to miner
+	Payment = "payment"
+	// Pool fee income of matured block
+	Fee = "fee"
+)
+
+type Entry struct {
+	// Stable id, sent as Idempotency-Key so receiver can drop redelivered entries
+	Id     string `json:"id"`
+	Kind   string `json:"kind"`
+	Tenant string `json:"tenant,omitempty"`
+	Login  string `json:"login,omitempty"`
+	TxHash string `json:"tx,omitempty"`
+	Height int64  `json:"height,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	// In Wei
+	Amount    string `json:"amount"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func PaymentEntry(tenant, login, txHash string, shannon int64) *Entry {
+	amount := new(big.Int).Mul(big.NewInt(shannon), util.Shannon)
+	return &Entry{Id: Payment + ":" + txHash + ":" + login, Kind: Payment, Tenant: tenant, Login: login, TxHash: txHash, Amount: amount.String()}
+}
+
+func FeeEntry(tenant string, height int64, hash string, wei *big.Int) *Entry {
+	return &Entry{Id: Fee + ":" + hash, Kind: Fee, Tenant: tenant, Height: height, Hash: hash, Amount: wei.String()}
+}
+
+// Values of {placeholders} in mapping templates
+func (e *Entry) fields() map[string]string {
+	amount, _ := new(big.Rat).SetString(e.Amount)
+	if amount == nil {
+		amount = new(big.Rat)
+	}
+	return map[string]string{
+		"id":        e.Id,
+		"kind":      e.Kind,
+		"tenant":    e.Tenant,
+		"login":     e.Login,
+		"tx":        e.TxHash,
+		"height":    strconv.FormatInt(e.Height, 10),
+		"hash":      e.Hash,
+		"amountWei": e.Amount,
+		"amount":    amount.Quo(amount, new(big.Rat).SetInt(util.Ether)).FloatString(9),
+		"timestamp": strconv.FormatInt(e.Timestamp, 10),
+		"date":      time.Unix(e.Timestamp, 0).UTC().Format("2006-01-02"),
+	}
+}
+
+// Delivery state is kept in redis, so entries survive restarts
+type queue interface {
+	QueueBookkeeping(id, data string) error
+	GetBookkeeping() (map[string]string, error)
+	RemoveBookkeeping(id string) error
+}
+
+type Exporter struct {
+	config *Config
+	client *http.Client
+	queue  queue
+	wake   chan struct{}
+}
+
+var exporter *Exporter
+
+func newExporter(cfg *Config, q queue) *Exporter {
+	timeout := defaultTimeout
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	return &Exporter{config: cfg, client: &http.Client{Timeout: timeout}, queue: q, wake: make(chan struct{}, 1)}
+}
+
+// Starts default exporter used by Record
+func Start(cfg *Config, q queue) {
+	e := newExporter(cfg, q)
+	intv := defaultInterval
+	if len(cfg.Interval) > 0 {
+		intv = util.MustParseDuration(cfg.Interval)
+	}
+	go e.run(intv)
+	exporter = e
+	log.Printf("Exporting payments and fee income to %s", cfg.Url)
+}
+
+// Queues entry for delivery, no-op unless exporter is started
+func Record(entry *Entry) {
+	if exporter != nil {
+		exporter.record(entry)
+	}
+}
+
+func (e *Exporter) record(entry *Entry) {
+	if entry.Timestamp == 0 {
+		entry.Timestamp = time.Now().Unix()
+	}
+	data, _ := json.Marshal(entry)
+	if err := e.queue.QueueBookkeeping(entry.Id, string(data)); err != nil {
+		log.Printf("Failed to queue bookkeeping entry %v: %v", entry.Id, err)
+		return
+	}
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Exporter) run(intv time.Duration) {
+	ticker := time.NewTicker(intv)
+	for {
+		e.deliver()
+		select {
+		case <-ticker.C:
+		case <-e.wake:
+		}
+	}
+}
+
+// Oldest entries go first, pass stops at first failure to keep order
+func (e *Exporter) deliver() {
+	rows, err := e.queue.GetBookkeeping()
+	if err != nil {
+		log.Printf("Failed to get bookkeeping entries: %v", err)
+		return
+	}
+	entries := make([]*Entry, 0, len(rows))
+	for id, data := range rows {
+		var entry Entry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			log.Printf("Dropping malformed bookkeeping entry %v: %v", id, err)
+			e.queue.RemoveBookkeeping(id)
+			continue
+		}
+		entries = append(entries, &entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Timestamp != entries[j].Timestamp {
+			return entries[i].Timestamp < entries[j].Timestamp
+		}
+		return entries[i].Id < entries[j].Id
+	})
+	for _, entry := range entries {
+		if err := e.post(entry); err != nil {
+			log.Printf("Failed to deliver bookkeeping entry %v, will retry: %v", entry.Id, err)
+			return
+		}
+		if err := e.queue.RemoveBookkeeping(entry.Id); err != nil {
+			log.Printf("Failed to remove delivered bookkeeping entry %v: %v", entry.Id, err)
+			return
+		}
+		log.Printf("Delivered bookkeeping entry %v", entry.Id)
+	}
+}
+
+func (e *Exporter) body(entry *Entry) []byte {
+	mapping, ok := e.config.Mapping[entry.Kind]
+	if !ok {
+		data, _ := json.Marshal(entry)
+		return data
+	}
+	fields := entry.fields()
+	args := make([]string, 0, len(fields)*2)
+	for k, v := range fields {
+		args = append(args, "{"+k+"}", v)
+	}
+	replacer := strings.NewReplacer(args...)
+	body := make(map[string]string, len(mapping))
+	for field, tpl := range mapping {
+		body[field] = replacer.Replace(tpl)
+	}
+	data, _ := json.Marshal(body)
+	return data
+}
+
+// Conflict means receiver already has entry with this key
+func (e *Exporter) post(entry *Entry) error {
+	req, err := http.NewRequest("POST", e.config.Url, bytes.NewReader(e.body(entry)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", entry.Id)
+	for k, v := range e.config.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(resp.Status + ": " + string(bytes.TrimSpace(msg)))
+	}
+	return nil
+}
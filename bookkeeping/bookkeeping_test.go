@@ -0,0 +1,92 @@
+package bookkeeping
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type memQueue struct {
+	sync.Mutex
+	entries map[string]string
+}
+
+func (q *memQueue) QueueBookkeeping(id, data string) error {
+	q.Lock()
+	defer q.Unlock()
+	if _, ok := q.entries[id]; !ok {
+		q.entries[id] = data
+	}
+	return nil
+}
+
+func (q *memQueue) GetBookkeeping() (map[string]string, error) {
+	q.Lock()
+	defer q.Unlock()
+	result := make(map[string]string, len(q.entries))
+	for k, v := range q.entries {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (q *memQueue) RemoveBookkeeping(id string) error {
+	q.Lock()
+	defer q.Unlock()
+	delete(q.entries, id)
+	return nil
+}
+
+func TestDeliver(t *testing.T) {
+	status := http.StatusInternalServerError
+	var keys []string
+	var bodies []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	q := &memQueue{entries: make(map[string]string)}
+	e := newExporter(&Config{Url: srv.URL, Headers: map[string]string{"Authorization": "Bearer secret"},
+		Mapping: map[string]map[string]string{Payment: {"account": "6000", "memo": "Payout to {login}", "amount": "{amount}", "date": "{date}"}}}, q)
+
+	payment := PaymentEntry("", "0xa", "0xtx", 1500000000)
+	payment.Timestamp = 1600000000
+	fee := FeeEntry("brand", 100, "0xb", big.NewInt(20000000000000000))
+	fee.Timestamp = 1600000001
+	e.record(payment)
+	e.record(fee)
+	e.record(payment)
+
+	e.deliver()
+	if len(keys) != 1 || len(q.entries) != 2 {
+		t.Fatalf("Must stop at first failure and keep entries, got %v requests and %v entries", len(keys), len(q.entries))
+	}
+
+	status = http.StatusOK
+	e.deliver()
+	if len(q.entries) != 0 {
+		t.Errorf("Must remove delivered entries, got %v", q.entries)
+	}
+	if len(keys) != 3 || keys[1] != "payment:0xtx:0xa" || keys[2] != "fee:0xb" {
+		t.Errorf("Must deliver oldest entries first with idempotency key, got %v", keys)
+	}
+	mapped := bodies[1]
+	if mapped["account"] != "6000" || mapped["memo"] != "Payout to 0xa" || mapped["amount"] != "1.500000000" || mapped["date"] != "2020-09-13" {
+		t.Errorf("Must map payment fields, got %v", mapped)
+	}
+	if bodies[2]["amount"] != "20000000000000000" || bodies[2]["tenant"] != "brand" {
+		t.Errorf("Must send unmapped entry as is, got %v", bodies[2])
+	}
+}
@@ -0,0 +1,181 @@
+package clickhouse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultBatchSize     = 10000
+	defaultFlushInterval = 5 * time.Second
+	defaultTimeout       = 10 * time.Second
+)
+
+// Share events are inserted over HTTP interface with JSONEachRow format
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// HTTP interface, e.g. http://127.0.0.1:8123
+	Url      string `json:"url"`
+	Database string `json:"database"`
+	Table    string `json:"table"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// Rows per insert, defaults to 10000
+	BatchSize int `json:"batchSize"`
+	// Partial batch is inserted after this period, defaults to 5s
+	FlushInterval string `json:"flushInterval"`
+	Timeout       string `json:"timeout"`
+	// Events queued in memory, newer ones are dropped while full. Defaults to 10 batches.
+	Buffer int `json:"buffer"`
+}
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if u, err := url.Parse(c.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs.Addf("proxy.clickHouse.url: must be http or https url")
+	}
+	if len(c.Table) == 0 {
+		errs.Addf("proxy.clickHouse.table: required")
+	}
+	errs.Duration("proxy.clickHouse.flushInterval", c.FlushInterval, true)
+	errs.Duration("proxy.clickHouse.timeout", c.Timeout, true)
+}
+
+// Outcome of submitted share
+const (
+	Accepted  = "accepted"
+	Duplicate = "duplicate"
+	Invalid   = "invalid"
+	Busy      = "busy"
+)
+
+type ShareEvent struct {
+	// Formatted as DateTime64(3) in UTC
+	Timestamp  string `json:"timestamp"`
+	Tenant     string `json:"tenant"`
+	Login      string `json:"login"`
+	Worker     string `json:"worker"`
+	IP         string `json:"ip"`
+	Height     uint64 `json:"height"`
+	Difficulty int64  `json:"difficulty"`
+	Status     string `json:"status"`
+}
+
+func NewShareEvent(t time.Time) *ShareEvent {
+	return &ShareEvent{Timestamp: t.UTC().Format("2006-01-02 15:04:05.000")}
+}
+
+type Exporter struct {
+	config        *Config
+	client        *http.Client
+	query         string
+	batchSize     int
+	flushInterval time.Duration
+	events        chan *ShareEvent
+	dropped       int64
+}
+
+func NewExporter(cfg *Config) *Exporter {
+	e := &Exporter{config: cfg, batchSize: cfg.BatchSize, flushInterval: defaultFlushInterval}
+	if e.batchSize <= 0 {
+		e.batchSize = defaultBatchSize
+	}
+	if len(cfg.FlushInterval) > 0 {
+		e.flushInterval = util.MustParseDuration(cfg.FlushInterval)
+	}
+	timeout := defaultTimeout
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	e.client = &http.Client{Timeout: timeout}
+	buffer := cfg.Buffer
+	if buffer <= 0 {
+		buffer = e.batchSize * 10
+	}
+	e.events = make(chan *ShareEvent, buffer)
+
+	table := cfg.Table
+	if len(cfg.Database) > 0 {
+		table = cfg.Database + "." + table
+	}
+	e.query = fmt.Sprintf("INSERT INTO %s FORMAT JSONEachRow", table)
+	return e
+}
+
+func (e *Exporter) Start() {
+	go e.run()
+	log.Printf("Exporting shares to ClickHouse table %s in batches of %v", e.config.Table, e.batchSize)
+}
+
+// Never blocks share processing, events are dropped if ClickHouse can't keep up
+func (e *Exporter) Send(ev *ShareEvent) {
+	select {
+	case e.events <- ev:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+func (e *Exporter) run() {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	batch := make([]*ShareEvent, 0, e.batchSize)
+	for {
+		select {
+		case ev := <-e.events:
+			batch = append(batch, ev)
+			if len(batch) < e.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.insert(batch); err != nil {
+			log.Printf("Failed to insert %v shares into ClickHouse: %v", len(batch), err)
+		}
+		if n := atomic.SwapInt64(&e.dropped, 0); n > 0 {
+			log.Printf("ClickHouse export queue is full, dropped %v shares", n)
+		}
+		batch = batch[:0]
+	}
+}
+
+func (e *Exporter) insert(batch []*ShareEvent) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, ev := range batch {
+		enc.Encode(ev)
+	}
+	req, err := http.NewRequest("POST", strings.TrimSuffix(e.config.Url, "/")+"/?query="+url.QueryEscape(e.query), &body)
+	if err != nil {
+		return err
+	}
+	if len(e.config.User) > 0 {
+		req.Header.Set("X-ClickHouse-User", e.config.User)
+		req.Header.Set("X-ClickHouse-Key", e.config.Password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
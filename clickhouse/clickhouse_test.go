@@ -0,0 +1,61 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExporterBatches(t *testing.T) {
+	inserts := make(chan []*ShareEvent, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if q := r.URL.Query().Get("query"); q != "INSERT INTO pool.shares FORMAT JSONEachRow" {
+			t.Errorf("Unexpected query %q", q)
+		}
+		if r.Header.Get("X-ClickHouse-User") != "pool" {
+			t.Error("Must authenticate with user")
+		}
+		var rows []*ShareEvent
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var ev ShareEvent
+			json.Unmarshal(scanner.Bytes(), &ev)
+			rows = append(rows, &ev)
+		}
+		inserts <- rows
+	}))
+	defer srv.Close()
+
+	e := NewExporter(&Config{Url: srv.URL + "/", Database: "pool", Table: "shares", User: "pool", BatchSize: 2, FlushInterval: "50ms"})
+	e.Start()
+	for _, status := range []string{Accepted, Invalid, Duplicate} {
+		ev := NewShareEvent(time.Unix(0, 0))
+		ev.Status = status
+		e.Send(ev)
+	}
+
+	rows := <-inserts
+	if len(rows) != 2 || rows[0].Status != Accepted || rows[0].Timestamp != "1970-01-01 00:00:00.000" {
+		t.Errorf("Must insert full batch, got %v", rows)
+	}
+	select {
+	case rows = <-inserts:
+		if len(rows) != 1 || rows[0].Status != Duplicate {
+			t.Errorf("Must flush partial batch, got %v", rows)
+		}
+	case <-time.After(time.Second):
+		t.Error("Must flush partial batch on interval")
+	}
+}
+
+func TestExporterDropsWhenFull(t *testing.T) {
+	e := NewExporter(&Config{Url: "http://127.0.0.1:1", Table: "shares", BatchSize: 1, Buffer: 1})
+	e.Send(&ShareEvent{})
+	e.Send(&ShareEvent{})
+	if e.dropped != 1 {
+		t.Errorf("Must drop event while buffer is full, got %v", e.dropped)
+	}
+}
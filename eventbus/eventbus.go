@@ -0,0 +1,386 @@
+package eventbus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultBuffer   = 10000
+	defaultTimeout  = 5 * time.Second
+	defaultMaxSize  = 100
+	defaultMaxFiles = 10
+	// Messages sent in one write or REST request
+	maxBatch = 100
+)
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// "nats", "kafka" or "file", Kafka is reached through Confluent compatible REST proxy
+	Provider string `json:"provider"`
+	// nats://host:4222 or http://rest-proxy:8082
+	Url      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// Event type is appended to it, e.g. pool.block
+	Subject string `json:"subject"`
+	Timeout string `json:"timeout"`
+	// Messages queued in memory, newer ones are dropped while full
+	Buffer int `json:"buffer"`
+	// JSON lines file of "file" provider, rotated to <path>.1, <path>.2... over maxSize megabytes
+	Path     string `json:"path"`
+	MaxSize  int64  `json:"maxSize"`
+	MaxFiles int    `json:"maxFiles"`
+	// Publish every accepted and rejected share, by far the busiest stream
+	Shares bool `json:"shares"`
+}
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	u, err := url.Parse(c.Url)
+	switch c.Provider {
+	case "nats":
+		if err != nil || u.Scheme != "nats" || len(u.Host) == 0 {
+			errs.Addf("eventBus.url: must be nats://host:port")
+		}
+	case "kafka":
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs.Addf("eventBus.url: must be http or https url of Kafka REST proxy")
+		}
+	case "file":
+		if len(c.Path) == 0 {
+			errs.Addf("eventBus.path: required for file provider")
+		}
+		if c.MaxSize < 0 || c.MaxFiles < 0 {
+			errs.Addf("eventBus: maxSize and maxFiles can't be negative")
+		}
+	default:
+		errs.Addf("eventBus.provider: unknown provider %q", c.Provider)
+	}
+	if len(c.Subject) == 0 && c.Provider != "file" {
+		errs.Addf("eventBus.subject: required")
+	}
+	errs.Duration("eventBus.timeout", c.Timeout, true)
+}
+
+// Event types published by pool modules
+const (
+	ShareSample   = "share.sample"
+	Share         = "share"
+	ShareRejected = "share.rejected"
+	Block         = "block"
+	Immature      = "block.immature"
+	Matured       = "block.matured"
+	Orphan        = "block.orphan"
+	Payment       = "payment"
+	Ban           = "ban"
+	ForkAlert     = "fork.alert"
+)
+
+type Message struct {
+	Type      string      `json:"type"`
+	Timestamp int64       `json:"ts"`
+	Data      interface{} `json:"data"`
+}
+
+type sender interface {
+	send(subject string, batch [][]byte) error
+}
+
+type Publisher struct {
+	config  *Config
+	sender  sender
+	queue   chan *Message
+	dropped int64
+}
+
+var publisher *Publisher
+
+func newPublisher(cfg *Config) *Publisher {
+	timeout := defaultTimeout
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	buffer := cfg.Buffer
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+	p := &Publisher{config: cfg, queue: make(chan *Message, buffer)}
+	switch cfg.Provider {
+	case "kafka":
+		p.sender = &kafkaSender{config: cfg, client: &http.Client{Timeout: timeout}}
+	case "file":
+		p.sender = newFileSender(cfg)
+	default:
+		p.sender = &natsSender{config: cfg, timeout: timeout}
+	}
+	return p
+}
+
+// Starts default publisher used by Publish
+func Start(cfg *Config) {
+	p := newPublisher(cfg)
+	go p.run()
+	publisher = p
+	target := cfg.Url
+	if cfg.Provider == "file" {
+		target = cfg.Path
+	}
+	log.Printf("Publishing pool events to %s at %s, shares: %v", cfg.Provider, target, cfg.Shares)
+}
+
+// Callers skip building share events nobody receives
+func SharesEnabled() bool {
+	return publisher != nil && publisher.config.Shares
+}
+
+// Queues event for publication, never blocks caller
+func Publish(eventType string, data interface{}) {
+	if publisher != nil {
+		publisher.publish(eventType, data)
+	}
+}
+
+func (p *Publisher) publish(eventType string, data interface{}) {
+	m := &Message{Type: eventType, Timestamp: util.MakeTimestamp(), Data: data}
+	select {
+	case p.queue <- m:
+	default:
+		atomic.AddInt64(&p.dropped, 1)
+	}
+}
+
+func (p *Publisher) run() {
+	for m := range p.queue {
+		// Messages queued meanwhile go in the same batch
+		batch := []*Message{m}
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case m := <-p.queue:
+				batch = append(batch, m)
+			default:
+				break collect
+			}
+		}
+		var subjects []string
+		groups := make(map[string][][]byte)
+		for _, m := range batch {
+			subject := p.config.Subject + "." + m.Type
+			if _, ok := groups[subject]; !ok {
+				subjects = append(subjects, subject)
+			}
+			data, _ := json.Marshal(m)
+			groups[subject] = append(groups[subject], data)
+		}
+		for _, subject := range subjects {
+			if err := p.sender.send(subject, groups[subject]); err != nil {
+				log.Printf("Failed to publish %v events to %s: %v", len(groups[subject]), subject, err)
+			}
+		}
+		if n := atomic.SwapInt64(&p.dropped, 0); n > 0 {
+			log.Printf("Event bus queue is full, dropped %v events", n)
+		}
+	}
+}
+
+// Core NATS text protocol, connection is reopened on next batch after failure
+type natsSender struct {
+	config  *Config
+	timeout time.Duration
+	mu      sync.Mutex
+	conn    net.Conn
+}
+
+func (s *natsSender) connect() error {
+	u, _ := url.Parse(s.config.Url)
+	conn, err := net.DialTimeout("tcp", u.Host, s.timeout)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(s.timeout))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return fmt.Errorf("unexpected greeting %q: %v", strings.TrimSpace(line), err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "open-etc-pool"}
+	if len(s.config.User) > 0 {
+		opts["user"], opts["pass"] = s.config.User, s.config.Password
+	}
+	data, _ := json.Marshal(opts)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", data); err != nil {
+		conn.Close()
+		return err
+	}
+	s.conn = conn
+	go s.read(conn, reader)
+	return nil
+}
+
+// Server pings idle clients and reports errors, the rest is ignored
+func (s *natsSender) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			s.mu.Lock()
+			if s.conn == conn {
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			s.mu.Lock()
+			conn.SetWriteDeadline(time.Now().Add(s.timeout))
+			conn.Write([]byte("PONG\r\n"))
+			s.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("NATS server error: %s", strings.TrimSpace(line))
+		}
+	}
+}
+
+func (s *natsSender) send(subject string, batch [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, data := range batch {
+		fmt.Fprintf(&buf, "PUB %s %d\r\n", subject, len(data))
+		buf.Write(data)
+		buf.WriteString("\r\n")
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Records are produced with REST proxy v2 JSON embedded format
+type kafkaSender struct {
+	config *Config
+	client *http.Client
+}
+
+func (s *kafkaSender) send(topic string, batch [][]byte) error {
+	records := make([]map[string]json.RawMessage, len(batch))
+	for i, data := range batch {
+		records[i] = map[string]json.RawMessage{"value": data}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"records": records})
+	req, err := http.NewRequest("POST", strings.TrimSuffix(s.config.Url, "/")+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	if len(s.config.User) > 0 {
+		req.SetBasicAuth(s.config.User, s.config.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.New(resp.Status + ": " + string(bytes.TrimSpace(msg)))
+	}
+	return nil
+}
+
+// Appends messages as JSON lines, file is rotated when batch would take it over max size
+type fileSender struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newFileSender(cfg *Config) *fileSender {
+	s := &fileSender{path: cfg.Path, maxSize: cfg.MaxSize << 20, maxFiles: cfg.MaxFiles}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize << 20
+	}
+	if s.maxFiles <= 0 {
+		s.maxFiles = defaultMaxFiles
+	}
+	return s
+}
+
+func (s *fileSender) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size = file, info.Size()
+	return nil
+}
+
+// Shifts <path>.N to <path>.N+1, the oldest one is overwritten
+func (s *fileSender) rotate() error {
+	s.file.Close()
+	s.file = nil
+	for i := s.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSender) send(subject string, batch [][]byte) error {
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for _, data := range batch {
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if s.size > 0 && s.size+int64(buf.Len()) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	return err
+}
@@ -0,0 +1,138 @@
+package eventbus
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNatsPublish(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 8)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\nPING\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- strings.TrimSpace(line)
+		}
+	}()
+
+	p := newPublisher(&Config{Provider: "nats", Url: "nats://" + ln.Addr().String(), User: "pool", Password: "secret", Subject: "pool"})
+	go p.run()
+	p.publish(Ban, map[string]string{"ip": "10.0.0.1"})
+
+	expect := func(prefix string) string {
+		select {
+		case line := <-lines:
+			if !strings.HasPrefix(line, prefix) {
+				t.Fatalf("Expected %q, got %q", prefix, line)
+			}
+			return line
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %q, got nothing", prefix)
+		}
+		return ""
+	}
+	if line := expect("CONNECT "); !strings.Contains(line, `"user":"pool"`) {
+		t.Errorf("Must authenticate, got %v", line)
+	}
+	// PING may be answered before or after the message
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, expect(""))
+	}
+	joined := strings.Join(got, "\n")
+	if !strings.Contains(joined, "PONG") || !strings.Contains(joined, "PUB pool.ban ") {
+		t.Fatalf("Must answer ping and publish to subject, got %v", got)
+	}
+	for _, line := range got {
+		if strings.HasPrefix(line, "{") {
+			var m Message
+			json.Unmarshal([]byte(line), &m)
+			if m.Type != Ban || m.Data.(map[string]interface{})["ip"] != "10.0.0.1" {
+				t.Errorf("Must publish event as JSON, got %v", line)
+			}
+		}
+	}
+}
+
+func TestKafkaPublish(t *testing.T) {
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/topics/pool.block" || r.Header.Get("Content-Type") != "application/vnd.kafka.json.v2+json" {
+			t.Errorf("Unexpected request %v %v", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	p := newPublisher(&Config{Provider: "kafka", Url: srv.URL, Subject: "pool"})
+	go p.run()
+	p.publish(Block, map[string]int64{"height": 100})
+
+	select {
+	case body := <-bodies:
+		if !strings.HasPrefix(body, `{"records":[{"value":{"type":"block",`) || !strings.Contains(body, `"data":{"height":100}`) {
+			t.Errorf("Must produce JSON records, got %v", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Must post records to REST proxy")
+	}
+}
+
+func TestFilePublish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	p := newPublisher(&Config{Provider: "file", Path: path, Shares: true})
+	go p.run()
+	p.publish(Share, map[string]string{"login": "0xa", "result": "valid"})
+
+	var data []byte
+	for i := 0; i < 100 && len(data) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		data, _ = os.ReadFile(path)
+	}
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil || m.Type != Share || !strings.HasSuffix(string(data), "}\n") {
+		t.Fatalf("Must append event as JSON line, got %q", data)
+	}
+
+	s := newFileSender(&Config{Path: path, MaxFiles: 2})
+	s.maxSize = 20
+	for i := 0; i < 4; i++ {
+		if err := s.send("", [][]byte{[]byte(`{"n":` + strconv.Itoa(i) + `}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	current, _ := os.ReadFile(path)
+	first, _ := os.ReadFile(path + ".1")
+	second, _ := os.ReadFile(path + ".2")
+	if string(current) != "{\"n\":2}\n{\"n\":3}\n" || string(first) != "{\"n\":0}\n{\"n\":1}\n" || !strings.HasPrefix(string(second), "{\"type\"") {
+		t.Errorf("Must rotate file over max size, got %q %q %q", current, first, second)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("Must keep only max files")
+	}
+}
@@ -4,42 +4,118 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/yvasiyarov/gorelic"
 
 	"github.com/etclabscore/open-etc-pool/api"
+	"github.com/etclabscore/open-etc-pool/bookkeeping"
+	"github.com/etclabscore/open-etc-pool/eventbus"
+	"github.com/etclabscore/open-etc-pool/metrics"
 	"github.com/etclabscore/open-etc-pool/payouts"
 	"github.com/etclabscore/open-etc-pool/proxy"
+	"github.com/etclabscore/open-etc-pool/statuspage"
 	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/webhooks"
 )
 
 var cfg proxy.Config
 var backend *storage.RedisClient
 
+// Accounting storage, backend itself unless PostgreSQL is configured
+var store storage.Storage
+var proxyServer *proxy.ProxyServer
+
+var replayPath = flag.String("replay", "", "Rebuild redis state from event log file and exit")
+var exportEventsPath = flag.String("export-events", "", "Write event log stream to file for replay and exit")
+var vardiffReport = flag.Bool("vardiff-report", false, "Recommend vardiff settings from recorded share samples and exit")
+var pruneNow = flag.Bool("prune", false, "Prune data past retention periods once and exit")
+var exportPath = flag.String("export", "", "Export balances, payments and blocks to .json file or CSV directory and exit")
+var importPath = flag.String("import", "", "Import balances, payments and blocks from .json file or CSV directory and exit")
+var archiveTenant = flag.String("tenant", "", "Tenant to export or import, pool itself if not set")
+var payoutsAction = flag.String("payouts", "", "Control running payouts of pool and tenants and exit: run, pause or resume")
+
 func startProxy() {
-	s := proxy.NewProxy(&cfg, backend)
-	s.Start()
+	proxyServer = proxy.NewProxy(&cfg, backend, store)
+	go proxyServer.Start()
 }
 
 func startApi() {
-	s := api.NewApiServer(&cfg.Api, backend)
+	if cfg.Api.Meta.Fee == 0 {
+		cfg.Api.Meta.Fee = cfg.BlockUnlocker.PoolFee
+	}
+	if cfg.Api.Meta.MinPayout == 0 {
+		cfg.Api.Meta.MinPayout = cfg.Payouts.Threshold
+	}
+	if len(cfg.Api.Meta.Coin) == 0 {
+		cfg.Api.Meta.Coin = cfg.Coin
+	}
+	rewardsMeta(&cfg.Api.Meta)
+	if cfg.Api.Explorer == (api.Explorer{}) {
+		cfg.Api.Explorer = api.DefaultExplorer(cfg.Network)
+	}
+	s := api.NewApiServer(&cfg.Api, backend, store)
+	for _, t := range cfg.Tenants {
+		if len(t.Domain) == 0 {
+			continue
+		}
+		meta := t.Meta
+		if len(meta.Name) == 0 {
+			meta.Name = t.Name
+		}
+		if meta.Fee == 0 {
+			meta.Fee = t.PoolFee
+		}
+		if meta.MinPayout == 0 {
+			meta.MinPayout = cfg.Payouts.Threshold
+		}
+		if len(meta.Coin) == 0 {
+			meta.Coin = cfg.Coin
+		}
+		rewardsMeta(&meta)
+		s.AddTenant(t.Domain, backend.Namespace(t.Name), meta)
+	}
 	s.Start()
 }
 
 func startBlockUnlocker() {
-	u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, backend, &cfg.Network)
+	u := payouts.NewBlockUnlocker(&cfg.BlockUnlocker, &cfg.Rewards, store, &cfg.Network, cfg.Testnet)
 	u.Start()
+	// Tenants share unlocker settings except fee
+	for _, t := range cfg.Tenants {
+		tenantCfg := cfg.BlockUnlocker
+		tenantCfg.PoolFee = t.PoolFee
+		tenantCfg.PoolFeeAddress = t.PoolFeeAddress
+		tenantCfg.FeeSplit = nil
+		log.Printf("Starting block unlocker for tenant %v", t.Name)
+		payouts.NewBlockUnlocker(&tenantCfg, &cfg.Rewards, store.ForTenant(t.Name), &cfg.Network, cfg.Testnet).Start()
+	}
 }
 
 func startPayoutsProcessor() {
-	u := payouts.NewPayoutsProcessor(&cfg.Payouts, backend)
+	u := payouts.NewPayoutsProcessor(&cfg.Payouts, store, backend, cfg.ExpectedChainId())
 	u.Start()
+	for _, t := range cfg.Tenants {
+		log.Printf("Starting payouts for tenant %v", t.Name)
+		payouts.NewPayoutsProcessor(&cfg.Payouts, store.ForTenant(t.Name), backend, cfg.ExpectedChainId()).Start()
+	}
+}
+
+func startPaymentVerifier() {
+	payouts.NewPaymentVerifier(&cfg.Payouts, store).Start()
+	for _, t := range cfg.Tenants {
+		log.Printf("Starting payment verification for tenant %v", t.Name)
+		payouts.NewPaymentVerifier(&cfg.Payouts, store.ForTenant(t.Name)).Start()
+	}
 }
 
 func startNewrelic() {
@@ -54,8 +130,8 @@ func startNewrelic() {
 
 func readConfig(cfg *proxy.Config) {
 	configFileName := "config.json"
-	if len(os.Args) > 1 {
-		configFileName = os.Args[1]
+	if flag.NArg() > 0 {
+		configFileName = flag.Arg(0)
 	}
 	configFileName, _ = filepath.Abs(configFileName)
 	log.Printf("Loading config: %v", configFileName)
@@ -69,10 +145,161 @@ func readConfig(cfg *proxy.Config) {
 	if err := jsonParser.Decode(&cfg); err != nil {
 		log.Fatal("Config error: ", err.Error())
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid config:\n%v", err)
+	}
+}
+
+func replayEvents(path string) {
+	// Don't append replayed events to the log being replayed
+	cfg.Redis.EventLog.Enabled = false
+	openBackend()
+	total, err := backend.ReplayEvents(path)
+	if err != nil {
+		log.Fatalf("Replay of %s stopped after %v events: %v", path, total, err)
+	}
+	log.Printf("Replayed %v events from %s", total, path)
+}
+
+func exportEvents(path string) {
+	openBackend()
+	total, err := backend.ExportEvents(path)
+	if err != nil {
+		log.Fatalf("Export of events to %s stopped after %v events: %v", path, total, err)
+	}
+	log.Printf("Exported %v events to %s", total, path)
+}
+
+func reportVarDiff() {
+	openBackend()
+	samples, err := backend.GetShareSamples()
+	if err != nil {
+		log.Fatalf("Failed to read share samples: %v", err)
+	}
+	a := proxy.AdviseVarDiff(samples, cfg.Proxy.VarDiff)
+	if a == nil {
+		log.Fatal("No share samples recorded, enable proxy.shareSampling first")
+	}
+	fmt.Printf("Sessions sampled: %v\n", a.Samples)
+	fmt.Printf("Hashrate p10/p50/p99: %.0f / %.0f / %.0f H/s\n", a.HashrateP10, a.HashrateP50, a.HashrateP99)
+	fmt.Printf("Share interval variation p90: %.2f (1.00 is ideal)\n", a.CVP90)
+	fmt.Printf("Recommended varDiff: sharesPerMinute=%v retargetInterval=%v (%v shares) minDiff=%v maxDiff=%v\n",
+		a.SharesPerMinute, a.RetargetInterval, a.WindowShares, a.MinDiff, a.MaxDiff)
+}
+
+func openBackend() {
+	backend = storage.NewRedisClient(&cfg.Redis, cfg.Redis.KeyPrefix(cfg.Coin))
+	backend.SetRewards(&cfg.Rewards)
+}
+
+// Accounting storage of pool, redis backend unless another driver is configured
+func openStorage() {
+	store = backend
+	if cfg.Storage.Driver == "postgres" {
+		pg := storage.NewPostgresClient(&cfg.Storage.Postgres, cfg.Redis.KeyPrefix(cfg.Coin))
+		pg.SetRewards(&cfg.Rewards)
+		store = pg
+	}
+}
+
+// Scheme of rewards is reported unless meta sets its own
+func rewardsMeta(meta *api.Meta) {
+	if len(meta.PayoutScheme) == 0 {
+		meta.PayoutScheme = cfg.Rewards.SchemeName()
+	}
+	if cfg.Rewards.Scheme == storage.SchemePPLNS {
+		meta.PPLNS = &cfg.Rewards.PPLNS
+	}
+}
+
+func newPruner() *storage.Pruner {
+	stores := []storage.Storage{store}
+	for _, t := range cfg.Tenants {
+		stores = append(stores, store.ForTenant(t.Name))
+	}
+	return storage.NewPruner(&cfg.Retention, stores...)
+}
+
+func pruneData() {
+	openBackend()
+	openStorage()
+	if err := newPruner().Prune(); err != nil {
+		log.Fatalf("Pruning failed: %v", err)
+	}
+}
+
+// Same as POST /api/admin/payouts, for hosts without admin API
+func controlPayouts(action string) {
+	openBackend()
+	if err := backend.ControlPayouts(action, "cli"); err != nil {
+		log.Fatalf("Failed to control payouts: %v", err)
+	}
+	log.Printf("Payouts %v requested, running payouts pick it up within 10 seconds", action)
+}
+
+// Storage of pool or tenant selected for export and import
+func archiveStorage() storage.Storage {
+	openBackend()
+	openStorage()
+	if len(*archiveTenant) > 0 {
+		return store.ForTenant(*archiveTenant)
+	}
+	return store
+}
+
+func exportArchive(path string) {
+	a, err := storage.ExportTo(archiveStorage(), path)
+	if err != nil {
+		log.Fatalf("Export to %s failed: %v", path, err)
+	}
+	log.Printf("Exported %v accounts, %v payments and %v blocks to %s", len(a.Accounts), len(a.Payments), len(a.Blocks), path)
+}
+
+func importArchive(path string) {
+	s := archiveStorage()
+	control, err := backend.GetPayoutsControl()
+	if err != nil {
+		log.Fatalf("Failed to get payouts control: %v", err)
+	}
+	result, err := storage.ImportFrom(s, control, path)
+	if err != nil {
+		log.Fatalf("Import from %s failed: %v", path, err)
+	}
+	log.Printf("Imported %v accounts, %v payments and %v blocks from %s", result.Accounts, result.Payments, result.Blocks, path)
 }
 
 func main() {
+	flag.Parse()
 	readConfig(&cfg)
+
+	if len(*replayPath) > 0 {
+		replayEvents(*replayPath)
+		return
+	}
+	if len(*exportEventsPath) > 0 {
+		exportEvents(*exportEventsPath)
+		return
+	}
+	if *vardiffReport {
+		reportVarDiff()
+		return
+	}
+	if *pruneNow {
+		pruneData()
+		return
+	}
+	if len(*exportPath) > 0 {
+		exportArchive(*exportPath)
+		return
+	}
+	if len(*importPath) > 0 {
+		importArchive(*importPath)
+		return
+	}
+	if len(*payoutsAction) > 0 {
+		controlPayouts(*payoutsAction)
+		return
+	}
 	rand.Seed(time.Now().UnixNano())
 
 	if cfg.Threads > 0 {
@@ -82,16 +309,33 @@ func main() {
 
 	startNewrelic()
 
-	backend = storage.NewRedisClient(&cfg.Redis, cfg.Coin)
+	if cfg.StatusPage.Enabled {
+		statuspage.Start(&cfg.StatusPage)
+	}
+	if cfg.EventBus.Enabled {
+		eventbus.Start(&cfg.EventBus)
+	}
+	if cfg.Metrics.Enabled {
+		metrics.Start(&cfg.Metrics)
+	}
+	if cfg.Webhooks.Enabled {
+		webhooks.Start(&cfg.Webhooks)
+	}
+
+	openBackend()
 	pong, err := backend.Check()
 	if err != nil {
 		log.Printf("Can't establish connection to backend: %v", err)
 	} else {
 		log.Printf("Backend check reply: %v", pong)
 	}
+	openStorage()
+	if cfg.Bookkeeping.Enabled {
+		bookkeeping.Start(&cfg.Bookkeeping, backend)
+	}
 
 	if cfg.Proxy.Enabled {
-		go startProxy()
+		startProxy()
 	}
 	if cfg.Api.Enabled {
 		go startApi()
@@ -102,6 +346,18 @@ func main() {
 	if cfg.Payouts.Enabled {
 		go startPayoutsProcessor()
 	}
-	quit := make(chan bool)
-	<-quit
+	if cfg.Payouts.Verify.Enabled {
+		go startPaymentVerifier()
+	}
+	if cfg.Retention.Enabled {
+		go newPruner().Start()
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigc
+	log.Printf("Received %v, shutting down", sig)
+	if proxyServer != nil {
+		proxyServer.Shutdown()
+	}
 }
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Run metrics of scheduled modules, which don't serve HTTP themselves. They are pushed
+// to Prometheus Pushgateway and/or stored in redis for /metrics of API.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// Pushgateway base url, e.g. http://127.0.0.1:9091, leave empty to not push
+	Pushgateway string `json:"pushgateway"`
+	Timeout     string `json:"timeout"`
+	// Instance grouping label on Pushgateway, defaults to hostname
+	Instance string `json:"instance"`
+	// Store metrics of last run in redis, exposed by API on /metrics
+	Redis bool `json:"redis"`
+}
+
+// Job names of pool modules
+const (
+	Unlocker = "unlocker"
+	Payouts  = "payouts"
+)
+
+// Backend of module, run metrics of tenants are kept apart
+type Store interface {
+	Tenant() string
+	WriteJobMetrics(job string, values map[string]float64) error
+}
+
+type pusher struct {
+	config   *Config
+	client   *http.Client
+	instance string
+}
+
+var defaultPusher *pusher
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if len(c.Pushgateway) == 0 && !c.Redis {
+		errs.Addf("metrics: either pushgateway or redis must be set")
+	}
+	if len(c.Pushgateway) > 0 {
+		if u, err := url.Parse(c.Pushgateway); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs.Addf("metrics.pushgateway: invalid url %q", c.Pushgateway)
+		}
+	}
+	errs.Duration("metrics.timeout", c.Timeout, true)
+}
+
+// Starts default pusher used by runs
+func Start(cfg *Config) {
+	p := &pusher{config: cfg, client: &http.Client{Timeout: 10 * time.Second}, instance: cfg.Instance}
+	if len(cfg.Timeout) > 0 {
+		p.client.Timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	if len(p.instance) == 0 {
+		p.instance, _ = os.Hostname()
+	}
+	defaultPusher = p
+	log.Printf("Publishing run metrics of scheduled modules, pushgateway: %q, redis: %v", cfg.Pushgateway, cfg.Redis)
+}
+
+// Metrics of a single run of module, collected while it runs
+type Run struct {
+	job     string
+	store   Store
+	started time.Time
+	values  map[string]float64
+}
+
+// Run is nil if metrics are not started, its methods are no-op then
+func NewRun(job string, store Store) *Run {
+	if defaultPusher == nil {
+		return nil
+	}
+	return &Run{job: job, store: store, started: time.Now(), values: make(map[string]float64)}
+}
+
+func (r *Run) Set(name string, value float64) {
+	if r != nil {
+		r.values[name] = value
+	}
+}
+
+func (r *Run) Add(name string, value float64) {
+	if r != nil {
+		r.values[name] += value
+	}
+}
+
+// Records duration and outcome of run and publishes its metrics
+func (r *Run) Done(success bool) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	r.values["last_run_timestamp_seconds"] = float64(now.Unix())
+	r.values["duration_seconds"] = now.Sub(r.started).Seconds()
+	r.values["success"] = 0
+	if success {
+		r.values["success"] = 1
+	}
+	defaultPusher.publish(r)
+}
+
+func (p *pusher) publish(r *Run) {
+	if p.config.Redis {
+		if err := r.store.WriteJobMetrics(r.job, r.values); err != nil {
+			log.Printf("Failed to write %s run metrics to backend: %v", r.job, err)
+		}
+	}
+	if len(p.config.Pushgateway) > 0 {
+		if err := p.push(r.job, r.store.Tenant(), r.values); err != nil {
+			log.Printf("Failed to push %s run metrics to pushgateway: %v", r.job, err)
+		}
+	}
+}
+
+// Replaces metrics of job group, so metrics of previous run don't linger
+func (p *pusher) push(job, tenant string, values map[string]float64) error {
+	var body bytes.Buffer
+	Write(&body, job, values)
+	path := fmt.Sprintf("%s/metrics/job/%s/instance/%s", strings.TrimRight(p.config.Pushgateway, "/"),
+		url.PathEscape(job), url.PathEscape(p.instance))
+	if len(tenant) > 0 {
+		path += "/tenant/" + url.PathEscape(tenant)
+	}
+	req, err := http.NewRequest("PUT", path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// Writes values as gauges in Prometheus text format named pool_<job>_<name>
+func Write(w io.Writer, job string, values map[string]float64) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		metric := "pool_" + job + "_" + name
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %v\n", metric, metric, values[name])
+	}
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testStore struct {
+	tenant string
+	jobs   map[string]map[string]float64
+}
+
+func (s *testStore) Tenant() string {
+	return s.tenant
+}
+
+func (s *testStore) WriteJobMetrics(job string, values map[string]float64) error {
+	s.jobs[job] = values
+	return nil
+}
+
+func TestRunWithoutStart(t *testing.T) {
+	defaultPusher = nil
+	run := NewRun(Unlocker, &testStore{})
+	if run != nil {
+		t.Fatal("Must not collect metrics if not started")
+	}
+	run.Set("orphans", 1)
+	run.Done(true)
+}
+
+func TestRunPublish(t *testing.T) {
+	var path, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("Must replace metrics group, got %v", r.Method)
+		}
+		path = r.URL.Path
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer srv.Close()
+
+	Start(&Config{Enabled: true, Pushgateway: srv.URL, Instance: "pool1", Redis: true})
+	defer func() { defaultPusher = nil }()
+
+	store := &testStore{tenant: "alpha", jobs: make(map[string]map[string]float64)}
+	run := NewRun(Payouts, store)
+	run.Add("miners_paid", 2)
+	run.Add("miners_paid", 1)
+	run.Done(false)
+
+	if path != "/metrics/job/payouts/instance/pool1/tenant/alpha" {
+		t.Errorf("Must push to group of job, instance and tenant, got %v", path)
+	}
+	if !strings.Contains(body, "# TYPE pool_payouts_miners_paid gauge\npool_payouts_miners_paid 3\n") {
+		t.Errorf("Must push metrics in text format, got %q", body)
+	}
+	if !strings.Contains(body, "pool_payouts_success 0\n") || !strings.Contains(body, "pool_payouts_last_run_timestamp_seconds") {
+		t.Errorf("Must push outcome and time of run, got %q", body)
+	}
+	if values := store.jobs[Payouts]; values["miners_paid"] != 3 || values["success"] != 0 {
+		t.Errorf("Must store metrics of run in backend, got %v", values)
+	}
+}
@@ -0,0 +1,319 @@
+package payouts
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"golang.org/x/crypto/sha3"
+
+	"github.com/etclabscore/open-etc-pool/bookkeeping"
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+	"github.com/etclabscore/open-etc-pool/webhooks"
+)
+
+const (
+	defaultBatchSize       = 100
+	defaultGasPerRecipient = "40000"
+)
+
+// ABI of multisend contract, see docs/PAYOUTS.md
+var (
+	multisendSelector = keccak("multisend(address[],uint256[])")[:4]
+	sentTopic         = hexutil.Encode(keccak("Sent(address,uint256)"))
+	failedTopic       = hexutil.Encode(keccak("Failed(address,uint256)"))
+)
+
+type BatchConfig struct {
+	Enabled bool `json:"enabled"`
+	// Multisend contract, it refunds transfers that failed
+	Contract string `json:"contract"`
+	// Recipients per transaction, 100 if not set
+	MaxRecipients int `json:"maxRecipients"`
+	// Gas limit is "gas" plus this for every recipient, unless autoGas is on, 40000 if not set
+	GasPerRecipient string `json:"gasPerRecipient"`
+}
+
+func (c *BatchConfig) Validate(errs *util.ConfigErrors) {
+	if !util.IsValidHexAddress(c.Contract) {
+		errs.Addf("payouts.batch.contract: must be address of multisend contract, got %q", c.Contract)
+	}
+	if c.MaxRecipients < 0 {
+		errs.Addf("payouts.batch.maxRecipients: can't be negative")
+	}
+	if len(c.GasPerRecipient) > 0 {
+		if n, ok := new(big.Int).SetString(c.GasPerRecipient, 0); !ok || n.Sign() < 0 {
+			errs.Addf("payouts.batch.gasPerRecipient: must be amount of gas, got %q", c.GasPerRecipient)
+		}
+	}
+}
+
+func (c *BatchConfig) size() int {
+	if c.MaxRecipients > 0 {
+		return c.MaxRecipients
+	}
+	return defaultBatchSize
+}
+
+func (c *BatchConfig) gasPerRecipient() *big.Int {
+	if len(c.GasPerRecipient) > 0 {
+		return util.String2Big(c.GasPerRecipient)
+	}
+	return util.String2Big(defaultGasPerRecipient)
+}
+
+type batchPayment struct {
+	login  string
+	amount int64
+}
+
+// Transfer of batch logged by contract
+type batchTransfer struct {
+	amount *big.Int
+	sent   bool
+}
+
+func keccak(s string) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// ABI word, left padded
+func word(b []byte) []byte {
+	w := make([]byte, 32)
+	copy(w[32-len(b):], b)
+	return w
+}
+
+// Call input of multisend(address[] recipients, uint256[] amounts), amounts in Wei
+func encodeMultisend(payments []*batchPayment) string {
+	n := int64(len(payments))
+	data := append([]byte{}, multisendSelector...)
+	// Offsets of both dynamic arrays, then length and items of each
+	data = append(data, word(big.NewInt(64).Bytes())...)
+	data = append(data, word(big.NewInt(64+32+32*n).Bytes())...)
+	data = append(data, word(big.NewInt(n).Bytes())...)
+	for _, p := range payments {
+		address, _ := hex.DecodeString(strings.TrimPrefix(strings.ToLower(p.login), "0x"))
+		data = append(data, word(address)...)
+	}
+	data = append(data, word(big.NewInt(n).Bytes())...)
+	for _, p := range payments {
+		data = append(data, word(new(big.Int).Mul(big.NewInt(p.amount), util.Shannon).Bytes())...)
+	}
+	return hexutil.Encode(data)
+}
+
+// Sent and Failed events of contract by lowercase recipient
+func batchTransfers(receipt *rpc.TxReceipt, contract string) map[string]*batchTransfer {
+	result := make(map[string]*batchTransfer)
+	for _, l := range receipt.Logs {
+		if !strings.EqualFold(l.Address, contract) || len(l.Topics) < 2 {
+			continue
+		}
+		topic := strings.ToLower(l.Topics[0])
+		indexed := strings.ToLower(strings.TrimPrefix(l.Topics[1], "0x"))
+		if (topic != sentTopic && topic != failedTopic) || len(indexed) != 64 {
+			continue
+		}
+		recipient := "0x" + indexed[24:]
+		result[recipient] = &batchTransfer{amount: util.String2Big(l.Data), sent: topic == sentTopic}
+	}
+	return result
+}
+
+// Pays due miners in batches, returns number of paid miners and amount in Shannon
+func (u *PayoutsProcessor) payBatches(payments []*batchPayment) (int, int64) {
+	var paid int
+	var total int64
+	size := u.config.Batch.size()
+	for len(payments) > 0 {
+		n := size
+		if n > len(payments) {
+			n = len(payments)
+		}
+		count, amount, ok := u.payBatch(payments[:n])
+		paid += count
+		total += amount
+		if !ok {
+			break
+		}
+		payments = payments[n:]
+	}
+	return paid, total
+}
+
+// Locks payouts for the whole batch, debits balances and sends one call of contract.
+// Recipients whose transfer failed are credited back. False if payouts have to stop.
+func (u *PayoutsProcessor) payBatch(payments []*batchPayment) (int, int64, bool) {
+	contract := u.config.Batch.Contract
+	var total int64
+	for _, p := range payments {
+		total += p.amount
+	}
+	totalInWei := new(big.Int).Mul(big.NewInt(total), util.Shannon)
+	if !u.canPay(totalInWei) {
+		return 0, 0, false
+	}
+	maxFee, priorityFee, ok := u.fees()
+	if !ok {
+		return 0, 0, false
+	}
+
+	if err := u.backend.LockPayouts(contract, total); err != nil {
+		log.Printf("Failed to lock batch payment of %v Shannon: %v", total, err)
+		u.halt = true
+		u.lastFail = err
+		return 0, 0, false
+	}
+	log.Printf("Locked batch payment to %v miners, %v Shannon", len(payments), total)
+	for _, p := range payments {
+		if err := u.backend.UpdateBalance(p.login, p.amount); err != nil {
+			log.Printf("Failed to update balance for %s, %v Shannon: %v", p.login, p.amount, err)
+			u.halt = true
+			u.lastFail = err
+			return 0, 0, false
+		}
+	}
+
+	gas := util.String2Big(u.config.Gas)
+	gas.Add(gas, new(big.Int).Mul(u.config.Batch.gasPerRecipient(), big.NewInt(int64(len(payments)))))
+	req := u.txRequest(contract, totalInWei, hexutil.EncodeBig(gas), maxFee, priorityFee)
+	req.Data = encodeMultisend(payments)
+	if u.signer != nil {
+		recipients := make(map[string]int64)
+		for _, p := range payments {
+			recipients[p.login] = p.amount
+		}
+		return u.payTracked(&storage.PaymentTx{Login: contract, Amount: total, Recipients: recipients}, req)
+	}
+	txHash, err := u.rpc.Send(req)
+	if err != nil {
+		log.Printf("Failed to send batch payment of %v Shannon to %v miners: %v. Check outgoing tx to %s in block explorer and docs/PAYOUTS.md",
+			total, len(payments), err, contract)
+		u.halt = true
+		u.lastFail = err
+		return 0, 0, false
+	}
+	log.Printf("Sent batch payment of %v Shannon to %v miners, TxHash: %v", total, len(payments), txHash)
+	u.batchSent(payments, txHash)
+	receipt, err := u.waitReceipt(txHash)
+	if err != nil {
+		log.Printf("%v. Batch payment stays pending, check outgoing txs of %s in block explorer and docs/PAYOUTS.md",
+			err, u.config.Address)
+		u.halt = true
+		u.lastFail = err
+		return 0, 0, false
+	}
+	return u.settleBatch(payments, receipt)
+}
+
+// Records transfers of confirmed batch transaction, recipients whose transfer failed are credited back.
+// Everyone is credited back if it was dropped, nil receipt. Payouts are unlocked unless they have to stop.
+func (u *PayoutsProcessor) settleBatch(payments []*batchPayment, receipt *rpc.TxReceipt) (int, int64, bool) {
+	if receipt == nil {
+		log.Printf("Batch payment to %v miners was dropped, crediting balances back", len(payments))
+		for _, p := range payments {
+			u.refund(p, "")
+		}
+		if u.halt {
+			return 0, 0, false
+		}
+		if err := u.backend.UnlockPayouts(); err != nil {
+			log.Println("Failed to unlock payouts:", err)
+			u.halt = true
+			u.lastFail = err
+			return 0, 0, false
+		}
+		return 0, 0, true
+	}
+	contract := u.config.Batch.Contract
+	txHash := receipt.TxHash
+	// Reverted call moved no funds
+	if !receipt.Successful() {
+		u.halt = true
+		u.lastFail = fmt.Errorf("batch payment tx %s reverted", txHash)
+		log.Printf("Batch payment tx reverted: %s, crediting balances back", txHash)
+		for _, p := range payments {
+			u.refund(p, txHash)
+		}
+		u.backend.UnlockPayouts()
+		return 0, 0, false
+	}
+
+	transfers := batchTransfers(receipt, contract)
+	var paid int
+	var amount int64
+	recipients := make(map[string]int64)
+	for _, p := range payments {
+		transfer, ok := transfers[strings.ToLower(p.login)]
+		if !ok {
+			// Leave it pending, payouts can't tell if it was paid
+			u.halt = true
+			u.lastFail = fmt.Errorf("batch payment tx %s has no transfer to %s", txHash, p.login)
+			log.Println(u.lastFail)
+			continue
+		}
+		if !transfer.sent {
+			log.Printf("Batch transfer to %s failed in tx %s, crediting %v Shannon back", p.login, txHash, p.amount)
+			u.refund(p, txHash)
+			continue
+		}
+		// Lock is held until whole batch is written
+		if err := u.backend.WriteBatchPayment(p.login, txHash, p.amount); err != nil {
+			log.Printf("Failed to log payment data for %s, %v Shannon, tx: %s: %v", p.login, p.amount, txHash, err)
+			u.halt = true
+			u.lastFail = err
+			continue
+		}
+		bookkeeping.Record(bookkeeping.PaymentEntry(u.backend.Tenant(), p.login, txHash, p.amount))
+		webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentConfirmed, u.backend.Tenant(), p.login, txHash, p.amount))
+		recipients[p.login] = p.amount
+		paid++
+		amount += p.amount
+	}
+	receiptRecord := u.paymentReceipt(contract, amount, receipt)
+	receiptRecord.Recipients = recipients
+	if err := u.backend.WritePaymentReceipt(receiptRecord); err != nil {
+		log.Printf("Failed to write payment receipt for batch tx %s: %v", txHash, err)
+	}
+	if u.halt {
+		return paid, amount, false
+	}
+	if err := u.backend.UnlockPayouts(); err != nil {
+		log.Println("Failed to unlock payouts:", err)
+		u.halt = true
+		u.lastFail = err
+		return paid, amount, false
+	}
+	log.Printf("Paid %v Shannon to %v of %v miners in batch tx %v", amount, paid, len(payments), txHash)
+	return paid, amount, true
+}
+
+// Pending payment is returned to balance, failure leaves it for RESOLVE_PAYOUT.
+// Transaction hash is empty if it was dropped.
+func (u *PayoutsProcessor) refund(p *batchPayment, txHash string) {
+	if err := u.backend.RollbackBalance(p.login, p.amount); err != nil {
+		log.Printf("Failed to credit %v Shannon back to %s: %v", p.amount, p.login, err)
+		u.halt = true
+		u.lastFail = err
+		return
+	}
+	webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentFailed, u.backend.Tenant(), p.login, txHash, p.amount))
+}
+
+func (u *PayoutsProcessor) batchSent(payments []*batchPayment, txHash string) {
+	e := webhooks.PaymentEvent(webhooks.PaymentSent, u.backend.Tenant(), u.config.Batch.Contract, txHash, 0)
+	e.Recipients = make(map[string]int64)
+	for _, p := range payments {
+		e.Recipients[p.login] = p.amount
+		e.Amount += p.amount
+	}
+	webhooks.Send(e)
+}
@@ -0,0 +1,58 @@
+package payouts
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+func TestEncodeMultisend(t *testing.T) {
+	if selector := hex.EncodeToString(keccak("transfer(address,uint256)")[:4]); selector != "a9059cbb" {
+		t.Fatalf("Must hash ABI signatures with keccak256, got %v", selector)
+	}
+	data := encodeMultisend([]*batchPayment{{login: "0x00000000000000000000000000000000000000aa", amount: 1},
+		{login: "0x00000000000000000000000000000000000000BB", amount: 2}})
+	words := strings.TrimPrefix(data, "0x")[8:]
+	if len(words) != 64*8 {
+		t.Fatalf("Must encode offsets, lengths and items of both arrays, got %v words", len(words)/64)
+	}
+	expected := []string{"40", "a0", "2", "aa", "bb", "2", "3b9aca00", "77359400"}
+	for i, v := range expected {
+		if w := strings.TrimLeft(words[i*64:(i+1)*64], "0"); w != v {
+			t.Errorf("Must encode word %v as %v, got %v", i, v, w)
+		}
+	}
+}
+
+func TestBatchTransfers(t *testing.T) {
+	recipient := "0x000000000000000000000000" + "00000000000000000000000000000000000000aa"
+	receipt := &rpc.TxReceipt{BlockHash: "0x2", Status: "0x1", Logs: []rpc.Log{
+		{Address: "0xMultisend", Topics: []string{sentTopic, recipient}, Data: "0x3b9aca00"},
+		{Address: "0xmultisend", Topics: []string{failedTopic, strings.Replace(recipient, "aa", "bb", 1)}, Data: "0x1"},
+		{Address: "0xother", Topics: []string{sentTopic, strings.Replace(recipient, "aa", "cc", 1)}, Data: "0x1"},
+	}}
+	transfers := batchTransfers(receipt, "0xmultisend")
+	if len(transfers) != 2 {
+		t.Fatalf("Must only take events of multisend contract, got %v", transfers)
+	}
+	if tr := transfers["0x00000000000000000000000000000000000000aa"]; tr == nil || !tr.sent || tr.amount.Int64() != 1000000000 {
+		t.Errorf("Must parse sent transfer, got %v", tr)
+	}
+	if tr := transfers["0x00000000000000000000000000000000000000bb"]; tr == nil || tr.sent {
+		t.Errorf("Must parse failed transfer, got %v", tr)
+	}
+
+	v := &PaymentVerifier{batchContract: "0xmultisend"}
+	tx := &rpc.Tx{From: "0xpool", To: "0xMultisend", Value: "0x3b9aca01"}
+	p := &storage.Payment{TxHash: "0x1", Address: "0x00000000000000000000000000000000000000AA", Amount: 1}
+	if reason := v.check(p, tx, receipt); len(reason) > 0 {
+		t.Errorf("Must accept payment sent by batch contract, got %v", reason)
+	}
+	p.Address = "0x00000000000000000000000000000000000000bb"
+	if reason := v.check(p, tx, receipt); reason != "not sent by batch contract" {
+		t.Errorf("Must report failed batch transfer, got %v", reason)
+	}
+}
@@ -0,0 +1,80 @@
+package payouts
+
+import (
+	"log"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Exchanges and smart wallets may need more gas than plain transfer or reject payments
+type ContractsConfig struct {
+	// Look up code of every recipient by eth_getCode before paying it
+	Check bool `json:"check"`
+	// Gas limit of payments to contracts, "gas" if not set. Ignored with autoGas.
+	Gas string `json:"gas"`
+	// Keep payments to contracts on balance for manual review instead of sending them
+	Hold bool `json:"hold"`
+	// Reviewed contracts paid despite hold
+	Allow []string `json:"allow"`
+}
+
+func (c *ContractsConfig) Validate(errs *util.ConfigErrors) {
+	if len(c.Gas) > 0 {
+		if n, ok := new(big.Int).SetString(c.Gas, 0); !ok || n.Sign() <= 0 {
+			errs.Addf("payouts.contracts.gas: must be amount of gas, got %q", c.Gas)
+		}
+	}
+	for i, address := range c.Allow {
+		if !util.IsValidHexAddress(address) {
+			errs.Addf("payouts.contracts.allow[%v]: invalid address %q", i, address)
+		}
+	}
+}
+
+func (c *ContractsConfig) allowed(login string) bool {
+	for _, address := range c.Allow {
+		if strings.EqualFold(address, login) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gas limit of payment to recipient, false if it is held for review or can't be checked.
+// Contract recipients are paid one by one, never in batch.
+func (u *PayoutsProcessor) recipientGas(login string, amount int64) (string, bool, bool) {
+	gas := u.config.GasHex()
+	if !u.config.Contracts.Check {
+		return gas, false, true
+	}
+	code, err := u.rpc.GetCode(login, "latest")
+	if err != nil {
+		log.Printf("Failed to check code of %s, skipping payment: %v", login, err)
+		return "", false, false
+	}
+	if len(code) <= 2 {
+		return gas, false, true
+	}
+	if u.config.Contracts.Hold && !u.config.Contracts.allowed(login) {
+		log.Printf("Holding payment of %v Shannon to contract %s for review, add it to payouts.contracts.allow to pay it",
+			amount, login)
+		if err := u.backend.WriteHeldPayment(login, amount); err != nil {
+			log.Printf("Failed to write held payment of %s to backend: %v", login, err)
+		}
+		return "", true, false
+	}
+	// Contract may have been held before it was allowed
+	if u.config.Contracts.Hold {
+		if err := u.backend.DeleteHeldPayment(login); err != nil {
+			log.Printf("Failed to remove held payment of %s from backend: %v", login, err)
+		}
+	}
+	if len(u.config.Contracts.Gas) > 0 {
+		gas = hexutil.EncodeBig(util.String2Big(u.config.Contracts.Gas))
+	}
+	return gas, true, true
+}
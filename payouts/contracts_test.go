@@ -0,0 +1,70 @@
+package payouts
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Records held payments only, other storage calls aren't expected
+type heldStorage struct {
+	storage.Storage
+	held map[string]int64
+}
+
+func (s *heldStorage) WriteHeldPayment(login string, amount int64) error {
+	s.held[login] = amount
+	return nil
+}
+
+func (s *heldStorage) DeleteHeldPayment(login string) error {
+	delete(s.held, login)
+	return nil
+}
+
+func TestRecipientGas(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []string `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		code := "0x"
+		if req.Params[0] != "0x0000000000000000000000000000000000000001" {
+			code = "0x6080"
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":"` + code + `"}`))
+	}))
+	defer srv.Close()
+	backend := &heldStorage{held: make(map[string]int64)}
+	u := &PayoutsProcessor{config: &PayoutsConfig{Gas: "21000"}, rpc: rpc.NewRPCClient("test", srv.URL, "1s"), backend: backend}
+	wallet, contract := "0x0000000000000000000000000000000000000001", "0x0000000000000000000000000000000000000002"
+
+	if gas, isContract, ok := u.recipientGas(contract, 1); !ok || isContract || gas != "0x5208" {
+		t.Errorf("Must not check code unless enabled, got %v %v %v", gas, isContract, ok)
+	}
+	u.config.Contracts = ContractsConfig{Check: true, Gas: "100000"}
+	if gas, isContract, ok := u.recipientGas(wallet, 1); !ok || isContract || gas != "0x5208" {
+		t.Errorf("Must pay account without code with default gas, got %v %v %v", gas, isContract, ok)
+	}
+	if gas, isContract, ok := u.recipientGas(contract, 1); !ok || !isContract || gas != "0x186a0" {
+		t.Errorf("Must pay contract with contract gas, got %v %v %v", gas, isContract, ok)
+	}
+	u.config.Contracts.Hold = true
+	if _, isContract, ok := u.recipientGas(contract, 1); ok || !isContract {
+		t.Error("Must hold payment to contract")
+	}
+	if backend.held[contract] != 1 {
+		t.Errorf("Must store held payment for review, got %v", backend.held)
+	}
+	u.config.Contracts.Allow = []string{"0x0000000000000000000000000000000000000002"}
+	if _, _, ok := u.recipientGas(contract, 1); !ok {
+		t.Error("Must pay reviewed contract")
+	}
+	if len(backend.held) != 0 {
+		t.Errorf("Must remove reviewed contract from held payments, got %v", backend.held)
+	}
+}
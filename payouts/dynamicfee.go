@@ -0,0 +1,78 @@
+package payouts
+
+import (
+	"log"
+	"math/big"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Priority fee if not configured, 1 Gwei
+var defaultPriorityFee = big.NewInt(1000000000)
+
+// Payments are sent as EIP-1559 transactions, all values are in Wei
+type DynamicFeeConfig struct {
+	Enabled bool `json:"enabled"`
+	// Twice base fee of latest block plus priority fee if not set
+	MaxFeePerGas string `json:"maxFeePerGas"`
+	// Tip to block producer, 1 Gwei if not set
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+	// Payouts are deferred while base fee plus priority fee is above this, max fee never exceeds it
+	FeeCap string `json:"feeCap"`
+}
+
+func (c *DynamicFeeConfig) Validate(errs *util.ConfigErrors) {
+	fields := []struct{ name, value string }{{"maxFeePerGas", c.MaxFeePerGas},
+		{"maxPriorityFeePerGas", c.MaxPriorityFeePerGas}, {"feeCap", c.FeeCap}}
+	for _, field := range fields {
+		if len(field.value) == 0 {
+			continue
+		}
+		if n, ok := new(big.Int).SetString(field.value, 0); !ok || n.Sign() <= 0 {
+			errs.Addf("payouts.dynamicFee.%s: must be positive amount in Wei, got %q", field.name, field.value)
+		}
+	}
+}
+
+// Max fee and priority fee per gas for given base fee, false if network is too expensive
+func (c *DynamicFeeConfig) fees(baseFee *big.Int) (*big.Int, *big.Int, bool) {
+	priorityFee := defaultPriorityFee
+	if len(c.MaxPriorityFeePerGas) > 0 {
+		priorityFee = util.String2Big(c.MaxPriorityFeePerGas)
+	}
+	var maxFee *big.Int
+	if len(c.MaxFeePerGas) > 0 {
+		maxFee = util.String2Big(c.MaxFeePerGas)
+	} else {
+		maxFee = new(big.Int).Add(new(big.Int).Mul(baseFee, big.NewInt(2)), priorityFee)
+	}
+	if len(c.FeeCap) > 0 {
+		feeCap := util.String2Big(c.FeeCap)
+		if new(big.Int).Add(baseFee, priorityFee).Cmp(feeCap) > 0 {
+			return nil, nil, false
+		}
+		if maxFee.Cmp(feeCap) > 0 {
+			maxFee = feeCap
+		}
+	}
+	// Node refuses transaction whose priority fee is above max fee
+	if priorityFee.Cmp(maxFee) > 0 {
+		priorityFee = maxFee
+	}
+	return maxFee, priorityFee, true
+}
+
+// Fees of next payment from base fee of latest block, false if payouts have to wait
+func (u *PayoutsProcessor) dynamicFees() (*big.Int, *big.Int, bool) {
+	baseFee, err := u.rpc.GetBaseFee()
+	if err != nil {
+		log.Println("Unable to process payouts, failed to get base fee from node:", err)
+		return nil, nil, false
+	}
+	maxFee, priorityFee, ok := u.config.DynamicFee.fees(baseFee)
+	if !ok {
+		log.Printf("Deferring payouts, base fee %v Wei is above fee cap %v Wei", baseFee, u.config.DynamicFee.FeeCap)
+		u.run.Set("deferred", 1)
+	}
+	return maxFee, priorityFee, ok
+}
@@ -0,0 +1,31 @@
+package payouts
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestDynamicFees(t *testing.T) {
+	cfg := &DynamicFeeConfig{Enabled: true}
+	maxFee, priorityFee, ok := cfg.fees(big.NewInt(10000000000))
+	if !ok || maxFee.Int64() != 21000000000 || priorityFee.Int64() != 1000000000 {
+		t.Errorf("Must estimate max fee from base fee, got %v %v %v", maxFee, priorityFee, ok)
+	}
+
+	cfg = &DynamicFeeConfig{Enabled: true, MaxPriorityFeePerGas: "2000000000", FeeCap: "15000000000"}
+	maxFee, priorityFee, ok = cfg.fees(big.NewInt(10000000000))
+	if !ok || maxFee.Int64() != 15000000000 || priorityFee.Int64() != 2000000000 {
+		t.Errorf("Must limit max fee by fee cap, got %v %v %v", maxFee, priorityFee, ok)
+	}
+	if _, _, ok = cfg.fees(big.NewInt(14000000000)); ok {
+		t.Error("Must defer payouts while base fee and priority fee are above fee cap")
+	}
+
+	var errs util.ConfigErrors
+	(&DynamicFeeConfig{MaxFeePerGas: "a lot", FeeCap: "0"}).Validate(&errs)
+	if len(errs) != 2 {
+		t.Errorf("Must require positive amounts, got %v", errs)
+	}
+}
@@ -0,0 +1,358 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+	"github.com/etclabscore/open-etc-pool/webhooks"
+)
+
+const (
+	defaultStuckTimeout = 10 * time.Minute
+	defaultBumpPercent  = 20
+	defaultMaxBumps     = 5
+	// Nodes refuse replacement with lower raise
+	minBumpPercent = 10
+)
+
+// Payment signed by pool which is not mined in timeout is replaced by the same
+// nonce with raised fees, after maxBumps replacements it is only rebroadcast
+type StuckConfig struct {
+	// 10m if not set
+	Timeout string `json:"timeout"`
+	// 20 if not set
+	BumpPercent int64 `json:"bumpPercent"`
+	// 5 if not set
+	MaxBumps int `json:"maxBumps"`
+}
+
+func (c *StuckConfig) Validate(errs *util.ConfigErrors) {
+	errs.Duration("payouts.stuck.timeout", c.Timeout, true)
+	if c.BumpPercent != 0 && c.BumpPercent < minBumpPercent {
+		errs.Addf("payouts.stuck.bumpPercent: must be at least %v, got %v", minBumpPercent, c.BumpPercent)
+	}
+	if c.MaxBumps < 0 {
+		errs.Addf("payouts.stuck.maxBumps: can't be negative")
+	}
+}
+
+func (c *StuckConfig) timeout() time.Duration {
+	if len(c.Timeout) > 0 {
+		return util.MustParseDuration(c.Timeout)
+	}
+	return defaultStuckTimeout
+}
+
+func (c *StuckConfig) bumpPercent() int64 {
+	if c.BumpPercent > 0 {
+		return c.BumpPercent
+	}
+	return defaultBumpPercent
+}
+
+func (c *StuckConfig) maxBumps() int {
+	if c.MaxBumps > 0 {
+		return c.MaxBumps
+	}
+	return defaultMaxBumps
+}
+
+// Payouts of tenants share address, nonce is taken from node until tx is broadcast
+var nonceMu sync.Mutex
+
+func rawTxHash(raw string) string {
+	data, err := hexutil.Decode(raw)
+	if err != nil {
+		return ""
+	}
+	return crypto.Keccak256Hash(data).Hex()
+}
+
+// Raises fees of replacement, rounding up. False if it would exceed fee cap.
+func (u *PayoutsProcessor) bumpFees(req *rpc.TxRequest) bool {
+	pct := big.NewInt(100 + u.config.Stuck.bumpPercent())
+	bump := func(s string) *big.Int {
+		n := new(big.Int).Mul(util.String2Big(s), pct)
+		n.Add(n, big.NewInt(99))
+		return n.Quo(n, big.NewInt(100))
+	}
+	if len(req.MaxFeePerGas) == 0 {
+		req.GasPrice = hexutil.EncodeBig(bump(req.GasPrice))
+		return true
+	}
+	maxFee := bump(req.MaxFeePerGas)
+	if cap := u.config.DynamicFee.FeeCap; len(cap) > 0 && maxFee.Cmp(util.String2Big(cap)) > 0 {
+		return false
+	}
+	req.MaxFeePerGas = hexutil.EncodeBig(maxFee)
+	req.MaxPriorityFeePerGas = hexutil.EncodeBig(bump(req.MaxPriorityFeePerGas))
+	return true
+}
+
+// Allocates nonce, records signed transaction before broadcast and blocks until one of its
+// broadcasts is mined. Returns number of miners paid and amount like payBatch.
+func (u *PayoutsProcessor) payTracked(ptx *storage.PaymentTx, req *rpc.TxRequest) (int, int64, bool) {
+	nonceMu.Lock()
+	err := u.allocate(ptx, req)
+	if err == nil {
+		err = u.sign(ptx, req)
+	}
+	if err == nil {
+		err = u.broadcast(ptx)
+	}
+	nonceMu.Unlock()
+	if err != nil {
+		log.Printf("Failed to send payment to %s, %v Shannon, nonce %v: %v. Check docs/PAYOUTS.md",
+			ptx.Login, ptx.Amount, ptx.Nonce, err)
+		u.halt = true
+		u.lastFail = err
+		return 0, 0, false
+	}
+	log.Printf("Sent payment of %v Shannon to %v, nonce %v, TxHash: %v", ptx.Amount, ptx.Login, ptx.Nonce, ptx.Hashes[0])
+	if len(ptx.Recipients) > 0 {
+		u.batchSent(batchPayments(ptx.Recipients), ptx.Hashes[0])
+	} else {
+		webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentSent, u.backend.Tenant(), ptx.Login, ptx.Hashes[0], ptx.Amount))
+	}
+
+	return u.settle(ptx, u.waitPayment(ptx))
+}
+
+// Nonce and missing gas fields are filled in, tracked payment is written before anything is signed
+func (u *PayoutsProcessor) allocate(ptx *storage.PaymentTx, req *rpc.TxRequest) error {
+	chainId, err := u.rpc.GetChainId()
+	if err != nil {
+		return err
+	}
+	nonce, err := u.rpc.GetTransactionCount(req.From, "pending")
+	if err != nil {
+		return err
+	}
+	req.Nonce = hexutil.EncodeUint64(nonce)
+	req.ChainId = hexutil.EncodeUint64(chainId)
+	if len(req.Gas) == 0 {
+		gas, err := u.rpc.EstimateGas(req)
+		if err != nil {
+			return fmt.Errorf("failed to estimate gas: %v", err)
+		}
+		req.Gas = hexutil.EncodeUint64(gas)
+	}
+	if len(req.MaxFeePerGas) == 0 && len(req.GasPrice) == 0 {
+		gasPrice, err := u.rpc.GasPrice()
+		if err != nil {
+			return fmt.Errorf("failed to query gas price: %v", err)
+		}
+		req.GasPrice = hexutil.EncodeBig(gasPrice)
+	}
+	ptx.Nonce = nonce
+	ptx.State = storage.PaymentTxAllocated
+	ptx.Request, _ = json.Marshal(req)
+	return u.backend.WritePaymentTx(ptx)
+}
+
+// Signed transaction is recorded first, so its hash is known whether broadcast reaches node or not
+func (u *PayoutsProcessor) sign(ptx *storage.PaymentTx, req *rpc.TxRequest) error {
+	raw, err := u.signer.SignTx(req)
+	if err != nil {
+		return fmt.Errorf("failed to sign tx: %v", err)
+	}
+	next := *ptx
+	next.State = storage.PaymentTxSigned
+	next.Request, _ = json.Marshal(req)
+	next.Raw = raw
+	next.Hashes = append(append([]string{}, ptx.Hashes...), rawTxHash(raw))
+	if err := u.backend.WritePaymentTx(&next); err != nil {
+		return err
+	}
+	*ptx = next
+	return nil
+}
+
+func (u *PayoutsProcessor) broadcast(ptx *storage.PaymentTx) error {
+	if _, err := u.rpc.SendRawTransaction(ptx.Raw); err != nil {
+		return err
+	}
+	ptx.State = storage.PaymentTxSent
+	ptx.SentAt = time.Now().Unix()
+	return u.backend.WritePaymentTx(ptx)
+}
+
+// Blocks until any broadcast of nonce is mined with enough confirmations, stuck transaction is
+// replaced or rebroadcast. Nil if nonce was taken by transaction unknown to payouts, so it was dropped.
+func (u *PayoutsProcessor) waitPayment(ptx *storage.PaymentTx) *rpc.TxReceipt {
+	timeout := u.config.Stuck.timeout()
+	for {
+		log.Printf("Waiting for tx confirmation: %v", ptx.Hashes[len(ptx.Hashes)-1])
+		time.Sleep(txCheckInterval)
+		receipts, err := u.rpc.GetTxReceipts(ptx.Hashes)
+		if err != nil {
+			log.Printf("Failed to get tx receipts for nonce %v: %v", ptx.Nonce, err)
+			continue
+		}
+		var mined *rpc.TxReceipt
+		for _, receipt := range receipts {
+			if receipt != nil && receipt.Confirmed() {
+				mined = receipt
+			}
+		}
+		// Receipt is fetched again until it is deep enough to survive reorg
+		if mined != nil {
+			if u.hasConfirmations(mined) {
+				return mined
+			}
+			continue
+		}
+		if time.Since(time.Unix(ptx.SentAt, 0)) < timeout {
+			continue
+		}
+		nonce, err := u.rpc.GetTransactionCount(u.config.Address, "latest")
+		if err != nil {
+			log.Printf("Failed to get nonce of %s: %v", u.config.Address, err)
+			continue
+		}
+		if nonce > ptx.Nonce {
+			// One of them may have been mined since receipts were fetched
+			receipts, err := u.rpc.GetTxReceipts(ptx.Hashes)
+			if err != nil {
+				log.Printf("Failed to get tx receipts for nonce %v: %v", ptx.Nonce, err)
+				continue
+			}
+			if anyMined(receipts) {
+				continue
+			}
+			log.Printf("Nonce %v of payment to %s was used by tx unknown to payouts, none of %v is mined. Check outgoing txs of %s.",
+				ptx.Nonce, ptx.Login, ptx.Hashes, u.config.Address)
+			return nil
+		}
+		u.replace(ptx)
+	}
+}
+
+func anyMined(receipts []*rpc.TxReceipt) bool {
+	for _, receipt := range receipts {
+		if receipt != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Replacement with raised fees is recorded before broadcast, rebroadcast of the
+// latest transaction if fees can't be raised further
+func (u *PayoutsProcessor) replace(ptx *storage.PaymentTx) {
+	nonceMu.Lock()
+	defer nonceMu.Unlock()
+	var req rpc.TxRequest
+	if err := json.Unmarshal(ptx.Request, &req); err == nil && ptx.Bumps < u.config.Stuck.maxBumps() && u.bumpFees(&req) {
+		prev := ptx.Hashes[len(ptx.Hashes)-1]
+		if err := u.sign(ptx, &req); err != nil {
+			log.Printf("Failed to replace stuck payment tx %s: %v", prev, err)
+		} else {
+			ptx.Bumps++
+			log.Printf("Replacing stuck payment tx %s with %s, nonce %v", prev, ptx.Hashes[len(ptx.Hashes)-1], ptx.Nonce)
+		}
+	} else {
+		log.Printf("Rebroadcasting stuck payment tx %s, nonce %v", ptx.Hashes[len(ptx.Hashes)-1], ptx.Nonce)
+	}
+	// Node refuses transaction it already has, it is only logged
+	if err := u.broadcast(ptx); err != nil {
+		log.Printf("Failed to broadcast payment tx %s: %v", ptx.Hashes[len(ptx.Hashes)-1], err)
+		ptx.SentAt = time.Now().Unix()
+		if err := u.backend.WritePaymentTx(ptx); err != nil {
+			log.Printf("Failed to write payment tx of nonce %v: %v", ptx.Nonce, err)
+		}
+	}
+}
+
+// Records payment of confirmed transaction, or credits it back if it was dropped, and stops tracking its nonce
+func (u *PayoutsProcessor) settle(ptx *storage.PaymentTx, receipt *rpc.TxReceipt) (int, int64, bool) {
+	var paid int
+	var amount int64
+	var ok bool
+	if len(ptx.Recipients) > 0 {
+		paid, amount, ok = u.settleBatch(batchPayments(ptx.Recipients), receipt)
+	} else {
+		paid, amount, ok = u.settlePayment(ptx.Login, ptx.Amount, ptx.Hashes[len(ptx.Hashes)-1], receipt)
+	}
+	// Nonce is done with, failed settlement is resolved like any pending payment
+	if err := u.backend.RemovePaymentTx(ptx.Nonce); err != nil {
+		log.Printf("Failed to remove payment tx of nonce %v: %v", ptx.Nonce, err)
+		u.halt = true
+		u.lastFail = err
+		return paid, amount, false
+	}
+	return paid, amount, ok
+}
+
+// Finishes payments tracked before restart. Transactions which were never signed are credited
+// back, others are rebroadcast and awaited. False if payouts must not start.
+func (u *PayoutsProcessor) reconcile() bool {
+	txs, err := u.backend.GetPaymentTxs()
+	if err != nil {
+		log.Println("Unable to start payouts, failed to get tracked payment txs:", err)
+		return false
+	}
+	for _, ptx := range txs {
+		if ptx.State == storage.PaymentTxAllocated {
+			log.Printf("Payment to %s, %v Shannon, nonce %v was never signed, crediting it back", ptx.Login, ptx.Amount, ptx.Nonce)
+			if !u.refundTracked(ptx) {
+				return false
+			}
+			continue
+		}
+		log.Printf("Resuming payment to %s, %v Shannon, nonce %v, txs: %v", ptx.Login, ptx.Amount, ptx.Nonce, ptx.Hashes)
+		// Harmless if it was mined or is known to node already
+		if _, err := u.rpc.SendRawTransaction(ptx.Raw); err != nil {
+			log.Printf("Rebroadcast of payment tx %s: %v", ptx.Hashes[len(ptx.Hashes)-1], err)
+		}
+		ptx.SentAt = time.Now().Unix()
+		if _, _, ok := u.settle(ptx, u.waitPayment(ptx)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *PayoutsProcessor) refundTracked(ptx *storage.PaymentTx) bool {
+	recipients := ptx.Recipients
+	if len(recipients) == 0 {
+		recipients = map[string]int64{ptx.Login: ptx.Amount}
+	}
+	for login, amount := range recipients {
+		if err := u.backend.RollbackBalance(login, amount); err != nil {
+			log.Printf("Failed to credit %v Shannon back to %s: %v", amount, login, err)
+			return false
+		}
+		webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentFailed, u.backend.Tenant(), login, "", amount))
+	}
+	if err := u.backend.RemovePaymentTx(ptx.Nonce); err != nil {
+		log.Printf("Failed to remove payment tx of nonce %v: %v", ptx.Nonce, err)
+		return false
+	}
+	if err := u.backend.UnlockPayouts(); err != nil {
+		log.Println("Failed to unlock payouts:", err)
+		return false
+	}
+	return true
+}
+
+// Batch payments of tracked transaction in stable order
+func batchPayments(recipients map[string]int64) []*batchPayment {
+	result := make([]*batchPayment, 0, len(recipients))
+	for login, amount := range recipients {
+		result = append(result, &batchPayment{login: login, amount: amount})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].login < result[j].login })
+	return result
+}
@@ -0,0 +1,39 @@
+package payouts
+
+import (
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestBumpFees(t *testing.T) {
+	u := &PayoutsProcessor{config: &PayoutsConfig{}}
+	req := &rpc.TxRequest{GasPrice: "0x3b9aca01"}
+	if !u.bumpFees(req) || req.GasPrice != "0x47868c02" {
+		t.Errorf("Must raise gas price by 20%% rounding up, got %v", req.GasPrice)
+	}
+
+	u.config.Stuck.BumpPercent = 10
+	u.config.DynamicFee.FeeCap = "2400000000"
+	req = &rpc.TxRequest{MaxFeePerGas: "0x77359400", MaxPriorityFeePerGas: "0x3b9aca00"}
+	if !u.bumpFees(req) || req.MaxFeePerGas != "0x83215600" || req.MaxPriorityFeePerGas != "0x4190ab00" {
+		t.Errorf("Must raise max fee and priority fee, got %v %v", req.MaxFeePerGas, req.MaxPriorityFeePerGas)
+	}
+	if u.bumpFees(req) || req.MaxFeePerGas != "0x83215600" {
+		t.Errorf("Must not raise max fee above fee cap, got %v", req.MaxFeePerGas)
+	}
+
+	var errs util.ConfigErrors
+	(&StuckConfig{Timeout: "soon", BumpPercent: 5, MaxBumps: -1}).Validate(&errs)
+	if len(errs) != 3 {
+		t.Errorf("Must validate stuck payment settings, got %v", errs)
+	}
+}
+
+func TestBatchPayments(t *testing.T) {
+	payments := batchPayments(map[string]int64{"0xb": 2, "0xa": 1})
+	if len(payments) != 2 || payments[0].login != "0xa" || payments[1].amount != 2 {
+		t.Errorf("Must restore batch payments in stable order, got %v %v", payments[0], payments[1])
+	}
+}
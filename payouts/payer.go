@@ -1,6 +1,7 @@
 package payouts
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
@@ -10,26 +11,71 @@ import (
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
+	"github.com/etclabscore/open-etc-pool/bookkeeping"
+	"github.com/etclabscore/open-etc-pool/metrics"
 	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/statuspage"
 	"github.com/etclabscore/open-etc-pool/storage"
 	"github.com/etclabscore/open-etc-pool/util"
+	"github.com/etclabscore/open-etc-pool/webhooks"
 )
 
-const txCheckInterval = 5 * time.Second
+var txCheckInterval = 5 * time.Second
 
 type PayoutsConfig struct {
 	Enabled      bool   `json:"enabled"`
 	RequirePeers int64  `json:"requirePeers"`
 	Interval     string `json:"interval"`
+	Schedule     string `json:"schedule"`
 	Daemon       string `json:"daemon"`
 	Timeout      string `json:"timeout"`
 	Address      string `json:"address"`
 	Gas          string `json:"gas"`
 	GasPrice     string `json:"gasPrice"`
 	AutoGas      bool   `json:"autoGas"`
+	// Blocks on top of payment tx before it is recorded as paid, 1 if not set
+	Confirmations int64 `json:"confirmations"`
+	// EIP-1559 transactions instead of gasPrice ones
+	DynamicFee DynamicFeeConfig `json:"dynamicFee"`
+	// Several miners paid by one call of multisend contract
+	Batch BatchConfig `json:"batch"`
+	// Signing by keystore or external signer instead of unlocked account of node
+	Signer SignerConfig `json:"signer"`
+	// Replacement of payments stuck in mempool, only with signer
+	Stuck StuckConfig `json:"stuck"`
+	// Checks of recipients with contract code
+	Contracts ContractsConfig `json:"contracts"`
 	// In Shannon
 	Threshold int64 `json:"threshold"`
 	BgSave    bool  `json:"bgsave"`
+
+	Verify VerifyConfig `json:"verify"`
+}
+
+func (c *PayoutsConfig) Validate(errs *util.ConfigErrors) {
+	c.Verify.Validate(errs)
+	if !c.Enabled {
+		return
+	}
+	if c.DynamicFee.Enabled {
+		c.DynamicFee.Validate(errs)
+	}
+	if c.Batch.Enabled {
+		c.Batch.Validate(errs)
+	}
+	c.Signer.Validate(errs)
+	c.Stuck.Validate(errs)
+	c.Contracts.Validate(errs)
+	if c.Confirmations < 0 {
+		errs.Addf("payouts.confirmations: can't be negative")
+	}
+	if len(c.Schedule) > 0 {
+		if _, err := util.ParseCron(c.Schedule); err != nil {
+			errs.Addf("payouts.schedule: %v", err)
+		}
+	}
+	errs.Duration("payouts.interval", c.Interval, len(c.Schedule) > 0)
+	errs.Duration("payouts.timeout", c.Timeout, false)
 }
 
 func (self PayoutsConfig) GasHex() string {
@@ -44,15 +90,40 @@ func (self PayoutsConfig) GasPriceHex() string {
 
 type PayoutsProcessor struct {
 	config   *PayoutsConfig
-	backend  *storage.RedisClient
+	backend  storage.Storage
 	rpc      *rpc.RPCClient
 	halt     bool
 	lastFail error
+	// Expected chain ID of node, not checked if 0
+	chainId uint64
+	run     *metrics.Run
+	// Nil if node signs payments
+	signer Signer
+	// Nil if payouts run every interval
+	schedule *util.Cron
+	interval time.Duration
+	control  Control
+	// Manual run request handled last, in ms
+	lastRequest int64
 }
 
-func NewPayoutsProcessor(cfg *PayoutsConfig, backend *storage.RedisClient) *PayoutsProcessor {
-	u := &PayoutsProcessor{config: cfg, backend: backend}
+func NewPayoutsProcessor(cfg *PayoutsConfig, backend storage.Storage, control Control, chainId uint64) *PayoutsProcessor {
+	u := &PayoutsProcessor{config: cfg, backend: backend, control: control, chainId: chainId}
+	if len(cfg.Schedule) > 0 {
+		schedule, err := util.ParseCron(cfg.Schedule)
+		if err != nil {
+			log.Fatalf("Invalid payouts schedule: %v", err)
+		}
+		u.schedule = schedule
+	} else {
+		u.interval = util.MustParseDuration(cfg.Interval)
+	}
 	u.rpc = rpc.NewRPCClient("PayoutsProcessor", cfg.Daemon, cfg.Timeout)
+	signer, err := newSigner(&cfg.Signer, cfg.Address)
+	if err != nil {
+		log.Fatalf("Failed to set up payouts signer: %v", err)
+	}
+	u.signer = signer
 	return u
 }
 
@@ -66,14 +137,23 @@ func (u *PayoutsProcessor) Start() {
 		return
 	}
 
-	intv := util.MustParseDuration(u.config.Interval)
-	timer := time.NewTimer(intv)
-	log.Printf("Set payouts interval to %v", intv)
+	if u.schedule != nil {
+		log.Printf("Set payouts schedule to %v UTC", u.config.Schedule)
+	} else {
+		log.Printf("Set payouts interval to %v", u.interval)
+	}
+
+	// Payments signed by pool are finished before pending ones are checked
+	if u.signer != nil && !u.reconcile() {
+		u.stopped(errors.New("failed to reconcile payment transactions"))
+		return
+	}
 
 	payments := u.backend.GetPendingPayments()
 	if len(payments) > 0 {
 		log.Printf("Previous payout failed, you have to resolve it. List of failed payments:\n %v",
 			formatPendingPayments(payments))
+		u.stopped(fmt.Errorf("previous payout failed, %v payments are pending", len(payments)))
 		return
 	}
 
@@ -84,22 +164,51 @@ func (u *PayoutsProcessor) Start() {
 	}
 	if locked {
 		log.Println("Unable to start payouts because they are locked")
+		u.stopped(errors.New("payouts are locked"))
 		return
 	}
 
-	// Immediately process payouts after start
-	u.process()
-	timer.Reset(intv)
-
-	go func() {
-		for {
-			select {
-			case <-timer.C:
-				u.process()
-				timer.Reset(intv)
-			}
+	// Requests made before start are not run
+	c, ok := u.readControl()
+	if c != nil {
+		u.lastRequest = c.RunRequested
+	}
+	// Immediately process payouts after start unless they run on schedule
+	if u.schedule == nil {
+		if ok {
+			u.payout()
+		} else if c != nil {
+			log.Printf("Payouts are paused by %v", c.PausedBy)
 		}
-	}()
+	}
+	next := u.nextRun(time.Now())
+	log.Printf("Next payouts run at %v", next)
+	go u.loop(next)
+}
+
+func (u *PayoutsProcessor) payout() {
+	u.run = metrics.NewRun(metrics.Payouts, u.backend)
+	halted := u.halt
+	u.process()
+	if u.halt && !halted {
+		webhooks.Send(webhooks.ErrorEvent(u.backend.Tenant(), u.lastFail))
+	}
+	u.reportStatus()
+	u.run.Done(!u.halt)
+}
+
+// Payouts can't start without operator
+func (u *PayoutsProcessor) stopped(err error) {
+	statuspage.Report(statuspage.Payouts, statuspage.Outage)
+	webhooks.Send(webhooks.ErrorEvent(u.backend.Tenant(), err))
+}
+
+func (u *PayoutsProcessor) reportStatus() {
+	if u.halt {
+		statuspage.Report(statuspage.Payouts, statuspage.Outage)
+	} else {
+		statuspage.Report(statuspage.Payouts, statuspage.Operational)
+	}
 }
 
 func (u *PayoutsProcessor) process() {
@@ -109,12 +218,14 @@ func (u *PayoutsProcessor) process() {
 	}
 	mustPay := 0
 	minersPaid := 0
+	held := 0
 	totalAmount := big.NewInt(0)
 	payees, err := u.backend.GetPayees()
 	if err != nil {
 		log.Println("Error while retrieving payees from backend:", err)
 		return
 	}
+	var batched []*batchPayment
 
 	for _, login := range payees {
 		amount, _ := u.backend.GetBalance(login)
@@ -128,27 +239,23 @@ func (u *PayoutsProcessor) process() {
 		}
 		mustPay++
 
-		// Require active peers before processing
-		if !u.checkPeers() {
-			break
+		gas, contract, ok := u.recipientGas(login, amount)
+		if !ok {
+			if contract {
+				held++
+			}
+			continue
 		}
-		// Require unlocked account
-		if !u.isUnlockedAccount() {
-			break
+		if u.config.Batch.Enabled && !contract {
+			batched = append(batched, &batchPayment{login: login, amount: amount})
+			continue
 		}
-
-		// Check if we have enough funds
-		poolBalance, err := u.rpc.GetBalance(u.config.Address)
-		if err != nil {
-			u.halt = true
-			u.lastFail = err
+		if !u.canPay(amountInWei) {
 			break
 		}
-		if poolBalance.Cmp(amountInWei) < 0 {
-			err := fmt.Errorf("Not enough balance for payment, need %s Wei, pool has %s Wei",
-				amountInWei.String(), poolBalance.String())
-			u.halt = true
-			u.lastFail = err
+		// Fees are estimated for every payment, as previous one may take a while to confirm
+		maxFee, priorityFee, ok := u.fees()
+		if !ok {
 			break
 		}
 
@@ -171,8 +278,18 @@ func (u *PayoutsProcessor) process() {
 			break
 		}
 
-		value := hexutil.EncodeBig(amountInWei)
-		txHash, err := u.rpc.SendTransaction(u.config.Address, login, u.config.GasHex(), u.config.GasPriceHex(), value, u.config.AutoGas)
+		req := u.txRequest(login, amountInWei, gas, maxFee, priorityFee)
+		if u.signer != nil {
+			paid, _, ok := u.payTracked(&storage.PaymentTx{Login: login, Amount: amount}, req)
+			if !ok {
+				break
+			}
+			minersPaid += paid
+			totalAmount.Add(totalAmount, big.NewInt(int64(paid)*amount))
+			continue
+		}
+
+		txHash, err := u.rpc.Send(req)
 		if err != nil {
 			log.Printf("Failed to send payment to %s, %v Shannon: %v. Check outgoing tx for %s in block explorer and docs/PAYOUTS.md",
 				login, amount, err, login)
@@ -180,43 +297,39 @@ func (u *PayoutsProcessor) process() {
 			u.lastFail = err
 			break
 		}
+		log.Printf("Sent payment of %v Shannon to %v, TxHash: %v", amount, login, txHash)
+		webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentSent, u.backend.Tenant(), login, txHash, amount))
 
-		// Log transaction hash
-		err = u.backend.WritePayment(login, txHash, amount)
+		// Wait for TX confirmations before further payouts
+		receipt, err := u.waitReceipt(txHash)
 		if err != nil {
-			log.Printf("Failed to log payment data for %s, %v Shannon, tx: %s: %v", login, amount, txHash, err)
+			log.Printf("%v. Payment to %s stays pending, check outgoing txs of %s in block explorer and docs/PAYOUTS.md",
+				err, login, u.config.Address)
 			u.halt = true
 			u.lastFail = err
 			break
 		}
+		paid, _, ok := u.settlePayment(login, amount, txHash, receipt)
+		if !ok {
+			break
+		}
+		minersPaid += paid
+		totalAmount.Add(totalAmount, big.NewInt(int64(paid)*amount))
+	}
 
-		minersPaid++
+	if len(batched) > 0 {
+		paid, amount := u.payBatches(batched)
+		minersPaid += paid
 		totalAmount.Add(totalAmount, big.NewInt(amount))
-		log.Printf("Paid %v Shannon to %v, TxHash: %v", amount, login, txHash)
-
-		// Wait for TX confirmation before further payouts
-		for {
-			log.Printf("Waiting for tx confirmation: %v", txHash)
-			time.Sleep(txCheckInterval)
-			receipt, err := u.rpc.GetTxReceipt(txHash)
-			if err != nil {
-				log.Printf("Failed to get tx receipt for %v: %v", txHash, err)
-				continue
-			}
-			// Tx has been mined
-			if receipt != nil && receipt.Confirmed() {
-				if receipt.Successful() {
-					log.Printf("Payout tx successful for %s: %s", login, txHash)
-				} else {
-					log.Printf("Payout tx failed for %s: %s. Address contract throws on incoming tx.", login, txHash)
-				}
-				break
-			}
-		}
 	}
 
+	u.run.Set("payees_due", float64(mustPay))
+	u.run.Set("miners_paid", float64(minersPaid))
+	u.run.Set("paid_shannon", float64(totalAmount.Int64()))
+	u.run.Set("payees_held", float64(held))
+
 	if mustPay > 0 {
-		log.Printf("Paid total %v Shannon to %v of %v payees", totalAmount, minersPaid, mustPay)
+		log.Printf("Paid total %v Shannon to %v of %v payees, %v held for review", totalAmount, minersPaid, mustPay, held)
 	} else {
 		log.Println("No payees that have reached payout threshold")
 	}
@@ -227,17 +340,227 @@ func (u *PayoutsProcessor) process() {
 	}
 }
 
-func (self PayoutsProcessor) isUnlockedAccount() bool {
-	_, err := self.rpc.Sign(self.config.Address, "0x0")
+// Node checks before every payment, false if payouts have to stop
+func (u *PayoutsProcessor) canPay(amountInWei *big.Int) bool {
+	state, err := u.rpc.GetAccountState(u.config.Address)
+	if err != nil {
+		log.Println("Unable to process payouts, failed to query node:", err)
+		return false
+	}
+	// Never send payments on node of another network
+	if !u.checkChainId(state) {
+		return false
+	}
+	// Require active peers before processing
+	if !u.checkPeers(state) {
+		return false
+	}
+	// Require unlocked account unless pool signs itself
+	if u.signer == nil && !u.isUnlockedAccount(state) {
+		return false
+	}
+
+	// Check if we have enough funds
+	poolBalance, err := state.Balance, state.BalanceErr
+	if err != nil {
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+	if poolBalance.Cmp(amountInWei) < 0 {
+		err := fmt.Errorf("Not enough balance for payment, need %s Wei, pool has %s Wei",
+			amountInWei.String(), poolBalance.String())
+		u.halt = true
+		u.lastFail = err
+		return false
+	}
+	return true
+}
+
+// Fees of EIP-1559 transaction, nil if they are not dynamic
+func (u *PayoutsProcessor) fees() (*big.Int, *big.Int, bool) {
+	if !u.config.DynamicFee.Enabled {
+		return nil, nil, true
+	}
+	return u.dynamicFees()
+}
+
+// Blocks until transaction is mined with enough confirmations. Nil receipt if it was dropped:
+// node doesn't know it for stuck timeout, mined nonce of pool account is past its nonce and it
+// still has no receipt. Error if it is gone from node but can't be proven dropped, as peers
+// may still mine it. Payment has to stay pending for operator then.
+func (u *PayoutsProcessor) waitReceipt(txHash string) (*rpc.TxReceipt, error) {
+	lastSeen := time.Now()
+	var nonce string
+	for {
+		log.Printf("Waiting for tx confirmation: %v", txHash)
+		time.Sleep(txCheckInterval)
+		receipt, err := u.rpc.GetTxReceipt(txHash)
+		if err != nil {
+			log.Printf("Failed to get tx receipt for %v: %v", txHash, err)
+			continue
+		}
+		// Tx has been mined, receipt is fetched again until it is deep enough to survive reorg
+		if receipt != nil && receipt.Confirmed() {
+			lastSeen = time.Now()
+			if u.hasConfirmations(receipt) {
+				return receipt, nil
+			}
+			continue
+		}
+		txs, err := u.rpc.GetTransactions([]string{txHash})
+		if err != nil {
+			log.Printf("Failed to get tx %v: %v", txHash, err)
+			continue
+		}
+		if txs[0] != nil {
+			lastSeen = time.Now()
+			nonce = txs[0].Nonce
+			continue
+		}
+		if time.Since(lastSeen) <= u.config.Stuck.timeout() {
+			continue
+		}
+		if len(nonce) == 0 {
+			return nil, fmt.Errorf("payment tx %s is unknown to node and its nonce is unknown", txHash)
+		}
+		n, err := hexutil.DecodeUint64(nonce)
+		if err != nil {
+			return nil, fmt.Errorf("payment tx %s has malformed nonce %q", txHash, nonce)
+		}
+		latest, err := u.rpc.GetTransactionCount(u.config.Address, "latest")
+		if err != nil {
+			log.Printf("Failed to get nonce of %s: %v", u.config.Address, err)
+			continue
+		}
+		if latest <= n {
+			return nil, fmt.Errorf("payment tx %s was dropped by node, its nonce %v is still unused", txHash, n)
+		}
+		// Tx may have been mined since receipt was fetched
+		receipt, err = u.rpc.GetTxReceipt(txHash)
+		if err != nil {
+			log.Printf("Failed to get tx receipt for %v: %v", txHash, err)
+			continue
+		}
+		if receipt == nil {
+			log.Printf("Payment tx %v was dropped, nonce %v was used by another tx", txHash, n)
+			return nil, nil
+		}
+	}
+}
+
+func (u *PayoutsProcessor) confirmations() int64 {
+	if u.config.Confirmations > 0 {
+		return u.config.Confirmations
+	}
+	return 1
+}
+
+// Block of receipt counts as the first confirmation
+func (u *PayoutsProcessor) hasConfirmations(receipt *rpc.TxReceipt) bool {
+	if u.confirmations() <= 1 {
+		return true
+	}
+	head, err := u.rpc.BlockNumber()
 	if err != nil {
+		log.Printf("Failed to get block number: %v", err)
+		return false
+	}
+	mined := util.String2Big(receipt.BlockNumber).Int64()
+	n := int64(head) - mined + 1
+	if n < u.confirmations() {
+		log.Printf("Payment tx %v has %v of %v confirmations", receipt.TxHash, n, u.confirmations())
+		return false
+	}
+	return true
+}
+
+// Moves confirmed payment from pending to paid. Reverted or dropped payment is returned
+// to balance, nil receipt means dropped. Returns number of miners paid like payBatch.
+func (u *PayoutsProcessor) settlePayment(login string, amount int64, txHash string, receipt *rpc.TxReceipt) (int, int64, bool) {
+	if receipt != nil {
+		if err := u.backend.WritePaymentReceipt(u.paymentReceipt(login, amount, receipt)); err != nil {
+			log.Printf("Failed to write payment receipt for %s, tx: %s: %v", login, receipt.TxHash, err)
+		}
+	}
+	if receipt == nil || !receipt.Successful() {
+		if receipt != nil {
+			log.Printf("Payout tx failed for %s: %s. Address contract throws on incoming tx, crediting %v Shannon back.",
+				login, txHash, amount)
+		}
+		if err := u.backend.RollbackBalance(login, amount); err != nil {
+			log.Printf("Failed to credit %v Shannon back to %s: %v", amount, login, err)
+			u.halt = true
+			u.lastFail = err
+			return 0, 0, false
+		}
+		webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentFailed, u.backend.Tenant(), login, txHash, amount))
+		if err := u.backend.UnlockPayouts(); err != nil {
+			log.Println("Failed to unlock payouts:", err)
+			u.halt = true
+			u.lastFail = err
+			return 0, 0, false
+		}
+		return 0, 0, true
+	}
+
+	txHash = receipt.TxHash
+	if err := u.backend.WritePayment(login, txHash, amount); err != nil {
+		log.Printf("Failed to log payment data for %s, %v Shannon, tx: %s: %v", login, amount, txHash, err)
+		u.halt = true
+		u.lastFail = err
+		return 0, 0, false
+	}
+	bookkeeping.Record(bookkeeping.PaymentEntry(u.backend.Tenant(), login, txHash, amount))
+	webhooks.Send(webhooks.PaymentEvent(webhooks.PaymentConfirmed, u.backend.Tenant(), login, txHash, amount))
+	log.Printf("Paid %v Shannon to %v, TxHash: %v", amount, login, txHash)
+	return 1, amount, true
+}
+
+// Payment from pool address, gas price is left to node with autoGas unless fees are dynamic
+func (u *PayoutsProcessor) txRequest(to string, value *big.Int, gas string, maxFee, priorityFee *big.Int) *rpc.TxRequest {
+	req := &rpc.TxRequest{From: u.config.Address, To: to, Value: hexutil.EncodeBig(value)}
+	if !u.config.AutoGas {
+		req.Gas = gas
+		req.GasPrice = u.config.GasPriceHex()
+	}
+	if maxFee != nil {
+		req.MaxFeePerGas = hexutil.EncodeBig(maxFee)
+		req.MaxPriorityFeePerGas = hexutil.EncodeBig(priorityFee)
+	}
+	return req
+}
+
+// Fee is computed from effective gas price of receipt, configured gas price is used if node doesn't report it
+func (u *PayoutsProcessor) paymentReceipt(login string, amount int64, receipt *rpc.TxReceipt) *storage.PaymentReceipt {
+	gasUsed := util.String2Big(receipt.GasUsed)
+	gasPrice := util.String2Big(receipt.EffectiveGasPrice)
+	if len(receipt.EffectiveGasPrice) == 0 {
+		gasPrice = util.String2Big(u.config.GasPrice)
+	}
+	return &storage.PaymentReceipt{
+		TxHash:    receipt.TxHash,
+		Login:     login,
+		Amount:    amount,
+		BlockHash: receipt.BlockHash,
+		GasUsed:   gasUsed.Uint64(),
+		GasPrice:  gasPrice.String(),
+		Fee:       new(big.Int).Mul(gasUsed, gasPrice).String(),
+		Success:   receipt.Successful(),
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+func (self PayoutsProcessor) isUnlockedAccount(state *rpc.AccountState) bool {
+	if err := state.SignErr; err != nil {
 		log.Println("Unable to process payouts:", err)
 		return false
 	}
 	return true
 }
 
-func (self PayoutsProcessor) checkPeers() bool {
-	n, err := self.rpc.GetPeerCount()
+func (self PayoutsProcessor) checkPeers(state *rpc.AccountState) bool {
+	n, err := state.Peers, state.PeersErr
 	if err != nil {
 		log.Println("Unable to start payouts, failed to retrieve number of peers from node:", err)
 		return false
@@ -249,6 +572,21 @@ func (self PayoutsProcessor) checkPeers() bool {
 	return true
 }
 
+func (self PayoutsProcessor) checkChainId(state *rpc.AccountState) bool {
+	if self.chainId == 0 {
+		return true
+	}
+	if err := state.ChainIdErr; err != nil {
+		log.Println("Unable to start payouts, failed to retrieve chain ID from node:", err)
+		return false
+	}
+	if state.ChainId != self.chainId {
+		log.Printf("Unable to start payouts, node is on chain %v, expected %v", state.ChainId, self.chainId)
+		return false
+	}
+	return true
+}
+
 func (self PayoutsProcessor) reachedThreshold(amount *big.Int) bool {
 	return big.NewInt(self.config.Threshold).Cmp(amount) < 0
 }
@@ -261,8 +599,13 @@ func formatPendingPayments(list []*storage.PendingPayment) string {
 	return s
 }
 
+// Only redis has to be asked to persist its state
 func (self PayoutsProcessor) bgSave() {
-	result, err := self.backend.BgSave()
+	redis, ok := self.backend.(*storage.RedisClient)
+	if !ok {
+		return
+	}
+	result, err := redis.BgSave()
 	if err != nil {
 		log.Println("Failed to perform BGSAVE on backend:", err)
 		return
@@ -271,6 +614,12 @@ func (self PayoutsProcessor) bgSave() {
 }
 
 func (self PayoutsProcessor) resolvePayouts() {
+	// Transactions of these may be mined yet, crediting them back could pay twice
+	if txs, err := self.backend.GetPaymentTxs(); err != nil || len(txs) > 0 {
+		log.Printf("Refusing to resolve payouts, %v payment txs signed by pool are tracked (%v). Start payouts normally to finish them.",
+			len(txs), err)
+		return
+	}
 	payments := self.backend.GetPendingPayments()
 
 	if len(payments) > 0 {
@@ -0,0 +1,144 @@
+package payouts
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestHasConfirmations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":"0x10"}`))
+	}))
+	defer srv.Close()
+	u := &PayoutsProcessor{config: &PayoutsConfig{}, rpc: rpc.NewRPCClient("test", srv.URL, "1s")}
+
+	receipt := &rpc.TxReceipt{TxHash: "0x1", BlockHash: "0x2", BlockNumber: "0x10"}
+	if !u.hasConfirmations(receipt) {
+		t.Error("Must accept mined tx by default")
+	}
+	u.config.Confirmations = 12
+	if u.hasConfirmations(receipt) {
+		t.Error("Must wait for confirmations")
+	}
+	receipt.BlockNumber = "0x5"
+	if !u.hasConfirmations(receipt) {
+		t.Error("Must count block of tx as confirmation")
+	}
+}
+
+type testControl storage.PayoutsControl
+
+func (c *testControl) GetPayoutsControl() (*storage.PayoutsControl, error) {
+	return (*storage.PayoutsControl)(c), nil
+}
+
+func TestSchedule(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 17, 0, 0, time.UTC)
+	u := &PayoutsProcessor{config: &PayoutsConfig{}, interval: 6 * time.Hour}
+	if next := u.nextRun(now); !next.Equal(now.Add(6 * time.Hour)) {
+		t.Errorf("Must run after interval, got %v", next)
+	}
+	u.schedule, _ = util.ParseCron("0 2 * * *")
+	if next := u.nextRun(now); !next.Equal(time.Date(2024, 3, 16, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Must run on schedule, got %v", next)
+	}
+
+	if _, ok := u.readControl(); !ok {
+		t.Error("Must run without control")
+	}
+	u.control = &testControl{Paused: true, PausedBy: "ops"}
+	if c, ok := u.readControl(); ok || c.PausedBy != "ops" {
+		t.Errorf("Must not run paused payouts, got %v", c)
+	}
+
+	var errs util.ConfigErrors
+	(&PayoutsConfig{Enabled: true, Schedule: "0 25 * * *", Timeout: "10s"}).Validate(&errs)
+	if len(errs) != 1 {
+		t.Errorf("Must validate schedule and not require interval with it, got %v", errs)
+	}
+}
+
+// Node which forgets tx of nonce 5 after first lookup
+type fakeNode struct {
+	latest  string
+	receipt string
+	// Receipt shows up once account nonce was asked
+	minedLate bool
+	seen      bool
+}
+
+func (n *fakeNode) result(method string) string {
+	switch method {
+	case "eth_getTransactionByHash":
+		if n.seen {
+			return "null"
+		}
+		n.seen = true
+		return `{"hash":"0x1","nonce":"0x5"}`
+	case "eth_getTransactionCount":
+		if n.minedLate {
+			n.receipt = `{"transactionHash":"0x1","blockHash":"0x2","status":"0x1"}`
+		}
+		return `"` + n.latest + `"`
+	case "eth_getTransactionReceipt":
+		if len(n.receipt) > 0 {
+			return n.receipt
+		}
+	}
+	return "null"
+}
+
+func (n *fakeNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	type call struct {
+		Id     int    `json:"id"`
+		Method string `json:"method"`
+	}
+	if body[0] == '[' {
+		var calls []call
+		json.Unmarshal(body, &calls)
+		var replies []string
+		for _, c := range calls {
+			replies = append(replies, fmt.Sprintf(`{"jsonrpc":"2.0","id":%v,"result":%s}`, c.Id, n.result(c.Method)))
+		}
+		w.Write([]byte("[" + strings.Join(replies, ",") + "]"))
+		return
+	}
+	var c call
+	json.Unmarshal(body, &c)
+	fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%v,"result":%s}`, c.Id, n.result(c.Method))
+}
+
+func TestWaitReceiptDropped(t *testing.T) {
+	txCheckInterval = time.Millisecond
+	defer func() { txCheckInterval = 5 * time.Second }()
+	wait := func(node *fakeNode) (*rpc.TxReceipt, error) {
+		srv := httptest.NewServer(node)
+		defer srv.Close()
+		u := &PayoutsProcessor{config: &PayoutsConfig{Stuck: StuckConfig{Timeout: "1ms"}}, rpc: rpc.NewRPCClient("test", srv.URL, "1s")}
+		return u.waitReceipt("0x1")
+	}
+
+	if receipt, err := wait(&fakeNode{latest: "0x5"}); receipt != nil || err == nil {
+		t.Errorf("Must not refund tx whose nonce is unused, got %v %v", receipt, err)
+	}
+	if receipt, err := wait(&fakeNode{latest: "0x6"}); receipt != nil || err != nil {
+		t.Errorf("Must treat tx as dropped once its nonce is used and it has no receipt, got %v %v", receipt, err)
+	}
+	if receipt, err := wait(&fakeNode{latest: "0x6", minedLate: true}); receipt == nil || err != nil {
+		t.Errorf("Must not refund tx mined before nonce was checked, got %v %v", receipt, err)
+	}
+	if receipt, err := wait(&fakeNode{latest: "0x6", seen: true}); receipt != nil || err == nil {
+		t.Errorf("Must not refund tx of unknown nonce, got %v %v", receipt, err)
+	}
+}
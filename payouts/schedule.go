@@ -0,0 +1,63 @@
+package payouts
+
+import (
+	"log"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// How often pause and manual run requests are checked
+const controlCheckInterval = 10 * time.Second
+
+// Source of pause and manual run requests set by admin
+type Control interface {
+	GetPayoutsControl() (*storage.PayoutsControl, error)
+}
+
+// Next scheduled run, at the next time matching schedule or after interval
+func (u *PayoutsProcessor) nextRun(now time.Time) time.Time {
+	if u.schedule != nil {
+		return u.schedule.Next(now)
+	}
+	return now.Add(u.interval)
+}
+
+// False if payouts are paused or control can't be read, nothing is paid then
+func (u *PayoutsProcessor) readControl() (*storage.PayoutsControl, bool) {
+	if u.control == nil {
+		return &storage.PayoutsControl{}, true
+	}
+	c, err := u.control.GetPayoutsControl()
+	if err != nil {
+		log.Println("Failed to check if payouts are paused:", err)
+		return nil, false
+	}
+	return c, !c.Paused
+}
+
+// Runs payouts on schedule and on manual request, paused payouts skip both
+func (u *PayoutsProcessor) loop(next time.Time) {
+	for now := range time.Tick(controlCheckInterval) {
+		c, ok := u.readControl()
+		if c != nil && c.RunRequested > u.lastRequest {
+			u.lastRequest = c.RunRequested
+			if ok {
+				log.Printf("Running payouts requested by %v", c.RequestedBy)
+				u.payout()
+			} else {
+				log.Printf("Ignoring payouts run requested by %v, payouts are paused by %v", c.RequestedBy, c.PausedBy)
+			}
+		}
+		if now.Before(next) || next.IsZero() {
+			continue
+		}
+		if ok {
+			u.payout()
+		} else if c != nil {
+			log.Printf("Skipping scheduled payouts, they are paused by %v", c.PausedBy)
+		}
+		next = u.nextRun(time.Now())
+		log.Printf("Next payouts run at %v", next)
+	}
+}
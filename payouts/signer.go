@@ -0,0 +1,166 @@
+package payouts
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Where payments are signed
+const (
+	SignerNode     = "node"
+	SignerKeystore = "keystore"
+	SignerExternal = "external"
+)
+
+// Payments are signed by pool and sent as raw transactions unless type is node,
+// so node doesn't need unlocked account
+type SignerConfig struct {
+	// node, keystore or external, node if not set
+	Type string `json:"type"`
+	// Encrypted key file of payouts.address
+	Keystore string `json:"keystore"`
+	// Environment variable or file holding password of keystore
+	PasswordEnv  string `json:"passwordEnv"`
+	PasswordFile string `json:"passwordFile"`
+	// JSON-RPC endpoint of Clef or Web3Signer, it must hold key of payouts.address
+	Url     string `json:"url"`
+	Timeout string `json:"timeout"`
+}
+
+func (c *SignerConfig) Validate(errs *util.ConfigErrors) {
+	switch c.Type {
+	case "", SignerNode:
+	case SignerKeystore:
+		if len(c.Keystore) == 0 {
+			errs.Addf("payouts.signer.keystore: must be path to key file")
+		}
+		if len(c.PasswordEnv) == 0 && len(c.PasswordFile) == 0 {
+			errs.Addf("payouts.signer: passwordEnv or passwordFile must be set for keystore")
+		}
+	case SignerExternal:
+		if len(c.Url) == 0 {
+			errs.Addf("payouts.signer.url: must be URL of external signer")
+		}
+		errs.Duration("payouts.signer.timeout", c.Timeout, true)
+	default:
+		errs.Addf("payouts.signer.type: must be node, keystore or external, got %q", c.Type)
+	}
+}
+
+func (c *SignerConfig) password() (string, error) {
+	if len(c.PasswordEnv) > 0 {
+		if pass, ok := os.LookupEnv(c.PasswordEnv); ok {
+			return pass, nil
+		}
+		if len(c.PasswordFile) == 0 {
+			return "", fmt.Errorf("environment variable %s is not set", c.PasswordEnv)
+		}
+	}
+	data, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Returns signed transaction in hex, nonce and chain ID of request are set
+type Signer interface {
+	SignTx(req *rpc.TxRequest) (string, error)
+}
+
+// Nil for node signing
+func newSigner(cfg *SignerConfig, address string) (Signer, error) {
+	switch cfg.Type {
+	case SignerKeystore:
+		return newKeystoreSigner(cfg, address)
+	case SignerExternal:
+		timeout := cfg.Timeout
+		if len(timeout) == 0 {
+			timeout = "10s"
+		}
+		return &externalSigner{rpc.NewRPCClient("Signer", cfg.Url, timeout)}, nil
+	}
+	return nil, nil
+}
+
+type keystoreSigner struct {
+	key *ecdsa.PrivateKey
+}
+
+func newKeystoreSigner(cfg *SignerConfig, address string) (*keystoreSigner, error) {
+	data, err := os.ReadFile(cfg.Keystore)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := cfg.password()
+	if err != nil {
+		return nil, fmt.Errorf("can't read keystore password: %v", err)
+	}
+	key, err := keystore.DecryptKey(data, pass)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(key.Address.Hex(), address) {
+		return nil, fmt.Errorf("keystore holds key of %s, payouts address is %s", key.Address.Hex(), address)
+	}
+	return &keystoreSigner{key: key.PrivateKey}, nil
+}
+
+func (s *keystoreSigner) SignTx(req *rpc.TxRequest) (string, error) {
+	nonce, err := hexutil.DecodeUint64(req.Nonce)
+	if err != nil {
+		return "", fmt.Errorf("nonce: %v", err)
+	}
+	chainId, err := hexutil.DecodeBig(req.ChainId)
+	if err != nil {
+		return "", fmt.Errorf("chain ID: %v", err)
+	}
+	gas, err := hexutil.DecodeUint64(req.Gas)
+	if err != nil {
+		return "", fmt.Errorf("gas: %v", err)
+	}
+	to := common.HexToAddress(req.To)
+	value := util.String2Big(req.Value)
+	var data []byte
+	if len(req.Data) > 0 {
+		if data, err = hexutil.Decode(req.Data); err != nil {
+			return "", fmt.Errorf("data: %v", err)
+		}
+	}
+	var tx *types.Transaction
+	if len(req.MaxFeePerGas) > 0 {
+		tx = types.NewTx(&types.DynamicFeeTx{ChainID: chainId, Nonce: nonce, Gas: gas, To: &to, Value: value, Data: data,
+			GasFeeCap: util.String2Big(req.MaxFeePerGas), GasTipCap: util.String2Big(req.MaxPriorityFeePerGas)})
+	} else {
+		tx = types.NewTx(&types.LegacyTx{Nonce: nonce, Gas: gas, To: &to, Value: value, Data: data,
+			GasPrice: util.String2Big(req.GasPrice)})
+	}
+	// EIP-155 replay protection, ETC and ETH transactions differ by chain ID
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(chainId), s.key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(raw), nil
+}
+
+type externalSigner struct {
+	rpc *rpc.RPCClient
+}
+
+func (s *externalSigner) SignTx(req *rpc.TxRequest) (string, error) {
+	return s.rpc.SignTransaction(req)
+}
@@ -0,0 +1,60 @@
+package payouts
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestKeystoreSigner(t *testing.T) {
+	dir := t.TempDir()
+	account, err := keystore.StoreKey(dir, "secret", keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "password"), []byte("secret\n"), 0600)
+	address := account.Address.Hex()
+
+	cfg := &SignerConfig{Type: SignerKeystore, Keystore: account.URL.Path, PasswordFile: filepath.Join(dir, "password")}
+	if _, err := newKeystoreSigner(cfg, "0xb85150eb365e7df0941f0cf08235f987ba91506a"); err == nil {
+		t.Error("Must refuse key of another address")
+	}
+	s, err := newKeystoreSigner(cfg, address)
+	if err != nil {
+		t.Fatalf("Must decrypt keystore, got %v", err)
+	}
+
+	req := &rpc.TxRequest{From: address, To: "0xb85150eb365e7df0941f0cf08235f987ba91506a", Value: "0xde0b6b3a7640000",
+		Gas: "0x5208", MaxFeePerGas: "0x4a817c800", MaxPriorityFeePerGas: "0x3b9aca00", Nonce: "0x7", ChainId: "0x3d"}
+	raw, err := s.SignTx(req)
+	if err != nil {
+		t.Fatalf("Must sign tx, got %v", err)
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+		t.Fatalf("Must encode signed tx, got %v", err)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(big.NewInt(61)), tx)
+	if err != nil || sender != account.Address {
+		t.Errorf("Must sign by keystore key, got %v (%v)", sender.Hex(), err)
+	}
+	if tx.Type() != types.DynamicFeeTxType || tx.Nonce() != 7 || tx.ChainId().Int64() != 61 || tx.GasFeeCap().Int64() != 20000000000 {
+		t.Errorf("Must sign EIP-1559 tx of request, got type %v nonce %v chain %v", tx.Type(), tx.Nonce(), tx.ChainId())
+	}
+
+	var errs util.ConfigErrors
+	(&SignerConfig{Type: SignerKeystore}).Validate(&errs)
+	(&SignerConfig{Type: SignerExternal}).Validate(&errs)
+	(&SignerConfig{Type: "wallet"}).Validate(&errs)
+	if len(errs) != 4 {
+		t.Errorf("Must require keystore, password and signer URL, got %v", errs)
+	}
+}
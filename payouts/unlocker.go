@@ -1,6 +1,7 @@
 package payouts
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log"
 	"math/big"
@@ -10,7 +11,10 @@ import (
 
 	"github.com/ethereum/go-ethereum/common/math"
 
+	"github.com/etclabscore/open-etc-pool/bookkeeping"
+	"github.com/etclabscore/open-etc-pool/metrics"
 	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/statuspage"
 	"github.com/etclabscore/open-etc-pool/storage"
 	"github.com/etclabscore/open-etc-pool/util"
 )
@@ -28,10 +32,46 @@ type UnlockerConfig struct {
 	Timeout           string   `json:"timeout"`
 	Ecip1017FBlock    int64    `json:"ecip1017FBlock"`
 	Ecip1017EraRounds *big.Int `json:"ecip1017EraRounds"`
+	// Percent of solo block reward, poolFee if not set
+	SoloFee float64 `json:"soloFee"`
+	// Split of pool fee among several addresses instead of poolFeeAddress, percents sum to 100
+	FeeSplit []storage.FeeRecipient `json:"feeSplit"`
+	// Receives donations miners opt in to, pool fee recipients if not set
+	DonationAddress string `json:"donationAddress"`
 }
 
 const minDepth = 16
 
+func (c *UnlockerConfig) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	errs.Duration("unlocker.interval", c.Interval, false)
+	errs.Duration("unlocker.timeout", c.Timeout, false)
+	if len(c.DonationAddress) != 0 && !util.IsValidHexAddress(c.DonationAddress) {
+		errs.Addf("unlocker.donationAddress: invalid address %q", c.DonationAddress)
+	}
+	if len(c.FeeSplit) == 0 {
+		return
+	}
+	if len(c.PoolFeeAddress) != 0 {
+		errs.Addf("unlocker: poolFeeAddress and feeSplit can't be both set")
+	}
+	var total float64
+	for i, r := range c.FeeSplit {
+		if !util.IsValidHexAddress(r.Address) {
+			errs.Addf("unlocker.feeSplit[%v].address: invalid address %q", i, r.Address)
+		}
+		if r.Percent <= 0 {
+			errs.Addf("unlocker.feeSplit[%v].percent: must be positive", i)
+		}
+		total += r.Percent
+	}
+	if total < 100-1e-9 || total > 100+1e-9 {
+		errs.Addf("unlocker.feeSplit: percents must sum to 100, got %v", total)
+	}
+}
+
 var disinflationRateQuotient = big.NewInt(4) // Disinflation rate quotient for ECIP1017
 var disinflationRateDivisor = big.NewInt(5)  // Disinflation rate divisor for ECIP1017
 var big32 = big.NewInt(32)
@@ -41,33 +81,59 @@ var homesteadReward = math.MustParseBig256("5000000000000000000")
 
 type BlockUnlocker struct {
 	config   *UnlockerConfig
-	backend  *storage.RedisClient
+	rewards  *storage.RewardsConfig
+	backend  storage.Storage
 	rpc      *rpc.RPCClient
 	halt     bool
 	lastFail error
+	run      *metrics.Run
+}
+
+// Era length of ECIP-1017 on network, configured or Mordor one on unknown networks
+func EraRounds(network string, configured *big.Int) *big.Int {
+	switch network {
+	case "classic":
+		return big.NewInt(5000000)
+	case "mordor":
+		return big.NewInt(2000000)
+	}
+	if configured != nil {
+		return configured
+	}
+	return big.NewInt(2000000)
 }
 
-func NewBlockUnlocker(cfg *UnlockerConfig, backend *storage.RedisClient, network *string) *BlockUnlocker {
+// Reward of block at height without uncle inclusion rewards and tx fees
+func BlockSubsidy(height int64, eraRounds *big.Int) *big.Int {
+	return getConstReward(GetBlockEra(big.NewInt(height), eraRounds))
+}
+
+// Testnet mode accepts unknown networks with configured or Mordor era rounds
+// and shallower maturity depth
+func NewBlockUnlocker(cfg *UnlockerConfig, rewards *storage.RewardsConfig, backend storage.Storage, network *string, testnet bool) *BlockUnlocker {
 	if *network == "classic" {
 		cfg.Ecip1017FBlock = 5000000
-		cfg.Ecip1017EraRounds = big.NewInt(5000000)
 	} else if *network == "mordor" {
 		cfg.Ecip1017FBlock = 0
-		cfg.Ecip1017EraRounds = big.NewInt(2000000)
-	} else {
-		log.Fatalln("Invalid network set", network)
+	} else if !testnet {
+		log.Fatalln("Invalid network set", *network)
 	}
+	cfg.Ecip1017EraRounds = EraRounds(*network, cfg.Ecip1017EraRounds)
 
 	if len(cfg.PoolFeeAddress) != 0 && !util.IsValidHexAddress(cfg.PoolFeeAddress) {
 		log.Fatalln("Invalid poolFeeAddress", cfg.PoolFeeAddress)
 	}
-	if cfg.Depth < minDepth*2 {
-		log.Fatalf("Block maturity depth can't be < %v, your depth is %v", minDepth*2, cfg.Depth)
+	depth := int64(minDepth * 2)
+	if testnet {
+		depth = minDepth
+	}
+	if cfg.Depth < depth {
+		log.Fatalf("Block maturity depth can't be < %v, your depth is %v", depth, cfg.Depth)
 	}
 	if cfg.ImmatureDepth < minDepth {
 		log.Fatalf("Immature depth can't be < %v, your depth is %v", minDepth, cfg.ImmatureDepth)
 	}
-	u := &BlockUnlocker{config: cfg, backend: backend}
+	u := &BlockUnlocker{config: cfg, rewards: rewards, backend: backend}
 	u.rpc = rpc.NewRPCClient("BlockUnlocker", cfg.Daemon, cfg.Timeout)
 	return u
 }
@@ -78,23 +144,61 @@ func (u *BlockUnlocker) Start() {
 	timer := time.NewTimer(intv)
 	log.Printf("Set block unlock interval to %v", intv)
 
+	u.publishParams()
+
 	// Immediately unlock after start
-	u.unlockPendingBlocks()
-	u.unlockAndCreditMiners()
+	u.unlock()
 	timer.Reset(intv)
 
 	go func() {
 		for {
 			select {
 			case <-timer.C:
-				u.unlockPendingBlocks()
-				u.unlockAndCreditMiners()
+				u.unlock()
 				timer.Reset(intv)
 			}
 		}
 	}()
 }
 
+func (u *BlockUnlocker) unlock() {
+	u.run = metrics.NewRun(metrics.Unlocker, u.backend)
+	u.unlockPendingBlocks()
+	u.unlockAndCreditMiners()
+	// Credits stay accrued until operator resolves halt
+	if u.rewards.PayPerShare() && !u.halt {
+		u.settlePPS()
+	}
+	u.reportStatus()
+	u.run.Done(!u.halt)
+}
+
+// Parameters are served on /api/transparency, so miners can check what pool charges
+func (u *BlockUnlocker) publishParams() {
+	params := &storage.UnlockerParams{
+		PoolFee:         u.config.PoolFee,
+		PoolFeeAddress:  u.config.PoolFeeAddress,
+		FeeSplit:        u.config.FeeSplit,
+		DonationAddress: u.config.DonationAddress,
+		Depth:           u.config.Depth,
+		ImmatureDepth:   u.config.ImmatureDepth,
+		KeepTxFees:      u.config.KeepTxFees,
+		SoloFee:         u.soloFee(),
+		Timestamp:       util.MakeTimestamp() / 1000,
+	}
+	if err := u.backend.WriteUnlockerParams(params); err != nil {
+		log.Printf("Failed to publish unlocker params: %v", err)
+	}
+}
+
+func (u *BlockUnlocker) reportStatus() {
+	if u.halt {
+		statuspage.Report(statuspage.Unlocker, statuspage.Outage)
+	} else {
+		statuspage.Report(statuspage.Unlocker, statuspage.Operational)
+	}
+}
+
 type UnlockResult struct {
 	maturedBlocks  []*storage.BlockData
 	orphanedBlocks []*storage.BlockData
@@ -125,19 +229,21 @@ func (u *BlockUnlocker) unlockCandidates(candidates []*storage.BlockData) (*Unlo
 			// avoid scanning the first 16 blocks
 			continue
 		}
+		// Whole search window is fetched in one round trip
+		var heights []int64
 		for i := int64(minDepth * -1); i < minDepth; i++ {
-			height := candidate.Height + i
-
-			if height < 0 {
-				continue
+			if height := candidate.Height + i; height >= 0 {
+				heights = append(heights, height)
 			}
+		}
+		blocks, err := u.rpc.GetBlocksByHeight(heights)
+		if err != nil {
+			log.Printf("Error while retrieving blocks around %v from node: %v", candidate.Height, err)
+			return nil, err
+		}
+		for j, block := range blocks {
+			height := heights[j]
 
-			block, err := u.rpc.GetBlockByHeight(height)
-
-			if err != nil {
-				log.Printf("Error while retrieving block %v from node: %v", height, err)
-				return nil, err
-			}
 			if block == nil {
 				return nil, fmt.Errorf("Error while retrieving block %v from node, wrong node height", height)
 			}
@@ -162,11 +268,11 @@ func (u *BlockUnlocker) unlockCandidates(candidates []*storage.BlockData) (*Unlo
 			}
 
 			// Trying to find uncle in current block during our forward check
-			for uncleIndex, uncleHash := range block.Uncles {
-				uncle, err := u.rpc.GetUncleByBlockNumberAndIndex(height, uncleIndex)
-				if err != nil {
-					return nil, fmt.Errorf("Error while retrieving uncle of block %v from node: %v", uncleHash, err)
-				}
+			uncles, err := u.rpc.GetUnclesByBlockNumber(height, len(block.Uncles))
+			if err != nil {
+				return nil, fmt.Errorf("Error while retrieving uncles of block %v from node: %v", height, err)
+			}
+			for _, uncle := range uncles {
 				if uncle == nil {
 					return nil, fmt.Errorf("Error while retrieving uncle of block %v from node", height)
 				}
@@ -248,6 +354,7 @@ func (u *BlockUnlocker) handleBlock(block *rpc.GetBlockReply, candidate *storage
 	candidate.Orphan = false
 	candidate.Hash = block.Hash
 	candidate.Reward = reward
+	candidate.ExtraData = extraDataTag(block.ExtraData)
 	return nil
 }
 
@@ -263,9 +370,25 @@ func handleUncle(height int64, uncle *rpc.GetBlockReply, candidate *storage.Bloc
 	candidate.Orphan = false
 	candidate.Hash = uncle.Hash
 	candidate.Reward = reward
+	candidate.ExtraData = extraDataTag(uncle.ExtraData)
 	return nil
 }
 
+// Printable part of hex encoded header extra data
+func extraDataTag(extra string) string {
+	data, err := hex.DecodeString(strings.TrimPrefix(extra, "0x"))
+	if err != nil {
+		return ""
+	}
+	tag := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, string(data))
+	return strings.TrimSpace(tag)
+}
+
 func (u *BlockUnlocker) unlockPendingBlocks() {
 	if u.halt {
 		log.Println("Unlocking suspended due to last critical error:", u.lastFail)
@@ -308,6 +431,9 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 		return
 	}
 	log.Printf("Immature %v blocks, %v uncles, %v orphans", result.blocks, result.uncles, result.orphans)
+	u.run.Set("immature_blocks", float64(result.blocks))
+	u.run.Set("immature_uncles", float64(result.uncles))
+	u.run.Add("orphans", float64(result.orphans))
 
 	err = u.backend.WritePendingOrphans(result.orphanedBlocks)
 	if err != nil {
@@ -331,7 +457,17 @@ func (u *BlockUnlocker) unlockPendingBlocks() {
 			log.Printf("Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
+		if _, err := u.applyDonations(roundRewards); err != nil {
+			u.halt = true
+			u.lastFail = err
+			log.Printf("Failed to apply donations to round %v: %v", block.RoundKey(), err)
+			return
+		}
 		err = u.backend.WriteImmatureBlock(block, roundRewards)
+		if err == storage.ErrAlreadyProcessed {
+			log.Printf("Round %v was credited by another unlocker, skipping", block.RoundKey())
+			continue
+		}
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
@@ -406,9 +542,16 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 		return
 	}
 	log.Printf("Unlocked %v blocks, %v uncles, %v orphans", result.blocks, result.uncles, result.orphans)
+	u.run.Set("matured_blocks", float64(result.blocks))
+	u.run.Set("matured_uncles", float64(result.uncles))
+	u.run.Add("orphans", float64(result.orphans))
 
 	for _, block := range result.orphanedBlocks {
 		err = u.backend.WriteOrphan(block)
+		if err == storage.ErrAlreadyProcessed {
+			log.Printf("Round %v was processed by another unlocker, skipping", block.RoundKey())
+			continue
+		}
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
@@ -430,13 +573,42 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 			log.Printf("Failed to calculate rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
+		donated, err := u.applyDonations(roundRewards)
+		if err != nil {
+			u.halt = true
+			u.lastFail = err
+			log.Printf("Failed to apply donations to round %v: %v", block.RoundKey(), err)
+			return
+		}
 		err = u.backend.WriteMaturedBlock(block, roundRewards)
+		if err == storage.ErrAlreadyProcessed {
+			log.Printf("Round %v was credited by another unlocker, skipping", block.RoundKey())
+			continue
+		}
 		if err != nil {
 			u.halt = true
 			u.lastFail = err
 			log.Printf("Failed to credit rewards for round %v: %v", block.RoundKey(), err)
 			return
 		}
+		if funding := u.ppsFunding(block); funding.Sign() > 0 {
+			amount := weiToShannonInt64(new(big.Rat).SetInt(funding))
+			if err := u.backend.FundPPS(block, amount); err != nil {
+				u.halt = true
+				u.lastFail = err
+				log.Printf("Failed to fund PPS buffer with round %v: %v", block.RoundKey(), err)
+				return
+			}
+			log.Printf("Funded PPS buffer with %v Shannon of round %v", amount, block.RoundKey())
+		}
+		if len(donated) > 0 {
+			if err := u.backend.WriteDonations(donated); err != nil {
+				log.Printf("Failed to record donations of round %v: %v", block.RoundKey(), err)
+			}
+		}
+		if fee := new(big.Int).Quo(poolProfit.Num(), poolProfit.Denom()); fee.Sign() > 0 {
+			bookkeeping.Record(bookkeeping.FeeEntry(u.backend.Tenant(), block.Height, block.Hash, fee))
+		}
 		totalRevenue.Add(totalRevenue, revenue)
 		totalMinersProfit.Add(totalMinersProfit, minersProfit)
 		totalPoolProfit.Add(totalPoolProfit, poolProfit)
@@ -463,16 +635,62 @@ func (u *BlockUnlocker) unlockAndCreditMiners() {
 	)
 }
 
-func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, error) {
-	revenue := new(big.Rat).SetInt(block.Reward)
-	minersProfit, poolProfit := chargeFee(revenue, u.config.PoolFee)
+// Part of block reward funding PPS buffer: whole reward under PPS,
+// subsidy of block or whole uncle reward under PPS+
+func (u *BlockUnlocker) ppsFunding(block *storage.BlockData) *big.Int {
+	// Solo shares are not credited by PPS
+	if len(block.Solver) > 0 {
+		return new(big.Int)
+	}
+	switch u.rewards.Scheme {
+	case storage.SchemePPS:
+		return new(big.Int).Set(block.Reward)
+	case storage.SchemePPSPlus:
+		if block.Uncle {
+			return new(big.Int).Set(block.Reward)
+		}
+		subsidy := BlockSubsidy(block.Height, u.config.Ecip1017EraRounds)
+		if subsidy.Cmp(block.Reward) > 0 {
+			return new(big.Int).Set(block.Reward)
+		}
+		return subsidy
+	}
+	return new(big.Int)
+}
 
-	shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
-	if err != nil {
-		return nil, nil, nil, nil, err
+func (u *BlockUnlocker) soloFee() float64 {
+	if u.config.SoloFee > 0 {
+		return u.config.SoloFee
+	}
+	return u.config.PoolFee
+}
+
+// Rewards are split from block reward less PPS funding, which is charged fee on each share instead.
+// Solo block goes to its solver whole.
+func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (*big.Rat, *big.Rat, *big.Rat, map[string]int64, error) {
+	revenue := new(big.Rat).SetInt(new(big.Int).Sub(block.Reward, u.ppsFunding(block)))
+	fee := u.config.PoolFee
+	if len(block.Solver) > 0 {
+		fee = u.soloFee()
 	}
+	minersProfit, poolProfit := chargeFee(revenue, fee)
 
-	rewards := calculateRewardsForShares(shares, block.TotalShares, minersProfit)
+	rewards := make(map[string]int64)
+	if len(block.Solver) > 0 {
+		rewards[block.Solver] = weiToShannonInt64(minersProfit)
+	} else if revenue.Sign() > 0 {
+		shares, err := u.backend.GetRoundShares(block.RoundHeight, block.Nonce)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		// Round shares hold PPLNS window instead of shares of round under that scheme,
+		// so reward is split by their own total
+		var total int64
+		for _, n := range shares {
+			total += n
+		}
+		rewards = calculateRewardsForShares(shares, total, minersProfit)
+	}
 
 	if block.ExtraReward != nil {
 		extraReward := new(big.Rat).SetInt(block.ExtraReward)
@@ -480,14 +698,86 @@ func (u *BlockUnlocker) calculateRewards(block *storage.BlockData) (*big.Rat, *b
 		revenue.Add(revenue, extraReward)
 	}
 
-	if len(u.config.PoolFeeAddress) != 0 {
-		address := strings.ToLower(u.config.PoolFeeAddress)
-		rewards[address] += weiToShannonInt64(poolProfit)
-	}
+	u.creditFee(rewards, weiToShannonInt64(poolProfit))
 
 	return revenue, minersProfit, poolProfit, rewards, nil
 }
 
+// Pool profit in Shannon goes to poolFeeAddress or is split among feeSplit recipients,
+// the first one gets rounding remainder. It stays on coinbase if neither is set.
+func (u *BlockUnlocker) creditFee(rewards map[string]int64, fee int64) {
+	if len(u.config.FeeSplit) == 0 {
+		if len(u.config.PoolFeeAddress) != 0 {
+			rewards[strings.ToLower(u.config.PoolFeeAddress)] += fee
+		}
+		return
+	}
+	rest := fee
+	for _, r := range u.config.FeeSplit[1:] {
+		amount := int64(float64(fee) * r.Percent / 100)
+		rewards[strings.ToLower(r.Address)] += amount
+		rest -= amount
+	}
+	rewards[strings.ToLower(u.config.FeeSplit[0].Address)] += rest
+}
+
+// Moves donated percent of round reward of opted-in miners to donationAddress, or to pool
+// fee recipients if it is not set. Nothing is donated without any recipient. Returns donated
+// amounts by miner.
+func (u *BlockUnlocker) applyDonations(rewards map[string]int64) (map[string]int64, error) {
+	percents, err := u.backend.GetDonations()
+	if err != nil {
+		return nil, err
+	}
+	return u.donate(rewards, percents), nil
+}
+
+func (u *BlockUnlocker) donate(rewards map[string]int64, percents map[string]float64) map[string]int64 {
+	address := strings.ToLower(u.config.DonationAddress)
+	donated := make(map[string]int64)
+	if len(address) == 0 && len(u.config.PoolFeeAddress) == 0 && len(u.config.FeeSplit) == 0 {
+		return donated
+	}
+	var total int64
+	for login, percent := range percents {
+		reward := rewards[login]
+		if login == address || reward <= 0 {
+			continue
+		}
+		if amount := int64(float64(reward) * percent / 100); amount > 0 {
+			rewards[login] -= amount
+			donated[login] = amount
+			total += amount
+		}
+	}
+	if total == 0 {
+		return donated
+	}
+	if len(address) != 0 {
+		rewards[address] += total
+	} else {
+		u.creditFee(rewards, total)
+	}
+	return donated
+}
+
+// Unsettled share credits are moved to balances once per run
+func (u *BlockUnlocker) settlePPS() {
+	credits, err := u.backend.SettlePPS()
+	if err != nil {
+		log.Printf("Failed to settle PPS credits: %v", err)
+		return
+	}
+	var total int64
+	for _, amount := range credits {
+		total += amount
+	}
+	u.run.Set("pps_credited", float64(total))
+	if len(credits) > 0 {
+		log.Printf("Credited %v Shannon of PPS shares to %v miners", total, len(credits))
+	}
+}
+
 func calculateRewardsForShares(shares map[string]int64, total int64, reward *big.Rat) map[string]int64 {
 	rewards := make(map[string]int64)
 
@@ -579,12 +869,16 @@ func getUncleReward(uHeight *big.Int, height *big.Int, era *big.Int, reward *big
 func (u *BlockUnlocker) getExtraRewardForTx(block *rpc.GetBlockReply) (*big.Int, error) {
 	amount := new(big.Int)
 
-	for _, tx := range block.Transactions {
-		receipt, err := u.rpc.GetTxReceipt(tx.Hash)
-		if err != nil {
-			return nil, err
-		}
-		if receipt != nil {
+	hashes := make([]string, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hashes[i] = tx.Hash
+	}
+	receipts, err := u.rpc.GetTxReceipts(hashes)
+	if err != nil {
+		return nil, err
+	}
+	for i, tx := range block.Transactions {
+		if receipt := receipts[i]; receipt != nil {
 			gasUsed := util.String2Big(receipt.GasUsed)
 			gasPrice := util.String2Big(tx.GasPrice)
 			fee := new(big.Int).Mul(gasUsed, gasPrice)
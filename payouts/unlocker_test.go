@@ -3,8 +3,10 @@ package payouts
 import (
 	"github.com/etclabscore/open-etc-pool/rpc"
 	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
 	"math/big"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -163,3 +165,86 @@ func TestMatchCandidate(t *testing.T) {
 		t.Error("Must match with hash")
 	}
 }
+
+func TestExtraDataTag(t *testing.T) {
+	if tag := extraDataTag("0x6f70656e2d6574632d706f6f6c"); tag != "open-etc-pool" {
+		t.Errorf("Must decode extra data, got %q", tag)
+	}
+	if tag := extraDataTag("0xd883010a0b846765746888676f312e31352e35856c696e7578"); tag != "gethgo1.15.5linux" {
+		t.Errorf("Must drop non printable bytes, got %q", tag)
+	}
+	if tag := extraDataTag("0xzz"); tag != "" {
+		t.Errorf("Must ignore malformed extra data, got %q", tag)
+	}
+}
+
+func TestSoloRewards(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{PoolFee: 1, SoloFee: 2, PoolFeeAddress: "0xFee"},
+		rewards: &storage.RewardsConfig{Scheme: storage.SchemePPS}}
+	block := &storage.BlockData{Height: 1, Solver: "0xs", Reward: homesteadReward}
+	_, _, _, rewards, err := u.calculateRewards(block)
+	if err != nil || !reflect.DeepEqual(rewards, map[string]int64{"0xs": 4900000000, "0xfee": 100000000}) {
+		t.Errorf("Must credit whole reward less solo fee to solver, got %v %v", rewards, err)
+	}
+}
+
+func TestPPSFunding(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{Ecip1017EraRounds: big.NewInt(5000000)},
+		rewards: &storage.RewardsConfig{Scheme: storage.SchemePPSPlus}}
+	reward := new(big.Int).Add(homesteadReward, big.NewInt(100))
+	if funding := u.ppsFunding(&storage.BlockData{Height: 1, Reward: reward}); funding.Cmp(homesteadReward) != 0 {
+		t.Errorf("Must fund buffer with subsidy only, got %v", funding)
+	}
+	if funding := u.ppsFunding(&storage.BlockData{Height: 1, Uncle: true, Reward: big.NewInt(100)}); funding.Int64() != 100 {
+		t.Errorf("Must fund buffer with whole uncle reward, got %v", funding)
+	}
+	u.rewards.Scheme = storage.SchemePPLNS
+	if funding := u.ppsFunding(&storage.BlockData{Height: 1, Reward: reward}); funding.Sign() != 0 {
+		t.Errorf("Must not fund buffer under PPLNS, got %v", funding)
+	}
+}
+
+func TestFeeSplit(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{FeeSplit: []storage.FeeRecipient{
+		{Name: "operator", Address: "0xA", Percent: 50},
+		{Name: "servers", Address: "0xb", Percent: 33.3},
+		{Name: "dev", Address: "0xc", Percent: 16.7},
+	}}}
+	rewards := map[string]int64{"0xa": 1}
+	u.creditFee(rewards, 1001)
+	if !reflect.DeepEqual(rewards, map[string]int64{"0xa": 502, "0xb": 333, "0xc": 167}) {
+		t.Errorf("Must split fee by percent with remainder to first recipient, got %v", rewards)
+	}
+
+	var errs util.ConfigErrors
+	(&UnlockerConfig{Enabled: true, Interval: "1m", Timeout: "1s", PoolFeeAddress: "0x0000000000000000000000000000000000000001",
+		FeeSplit: []storage.FeeRecipient{{Address: "0x0000000000000000000000000000000000000002", Percent: 60}, {Address: "0xz", Percent: 0}}}).Validate(&errs)
+	if len(errs) != 4 {
+		t.Errorf("Must reject fee address with split, bad address, zero percent and sum other than 100, got %v", errs)
+	}
+}
+
+func TestDonations(t *testing.T) {
+	u := &BlockUnlocker{config: &UnlockerConfig{PoolFeeAddress: "0xFee"}}
+	rewards := map[string]int64{"0xa": 1000, "0xb": 1000, "0xfee": 10}
+	donated := u.donate(rewards, map[string]float64{"0xa": 2.5, "0xc": 50})
+	if !reflect.DeepEqual(donated, map[string]int64{"0xa": 25}) {
+		t.Errorf("Must donate from round reward of opted-in miners only, got %v", donated)
+	}
+	if !reflect.DeepEqual(rewards, map[string]int64{"0xa": 975, "0xb": 1000, "0xfee": 35}) {
+		t.Errorf("Must credit donation to pool fee address, got %v", rewards)
+	}
+
+	u.config.DonationAddress = "0xCharity"
+	rewards = map[string]int64{"0xa": 1000}
+	u.donate(rewards, map[string]float64{"0xa": 10})
+	if !reflect.DeepEqual(rewards, map[string]int64{"0xa": 900, "0xcharity": 100}) {
+		t.Errorf("Must credit donation to donation address, got %v", rewards)
+	}
+
+	u.config = &UnlockerConfig{}
+	rewards = map[string]int64{"0xa": 1000}
+	if donated := u.donate(rewards, map[string]float64{"0xa": 10}); len(donated) != 0 || rewards["0xa"] != 1000 {
+		t.Errorf("Must not take donation without recipient, got %v %v", donated, rewards)
+	}
+}
@@ -0,0 +1,145 @@
+package payouts
+
+import (
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const defaultVerifyInterval = 24 * time.Hour
+
+// Periodic check of payments history against chain. Nodes index only recent
+// transactions by default, so archive node should be used for old payments.
+type VerifyConfig struct {
+	Enabled bool `json:"enabled"`
+	// Defaults to payouts daemon and timeout
+	Daemon   string `json:"daemon"`
+	Timeout  string `json:"timeout"`
+	Interval string `json:"interval"`
+}
+
+func (c *VerifyConfig) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	errs.Duration("payouts.verify.timeout", c.Timeout, true)
+	errs.Duration("payouts.verify.interval", c.Interval, true)
+}
+
+type PaymentVerifier struct {
+	address string
+	backend storage.Storage
+	rpc     *rpc.RPCClient
+	intv    time.Duration
+	// Multisend contract of batch payments, kept after batches are disabled
+	batchContract string
+}
+
+func NewPaymentVerifier(cfg *PayoutsConfig, backend storage.Storage) *PaymentVerifier {
+	daemon, timeout := cfg.Verify.Daemon, cfg.Verify.Timeout
+	if len(daemon) == 0 {
+		daemon = cfg.Daemon
+	}
+	if len(timeout) == 0 {
+		timeout = cfg.Timeout
+	}
+	v := &PaymentVerifier{address: cfg.Address, batchContract: cfg.Batch.Contract, backend: backend, intv: defaultVerifyInterval}
+	if len(cfg.Verify.Interval) > 0 {
+		v.intv = util.MustParseDuration(cfg.Verify.Interval)
+	}
+	v.rpc = rpc.NewRPCClient("PaymentVerifier", daemon, timeout)
+	return v
+}
+
+func (v *PaymentVerifier) Start() {
+	log.Printf("Verifying payments on chain every %v", v.intv)
+	v.run()
+	ticker := time.NewTicker(v.intv)
+	go func() {
+		for range ticker.C {
+			v.run()
+		}
+	}()
+}
+
+func (v *PaymentVerifier) run() {
+	report, err := v.verify()
+	if err != nil {
+		log.Printf("Failed to verify payments: %v", err)
+		return
+	}
+	for _, d := range report.Discrepancies {
+		log.Printf("Payment discrepancy: %v Shannon to %v at %v, tx %v: %v",
+			d.Amount, d.Address, time.Unix(d.Timestamp, 0), d.TxHash, d.Reason)
+	}
+	log.Printf("Verified %v payments, %v discrepancies", report.Checked, len(report.Discrepancies))
+	if err := v.backend.WritePaymentsReport(report); err != nil {
+		log.Printf("Failed to write payments report to backend: %v", err)
+	}
+}
+
+func (v *PaymentVerifier) verify() (*storage.PaymentsReport, error) {
+	payments, err := v.backend.GetAllPayments()
+	if err != nil {
+		return nil, err
+	}
+	report := &storage.PaymentsReport{Timestamp: util.MakeTimestamp() / 1000, Checked: len(payments)}
+	hashes := make([]string, len(payments))
+	for i, p := range payments {
+		hashes[i] = p.TxHash
+	}
+	txs, err := v.rpc.GetTransactions(hashes)
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := v.rpc.GetTxReceipts(hashes)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range payments {
+		if reason := v.check(p, txs[i], receipts[i]); len(reason) > 0 {
+			report.Discrepancies = append(report.Discrepancies, &storage.PaymentDiscrepancy{Payment: *p, Reason: reason})
+		}
+	}
+	return report, nil
+}
+
+// Reason of mismatch, empty if payment is on chain as recorded
+func (v *PaymentVerifier) check(p *storage.Payment, tx *rpc.Tx, receipt *rpc.TxReceipt) string {
+	if tx == nil {
+		return "transaction not found"
+	}
+	if receipt == nil || !receipt.Confirmed() {
+		return "transaction not mined"
+	}
+	if !receipt.Successful() {
+		return "transaction failed"
+	}
+	if len(v.address) > 0 && !strings.EqualFold(tx.From, v.address) {
+		return "sent from " + tx.From
+	}
+	amount := new(big.Int).Mul(big.NewInt(p.Amount), util.Shannon)
+	// Payment of batch is transfer logged by multisend contract
+	if len(v.batchContract) > 0 && strings.EqualFold(tx.To, v.batchContract) {
+		transfer, ok := batchTransfers(receipt, v.batchContract)[strings.ToLower(p.Address)]
+		if !ok || !transfer.sent {
+			return "not sent by batch contract"
+		}
+		if transfer.amount.Cmp(amount) != 0 {
+			return "batch transfer is " + transfer.amount.String() + " Wei, recorded " + amount.String() + " Wei"
+		}
+		return ""
+	}
+	if !strings.EqualFold(tx.To, p.Address) {
+		return "sent to " + tx.To
+	}
+	if value := util.String2Big(tx.Value); value.Cmp(amount) != 0 {
+		return "value is " + value.String() + " Wei, recorded " + amount.String() + " Wei"
+	}
+	return ""
+}
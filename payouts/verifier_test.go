@@ -0,0 +1,31 @@
+package payouts
+
+import (
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+func TestCheckPayment(t *testing.T) {
+	v := &PaymentVerifier{address: "0xPool"}
+	p := &storage.Payment{TxHash: "0x1", Address: "0xminer", Amount: 1000000000}
+	tx := &rpc.Tx{From: "0xpool", To: "0xMiner", Value: "0xde0b6b3a7640000"}
+	mined := &rpc.TxReceipt{BlockHash: "0x2", Status: "0x1"}
+
+	if reason := v.check(p, tx, mined); len(reason) > 0 {
+		t.Errorf("Must accept matching payment, got %v", reason)
+	}
+	if reason := v.check(p, nil, nil); reason != "transaction not found" {
+		t.Errorf("Must report missing transaction, got %v", reason)
+	}
+	if reason := v.check(p, tx, &rpc.TxReceipt{BlockHash: "0x2", Status: "0x0"}); reason != "transaction failed" {
+		t.Errorf("Must report failed transaction, got %v", reason)
+	}
+	if reason := v.check(p, &rpc.Tx{From: "0xpool", To: "0xother", Value: tx.Value}, mined); reason != "sent to 0xother" {
+		t.Errorf("Must report other recipient, got %v", reason)
+	}
+	if reason := v.check(p, &rpc.Tx{From: "0xpool", To: "0xminer", Value: "0x1"}, mined); len(reason) == 0 {
+		t.Error("Must report value mismatch")
+	}
+}
@@ -3,12 +3,14 @@ package policy
 import (
 	"fmt"
 	"log"
+	"net"
 	"os/exec"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/etclabscore/open-etc-pool/eventbus"
 	"github.com/etclabscore/open-etc-pool/storage"
 	"github.com/etclabscore/open-etc-pool/util"
 )
@@ -19,6 +21,17 @@ type Config struct {
 	Limits          Limits  `json:"limits"`
 	ResetInterval   string  `json:"resetInterval"`
 	RefreshInterval string  `json:"refreshInterval"`
+	// Track and ban IPv6 clients by network prefix of this length, 0 to use full address
+	IPv6Prefix int `json:"ipv6Prefix"`
+	// Stricter thresholds applied while pool is in DDoS mode, zero keeps regular value
+	Emergency Emergency `json:"emergency"`
+}
+
+type Emergency struct {
+	Limit          int32   `json:"limit"`
+	InvalidPercent float32 `json:"invalidPercent"`
+	CheckThreshold int32   `json:"checkThreshold"`
+	MalformedLimit int32   `json:"malformedLimit"`
 }
 
 type Limits struct {
@@ -31,6 +44,7 @@ type Limits struct {
 type Banning struct {
 	Enabled        bool    `json:"enabled"`
 	IPSet          string  `json:"ipset"`
+	IPSet6         string  `json:"ipset6"`
 	Timeout        int64   `json:"timeout"`
 	InvalidPercent float32 `json:"invalidPercent"`
 	CheckThreshold int32   `json:"checkThreshold"`
@@ -62,6 +76,13 @@ type PolicyServer struct {
 	blacklist  []string
 	whitelist  []string
 	storage    *storage.RedisClient
+	emergency  int32
+}
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	errs.Duration("proxy.policy.limits.grace", c.Limits.Grace, false)
+	errs.Duration("proxy.policy.resetInterval", c.ResetInterval, false)
+	errs.Duration("proxy.policy.refreshInterval", c.RefreshInterval, false)
 }
 
 func Start(cfg *Config, storage *storage.RedisClient) *PolicyServer {
@@ -151,6 +172,12 @@ func (s *PolicyServer) refreshState() {
 	if err != nil {
 		log.Printf("Failed to get blacklist from backend: %v", err)
 	}
+	// Logins are matched in canonical form, entries may be checksummed
+	for i, addy := range s.blacklist {
+		if v, ok := util.CanonicalAddress(addy); ok {
+			s.blacklist[i] = v
+		}
+	}
 	s.whitelist, err = s.storage.GetWhitelist()
 	if err != nil {
 		log.Printf("Failed to get whitelist from backend: %v", err)
@@ -158,15 +185,70 @@ func (s *PolicyServer) refreshState() {
 	log.Println("Policy state refresh complete")
 }
 
+// Switches emergency thresholds on or off
+func (s *PolicyServer) SetEmergency(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.emergency, v)
+}
+
+func (s *PolicyServer) inEmergency() bool {
+	return atomic.LoadInt32(&s.emergency) > 0
+}
+
+func (s *PolicyServer) connLimit() int32 {
+	if s.inEmergency() && s.config.Emergency.Limit > 0 {
+		return s.config.Emergency.Limit
+	}
+	return s.config.Limits.Limit
+}
+
+func (s *PolicyServer) invalidPercent() float32 {
+	if s.inEmergency() && s.config.Emergency.InvalidPercent > 0 {
+		return s.config.Emergency.InvalidPercent
+	}
+	return s.config.Banning.InvalidPercent
+}
+
+func (s *PolicyServer) checkThreshold() int32 {
+	if s.inEmergency() && s.config.Emergency.CheckThreshold > 0 {
+		return s.config.Emergency.CheckThreshold
+	}
+	return s.config.Banning.CheckThreshold
+}
+
+func (s *PolicyServer) malformedLimit() int32 {
+	if s.inEmergency() && s.config.Emergency.MalformedLimit > 0 {
+		return s.config.Emergency.MalformedLimit
+	}
+	return s.config.Banning.MalformedLimit
+}
+
 func (s *PolicyServer) NewStats() *Stats {
 	x := &Stats{
-		ConnLimit: s.config.Limits.Limit,
+		ConnLimit: s.connLimit(),
 	}
 	x.heartbeat()
 	return x
 }
 
+// Canonical policy key for an address, IPv6 clients can be aggregated by prefix
+func (s *PolicyServer) key(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if parsed.To4() == nil && s.config.IPv6Prefix > 0 && s.config.IPv6Prefix < 128 {
+		mask := net.CIDRMask(s.config.IPv6Prefix, 128)
+		return (&net.IPNet{IP: parsed.Mask(mask), Mask: mask}).String()
+	}
+	return parsed.String()
+}
+
 func (s *PolicyServer) Get(ip string) *Stats {
+	ip = s.key(ip)
 	s.statsMu.Lock()
 	defer s.statsMu.Unlock()
 
@@ -195,6 +277,14 @@ func (s *PolicyServer) ApplyLimitPolicy(ip string) bool {
 		return true
 	}
 	now := util.MakeTimestamp()
+	// No grace period in emergency, limits raised by valid shares are cut down
+	if s.inEmergency() {
+		x := s.Get(ip)
+		if limit := s.connLimit(); atomic.LoadInt32(&x.ConnLimit) > limit {
+			atomic.StoreInt32(&x.ConnLimit, limit)
+		}
+		return x.decrLimit() > 0
+	}
 	if now-s.startedAt > s.grace {
 		return s.Get(ip).decrLimit() > 0
 	}
@@ -213,7 +303,7 @@ func (s *PolicyServer) ApplyLoginPolicy(addy, ip string) bool {
 func (s *PolicyServer) ApplyMalformedPolicy(ip string) bool {
 	x := s.Get(ip)
 	n := x.incrMalformed()
-	if n >= s.config.Banning.MalformedLimit {
+	if n >= s.malformedLimit() {
 		s.forceBan(x, ip)
 		return false
 	}
@@ -234,7 +324,7 @@ func (s *PolicyServer) ApplySharePolicy(ip string, validShare bool) bool {
 	}
 
 	totalShares := x.ValidShares + x.InvalidShares
-	if totalShares < s.config.Banning.CheckThreshold {
+	if totalShares < s.checkThreshold() {
 		x.Unlock()
 		return true
 	}
@@ -245,7 +335,7 @@ func (s *PolicyServer) ApplySharePolicy(ip string, validShare bool) bool {
 
 	ratio := invalidShares / validShares
 
-	if ratio >= s.config.Banning.InvalidPercent/100.0 {
+	if ratio >= s.invalidPercent()/100.0 {
 		s.forceBan(x, ip)
 		return false
 	}
@@ -264,10 +354,12 @@ func (s *PolicyServer) forceBan(x *Stats, ip string) {
 	atomic.StoreInt64(&x.BannedAt, util.MakeTimestamp())
 
 	if atomic.CompareAndSwapInt32(&x.Banned, 0, 1) {
-		if len(s.config.Banning.IPSet) > 0 {
-			s.banChannel <- ip
+		key := s.key(ip)
+		eventbus.Publish(eventbus.Ban, map[string]string{"ip": key})
+		if len(s.ipset(key)) > 0 {
+			s.banChannel <- key
 		} else {
-			log.Println("Banned peer", ip)
+			log.Println("Banned peer", key)
 		}
 	}
 }
@@ -291,13 +383,22 @@ func (s *PolicyServer) InBlackList(addy string) bool {
 }
 
 func (s *PolicyServer) InWhiteList(ip string) bool {
+	key := s.key(ip)
 	s.RLock()
 	defer s.RUnlock()
-	return util.StringInSlice(ip, s.whitelist)
+	return util.StringInSlice(ip, s.whitelist) || util.StringInSlice(key, s.whitelist)
+}
+
+// IPv6 addresses and prefixes need own ipset of inet6 family
+func (s *PolicyServer) ipset(key string) string {
+	if strings.Contains(key, ":") {
+		return s.config.Banning.IPSet6
+	}
+	return s.config.Banning.IPSet
 }
 
 func (s *PolicyServer) doBan(ip string) {
-	set, timeout := s.config.Banning.IPSet, s.config.Banning.Timeout
+	set, timeout := s.ipset(ip), s.config.Banning.Timeout
 	cmd := fmt.Sprintf("sudo ipset add %s %s timeout %v -!", set, ip, timeout)
 	args := strings.Fields(cmd)
 	head := args[0]
@@ -0,0 +1,52 @@
+package policy
+
+import "testing"
+
+func TestKey(t *testing.T) {
+	s := &PolicyServer{config: &Config{}}
+	if k := s.key("2001:DB8:0:0::1"); k != "2001:db8::1" {
+		t.Errorf("Must canonicalize IPv6 address, got %v", k)
+	}
+	if k := s.key("::ffff:10.0.0.1"); k != "10.0.0.1" {
+		t.Errorf("Must unmap IPv4 address, got %v", k)
+	}
+
+	s.config.IPv6Prefix = 64
+	if k := s.key("2001:db8:1:2:aaaa::1"); k != "2001:db8:1:2::/64" {
+		t.Errorf("Must aggregate IPv6 address by prefix, got %v", k)
+	}
+	if k := s.key("10.0.0.1"); k != "10.0.0.1" {
+		t.Errorf("Must not aggregate IPv4 address, got %v", k)
+	}
+}
+
+func TestIPSet(t *testing.T) {
+	s := &PolicyServer{config: &Config{Banning: Banning{IPSet: "blacklist", IPSet6: "blacklist6"}}}
+	if s.ipset("10.0.0.1") != "blacklist" {
+		t.Error("Must use IPv4 set")
+	}
+	if s.ipset("2001:db8::/64") != "blacklist6" {
+		t.Error("Must use IPv6 set")
+	}
+}
+
+func TestEmergencyThresholds(t *testing.T) {
+	s := &PolicyServer{config: &Config{
+		Banning:   Banning{MalformedLimit: 5, InvalidPercent: 30},
+		Emergency: Emergency{MalformedLimit: 2},
+	}}
+	if s.malformedLimit() != 5 {
+		t.Error("Must use regular malformed limit")
+	}
+	s.SetEmergency(true)
+	if s.malformedLimit() != 2 {
+		t.Error("Must use emergency malformed limit")
+	}
+	if s.invalidPercent() != 30 {
+		t.Error("Must keep regular invalid percent if emergency value is not set")
+	}
+	s.SetEmergency(false)
+	if s.malformedLimit() != 5 {
+		t.Error("Must restore regular malformed limit")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 
@@ -30,6 +32,8 @@ type BlockTemplate struct {
 	GetPendingBlockCache *rpc.GetBlockReplyPart
 	nonces               map[string]bool
 	headers              map[string]heightDiffPair
+	// When first job of this height was fetched
+	heightAt time.Time
 }
 
 type Block struct {
@@ -46,7 +50,46 @@ func (b Block) Nonce() uint64            { return b.nonce }
 func (b Block) MixDigest() common.Hash   { return b.mixDigest }
 func (b Block) NumberU64() uint64        { return b.number }
 
+// Runs fn once for concurrent callers, those arriving while it runs wait for it to finish
+type singleFlight struct {
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+// True if caller waited for run started by another one
+func (f *singleFlight) do(fn func()) bool {
+	f.mu.Lock()
+	if done := f.done; done != nil {
+		f.mu.Unlock()
+		<-done
+		return true
+	}
+	done := make(chan struct{})
+	f.done = done
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.done = nil
+		f.mu.Unlock()
+		close(done)
+	}()
+	fn()
+	return false
+}
+
+// Burst of refresh triggers, e.g. submissions right after new block, makes one upstream fetch,
+// the rest get template it stored
 func (s *ProxyServer) fetchBlockTemplate() {
+	s.templateFetch.do(s.refreshBlockTemplate)
+}
+
+func (s *ProxyServer) refreshBlockTemplate() {
+	// Work of node on another network or past fork without upgrade is never served
+	i := int(atomic.LoadInt32(&s.upstream))
+	if !s.chainVerified(i) || !s.forkAllowed(i) {
+		return
+	}
 	rpc := s.rpc()
 	t := s.currentBlockTemplate()
 	pendingReply, height, diff, err := s.fetchPendingBlock()
@@ -74,6 +117,10 @@ func (s *ProxyServer) fetchBlockTemplate() {
 		Difficulty:           big.NewInt(diff),
 		GetPendingBlockCache: pendingReply,
 		headers:              make(map[string]heightDiffPair),
+		heightAt:             time.Now(),
+	}
+	if t != nil && t.Height == height {
+		newTemplate.heightAt = t.heightAt
 	}
 	// Copy job backlog and add current one
 	newTemplate.headers[reply[0]] = heightDiffPair{
@@ -81,8 +128,12 @@ func (s *ProxyServer) fetchBlockTemplate() {
 		height: height,
 	}
 	if t != nil {
+		backlog := uint64(maxBacklog)
+		if s.config.Proxy.JobBacklog > 0 {
+			backlog = uint64(s.config.Proxy.JobBacklog)
+		}
 		for k, v := range t.headers {
-			if v.height > height-maxBacklog {
+			if v.height+backlog > height {
 				newTemplate.headers[k] = v
 			}
 		}
@@ -90,9 +141,21 @@ func (s *ProxyServer) fetchBlockTemplate() {
 	s.blockTemplate.Store(&newTemplate)
 	log.Printf("New block to mine on %s at height %d / %s", rpc.Name, height, reply[0][0:10])
 
+	if t == nil || t.Height != height {
+		s.pow.prepare(height)
+	}
+
+	if s.config.Proxy.LongPoll.Enabled && (t == nil || t.Height != height) {
+		s.notifyLongPoll()
+	}
+
+	if s.diffRatio > 0 && t != nil && t.Difficulty.Cmp(newTemplate.Difficulty) > 0 {
+		s.capSessionDifficulty()
+	}
+
 	// Stratum
 	if s.config.Proxy.Stratum.Enabled {
-		go s.broadcastNewJobs()
+		go s.broadcastNewJobs(false)
 	}
 }
 
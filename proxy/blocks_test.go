@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlight(t *testing.T) {
+	var f singleFlight
+	var calls, shared int32
+	release := make(chan struct{})
+	fetch := func() {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if f.do(fetch) {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 || shared != 9 {
+		t.Errorf("Must run one fetch for concurrent callers, got %v calls and %v shared", calls, shared)
+	}
+
+	f.do(fetch)
+	if calls != 2 {
+		t.Error("Must run again after previous run finished")
+	}
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+const (
+	chainIdClassic = 61
+	chainIdMordor  = 63
+)
+
+// Results of upstream chain ID check
+const (
+	chainUnverified = 0
+	chainOk         = 1
+	chainWrong      = -1
+)
+
+// Chain ID upstreams must report, 0 disables the check on unknown networks
+func (c *Config) ExpectedChainId() uint64 {
+	if c.ChainId > 0 {
+		return c.ChainId
+	}
+	switch c.Network {
+	case "classic":
+		return chainIdClassic
+	case "mordor":
+		return chainIdMordor
+	}
+	return 0
+}
+
+// Upstreams start unverified, so no work is taken from them before the first check
+func (s *ProxyServer) initChainCheck() {
+	s.chainId = s.config.ExpectedChainId()
+	s.chainStates = make([]int32, len(s.upstreams))
+	if s.chainId == 0 {
+		log.Printf("Chain ID of upstreams is not checked on %q network", s.config.Network)
+	}
+	for i := range s.upstreams {
+		s.checkChainId(i)
+	}
+}
+
+// Unreachable upstream keeps result of previous check
+func (s *ProxyServer) checkChainId(i int) bool {
+	if s.chainId == 0 {
+		atomic.StoreInt32(&s.chainStates[i], chainOk)
+		return true
+	}
+	u := s.upstreams[i]
+	id, err := u.GetChainId()
+	if err != nil {
+		log.Printf("Failed to get chain ID of %v upstream: %v", u.Name, err)
+		return s.chainVerified(i)
+	}
+	if id != s.chainId {
+		if atomic.SwapInt32(&s.chainStates[i], chainWrong) != chainWrong {
+			log.Printf("Upstream %v is on chain %v, expected %v, refusing its work", u.Name, id, s.chainId)
+		}
+		return false
+	}
+	if atomic.SwapInt32(&s.chainStates[i], chainOk) != chainOk {
+		log.Printf("Upstream %v is on chain %v", u.Name, id)
+	}
+	return true
+}
+
+func (s *ProxyServer) chainVerified(i int) bool {
+	return s.chainStates == nil || atomic.LoadInt32(&s.chainStates[i]) == chainOk
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+)
+
+func TestChainIdCheck(t *testing.T) {
+	classic := submitNode(`"0x3d"`, 0)
+	defer classic.Close()
+	mainnet := submitNode(`"0x1"`, 0)
+	defer mainnet.Close()
+
+	s := &ProxyServer{config: &Config{Network: "classic"}, upstreams: []*rpc.RPCClient{
+		rpc.NewRPCClient("mainnet", mainnet.URL, "1s"),
+		rpc.NewRPCClient("classic", classic.URL, "1s"),
+		rpc.NewRPCClient("down", "http://127.0.0.1:1", "1s"),
+	}}
+	s.initChainCheck()
+	if s.chainVerified(0) || !s.chainVerified(1) || s.chainVerified(2) {
+		t.Errorf("Must verify only upstream on expected chain, got %v", s.chainStates)
+	}
+	if healthy := s.healthyUpstreams(); len(healthy) != 1 || healthy[0].Name != "classic" {
+		t.Errorf("Must never submit blocks to upstream on another chain, got %v", healthy)
+	}
+	s.fetchBlockTemplate()
+	if s.currentBlockTemplate() != nil {
+		t.Error("Must not take work of upstream on another chain")
+	}
+
+	s.config.ChainId = 1
+	if s.config.ExpectedChainId() != 1 {
+		t.Error("Must override network chain ID")
+	}
+}
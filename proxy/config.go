@@ -2,9 +2,17 @@ package proxy
 
 import (
 	"github.com/etclabscore/open-etc-pool/api"
+	"github.com/etclabscore/open-etc-pool/bookkeeping"
+	"github.com/etclabscore/open-etc-pool/clickhouse"
+	"github.com/etclabscore/open-etc-pool/eventbus"
+	"github.com/etclabscore/open-etc-pool/metrics"
 	"github.com/etclabscore/open-etc-pool/payouts"
 	"github.com/etclabscore/open-etc-pool/policy"
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/statuspage"
 	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+	"github.com/etclabscore/open-etc-pool/webhooks"
 )
 
 type Config struct {
@@ -13,16 +21,49 @@ type Config struct {
 	Api                   api.ApiConfig `json:"api"`
 	Upstream              []Upstream    `json:"upstream"`
 	UpstreamCheckInterval string        `json:"upstreamCheckInterval"`
+	// Consecutive failed checks before leaving current upstream, 1 if not set
+	UpstreamFailAfter int `json:"upstreamFailAfter"`
+	// Consecutive successful checks of preferred upstream before returning to it, 1 if not set
+	UpstreamFailBackAfter int `json:"upstreamFailBackAfter"`
 
 	Threads int `json:"threads"`
 
-	Network string         `json:"network"`
-	Coin    string         `json:"coin"`
-	Redis   storage.Config `json:"redis"`
+	Network string `json:"network"`
+	// etchash or ethash, defaults to etchash for classic and mordor
+	Algorithm string `json:"algorithm"`
+	// Block from which etchash epochs are 60000 blocks long, overrides network default
+	Ecip1099Block uint64 `json:"ecip1099Block"`
+	// Low difficulty test network, relaxes sanity thresholds of proxy and unlocker
+	Testnet bool `json:"testnet"`
+	// Upstreams and payouts node must report it as eth_chainId, defaults to 61 for classic and 63 for mordor
+	ChainId uint64 `json:"chainId"`
+	// Upcoming network upgrades, upstreams below minimum version raise alerts and are refused past fork block
+	Forks []Fork `json:"forks"`
+
+	Coin  string         `json:"coin"`
+	Redis storage.Config `json:"redis"`
+	// Accounting storage, redis is used if driver is not set
+	Storage storage.StorageConfig `json:"storage"`
+	// Split of block rewards, proportional to round shares if not set
+	Rewards storage.RewardsConfig `json:"rewards"`
+	// How long workers, blocks, payments and charts data is kept
+	Retention storage.RetentionConfig `json:"retention"`
 
 	BlockUnlocker payouts.UnlockerConfig `json:"unlocker"`
 	Payouts       payouts.PayoutsConfig  `json:"payouts"`
 
+	StatusPage statuspage.Config `json:"statusPage"`
+	EventBus   eventbus.Config   `json:"eventBus"`
+	// Run metrics of unlocker and payouts
+	Metrics metrics.Config `json:"metrics"`
+	// Export payments and fee income to external accounting system
+	Bookkeeping bookkeeping.Config `json:"bookkeeping"`
+	// Payment and payout error events POSTed to operator endpoints
+	Webhooks webhooks.Config `json:"webhooks"`
+
+	// Branded pools sharing this deployment, each with own stats under <coin>:tenant:<name> keys
+	Tenants []Tenant `json:"tenants"`
+
 	NewrelicName    string `json:"newrelicName"`
 	NewrelicKey     string `json:"newrelicKey"`
 	NewrelicVerbose bool   `json:"newrelicVerbose"`
@@ -30,22 +71,62 @@ type Config struct {
 }
 
 type Proxy struct {
-	Enabled              bool   `json:"enabled"`
-	Listen               string `json:"listen"`
-	LimitHeadersSize     int    `json:"limitHeadersSize"`
-	LimitBodySize        int64  `json:"limitBodySize"`
-	BehindReverseProxy   bool   `json:"behindReverseProxy"`
-	BlockRefreshInterval string `json:"blockRefreshInterval"`
-	Difficulty           int64  `json:"difficulty"`
-	StateUpdateInterval  string `json:"stateUpdateInterval"`
-	HashrateExpiration   string `json:"hashrateExpiration"`
+	Enabled            bool   `json:"enabled"`
+	Listen             string `json:"listen"`
+	ListenNetwork      string `json:"listenNetwork"`
+	BehindReverseProxy bool   `json:"behindReverseProxy"`
+	// Number of bytes or string with unit, e.g. "1KB"
+	LimitHeadersSize util.Size `json:"limitHeadersSize"`
+	LimitBodySize    util.Size `json:"limitBodySize"`
+	// Forwarded headers are honored only from these addresses or CIDRs, loopback if empty
+	TrustedProxies       []string `json:"trustedProxies"`
+	BlockRefreshInterval string   `json:"blockRefreshInterval"`
+	Difficulty           int64    `json:"difficulty"`
+	StateUpdateInterval  string   `json:"stateUpdateInterval"`
+	HashrateExpiration   string   `json:"hashrateExpiration"`
+	// Block refresh interval while current upstream has live newHeads subscription
+	WsRefreshInterval string `json:"wsRefreshInterval"`
+	// Share difficulty is capped at this fraction of network difficulty, off if 0
+	NetworkDiffRatio float64 `json:"networkDiffRatio"`
+	// Validate Content-Type, jsonrpc version and id type on HTTP endpoint, lenient if false
+	StrictRPC bool `json:"strictRPC"`
+	// Shares for jobs of this many recent heights are accepted, older ones are counted as stale
+	JobBacklog int `json:"jobBacklog"`
+	// Time given to stratum sessions to finish on shutdown
+	DrainTimeout string `json:"drainTimeout"`
+	// Keep replies to HTTP submissions with Idempotency-Key header for this period
+	IdempotencyWindow string `json:"idempotencyWindow"`
+	// Tag put into extra data of pool blocks with miner_setExtra on every upstream, up to 32 bytes
+	ExtraData string `json:"extraData"`
 
 	Policy policy.Config `json:"policy"`
 
 	MaxFails    int64 `json:"maxFails"`
 	HealthCheck bool  `json:"healthCheck"`
 
-	Stratum Stratum `json:"stratum"`
+	Stratum Stratum     `json:"stratum"`
+	VarDiff VarDiff     `json:"varDiff"`
+	Worker  WorkerRules `json:"worker"`
+	DDoS    DDoS        `json:"ddos"`
+
+	// Fixed difficulty requested by miner with login suffix or password
+	StaticDiff StaticDiff `json:"staticDiff"`
+
+	LongPoll LongPoll `json:"longPoll"`
+
+	BlockRetry BlockRetry `json:"blockRetry"`
+
+	StaleWindow StaleWindow `json:"staleWindow"`
+
+	Verifier Verifier `json:"verifier"`
+
+	ShareSampling ShareSampling `json:"shareSampling"`
+
+	// Stream share outcomes to ClickHouse for analytics
+	ClickHouse clickhouse.Config `json:"clickHouse"`
+
+	// Overrides for miner-facing error messages, see proxy/messages.go for keys
+	Messages map[string]string `json:"messages"`
 }
 
 type Stratum struct {
@@ -53,10 +134,194 @@ type Stratum struct {
 	Listen  string `json:"listen"`
 	Timeout string `json:"timeout"`
 	MaxConn int    `json:"maxConn"`
+	// One of tcp4 (default), tcp6 or tcp for dual-stack
+	ListenNetwork string `json:"listenNetwork"`
+
+	// Multiple listeners with own difficulty, "listen" and "maxConn" above are ignored if set
+	Ports []StratumPort `json:"ports"`
+
+	HighLatency HighLatency `json:"highLatency"`
+
+	PortHint PortHint `json:"portHint"`
+
+	// Protocol adjustments by miner software
+	Quirks []Quirk `json:"quirks"`
+
+	// Expect HAProxy PROXY protocol v1/v2 header on every connection
+	ProxyProtocol bool `json:"proxyProtocol"`
+
+	WebSocket WebSocket `json:"webSocket"`
+
+	// Experimental Stratum V2 listener
+	V2 StratumV2 `json:"v2"`
+
+	// Messages queued per session before slow miner is disconnected
+	SendQueue int `json:"sendQueue"`
+	// Deadline for a single write to miner
+	WriteTimeout string `json:"writeTimeout"`
+	// Stratum sessions allowed for one login on all listeners, unlimited if 0
+	MaxSessionsPerLogin int `json:"maxSessionsPerLogin"`
+
+	ConnRate ConnRate `json:"connRate"`
+}
+
+// Token bucket limit of new TCP and V2 connections, checked right after accept
+type ConnRate struct {
+	// Connections per second and burst for single IP, 0 to disable
+	IP      float64 `json:"ip"`
+	IPBurst int     `json:"ipBurst"`
+	// Same for /24 IPv4 or /64 IPv6 subnet
+	Subnet      float64 `json:"subnet"`
+	SubnetBurst int     `json:"subnetBurst"`
+}
+
+// Share verification workers, shares are rejected with "busy" message while queue is full
+type Verifier struct {
+	// Defaults to number of CPUs
+	Workers int `json:"workers"`
+	// Defaults to 64 per worker
+	Queue int `json:"queue"`
+	// Retry delay suggested to miners while queue is full, defaults to 1s
+	Backoff string `json:"backoff"`
+	// Session difficulty is raised by this factor for a minute when its share is dropped, defaults to 2
+	BusyDiffFactor float64 `json:"busyDiffFactor"`
+}
+
+// Anonymized share intervals of stratum sessions, analyzed offline with -vardiff-report
+type ShareSampling struct {
+	Enabled bool `json:"enabled"`
+	// Defaults to 10m
+	Interval string `json:"interval"`
+	// Most recent samples kept, defaults to 10000
+	Keep int `json:"keep"`
+}
+
+// Block solutions which failed to reach any upstream are kept in redis and
+// resubmitted to healthy upstreams until accepted, rejected or window is over
+type BlockRetry struct {
+	Enabled  bool   `json:"enabled"`
+	Window   string `json:"window"`
+	Interval string `json:"interval"`
+}
+
+// Shares for previous height arriving shortly after new job are credited as valid.
+// Window of stratum session is extended by its RTT multiplied by factor, up to max.
+type StaleWindow struct {
+	Enabled   bool    `json:"enabled"`
+	Window    string  `json:"window"`
+	RTTFactor float64 `json:"rttFactor"`
+	Max       string  `json:"max"`
+}
+
+// HTTP getwork requests to /longpoll/<login> held until new height
+type LongPoll struct {
+	Enabled bool   `json:"enabled"`
+	Timeout string `json:"timeout"`
+}
+
+type StratumV2 struct {
+	Enabled    bool   `json:"enabled"`
+	Listen     string `json:"listen"`
+	Difficulty int64  `json:"difficulty"`
+	MaxConn    int    `json:"maxConn"`
+	// Standard channels allowed on a single connection
+	MaxChannels int `json:"maxChannels"`
+	// Hex encoded X25519 server key, generated if file doesn't exist
+	KeyFile string `json:"keyFile"`
+	Tenant  string `json:"tenant"`
+}
+
+// Same stratum messages over WebSocket, one JSON-RPC message per frame
+type WebSocket struct {
+	Enabled    bool   `json:"enabled"`
+	Listen     string `json:"listen"`
+	Path       string `json:"path"`
+	Difficulty int64  `json:"difficulty"`
+	MaxConn    int    `json:"maxConn"`
+	// Browser origins allowed to connect, any origin if empty
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// Serve TLS directly if set
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Miners on this listener mine for tenant with this name
+	Tenant string `json:"tenant"`
+}
+
+// Batch replies with job pushes for sessions with RTT above threshold
+type HighLatency struct {
+	Enabled    bool   `json:"enabled"`
+	Threshold  string `json:"threshold"`
+	FlushDelay string `json:"flushDelay"`
+}
+
+type StratumPort struct {
+	Listen     string `json:"listen"`
+	Difficulty int64  `json:"difficulty"`
+	MaxConn    int    `json:"maxConn"`
+	// Miners on this listener mine for tenant with this name
+	Tenant string `json:"tenant"`
+	// Shares count for hashrate only, block reward goes to its finder less fee
+	Solo bool `json:"solo"`
+}
+
+type Tenant struct {
+	Name string `json:"name"`
+	// Frontend domain, API requests with this Host get tenant stats
+	Domain         string  `json:"domain"`
+	PoolFee        float64 `json:"poolFee"`
+	PoolFeeAddress string  `json:"poolFeeAddress"`
+	// Branding served on /api/meta for tenant domain
+	Meta api.Meta `json:"meta"`
+}
+
+type VarDiff struct {
+	Enabled          bool    `json:"enabled"`
+	MinDiff          int64   `json:"minDiff"`
+	MaxDiff          int64   `json:"maxDiff"`
+	SharesPerMinute  float64 `json:"sharesPerMinute"`
+	RetargetInterval string  `json:"retargetInterval"`
+	VariancePercent  float64 `json:"variancePercent"`
+}
+
+// Worker names not matching these rules are handled by fallback
+type WorkerRules struct {
+	// Defaults to 8
+	MaxLength int `json:"maxLength"`
+	// Regexp character class body, defaults to 0-9a-zA-Z-_
+	Charset string `json:"charset"`
+	// One of zero (rename to "0"), reject, strip (drop invalid chars) or hash (of original name)
+	Fallback string `json:"fallback"`
+	// Deprecated, same as reject fallback
+	Reject bool `json:"reject"`
+}
+
+// Applied while DDoS mode is switched on through admin API, together with policy emergency thresholds
+type DDoS struct {
+	// How often to check DDoS mode state in redis, leave blank to ignore DDoS mode
+	CheckInterval string `json:"checkInterval"`
+	MinDiff       int64  `json:"minDiff"`
+	// Max stratum connections accepted per second on each listener
+	AcceptRate int `json:"acceptRate"`
+}
+
+type StaticDiff struct {
+	Enabled bool  `json:"enabled"`
+	MinDiff int64 `json:"minDiff"`
+	MaxDiff int64 `json:"maxDiff"`
 }
 
 type Upstream struct {
 	Name    string `json:"name"`
 	Url     string `json:"url"`
 	Timeout string `json:"timeout"`
+	// Lower is preferred, upstreams of equal priority in order of list
+	Priority int `json:"priority"`
+	// WebSocket endpoint, new heads subscription triggers immediate template refresh
+	WsUrl string `json:"wsUrl"`
+	// Also refresh template on pending transactions of the node, at most once a second
+	PendingTxs bool `json:"pendingTxs"`
+	// Credentials and client certificate of https:// endpoint
+	Auth *rpc.Auth `json:"auth"`
+	// Node client: geth (default, also core-geth), besu or nethermind
+	Flavor string `json:"flavor"`
 }
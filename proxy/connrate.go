@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Token buckets of new connections per IP and per subnet, checked in accept loops
+// before any session or policy state is allocated
+type connLimiter struct {
+	mu      sync.Mutex
+	cfg     ConnRate
+	ips     map[string]*bucket
+	subnets map[string]*bucket
+}
+
+func newConnLimiter(cfg ConnRate) *connLimiter {
+	return &connLimiter{cfg: cfg, ips: make(map[string]*bucket), subnets: make(map[string]*bucket)}
+}
+
+func (l *connLimiter) allow(addr net.Addr, now time.Time) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cfg.IP > 0 && !take(l.ips, tcp.IP.String(), l.cfg.IP, l.cfg.IPBurst, now) {
+		return false
+	}
+	if l.cfg.Subnet > 0 && !take(l.subnets, subnetKey(tcp.IP), l.cfg.Subnet, l.cfg.SubnetBurst, now) {
+		return false
+	}
+	return true
+}
+
+func take(buckets map[string]*bucket, key string, rate float64, burst int, now time.Time) bool {
+	if burst < 1 {
+		burst = 1
+	}
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// /24 for IPv4 and /64 for IPv6
+func subnetKey(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
+
+// Drops buckets which are full again, they behave the same as missing ones
+func (l *connLimiter) purge(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	purgeBuckets(l.ips, l.cfg.IP, l.cfg.IPBurst, now)
+	purgeBuckets(l.subnets, l.cfg.Subnet, l.cfg.SubnetBurst, now)
+}
+
+func purgeBuckets(buckets map[string]*bucket, rate float64, burst int, now time.Time) {
+	for key, b := range buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+			delete(buckets, key)
+		}
+	}
+}
+
+func (s *ProxyServer) initConnLimiter() {
+	cfg := s.config.Proxy.Stratum.ConnRate
+	if cfg.IP <= 0 && cfg.Subnet <= 0 {
+		return
+	}
+	if s.config.Proxy.Stratum.ProxyProtocol {
+		log.Println("Connection rate limit is ignored with PROXY protocol, all connections come from balancer")
+		return
+	}
+	s.connLimit = newConnLimiter(cfg)
+	go func() {
+		for range time.Tick(time.Minute) {
+			s.connLimit.purge(time.Now())
+		}
+	}()
+}
+
+func (s *ProxyServer) allowConn(conn net.Conn) bool {
+	return s.connLimit == nil || s.connLimit.allow(conn.RemoteAddr(), time.Now())
+}
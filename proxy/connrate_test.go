@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(ConnRate{IP: 1, IPBurst: 2, Subnet: 10, SubnetBurst: 3})
+	now := time.Now()
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1000} }
+
+	if !l.allow(addr("10.0.0.1"), now) || !l.allow(addr("10.0.0.1"), now) {
+		t.Fatal("Must allow burst of connections")
+	}
+	if l.allow(addr("10.0.0.1"), now) {
+		t.Error("Must drop connection above IP burst")
+	}
+	if !l.allow(addr("10.0.0.2"), now) {
+		t.Error("Must allow other IP of subnet")
+	}
+	if l.allow(addr("10.0.0.3"), now) {
+		t.Error("Must drop connection above subnet burst")
+	}
+	if !l.allow(addr("10.0.1.1"), now) {
+		t.Error("Must allow other subnet")
+	}
+	if !l.allow(addr("10.0.0.1"), now.Add(time.Second)) {
+		t.Error("Must refill tokens over time")
+	}
+
+	l.purge(now.Add(time.Minute))
+	if len(l.ips) != 0 || len(l.subnets) != 0 {
+		t.Errorf("Must purge refilled buckets, got %v and %v", len(l.ips), len(l.subnets))
+	}
+}
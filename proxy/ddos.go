@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Polls DDoS mode switched on by admin API, mode expires with redis key
+func (s *ProxyServer) ddosWatcher() {
+	intv := util.MustParseDuration(s.config.Proxy.DDoS.CheckInterval)
+	ticker := time.NewTicker(intv)
+	for range ticker.C {
+		s.checkDDoSMode()
+	}
+}
+
+func (s *ProxyServer) checkDDoSMode() {
+	ttl, err := s.backend.GetDDoSMode()
+	if err != nil {
+		log.Printf("Failed to get DDoS mode from backend: %v", err)
+		return
+	}
+	active := ttl > 0
+	if active == s.underAttack() {
+		return
+	}
+
+	var v int32
+	if active {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ddos, v)
+	s.policy.SetEmergency(active)
+
+	if active {
+		log.Printf("DDoS mode enabled for %v, HTTP getwork is disabled", ttl)
+		s.raiseDifficulty()
+	} else {
+		log.Println("DDoS mode disabled")
+		s.restoreDDoSDifficulty()
+	}
+}
+
+func (s *ProxyServer) underAttack() bool {
+	return atomic.LoadInt32(&s.ddos) > 0
+}
+
+// Share difficulty is never lower than DDoS mode minimum while it is on
+func (s *ProxyServer) minDifficulty(diff int64) int64 {
+	if s.underAttack() && diff < s.config.Proxy.DDoS.MinDiff {
+		return s.config.Proxy.DDoS.MinDiff
+	}
+	return diff
+}
+
+func (s *ProxyServer) raiseDifficulty() {
+	sessions := s.sessions.all()
+
+	total := 0
+	for _, cs := range sessions {
+		diff, _ := cs.difficulty()
+		if newDiff := s.sessionDifficulty(diff); newDiff != diff {
+			cs.setDifficulty(newDiff)
+			cs.markDDoSRaise(diff, newDiff)
+			s.sendJob(cs)
+			total++
+		}
+	}
+	log.Printf("Raised difficulty for %v stratum sessions", total)
+}
+
+// Remembers port or vardiff difficulty raised by DDoS mode to restore it once mode is off
+func (cs *Session) markDDoSRaise(diff, raised int64) {
+	cs.diffMu.Lock()
+	cs.ddosDiff, cs.ddosRaised = diff, raised
+	cs.diffMu.Unlock()
+}
+
+// Lowers difficulty raised by DDoS mode back, unless vardiff changed it meanwhile
+func (s *ProxyServer) restoreDDoSDifficulty() {
+	total := 0
+	for _, cs := range s.sessions.all() {
+		cs.diffMu.Lock()
+		diff, raised, current := cs.ddosDiff, cs.ddosRaised, cs.diff
+		cs.ddosDiff, cs.ddosRaised = 0, 0
+		cs.diffMu.Unlock()
+		if diff == 0 || current != raised {
+			continue
+		}
+		if diff = s.sessionDifficulty(diff); diff != current {
+			cs.setDifficulty(diff)
+			s.sendJob(cs)
+			total++
+		}
+	}
+	log.Printf("Restored difficulty of %v stratum sessions", total)
+}
+
+// Limits accepted connections per second on a single listener while DDoS mode is on
+type acceptLimiter struct {
+	rate     int
+	second   int64
+	accepted int
+}
+
+// Not safe for concurrent use, called from accept loop only
+func (l *acceptLimiter) allow(now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	if sec := now.Unix(); sec != l.second {
+		l.second = sec
+		l.accepted = 0
+	}
+	l.accepted++
+	return l.accepted <= l.rate
+}
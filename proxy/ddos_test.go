@@ -0,0 +1,29 @@
+package proxy
+
+import "testing"
+
+func TestDDoSDifficultyRestore(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.config.Proxy.DDoS.MinDiff = 5000
+	a, b := &Session{}, &Session{}
+	a.setDifficulty(1000)
+	b.setDifficulty(2000)
+	s.sessions.add(a)
+	s.sessions.add(b)
+
+	s.ddos = 1
+	s.raiseDifficulty()
+	if diff, _ := a.difficulty(); diff != 5000 {
+		t.Fatalf("Must raise difficulty to DDoS minimum, got %v", diff)
+	}
+	b.setDifficulty(8000)
+
+	s.ddos = 0
+	s.restoreDDoSDifficulty()
+	if diff, _ := a.difficulty(); diff != 1000 {
+		t.Errorf("Must restore difficulty once DDoS mode is off, got %v", diff)
+	}
+	if diff, _ := b.difficulty(); diff != 8000 {
+		t.Errorf("Must keep difficulty changed by vardiff, got %v", diff)
+	}
+}
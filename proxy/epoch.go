@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/go-etchash"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	epochLength         = 30000
+	epochLengthECIP1099 = 60000
+	// Cache of next epoch is generated this many blocks before the switch
+	epochPrepareBlocks = 1000
+)
+
+var ecip1099FBlockClassic uint64 = 11700000 // classic mainnet
+var ecip1099FBlockMordor uint64 = 2520000   // mordor
+
+type cacheState struct {
+	started time.Time
+	took    time.Duration
+	ready   bool
+}
+
+// Ethash light caches used for share verification. Cache of current epoch is
+// generated on first template and next one ahead of the epoch switch, so shares
+// never wait minutes for cache generation at epoch boundary.
+type epochCaches struct {
+	hasher         *etchash.Etchash
+	ecip1099FBlock uint64
+	// Generates cache for epoch containing block, blocks until it's ready
+	generate func(block uint64)
+
+	mu     sync.Mutex
+	states map[uint64]*cacheState
+	// Epochs of last prepared height and the one after it, which differ by
+	// more than one at ECIP-1099 switch
+	current uint64
+	next    uint64
+}
+
+// Ethash is etchash whose ECIP-1099 fork never activates
+func newEpochCaches(cfg *Config) (*epochCaches, error) {
+	c := &epochCaches{states: make(map[uint64]*cacheState)}
+	algorithm := cfg.Algorithm
+	switch cfg.Network {
+	case "classic":
+		c.ecip1099FBlock = ecip1099FBlockClassic
+	case "mordor":
+		c.ecip1099FBlock = ecip1099FBlockMordor
+	default:
+		if len(algorithm) == 0 {
+			return nil, fmt.Errorf("unknown network %q, algorithm must be set", cfg.Network)
+		}
+	}
+	switch algorithm {
+	case "", "etchash":
+		if cfg.Ecip1099Block > 0 {
+			c.ecip1099FBlock = cfg.Ecip1099Block
+		}
+		if c.ecip1099FBlock == 0 {
+			return nil, fmt.Errorf("ecip1099Block is required for etchash on network %q", cfg.Network)
+		}
+	case "ethash":
+		if cfg.Ecip1099Block > 0 {
+			return nil, errors.New("ecip1099Block can't be used with ethash")
+		}
+		c.ecip1099FBlock = math.MaxUint64
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+	}
+	c.hasher = etchash.New(&c.ecip1099FBlock, nil)
+	c.generate = func(block uint64) {
+		c.hasher.Compute(block, common.Hash{}, 0)
+	}
+	return c, nil
+}
+
+// Epoch of block and first block of the next epoch
+func (c *epochCaches) epoch(height uint64) (uint64, uint64) {
+	length := uint64(epochLength)
+	if height >= c.ecip1099FBlock {
+		length = epochLengthECIP1099
+	}
+	epoch := height / length
+	return epoch, (epoch + 1) * length
+}
+
+// Difficulty achieved by hash of solution, zero if mix digest doesn't match.
+// Solution is valid for any difficulty up to it.
+func (c *epochCaches) difficulty(b Block) *big.Int {
+	mixDigest, result := c.hasher.Compute(b.number, b.hashNoNonce, b.nonce)
+	if mixDigest != b.mixDigest || result.Big().Sign() == 0 {
+		return new(big.Int)
+	}
+	return util.TargetToDiff(result.Big())
+}
+
+// Starts background generation of caches needed around height
+func (c *epochCaches) prepare(height uint64) {
+	epoch, nextBlock := c.epoch(height)
+	nextEpoch, _ := c.epoch(nextBlock)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current, c.next = epoch, nextEpoch
+	c.startLocked(epoch, height)
+	if height+epochPrepareBlocks >= nextBlock {
+		c.startLocked(nextEpoch, nextBlock)
+	}
+	// Library keeps at most a few caches, forget ones which are evicted anyway
+	for e := range c.states {
+		if e != epoch && e != nextEpoch {
+			delete(c.states, e)
+		}
+	}
+}
+
+func (c *epochCaches) startLocked(epoch, block uint64) {
+	if _, ok := c.states[epoch]; ok {
+		return
+	}
+	state := &cacheState{started: time.Now()}
+	c.states[epoch] = state
+	log.Printf("Generating ethash cache for epoch %v", epoch)
+	go func() {
+		c.generate(block)
+		c.mu.Lock()
+		state.ready = true
+		state.took = time.Since(state.started)
+		c.mu.Unlock()
+		log.Printf("Ethash cache for epoch %v ready in %v", epoch, state.took.Round(time.Millisecond))
+	}()
+}
+
+// Current epoch and whether caches of it and of next epoch are ready, next one
+// is only generated close to the switch
+func (c *epochCaches) status() (epoch uint64, ready, nextReady bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.states[c.current]; ok {
+		ready = s.ready
+	}
+	if s, ok := c.states[c.next]; ok {
+		nextReady = s.ready
+	}
+	return c.current, ready, nextReady
+}
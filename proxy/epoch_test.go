@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEpochBoundaries(t *testing.T) {
+	c, _ := newEpochCaches(&Config{Network: "classic"})
+	if epoch, next := c.epoch(11699999); epoch != 389 || next != 11700000 {
+		t.Errorf("Must use 30000 blocks epochs before ECIP-1099, got %v %v", epoch, next)
+	}
+	if epoch, next := c.epoch(11700000); epoch != 195 || next != 11760000 {
+		t.Errorf("Must use 60000 blocks epochs after ECIP-1099, got %v %v", epoch, next)
+	}
+	if _, err := newEpochCaches(&Config{Network: "ropsten"}); err == nil {
+		t.Error("Must reject unknown network")
+	}
+}
+
+func TestEpochAlgorithms(t *testing.T) {
+	c, err := newEpochCaches(&Config{Network: "ropsten", Algorithm: "ethash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if epoch, next := c.epoch(11700000); epoch != 390 || next != 11730000 {
+		t.Errorf("Must keep 30000 blocks epochs for ethash, got %v %v", epoch, next)
+	}
+	c, _ = newEpochCaches(&Config{Network: "private", Algorithm: "etchash", Ecip1099Block: 60000})
+	if epoch, next := c.epoch(60000); epoch != 1 || next != 120000 {
+		t.Errorf("Must use configured ECIP-1099 block, got %v %v", epoch, next)
+	}
+	if _, err := newEpochCaches(&Config{Network: "private", Algorithm: "etchash"}); err == nil {
+		t.Error("Must require ECIP-1099 block for etchash on unknown network")
+	}
+	if _, err := newEpochCaches(&Config{Network: "classic", Algorithm: "ethash", Ecip1099Block: 1}); err == nil {
+		t.Error("Must reject ECIP-1099 block for ethash")
+	}
+}
+
+func TestPrepareNextEpoch(t *testing.T) {
+	c, _ := newEpochCaches(&Config{Network: "classic"})
+	var mu sync.Mutex
+	var generated []uint64
+	done := make(chan struct{}, 4)
+	c.generate = func(block uint64) {
+		mu.Lock()
+		generated = append(generated, block)
+		mu.Unlock()
+		done <- struct{}{}
+	}
+	wait := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Must generate cache in background")
+			}
+		}
+	}
+
+	c.prepare(11760000)
+	c.prepare(11760001)
+	wait(1)
+	if epoch, ready, nextReady := c.status(); epoch != 196 || !ready || nextReady {
+		t.Errorf("Must only generate current epoch far from switch, got %v %v %v", epoch, ready, nextReady)
+	}
+
+	c.prepare(11820000 - epochPrepareBlocks)
+	wait(1)
+	if _, ready, nextReady := c.status(); !ready || !nextReady {
+		t.Errorf("Must generate next epoch ahead of switch, got %v %v", ready, nextReady)
+	}
+	c.prepare(11820000)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(generated) != 2 || generated[1] != 11820000 {
+		t.Errorf("Must generate each epoch once, got %v", generated)
+	}
+}
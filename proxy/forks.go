@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/etclabscore/open-etc-pool/eventbus"
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// About 1.5 days of ETC blocks
+const defaultForkAlertBlocks = 10000
+
+// Results of upstream fork readiness check
+const (
+	forkReady = 0
+	// Outdated, but fork is not reached yet
+	forkAlerted = 1
+	// Outdated past fork block, its work is refused
+	forkOutdated = 2
+)
+
+// Network upgrade upstreams must be ready for
+type Fork struct {
+	Name  string `json:"name"`
+	Block uint64 `json:"block"`
+	// Minimum client version by upstream flavor, e.g. {"geth": "1.12.20", "besu": "24.1.0"}
+	MinVersions map[string]string `json:"minVersions"`
+	// Check versions this many blocks ahead of fork, 10000 if not set
+	AlertBlocks uint64 `json:"alertBlocks"`
+}
+
+func (f *Fork) alertBlocks() uint64 {
+	if f.AlertBlocks > 0 {
+		return f.AlertBlocks
+	}
+	return defaultForkAlertBlocks
+}
+
+func (c *Config) validateForks(errs *util.ConfigErrors) {
+	for i, f := range c.Forks {
+		if len(f.Name) == 0 {
+			errs.Addf("forks[%d].name: required", i)
+		}
+		if f.Block == 0 {
+			errs.Addf("forks[%d].block: required", i)
+		}
+		if len(f.MinVersions) == 0 {
+			errs.Addf("forks[%d].minVersions: at least one flavor is required", i)
+		}
+		for flavor, version := range f.MinVersions {
+			if len(flavor) == 0 || !rpc.ValidFlavor(flavor) {
+				errs.Addf("forks[%d].minVersions: unknown flavor %q", i, flavor)
+			}
+			if _, ok := parseVersion(version); !ok {
+				errs.Addf("forks[%d].minVersions.%s: invalid version %q", i, flavor, version)
+			}
+		}
+	}
+}
+
+// Numeric part of version, e.g. 1.12.19 of "CoreGeth/v1.12.19-stable-b8ad5dd8/linux-amd64/go1.21.6"
+func parseVersion(s string) ([]int, bool) {
+	for _, part := range strings.Split(s, "/") {
+		part = strings.TrimPrefix(part, "v")
+		if len(part) == 0 || part[0] < '0' || part[0] > '9' {
+			continue
+		}
+		if i := strings.IndexFunc(part, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); i >= 0 {
+			part = part[:i]
+		}
+		var version []int
+		for _, n := range strings.Split(strings.Trim(part, "."), ".") {
+			v, err := strconv.Atoi(n)
+			if err != nil {
+				return nil, false
+			}
+			version = append(version, v)
+		}
+		return version, true
+	}
+	return nil, false
+}
+
+// Missing components are zero, so 1.12 equals 1.12.0
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func (s *ProxyServer) initForkCheck() {
+	s.forkStates = make([]int32, len(s.upstreams))
+	for _, f := range s.config.Forks {
+		log.Printf("Upstreams are checked for %v fork at block %v", f.Name, f.Block)
+	}
+}
+
+// Versions are checked once fork is within alert window. Outdated upstream raises alert
+// before fork block and is refused past it, so pool doesn't mine on dead chain.
+// Unreachable upstream keeps result of previous check.
+func (s *ProxyServer) checkFork(i int, height uint64) bool {
+	if len(s.config.Forks) == 0 || height == 0 {
+		return s.forkAllowed(i)
+	}
+	u := s.upstreams[i]
+	flavor := u.Flavor
+	if len(flavor) == 0 {
+		flavor = rpc.FlavorGeth
+	}
+	var pending []*Fork
+	for j := range s.config.Forks {
+		f := &s.config.Forks[j]
+		if _, ok := f.MinVersions[flavor]; ok && height+f.alertBlocks() >= f.Block {
+			pending = append(pending, f)
+		}
+	}
+	if len(pending) == 0 {
+		atomic.StoreInt32(&s.forkStates[i], forkReady)
+		return true
+	}
+
+	raw, err := u.ClientVersion()
+	if err != nil {
+		log.Printf("Failed to get client version of %v upstream: %v", u.Name, err)
+		return s.forkAllowed(i)
+	}
+	version, parsed := parseVersion(raw)
+	state := int32(forkReady)
+	var blocking *Fork
+	for _, f := range pending {
+		min, _ := parseVersion(f.MinVersions[flavor])
+		if parsed && compareVersions(version, min) >= 0 {
+			continue
+		}
+		if height >= f.Block {
+			state, blocking = forkOutdated, f
+		} else if state == forkReady {
+			state, blocking = forkAlerted, f
+		}
+	}
+
+	if prev := atomic.SwapInt32(&s.forkStates[i], state); prev != state {
+		s.reportFork(u, raw, blocking, height, state)
+	}
+	return state != forkOutdated
+}
+
+func (s *ProxyServer) reportFork(u *rpc.RPCClient, version string, f *Fork, height uint64, state int32) {
+	if state == forkReady {
+		log.Printf("Upstream %v running %q is ready for upcoming forks", u.Name, version)
+		return
+	}
+	flavor := u.Flavor
+	if len(flavor) == 0 {
+		flavor = rpc.FlavorGeth
+	}
+	var msg string
+	if state == forkOutdated {
+		msg = fmt.Sprintf("Upstream %v running %q is past %v fork at block %v without required %v %v, refusing its work",
+			u.Name, version, f.Name, f.Block, flavor, f.MinVersions[flavor])
+	} else {
+		msg = fmt.Sprintf("ALERT: upstream %v running %q must be upgraded to %v %v before %v fork at block %v, %v blocks left",
+			u.Name, version, flavor, f.MinVersions[flavor], f.Name, f.Block, f.Block-height)
+	}
+	log.Print(msg)
+	eventbus.Publish(eventbus.ForkAlert, map[string]interface{}{
+		"upstream":   u.Name,
+		"version":    version,
+		"fork":       f.Name,
+		"block":      f.Block,
+		"height":     height,
+		"minVersion": f.MinVersions[flavor],
+		"refused":    state == forkOutdated,
+	})
+}
+
+func (s *ProxyServer) forkAllowed(i int) bool {
+	return s.forkStates == nil || atomic.LoadInt32(&s.forkStates[i]) != forkOutdated
+}
+
+// Number of upstreams which aren't ready for forks within alert window
+func (s *ProxyServer) outdatedUpstreams() int {
+	n := 0
+	for i := range s.forkStates {
+		if atomic.LoadInt32(&s.forkStates[i]) != forkReady {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestParseVersion(t *testing.T) {
+	for raw, expected := range map[string][]int{
+		"CoreGeth/v1.12.19-stable-b8ad5dd8/linux-amd64/go1.21.6": {1, 12, 19},
+		"besu/v24.1.0/linux-x86_64/openjdk-java-17":              {24, 1, 0},
+		"Nethermind/v1.25.4+20b10b35/linux-x64/dotnet8.0.2":      {1, 25, 4},
+		"Geth/mynode/v1.13.0-stable/linux-amd64/go1.21.6":        {1, 13, 0},
+		"1.12": {1, 12},
+	} {
+		version, ok := parseVersion(raw)
+		if !ok || compareVersions(version, expected) != 0 {
+			t.Errorf("Must parse %q as %v, got %v", raw, expected, version)
+		}
+	}
+	if _, ok := parseVersion("CoreGeth/unstable"); ok {
+		t.Error("Must not parse version without numbers")
+	}
+	if compareVersions([]int{1, 12}, []int{1, 12, 0}) != 0 || compareVersions([]int{1, 9}, []int{1, 12}) >= 0 {
+		t.Error("Must compare versions numerically")
+	}
+}
+
+func TestForkCheck(t *testing.T) {
+	old := submitNode(`"CoreGeth/v1.12.19-stable/linux-amd64/go1.21.6"`, 0)
+	defer old.Close()
+	upgraded := submitNode(`"CoreGeth/v1.12.20-stable/linux-amd64/go1.21.6"`, 0)
+	defer upgraded.Close()
+
+	s := &ProxyServer{config: &Config{Forks: []Fork{{Name: "Spiral", Block: 100000, MinVersions: map[string]string{"geth": "1.12.20"}}}},
+		upstreams: []*rpc.RPCClient{
+			rpc.NewRPCClient("old", old.URL, "1s"),
+			rpc.NewRPCClient("upgraded", upgraded.URL, "1s"),
+		}}
+	s.initForkCheck()
+
+	if !s.checkFork(0, 50000) || s.outdatedUpstreams() != 0 {
+		t.Error("Must not check versions before alert window")
+	}
+	if !s.checkFork(0, 95000) || !s.checkFork(1, 95000) {
+		t.Error("Must keep using outdated upstream before fork block")
+	}
+	if s.outdatedUpstreams() != 1 {
+		t.Errorf("Must alert about outdated upstream, got %v", s.forkStates)
+	}
+	if s.checkFork(0, 100000) || !s.checkFork(1, 100000) {
+		t.Error("Must refuse outdated upstream past fork block")
+	}
+	if healthy := s.healthyUpstreams(); len(healthy) != 1 || healthy[0].Name != "upgraded" {
+		t.Errorf("Must not submit blocks to outdated upstream, got %v", healthy)
+	}
+	var calls int32
+	counting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer counting.Close()
+	outdated := s.upstreams[0]
+	s.upstreams[0] = rpc.NewRPCClient("old", counting.URL, "1s")
+	s.fetchBlockTemplate()
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Error("Must not take work of outdated upstream past fork block")
+	}
+	s.upstreams[0] = outdated
+
+	s.upstreams[0].Flavor = rpc.FlavorBesu
+	if !s.checkFork(0, 100000) {
+		t.Error("Must not check upstream of flavor without minimum version")
+	}
+}
+
+func TestForksValidation(t *testing.T) {
+	c := &Config{Forks: []Fork{{Name: "Spiral", Block: 100000, MinVersions: map[string]string{"geth": "v1.12.20", "erigon": "2.0", "besu": "latest"}}}}
+	var errs util.ConfigErrors
+	c.validateForks(&errs)
+	if len(errs) != 2 {
+		t.Errorf("Must reject unknown flavor and invalid version, got %v", errs)
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies trusted when behindReverseProxy is on and no list is configured
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1/128"}
+
+// Parses trusted proxy list, single addresses are accepted as /32 or /128
+func parseTrustedProxies(list []string) []*net.IPNet {
+	if len(list) == 0 {
+		list = defaultTrustedProxies
+	}
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, v := range list {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				log.Fatalf("Invalid trusted proxy address: %v", v)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			log.Fatalf("Invalid trusted proxy CIDR %v: %v", v, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func (s *ProxyServer) isTrustedProxy(ip net.IP) bool {
+	for _, n := range s.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client address of HTTP request. Forwarded headers are honored only if the
+// peer is a trusted proxy. X-Forwarded-For is walked from the right skipping
+// trusted hops, so addresses prepended by client are never reached.
+func (s *ProxyServer) remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.config.Proxy.BehindReverseProxy {
+		return host
+	}
+	peer := net.ParseIP(host)
+	if peer == nil || !s.isTrustedProxy(peer) {
+		return host
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				// Malformed hop, don't trust anything left of it
+				break
+			}
+			client = ip
+			if !s.isTrustedProxy(ip) {
+				break
+			}
+		}
+		return client.String()
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return host
+}
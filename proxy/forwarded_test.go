@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	s := &ProxyServer{config: &Config{Proxy: Proxy{BehindReverseProxy: true}}}
+	s.trustedProxies = parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+
+	req := func(remote string, headers map[string]string) *http.Request {
+		r, _ := http.NewRequest("POST", "/", nil)
+		r.RemoteAddr = remote
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		return r
+	}
+
+	if ip := s.remoteAddr(req("1.2.3.4:5000", map[string]string{"X-Forwarded-For": "5.5.5.5"})); ip != "1.2.3.4" {
+		t.Errorf("Must ignore forwarded header from untrusted peer, got %v", ip)
+	}
+	if ip := s.remoteAddr(req("10.1.1.1:5000", map[string]string{"X-Forwarded-For": "5.5.5.5"})); ip != "5.5.5.5" {
+		t.Errorf("Must honor forwarded header from trusted peer, got %v", ip)
+	}
+	if ip := s.remoteAddr(req("10.1.1.1:5000", map[string]string{"X-Forwarded-For": "6.6.6.6, 5.5.5.5, 192.168.1.1"})); ip != "5.5.5.5" {
+		t.Errorf("Must skip trusted hops and stop at first untrusted one, got %v", ip)
+	}
+	if ip := s.remoteAddr(req("10.1.1.1:5000", map[string]string{"X-Forwarded-For": "5.5.5.5, bogus"})); ip != "10.1.1.1" {
+		t.Errorf("Must not trust hops left of malformed entry, got %v", ip)
+	}
+	if ip := s.remoteAddr(req("10.1.1.1:5000", map[string]string{"X-Real-IP": "7.7.7.7"})); ip != "7.7.7.7" {
+		t.Errorf("Must honor X-Real-IP from trusted peer, got %v", ip)
+	}
+
+	s.config.Proxy.BehindReverseProxy = false
+	if ip := s.remoteAddr(req("10.1.1.1:5000", map[string]string{"X-Real-IP": "7.7.7.7"})); ip != "10.1.1.1" {
+		t.Errorf("Must ignore forwarded headers if not behind reverse proxy, got %v", ip)
+	}
+}
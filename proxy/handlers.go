@@ -2,40 +2,55 @@ package proxy
 
 import (
 	"log"
-	"regexp"
+	"math/big"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/etclabscore/open-etc-pool/clickhouse"
 	"github.com/etclabscore/open-etc-pool/rpc"
 	"github.com/etclabscore/open-etc-pool/util"
 )
 
-var (
-	noncePattern  = regexp.MustCompile("^0x[0-9a-f]{16}$")
-	hashPattern   = regexp.MustCompile("^0x[0-9a-f]{64}$")
-	workerPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,8}$")
-	addressCache  = sync.Map{} // Concurrent address cache
-)
+var addressCache = sync.Map{} // Concurrent address cache
 
 // Optimized login handler with caching
 func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (bool, *ErrorReply) {
 	if len(params) == 0 {
-		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
+		return false, s.errorReply(cs, -1, msgInvalidParams)
+	}
+
+	var password string
+	if len(params) > 1 {
+		password = params[1]
 	}
+	login, staticDiff := splitStaticDiff(params[0], password)
 
-	login := strings.ToLower(params[0])
+	// Worker may be passed as address.worker
+	if i := strings.IndexByte(login, '.'); i >= 0 {
+		cs.worker = login[i+1:]
+		login = login[:i]
+	}
 
 	// Fast path with cached validation
-	if valid, ok := addressCache.Load(login); ok {
-		if !valid.(bool) {
-			return false, &ErrorReply{Code: -1, Message: "Invalid login"}
-		}
+	if cached, ok := addressCache.Load(login); ok {
+		login = cached.(string)
 	} else {
-		valid := util.IsValidHexAddress(login)
-		addressCache.Store(login, valid)
-		if !valid {
-			return false, &ErrorReply{Code: -1, Message: "Invalid login"}
+		canonical, _ := util.CanonicalAddress(login)
+		addressCache.Store(login, canonical)
+		login = canonical
+	}
+	if len(login) == 0 {
+		return false, s.errorReply(cs, -1, msgInvalidLogin)
+	}
+	if len(cs.worker) > 0 {
+		worker, ok := s.workerName(cs, login, cs.worker)
+		if !ok {
+			return false, s.errorReply(cs, -1, msgInvalidWorker)
 		}
+		cs.worker = worker
 	}
 
 	// Parallel policy check
@@ -45,11 +60,33 @@ func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (b
 	}()
 
 	if !<-policyOk {
-		return false, &ErrorReply{Code: -1, Message: "You are blacklisted"}
+		return false, s.errorReply(cs, -1, msgBlacklisted)
+	}
+
+	if len(staticDiff) > 0 && s.config.Proxy.StaticDiff.Enabled {
+		if err := s.applyStaticDiff(cs, staticDiff); err != nil {
+			log.Printf("Invalid static difficulty %q from %v@%v: %v", staticDiff, login, cs.ip, err)
+			return false, s.errorReply(cs, -1, msgInvalidParams)
+		}
+	}
+
+	diff, _ := cs.difficulty()
+	if minDiff := s.sessionDifficulty(diff); minDiff != diff {
+		cs.setDifficulty(minDiff)
+		if minDiff > diff {
+			cs.markDDoSRaise(diff, minDiff)
+		}
 	}
 
+	if !s.registerLogin(cs, login) {
+		log.Printf("Too many sessions for %v, rejecting %v", login, cs.ip)
+		return false, s.errorReply(cs, -1, msgTooManySessions)
+	}
 	cs.login = login
-	s.registerSession(cs)
+	if s.config.Proxy.ShareSampling.Enabled && cs.sampler == nil {
+		cs.sampler = newShareSampler()
+	}
+	s.checkLatency(cs)
 	log.Printf("Stratum miner connected %v@%v", login, cs.ip)
 	return true, nil
 }
@@ -58,70 +95,130 @@ func (s *ProxyServer) handleLoginRPC(cs *Session, params []string, id string) (b
 func (s *ProxyServer) handleGetWorkRPC(cs *Session) ([]string, *ErrorReply) {
 	t := s.currentBlockTemplate()
 	if t == nil || len(t.Header) == 0 || s.isSick() {
-		return nil, &ErrorReply{Code: 0, Message: "Work not ready"}
+		return nil, s.errorReply(cs, 0, msgWorkNotReady)
 	}
-	return []string{t.Header, t.Seed, s.diff}, nil
+	diff, target := cs.difficulty()
+	reply := []string{t.Header, t.Seed, target}
+	if cs.conn != nil {
+		cs.diffMu.Lock()
+		cs.recordJobLocked(t.Header, diff)
+		cs.diffMu.Unlock()
+	}
+	// HTTP miners get message of the day in extended reply after block number
+	if cs.conn == nil {
+		if motd := s.message(cs, msgMotd); len(motd) > 0 {
+			reply = append(reply, hexutil.EncodeUint64(t.Height), motd)
+		}
+	}
+	return reply, nil
 }
 
 // Optimized submit handler with parallel validation
 func (s *ProxyServer) handleTCPSubmitRPC(cs *Session, id string, params []string) (bool, *ErrorReply) {
-	s.sessionsMu.RLock()
-	_, ok := s.sessions[cs]
-	s.sessionsMu.RUnlock()
-
-	if !ok {
-		return false, &ErrorReply{Code: 25, Message: "Not subscribed"}
+	// HTTP sessions live for a single request and are never registered
+	if cs.conn != nil {
+		if !s.sessions.contains(cs) {
+			return false, s.errorReply(cs, 25, msgNotSubscribed)
+		}
 	}
 
 	// Fast validation
 	if len(params) != 3 {
 		s.policy.ApplyMalformedPolicy(cs.ip)
-		return false, &ErrorReply{Code: -1, Message: "Invalid params"}
+		return false, s.errorReply(cs, -1, msgInvalidParams)
 	}
 
 	// Worker name processing
-	if !workerPattern.MatchString(id) {
-		id = "0"
+	if len(id) == 0 {
+		id = cs.worker
 	}
-
-	// Parallel pattern validation
-	var valid [3]bool
-	var wg sync.WaitGroup
-	wg.Add(3)
-
-	validate := func(i int, pattern *regexp.Regexp, s string) {
-		defer wg.Done()
-		valid[i] = pattern.MatchString(s)
+	id, okWorker := s.workerName(cs, cs.login, id)
+	if !okWorker {
+		return false, s.errorReply(cs, -1, msgInvalidWorker)
 	}
 
-	go validate(0, noncePattern, params[0])
-	go validate(1, hashPattern, params[1])
-	go validate(2, hashPattern, params[2])
-
-	wg.Wait()
-
-	if !valid[0] || !valid[1] || !valid[2] {
+	nonce, okNonce := util.CanonicalNonce(params[0])
+	powHash, okHash := util.CanonicalHash(params[1])
+	mixDigest, okMix := util.CanonicalHash(params[2])
+	if !okNonce || !okHash || !okMix {
 		s.policy.ApplyMalformedPolicy(cs.ip)
-		return false, &ErrorReply{Code: -1, Message: "Malformed PoW result"}
+		return false, s.errorReply(cs, -1, msgMalformedPoW)
 	}
+	params = []string{nonce, powHash, mixDigest}
 
+	if s.verifier != nil {
+		s.restoreDifficulty(cs, time.Now())
+	}
+
+	// Shutdown takes write lock to wait for pending share writes
+	s.submitsMu.RLock()
 	t := s.currentBlockTemplate()
-	exist, validShare := s.processShare(cs.login, id, cs.ip, t, params)
-	ok = s.policy.ApplySharePolicy(cs.ip, !exist && validShare)
+	exist, validShare, queued := s.verifyShare(cs, id, t, params)
+	s.submitsMu.RUnlock()
+	if !queued {
+		s.exportShare(cs, id, t, clickhouse.Busy)
+		s.throttleSession(cs, time.Now())
+		return false, s.busyReply(cs)
+	}
+	ok := s.policy.ApplySharePolicy(cs.ip, !exist && validShare)
 
 	if exist {
-		return false, &ErrorReply{Code: 22, Message: "Duplicate share"}
+		s.exportShare(cs, id, t, clickhouse.Duplicate)
+		return false, s.errorReply(cs, 22, msgDuplicateShare)
 	}
 
 	if !validShare {
+		s.exportShare(cs, id, t, clickhouse.Invalid)
 		if !ok {
-			return false, &ErrorReply{Code: 23, Message: "Invalid share"}
+			return false, s.errorReply(cs, 23, msgInvalidShare)
 		}
 		return false, nil
 	}
 
+	s.exportShare(cs, id, t, clickhouse.Accepted)
+	if !ok {
+		return true, s.errorReply(cs, -1, msgHighInvalidRate)
+	}
+	return true, nil
+}
+
+func (s *ProxyServer) exportShare(cs *Session, id string, t *BlockTemplate, status string) {
+	if s.shareExport == nil {
+		return
+	}
+	ev := clickhouse.NewShareEvent(time.Now())
+	ev.Tenant = s.sessionBackend(cs).Tenant()
+	ev.Login, ev.Worker, ev.IP = cs.login, id, cs.ip
+	ev.Height = t.Height
+	ev.Difficulty, _ = cs.difficulty()
+	ev.Status = status
+	s.shareExport.Send(ev)
+}
+
+// Stores hashrate reported by mining software for comparison with calculated one
+func (s *ProxyServer) handleSubmitHashrateRPC(cs *Session, id string, params []string) (bool, *ErrorReply) {
+	if len(cs.login) == 0 {
+		return false, s.errorReply(cs, 25, msgNotSubscribed)
+	}
+	if len(params) != 2 {
+		return false, s.errorReply(cs, -1, msgInvalidParams)
+	}
+	hashrate, ok := new(big.Int).SetString(strings.TrimPrefix(params[0], "0x"), 16)
+	if !ok || hashrate.Sign() < 0 || !hashrate.IsInt64() {
+		return false, s.errorReply(cs, -1, msgInvalidParams)
+	}
+	clientId, _ := util.CanonicalHash(params[1])
+
+	if len(id) == 0 {
+		id = cs.worker
+	}
+	id, ok = s.workerName(cs, cs.login, id)
 	if !ok {
-		return true, &ErrorReply{Code: -1, Message: "High rate of invalid shares"}
+		return false, s.errorReply(cs, -1, msgInvalidWorker)
+	}
+	err := s.accounting(s.sessionBackend(cs)).WriteReportedHashrate(cs.login, id, hashrate.Int64(), clientId, s.hashrateExpiration)
+	if err != nil {
+		log.Printf("Failed to store reported hashrate of %v.%v: %v", cs.login, id, err)
 	}
 	return true, nil
 }
@@ -136,5 +233,5 @@ func (s *ProxyServer) handleGetBlockByNumberRPC() *rpc.GetBlockReplyPart {
 
 func (s *ProxyServer) handleUnknownRPC(cs *Session, m string) *ErrorReply {
 	s.policy.ApplyMalformedPolicy(cs.ip)
-	return &ErrorReply{Code: -3, Message: "Method not found"}
+	return s.errorReply(cs, -3, msgMethodNotFound)
 }
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	headsDialTimeout    = 10 * time.Second
+	headsReconnectDelay = 5 * time.Second
+	// Connection is considered dead without heads for this long
+	headsReadTimeout = 2 * time.Minute
+	// Node may update pending block shortly after announcing head
+	headFetchAttempts = 5
+	headFetchDelay    = 100 * time.Millisecond
+	// At most one refresh per this delay on pending transactions
+	pendingTxRefreshDelay = time.Second
+)
+
+// Replies to eth_subscribe and subscription notifications share connection
+type wsMessage struct {
+	Id     int              `json:"id"`
+	Result json.RawMessage  `json:"result"`
+	Error  *json.RawMessage `json:"error"`
+	Method string           `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+type headsHandler struct {
+	// Called once newHeads subscription is confirmed
+	ready     func()
+	head      func(number uint64)
+	pendingTx func()
+}
+
+// Refreshes template as soon as upstream with "wsUrl" announces new head,
+// instead of waiting for the next block refresh tick. Polling interval is
+// restored as soon as subscription drops.
+func (s *ProxyServer) watchNewHeads(i int, cfg Upstream) {
+	current := func() bool {
+		// Template is taken from current upstream only
+		return int(atomic.LoadInt32(&s.upstream)) == i
+	}
+	// Pending transactions only refresh block contents, so refreshes are coalesced
+	pending := make(chan struct{}, 1)
+	go func() {
+		for range pending {
+			s.fetchBlockTemplate()
+			time.Sleep(pendingTxRefreshDelay)
+		}
+	}()
+	h := headsHandler{
+		ready: func() { atomic.StoreInt32(&s.headsLive[i], 1) },
+		head: func(number uint64) {
+			if current() {
+				s.onNewHead(number, time.Now())
+			}
+		},
+		pendingTx: func() {
+			if current() {
+				select {
+				case pending <- struct{}{}:
+				default:
+				}
+			}
+		},
+	}
+	for {
+		err := subscribeNewHeads(cfg.WsUrl, cfg.PendingTxs, h)
+		if atomic.SwapInt32(&s.headsLive[i], 0) == 1 && current() {
+			select {
+			case s.headsDown <- struct{}{}:
+			default:
+			}
+		}
+		log.Printf("New heads subscription to %s closed: %v", cfg.Name, err)
+		time.Sleep(headsReconnectDelay)
+	}
+}
+
+func subscribeNewHeads(url string, pendingTxs bool, h headsHandler) error {
+	dialer := websocket.Dialer{HandshakeTimeout: headsDialTimeout}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	topics := []string{"newHeads"}
+	if pendingTxs {
+		topics = append(topics, "newPendingTransactions")
+	}
+	for i, topic := range topics {
+		req := map[string]interface{}{"jsonrpc": "2.0", "id": i + 1, "method": "eth_subscribe", "params": []string{topic}}
+		if err := conn.WriteJSON(req); err != nil {
+			return err
+		}
+	}
+	// Topic by subscription id
+	subs := make(map[string]string)
+	for {
+		if len(subs) < len(topics) {
+			conn.SetReadDeadline(time.Now().Add(headsDialTimeout))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(headsReadTimeout))
+		}
+		var m wsMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			return err
+		}
+		if m.Method != "eth_subscription" {
+			if m.Id < 1 || m.Id > len(topics) {
+				continue
+			}
+			if m.Error != nil {
+				return errors.New(string(*m.Error))
+			}
+			var id string
+			if err := json.Unmarshal(m.Result, &id); err != nil {
+				return err
+			}
+			subs[id] = topics[m.Id-1]
+			if m.Id == 1 {
+				h.ready()
+			}
+			continue
+		}
+		switch subs[m.Params.Subscription] {
+		case "newHeads":
+			var head struct {
+				Number string `json:"number"`
+			}
+			json.Unmarshal(m.Params.Result, &head)
+			number, err := strconv.ParseUint(strings.TrimPrefix(head.Number, "0x"), 16, 64)
+			if err == nil {
+				h.head(number)
+			}
+		case "newPendingTransactions":
+			h.pendingTx()
+		}
+	}
+}
+
+// Records time from head announcement to job of the next height
+func (s *ProxyServer) onNewHead(number uint64, arrival time.Time) {
+	for i := 0; i < headFetchAttempts; i++ {
+		if t := s.currentBlockTemplate(); t != nil && t.Height > number {
+			break
+		}
+		if i > 0 {
+			time.Sleep(headFetchDelay)
+		}
+		s.fetchBlockTemplate()
+	}
+	if t := s.currentBlockTemplate(); t != nil && t.Height > number {
+		atomic.StoreInt64(&s.headToJob, int64(time.Since(arrival)/time.Millisecond))
+	}
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSubscribeNewHeads(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < 2; i++ {
+			var req map[string]interface{}
+			conn.ReadJSON(&req)
+			if req["method"] != "eth_subscribe" {
+				t.Errorf("Must subscribe, got %v", req)
+			}
+		}
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"result":"0xcd0c"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xcd0c","result":{"number":"0x1b4"}}}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":2,"result":"0xab12"}`))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"0xab12","result":"0xd6fd"}}`))
+		time.Sleep(time.Second)
+	}))
+	defer srv.Close()
+
+	ready := make(chan bool, 1)
+	heads := make(chan uint64, 1)
+	txs := make(chan bool, 1)
+	go subscribeNewHeads("ws"+strings.TrimPrefix(srv.URL, "http"), true, headsHandler{
+		ready:     func() { ready <- true },
+		head:      func(number uint64) { heads <- number },
+		pendingTx: func() { txs <- true },
+	})
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatal("Must confirm subscription")
+	}
+	select {
+	case n := <-heads:
+		if n != 436 {
+			t.Errorf("Must parse head number, got %v", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Must receive new head")
+	}
+	select {
+	case <-txs:
+	case <-time.After(time.Second):
+		t.Fatal("Must receive pending transaction")
+	}
+}
+
+func TestWatchNewHeadsSignalsDown(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var req map[string]interface{}
+		conn.ReadJSON(&req)
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"result":"0xcd0c"}`))
+		time.Sleep(100 * time.Millisecond)
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	s := &ProxyServer{headsLive: make([]int32, 1), headsDown: make(chan struct{}, 1)}
+	go s.watchNewHeads(0, Upstream{Name: "test", WsUrl: "ws" + strings.TrimPrefix(srv.URL, "http")})
+	select {
+	case <-s.headsDown:
+	case <-time.After(time.Second):
+		t.Fatal("Must signal dropped subscription to restore polling")
+	}
+}
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+)
+
+// HTTP miners may send this header with eth_submitWork, retries with the same key get original reply
+const idempotencyHeader = "Idempotency-Key"
+
+var idempotencyKeyPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,64}$")
+
+type idempotentReply struct {
+	Result bool        `json:"result"`
+	Error  *ErrorReply `json:"error,omitempty"`
+}
+
+func (s *ProxyServer) handleIdempotentSubmitRPC(cs *Session, key, id string, params []string) (bool, *ErrorReply) {
+	if s.idempotencyWindow == 0 || len(key) == 0 || len(params) == 0 {
+		return s.handleTCPSubmitRPC(cs, id, params)
+	}
+	if !idempotencyKeyPattern.MatchString(key) {
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		return false, s.errorReply(cs, -1, msgInvalidParams)
+	}
+
+	// Nonce is a part of the key, so reusing key for another share doesn't hide it
+	cached, err := s.sessionBackend(cs).GetIdempotentReply(cs.login, key, params[0])
+	if err != nil {
+		log.Printf("Failed to get idempotent reply from backend: %v", err)
+	} else if len(cached) > 0 {
+		var reply idempotentReply
+		if err := json.Unmarshal([]byte(cached), &reply); err == nil {
+			return reply.Result, reply.Error
+		}
+	}
+
+	result, errReply := s.handleTCPSubmitRPC(cs, id, params)
+	data, _ := json.Marshal(idempotentReply{Result: result, Error: errReply})
+	err = s.sessionBackend(cs).WriteIdempotentReply(cs.login, key, params[0], string(data), s.idempotencyWindow)
+	if err != nil {
+		log.Printf("Failed to write idempotent reply to backend: %v", err)
+	}
+	return result, errReply
+}
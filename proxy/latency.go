@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Switches session to buffered writes if its RTT is above configured threshold.
+// Replies are held for a short delay so they leave in one packet with the next job push.
+func (s *ProxyServer) checkLatency(cs *Session) {
+	if s.highLatency == 0 && !s.config.Proxy.StaleWindow.Enabled {
+		return
+	}
+	// Only stratum sessions hold a socket to probe
+	if cs.tcpConn() == nil {
+		return
+	}
+	rtt, err := cs.measureRTT()
+	if err != nil {
+		log.Printf("Failed to measure RTT for %v: %v", cs.ip, err)
+		return
+	}
+	if s.highLatency == 0 || rtt < s.highLatency {
+		return
+	}
+	cs.Lock()
+	defer cs.Unlock()
+	if ws, ok := cs.conn.(*wsConn); ok {
+		ws.EnableWriteCompression(true)
+	}
+	if cs.buf == nil {
+		cs.buf = bufio.NewWriter(cs.conn)
+		cs.flushDelay = s.highLatencyFlush
+		log.Printf("High latency session %v@%v, RTT %v, batching replies", cs.login, cs.ip, rtt)
+	}
+}
+
+// Underlying TCP connection of stratum session, nil for HTTP sessions
+func (cs *Session) tcpConn() *net.TCPConn {
+	conn := cs.conn
+	switch c := conn.(type) {
+	case *wsConn:
+		return c.tcpConn()
+	case *sv2Conn:
+		conn = c.Conn
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcp, _ := conn.(*net.TCPConn)
+	return tcp
+}
+
+// Kernel smoothed RTT of session connection, kept for when it can't be measured again
+func (cs *Session) measureRTT() (time.Duration, error) {
+	tcp := cs.tcpConn()
+	if tcp == nil {
+		return 0, errors.New("not a TCP connection")
+	}
+	rtt, err := connRTT(tcp)
+	if err != nil {
+		return 0, err
+	}
+	atomic.StoreInt64(&cs.rtt, int64(rtt))
+	return rtt, nil
+}
+
+// Must be called with session lock held
+func (cs *Session) flushLocked() error {
+	if cs.buf == nil {
+		return nil
+	}
+	if cs.flushTimer != nil {
+		cs.flushTimer.Stop()
+		cs.flushTimer = nil
+	}
+	return cs.buf.Flush()
+}
+
+// Must be called with session lock held
+func (cs *Session) scheduleFlushLocked() {
+	if cs.flushTimer != nil {
+		return
+	}
+	cs.flushTimer = time.AfterFunc(cs.flushDelay, func() {
+		cs.Lock()
+		defer cs.Unlock()
+		cs.flushTimer = nil
+		cs.conn.SetWriteDeadline(time.Now().Add(cs.writeTimeout))
+		if err := cs.buf.Flush(); err != nil {
+			log.Printf("Job transmit error to %v@%v: %v", cs.login, cs.ip, err)
+			cs.conn.Close()
+		}
+	})
+}
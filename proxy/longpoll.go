@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const defaultLongPollTimeout = 60 * time.Second
+
+// Wakes up long polling miners, called on new height
+func (s *ProxyServer) notifyLongPoll() {
+	s.longPollMu.Lock()
+	defer s.longPollMu.Unlock()
+	if s.longPoll != nil {
+		close(s.longPoll)
+	}
+	s.longPoll = make(chan struct{})
+}
+
+func (s *ProxyServer) waitLongPoll() <-chan struct{} {
+	s.longPollMu.Lock()
+	defer s.longPollMu.Unlock()
+	if s.longPoll == nil {
+		s.longPoll = make(chan struct{})
+	}
+	return s.longPoll
+}
+
+// Holds request until new height or timeout and replies as eth_getWork.
+// Miner may pass its current header with ?header= to get reply at once if it is outdated.
+func (s *ProxyServer) ServeLongPoll(w http.ResponseWriter, r *http.Request) {
+	if s.underAttack() || s.isDraining() {
+		s.writeError(w, http.StatusServiceUnavailable, "rpc: long polling is disabled")
+		return
+	}
+	ip := s.remoteAddr(r)
+	if s.policy.IsBanned(ip) {
+		return
+	}
+	login, ok := util.CanonicalAddress(mux.Vars(r)["login"])
+	if !ok {
+		s.writeError(w, http.StatusBadRequest, "rpc: invalid login")
+		return
+	}
+	if !s.policy.ApplyLoginPolicy(login, ip) {
+		s.writeError(w, http.StatusForbidden, "rpc: blacklisted")
+		return
+	}
+
+	wait := s.waitLongPoll()
+	if t := s.currentBlockTemplate(); t == nil || t.Header == r.URL.Query().Get("header") {
+		select {
+		case <-wait:
+		case <-time.After(s.longPollTimeout):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	diff, target := s.httpDifficulty()
+	cs := &Session{ip: ip, login: login, diff: diff, target: target}
+	message := JSONRpcResp{Id: json.RawMessage("0"), Version: "2.0"}
+	if reply, errReply := s.handleGetWorkRPC(cs); errReply != nil {
+		message.Error = errReply
+	} else {
+		message.Result = reply
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&message); err != nil {
+		log.Printf("Long poll reply error to %v@%v: %v", login, ip, err)
+	}
+}
+
+func (s *ProxyServer) initLongPoll() {
+	s.longPollTimeout = defaultLongPollTimeout
+	if len(s.config.Proxy.LongPoll.Timeout) > 0 {
+		s.longPollTimeout = util.MustParseDuration(s.config.Proxy.LongPoll.Timeout)
+	}
+	log.Printf("HTTP long polling enabled with %v timeout", s.longPollTimeout)
+}
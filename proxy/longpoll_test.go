@@ -0,0 +1,25 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLongPollNotify(t *testing.T) {
+	s := &ProxyServer{}
+	first := s.waitLongPoll()
+	if s.waitLongPoll() != first {
+		t.Error("Must share channel until new height")
+	}
+	s.notifyLongPoll()
+	select {
+	case <-first:
+	case <-time.After(time.Second):
+		t.Error("Must wake up waiters on new height")
+	}
+	select {
+	case <-s.waitLongPoll():
+		t.Error("Must not wake up next waiters")
+	default:
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"log"
+	"strings"
+)
+
+// Keys of miner-facing messages, can be overridden in "messages" section of proxy config
+const (
+	msgInvalidParams    = "invalidParams"
+	msgInvalidLogin     = "invalidLogin"
+	msgBlacklisted      = "blacklisted"
+	msgWorkNotReady     = "workNotReady"
+	msgNotSubscribed    = "notSubscribed"
+	msgMalformedPoW     = "malformedPoW"
+	msgMalformedRequest = "malformedRequest"
+	msgDuplicateShare   = "duplicateShare"
+	msgInvalidShare     = "invalidShare"
+	msgHighInvalidRate  = "highInvalidRate"
+	msgMethodNotFound   = "methodNotFound"
+	msgInvalidPing      = "invalidPing"
+	msgInvalidWorker    = "invalidWorker"
+	msgTooManySessions  = "tooManySessions"
+	msgBusy             = "busy"
+	// Placeholders {port} and {difficulty} are substituted with suggested listener
+	msgPortHint = "portHint"
+	// Message of the day, not sent if empty
+	msgMotd = "motd"
+)
+
+var defaultMessages = map[string]string{
+	msgInvalidParams:    "Invalid params",
+	msgInvalidLogin:     "Invalid login",
+	msgBlacklisted:      "You are blacklisted",
+	msgWorkNotReady:     "Work not ready",
+	msgNotSubscribed:    "Not subscribed",
+	msgMalformedPoW:     "Malformed PoW result",
+	msgMalformedRequest: "Malformed request",
+	msgDuplicateShare:   "Duplicate share",
+	msgInvalidShare:     "Invalid share",
+	msgHighInvalidRate:  "High rate of invalid shares",
+	msgMethodNotFound:   "Method not found",
+	msgInvalidPing:      "Invalid ping",
+	msgInvalidWorker:    "Invalid worker name",
+	msgTooManySessions:  "Too many connections for {login}, use fewer rigs per address or a mining proxy",
+	msgBusy:             "Server busy, try later",
+	msgPortHint:         "Your hashrate fits port {port} with difficulty {difficulty} better, please reconnect there",
+	msgMotd:             "",
+}
+
+func checkMessages(messages map[string]string) {
+	for key := range messages {
+		if _, ok := defaultMessages[key]; !ok {
+			log.Printf("Unknown miner message key in config: %s", key)
+		}
+	}
+}
+
+// Message text for a given key, {login} and {ip} placeholders are substituted with session data.
+// Messages of session software quirks take precedence over configured ones.
+func (s *ProxyServer) message(cs *Session, key string) string {
+	msg, ok := cs.quirks().message(key)
+	if !ok {
+		msg, ok = s.config.Proxy.Messages[key]
+	}
+	if !ok || len(msg) == 0 {
+		msg = defaultMessages[key]
+	}
+	if strings.Contains(msg, "{") {
+		msg = strings.NewReplacer("{login}", cs.login, "{ip}", cs.ip).Replace(msg)
+	}
+	return msg
+}
+
+func (s *ProxyServer) errorReply(cs *Session, code int, key string) *ErrorReply {
+	return &ErrorReply{Code: code, Message: s.message(cs, key)}
+}
@@ -2,91 +2,178 @@ package proxy
 
 import (
 	"log"
+	"math"
 	"math/big"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/etclabscore/go-etchash"
 	"github.com/ethereum/go-ethereum/common"
-)
-
-var ecip1099FBlockClassic uint64 = 11700000 // classic mainnet
-var ecip1099FBlockMordor uint64 = 2520000   // mordor
 
-var hasher *etchash.Etchash = nil
+	"github.com/etclabscore/open-etc-pool/eventbus"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
 
-func (s *ProxyServer) processShare(login, id, ip string, t *BlockTemplate, params []string) (bool, bool) {
-	if hasher == nil {
-		if s.config.Network == "classic" {
-			hasher = etchash.New(&ecip1099FBlockClassic, nil)
-		} else if s.config.Network == "mordor" {
-			hasher = etchash.New(&ecip1099FBlockMordor, nil)
-		} else {
-			// unknown network
-			log.Printf("Unknown network configuration %s", s.config.Network)
-			return false, false
-		}
-	}
+func (s *ProxyServer) processShare(cs *Session, id string, t *BlockTemplate, params []string, received time.Time) (bool, bool) {
+	login, ip := cs.login, cs.ip
+	backend := s.sessionBackend(cs)
+	solo := cs.solo()
 	nonceHex := params[0]
 	hashNoNonce := params[1]
 	mixDigest := params[2]
 	nonce, _ := strconv.ParseUint(strings.Replace(nonceHex, "0x", "", -1), 16, 64)
-	shareDiff := s.config.Proxy.Difficulty
+	// Checked against difficulty of the job, share may be mined before retarget reached the miner
+	shareDiff := cs.jobDifficulty(hashNoNonce)
 
 	h, ok := t.headers[hashNoNonce]
 	if !ok {
-		log.Printf("Stale share from %v@%v", login, ip)
+		log.Printf("Unknown job share from %v@%v", login, ip)
+		s.publishShare(cs, id, 0, shareDiff, 0, shareUnknownJob)
 		return false, false
 	}
 
 	share := Block{
 		number:      h.height,
 		hashNoNonce: common.HexToHash(hashNoNonce),
-		difficulty:  big.NewInt(shareDiff),
 		nonce:       nonce,
 		mixDigest:   common.HexToHash(mixDigest),
 	}
 
-	block := Block{
-		number:      h.height,
-		hashNoNonce: common.HexToHash(hashNoNonce),
-		difficulty:  h.diff,
-		nonce:       nonce,
-		mixDigest:   common.HexToHash(mixDigest),
+	// Hashed once, then compared with share and block difficulty
+	achieved := s.pow.difficulty(share)
+	if achieved.Cmp(big.NewInt(shareDiff)) < 0 {
+		s.publishShare(cs, id, h.height, shareDiff, 0, shareLowDifficulty)
+		return false, false
+	}
+	// Credited at session difficulty, achieved one is kept for best share stats
+	actualDiff := int64(math.MaxInt64)
+	if achieved.IsInt64() {
+		actualDiff = achieved.Int64()
 	}
 
-	if !hasher.Verify(share) {
-		return false, false
+	// Job for previous height, can't make a block but miner did the work, so it is credited.
+	// Counted as stale unless it is late only by session latency.
+	late := h.height < t.Height && s.isLateShare(cs, t, h.height, time.Now())
+	if h.height < t.Height && !late {
+		exist, err := s.writeStaleShare(backend, solo, login, id, params, shareDiff, actualDiff, h.height)
+		if exist {
+			s.publishShare(cs, id, h.height, shareDiff, actualDiff, shareDuplicate)
+			return true, false
+		}
+		if err != nil {
+			log.Println("Failed to insert stale share data into backend:", err)
+		} else if !solo {
+			s.creditPPS(backend, login, shareDiff, h)
+		}
+		log.Printf("Stale share from %v@%v at height %v", login, ip, h.height)
+		s.publishShare(cs, id, h.height, shareDiff, actualDiff, shareStale)
+		cs.countShare(shareDiff)
+		return false, true
+	}
+
+	if late {
+		log.Printf("Late share from %v@%v at height %v credited within stale window", login, ip, h.height)
 	}
 
-	if hasher.Verify(block) {
-		ok, err := s.rpc().SubmitBlock(params)
+	if !late && achieved.Cmp(h.diff) >= 0 {
+		submitAt := time.Now()
+		ok, rejections, err := s.submitBlock(params, h.height)
+		block := &storage.PendingBlock{
+			Login: login, Worker: id, Params: params, Height: h.height,
+			Diff: shareDiff, ActualDiff: actualDiff, RoundDiff: h.diff.Int64(),
+			Window: s.hashrateExpiration, Solo: solo,
+		}
 		if err != nil {
 			log.Printf("Block submission failure at height %v for %v: %v", h.height, t.Header, err)
+			if s.config.Proxy.BlockRetry.Enabled {
+				block.FoundAt = util.MakeTimestamp()
+				s.queuePendingBlock(backend, block)
+			}
 		} else if !ok {
 			log.Printf("Block rejected at height %v for %v", h.height, t.Header)
+			go s.reportBlockRace(&storage.BlockRace{
+				Instance: s.config.Name, Tenant: backend.Tenant(), Login: login, Worker: id, Height: h.height,
+				ReceivedAt:  received.UnixNano() / int64(time.Millisecond),
+				TemplateAge: msSince(t.heightAt, received),
+				VerifyDelay: msSince(received, submitAt),
+			}, rejections)
+			s.publishShare(cs, id, h.height, shareDiff, actualDiff, shareBlockRejected)
 			return false, false
 		} else {
 			s.fetchBlockTemplate()
-			exist, err := s.backend.WriteBlock(login, id, params, shareDiff, h.diff.Int64(), h.height, s.hashrateExpiration)
+			exist, err := s.writeBlock(backend, block)
 			if exist {
+				s.publishShare(cs, id, h.height, shareDiff, actualDiff, shareDuplicate)
 				return true, false
 			}
 			if err != nil {
 				log.Println("Failed to insert block candidate into backend:", err)
 			} else {
 				log.Printf("Inserted block %v to backend", h.height)
+				if !solo {
+					s.creditPPS(backend, login, shareDiff, h)
+				}
 			}
 			log.Printf("Block found by miner %v@%v at height %d", login, ip, h.height)
 		}
 	} else {
-		exist, err := s.backend.WriteShare(login, id, params, shareDiff, h.height, s.hashrateExpiration)
+		exist, err := s.writeShare(backend, solo, login, id, params, shareDiff, actualDiff, h.height)
 		if exist {
+			s.publishShare(cs, id, h.height, shareDiff, actualDiff, shareDuplicate)
 			return true, false
 		}
 		if err != nil {
 			log.Println("Failed to insert share data into backend:", err)
+		} else if !solo {
+			s.creditPPS(backend, login, shareDiff, h)
 		}
 	}
+	result := shareValid
+	if late {
+		result = shareLate
+	} else if achieved.Cmp(h.diff) >= 0 {
+		result = shareBlock
+	}
+	s.publishShare(cs, id, h.height, shareDiff, actualDiff, result)
+	cs.countShare(shareDiff)
 	return false, true
 }
+
+// Share results on event bus
+const (
+	shareValid         = "valid"
+	shareLate          = "late"
+	shareBlock         = "block"
+	shareStale         = "stale"
+	shareDuplicate     = "duplicate"
+	shareLowDifficulty = "lowDifficulty"
+	shareUnknownJob    = "unknownJob"
+	shareBlockRejected = "blockRejected"
+)
+
+type shareEvent struct {
+	Login      string `json:"login"`
+	Worker     string `json:"worker"`
+	Ip         string `json:"ip"`
+	Tenant     string `json:"tenant,omitempty"`
+	Height     uint64 `json:"height,omitempty"`
+	Difficulty int64  `json:"difficulty"`
+	// Difficulty achieved by share hash, unknown for shares rejected before hashing or under difficulty
+	ActualDiff int64  `json:"actualDiff,omitempty"`
+	Result     string `json:"result"`
+}
+
+// Accepted shares, including stale ones, go to share stream, the rest to share.rejected
+func (s *ProxyServer) publishShare(cs *Session, id string, height uint64, diff, actualDiff int64, result string) {
+	if !eventbus.SharesEnabled() {
+		return
+	}
+	eventType := eventbus.Share
+	switch result {
+	case shareDuplicate, shareLowDifficulty, shareUnknownJob, shareBlockRejected:
+		eventType = eventbus.ShareRejected
+	}
+	eventbus.Publish(eventType, &shareEvent{Login: cs.login, Worker: id, Ip: cs.ip, Tenant: s.sessionBackend(cs).Tenant(), Height: height,
+		Difficulty: diff, ActualDiff: actualDiff, Result: result})
+}
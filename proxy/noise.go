@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Noise NX handshake: client sends ephemeral key, server replies with
+// ephemeral and encrypted static key, client pins static key out of band
+const noiseProtocolName = "Noise_NX_25519_ChaChaPoly_SHA256"
+
+const (
+	noiseKeyLen  = 32
+	noiseTagLen  = 16
+	noiseMaxMsg  = 65535
+	noiseMsg1Len = noiseKeyLen
+	noiseMsg2Len = noiseKeyLen + noiseKeyLen + noiseTagLen + noiseTagLen
+)
+
+var errNoiseDecrypt = errors.New("noise: decryption failed")
+
+type cipherState struct {
+	aead cipher.AEAD
+	n    uint64
+}
+
+func newCipherState(key []byte) *cipherState {
+	aead, _ := chacha20poly1305.New(key)
+	return &cipherState{aead: aead}
+}
+
+func (c *cipherState) nonce() []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[4:], c.n)
+	c.n++
+	return nonce
+}
+
+func (c *cipherState) encrypt(ad, plaintext []byte) []byte {
+	return c.aead.Seal(nil, c.nonce(), plaintext, ad)
+}
+
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.aead.Open(nil, c.nonce(), ciphertext, ad)
+	if err != nil {
+		return nil, errNoiseDecrypt
+	}
+	return plaintext, nil
+}
+
+type symmetricState struct {
+	cs *cipherState
+	ck []byte
+	h  []byte
+}
+
+func newSymmetricState() *symmetricState {
+	// Protocol name is exactly hash length, so it is used as is
+	h := []byte(noiseProtocolName)
+	s := &symmetricState{ck: append([]byte{}, h...), h: h}
+	// Empty prologue
+	s.mixHash(nil)
+	return s
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h)
+	h.Write(data)
+	s.h = h.Sum(nil)
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	var key []byte
+	s.ck, key = hkdf(s.ck, ikm)
+	s.cs = newCipherState(key)
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	out := plaintext
+	if s.cs != nil {
+		out = s.cs.encrypt(s.h, plaintext)
+	}
+	s.mixHash(out)
+	return out
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	out := ciphertext
+	if s.cs != nil {
+		var err error
+		if out, err = s.cs.decrypt(s.h, ciphertext); err != nil {
+			return nil, err
+		}
+	}
+	s.mixHash(ciphertext)
+	return out, nil
+}
+
+// Returns initiator to responder and responder to initiator ciphers
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+	k1, k2 := hkdf(s.ck, nil)
+	return newCipherState(k1), newCipherState(k2)
+}
+
+func hkdf(ck, ikm []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, ck)
+	mac.Write(ikm)
+	temp := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write([]byte{1})
+	out1 := mac.Sum(nil)
+
+	mac = hmac.New(sha256.New, temp)
+	mac.Write(out1)
+	mac.Write([]byte{2})
+	return out1, mac.Sum(nil)
+}
+
+func dh(priv *ecdh.PrivateKey, pub []byte) ([]byte, error) {
+	remote, err := ecdh.X25519().NewPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return priv.ECDH(remote)
+}
+
+// Responder side of handshake, returns ciphers for sending and receiving
+func noiseAccept(rw io.ReadWriter, static *ecdh.PrivateKey) (*cipherState, *cipherState, error) {
+	s := newSymmetricState()
+
+	// -> e
+	msg := make([]byte, noiseMsg1Len)
+	if _, err := io.ReadFull(rw, msg); err != nil {
+		return nil, nil, err
+	}
+	re := msg[:noiseKeyLen]
+	s.mixHash(re)
+	if _, err := s.decryptAndHash(nil); err != nil {
+		return nil, nil, err
+	}
+
+	// <- e, ee, s, es
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := append([]byte{}, e.PublicKey().Bytes()...)
+	s.mixHash(e.PublicKey().Bytes())
+	ee, err := dh(e, re)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mixKey(ee)
+	out = append(out, s.encryptAndHash(static.PublicKey().Bytes())...)
+	es, err := dh(static, re)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.mixKey(es)
+	out = append(out, s.encryptAndHash(nil)...)
+	if _, err := rw.Write(out); err != nil {
+		return nil, nil, err
+	}
+
+	recv, send := s.split()
+	return send, recv, nil
+}
+
+// Initiator side of handshake, returns ciphers and static key of responder
+func noiseConnect(rw io.ReadWriter) (*cipherState, *cipherState, []byte, error) {
+	s := newSymmetricState()
+
+	e, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.mixHash(e.PublicKey().Bytes())
+	out := append(e.PublicKey().Bytes(), s.encryptAndHash(nil)...)
+	if _, err := rw.Write(out); err != nil {
+		return nil, nil, nil, err
+	}
+
+	msg := make([]byte, noiseMsg2Len)
+	if _, err := io.ReadFull(rw, msg); err != nil {
+		return nil, nil, nil, err
+	}
+	re := msg[:noiseKeyLen]
+	s.mixHash(re)
+	ee, err := dh(e, re)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.mixKey(ee)
+	rs, err := s.decryptAndHash(msg[noiseKeyLen : 2*noiseKeyLen+noiseTagLen])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	es, err := dh(e, rs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.mixKey(es)
+	if _, err := s.decryptAndHash(msg[2*noiseKeyLen+noiseTagLen:]); err != nil {
+		return nil, nil, nil, err
+	}
+
+	send, recv := s.split()
+	return send, recv, rs, nil
+}
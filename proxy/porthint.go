@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultPortHintWindow = 5 * time.Minute
+	defaultPortHintFactor = 4
+	defaultPortHintRate   = 4
+	portHintInterval      = 30 * time.Second
+)
+
+// Suggests better listener to stratum sessions whose share rate doesn't fit difficulty of their port
+type PortHint struct {
+	Enabled bool `json:"enabled"`
+	// Share rate is observed for this period after connect, 5m if not set
+	Window string `json:"window"`
+	// Desired shares per minute, vardiff goal or 4 if not set
+	SharesPerMinute float64 `json:"sharesPerMinute"`
+	// Hint is sent if share rate is off by this factor, 4 if not set
+	Factor float64 `json:"factor"`
+}
+
+// Every session is checked once, when observation window is over
+func (s *ProxyServer) portHinter() {
+	cfg := s.config.Proxy.Stratum.PortHint
+	window := defaultPortHintWindow
+	if len(cfg.Window) > 0 {
+		window = util.MustParseDuration(cfg.Window)
+	}
+	log.Printf("Suggesting stratum ports by share rate observed for %v", window)
+
+	ticker := time.NewTicker(portHintInterval)
+	for range ticker.C {
+		now := time.Now()
+		for _, cs := range s.sessions.all() {
+			if cs.port == nil || cs.hinted || cs.hasStaticDifficulty() || len(cs.login) == 0 || now.Sub(cs.connectedAt) < window {
+				continue
+			}
+			cs.hinted = true
+			if port := s.betterPort(cs, now); port != nil {
+				s.sendPortHint(cs, port)
+			}
+		}
+	}
+}
+
+// Port of session tenant and mining mode whose difficulty brings share rate closest to the goal,
+// nil if rate on current port is within allowed factor
+func (s *ProxyServer) betterPort(cs *Session, now time.Time) *StratumPort {
+	cfg := &s.config.Proxy.Stratum.PortHint
+	goal := cfg.SharesPerMinute
+	if goal <= 0 {
+		goal = s.config.Proxy.VarDiff.SharesPerMinute
+	}
+	if goal <= 0 {
+		goal = defaultPortHintRate
+	}
+	factor := cfg.Factor
+	if factor <= 1 {
+		factor = defaultPortHintFactor
+	}
+
+	elapsed := now.Sub(cs.connectedAt).Minutes()
+	if elapsed <= 0 {
+		return nil
+	}
+	credited := atomic.LoadInt64(&cs.creditedDiff)
+	// Session without shares is assumed to be just short of one
+	if credited == 0 {
+		credited = cs.port.Difficulty / 2
+	}
+	hashrate := float64(credited) / elapsed
+	offGoal := func(diff int64) float64 {
+		return math.Abs(math.Log(hashrate / float64(diff) / goal))
+	}
+	if offGoal(cs.port.Difficulty) < math.Log(factor) {
+		return nil
+	}
+
+	var best *StratumPort
+	for _, port := range s.stratumPorts() {
+		if port.Tenant != cs.port.Tenant || port.Solo != cs.port.Solo {
+			continue
+		}
+		if best == nil || offGoal(port.Difficulty) < offGoal(best.Difficulty) {
+			p := port
+			best = &p
+		}
+	}
+	if best == nil || best.Listen == cs.port.Listen {
+		return nil
+	}
+	return best
+}
+
+func (s *ProxyServer) sendPortHint(cs *Session, port *StratumPort) {
+	_, number, err := net.SplitHostPort(port.Listen)
+	if err != nil {
+		number = port.Listen
+	}
+	difficulty := strconv.FormatInt(port.Difficulty, 10)
+	log.Printf("Suggesting port %v with difficulty %v to %v@%v connected to %v", number, difficulty, cs.login, cs.ip, cs.port.Listen)
+	msg := strings.NewReplacer("{port}", number, "{difficulty}", difficulty).Replace(s.message(cs, msgPortHint))
+	if err := cs.showMessage(msg); err != nil {
+		log.Printf("Failed to send port hint to %v@%v: %v", cs.login, cs.ip, err)
+	}
+}
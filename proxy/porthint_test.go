@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBetterPort(t *testing.T) {
+	s := &ProxyServer{config: &Config{Proxy: Proxy{Stratum: Stratum{Ports: []StratumPort{
+		{Listen: "0.0.0.0:8008", Difficulty: 4000000000},
+		{Listen: "0.0.0.0:8009", Difficulty: 40000000000},
+		{Listen: "0.0.0.0:8010", Difficulty: 400000000000},
+		{Listen: "0.0.0.0:9008", Difficulty: 400000000000, Tenant: "other"},
+	}}}}}
+	ports := s.stratumPorts()
+	now := time.Now()
+
+	// 100 shares per minute on low port
+	cs := &Session{port: &ports[0], connectedAt: now.Add(-5 * time.Minute), creditedDiff: 500 * 4000000000}
+	if port := s.betterPort(cs, now); port == nil || port.Listen != "0.0.0.0:8009" {
+		t.Errorf("Must suggest port closest to share rate goal to farm on low port, got %v", port)
+	}
+
+	// 2 shares per minute on middle port
+	cs = &Session{port: &ports[1], connectedAt: now.Add(-5 * time.Minute), creditedDiff: 10 * 40000000000}
+	if port := s.betterPort(cs, now); port != nil {
+		t.Errorf("Must not suggest port if share rate is within factor, got %v", port)
+	}
+
+	cs = &Session{port: &ports[2], connectedAt: now.Add(-5 * time.Minute)}
+	if port := s.betterPort(cs, now); port == nil || port.Listen != "0.0.0.0:8008" {
+		t.Errorf("Must suggest low difficulty port to session without shares, got %v", port)
+	}
+
+	cs = &Session{port: &ports[3], connectedAt: now.Add(-5 * time.Minute)}
+	if port := s.betterPort(cs, now); port != nil {
+		t.Errorf("Must only suggest ports of session tenant, got %v", port)
+	}
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"log"
+	"math/big"
+
+	"github.com/etclabscore/open-etc-pool/payouts"
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Percent charged on PPS share value, rewards.pps.fee or pool fee of tenant
+func (s *ProxyServer) ppsFee(tenant string) float64 {
+	if s.config.Rewards.PPS.Fee > 0 {
+		return s.config.Rewards.PPS.Fee
+	}
+	for _, t := range s.config.Tenants {
+		if t.Name == tenant {
+			return t.PoolFee
+		}
+	}
+	return s.config.BlockUnlocker.PoolFee
+}
+
+// Expected value of share in Shannon: its part of network difficulty in block subsidy, less fee
+func ppsValue(shareDiff int64, netDiff, subsidy *big.Int, fee float64) float64 {
+	if netDiff.Sign() <= 0 {
+		return 0
+	}
+	value := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(shareDiff), subsidy), new(big.Int).Mul(netDiff, big.NewInt(1e9)))
+	shannon, _ := value.Float64()
+	return shannon * (1 - fee/100)
+}
+
+// Accrued credit is settled to balance by unlocker
+func (s *ProxyServer) creditPPS(backend *storage.RedisClient, login string, shareDiff int64, h heightDiffPair) {
+	if !s.config.Rewards.PayPerShare() {
+		return
+	}
+	subsidy := payouts.BlockSubsidy(int64(h.height), payouts.EraRounds(s.config.Network, s.config.BlockUnlocker.Ecip1017EraRounds))
+	value := ppsValue(shareDiff, h.diff, subsidy, s.ppsFee(backend.Tenant()))
+	if err := s.accounting(backend).AccruePPS(login, value); err != nil {
+		log.Printf("Failed to accrue PPS credit of %v: %v", login, err)
+	}
+}
@@ -0,0 +1,16 @@
+package proxy
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestPPSValue(t *testing.T) {
+	subsidy, _ := new(big.Int).SetString("2560000000000000000", 10)
+	if v := ppsValue(4000000000, big.NewInt(4000000000000000), subsidy, 1); v < 2534.39 || v > 2534.41 {
+		t.Errorf("Must credit share part of network difficulty in subsidy less fee, got %v", v)
+	}
+	if v := ppsValue(4000000000, big.NewInt(0), subsidy, 1); v != 0 {
+		t.Errorf("Must not credit share without network difficulty, got %v", v)
+	}
+}
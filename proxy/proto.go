@@ -3,24 +3,35 @@ package proxy
 import "encoding/json"
 
 type JSONRpcReq struct {
-	Id     json.RawMessage `json:"id"`
-	Method string          `json:"method"`
-	Params json.RawMessage `json:"params"`
+	Id      json.RawMessage `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
 }
 
 type StratumReq struct {
 	JSONRpcReq
 	Worker string `json:"worker"`
+	// Miner software, sent by some miners on login
+	Agent string `json:"agent"`
 }
 
 // Stratum
 type JSONPushMessage struct {
-	// FIXME: Temporarily add ID for Claymore compliance
-	Id      int64       `json:"id"`
+	// FIXME: Temporarily add ID for Claymore compliance, 0 unless session quirks set another one
+	Id      interface{} `json:"id"`
 	Version string      `json:"jsonrpc"`
 	Result  interface{} `json:"result"`
 }
 
+// Stratum notification, miner doesn't reply to it
+type JSONNotification struct {
+	Id      json.RawMessage `json:"id"`
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params"`
+}
+
 type JSONRpcResp struct {
 	Id      json.RawMessage `json:"id"`
 	Version string          `json:"jsonrpc"`
@@ -33,6 +44,7 @@ type SubmitReply struct {
 }
 
 type ErrorReply struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
 }
@@ -1,81 +1,307 @@
 package proxy
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"mime"
 	"net"
 	"net/http"
-	"strings"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/etclabscore/open-etc-pool/clickhouse"
 	"github.com/etclabscore/open-etc-pool/policy"
 	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/statuspage"
 	"github.com/etclabscore/open-etc-pool/storage"
 	"github.com/etclabscore/open-etc-pool/util"
 )
 
+// Header extra data size limit of ethash chains
+const maxExtraData = 32
+
 type ProxyServer struct {
 	config             *Config
 	blockTemplate      atomic.Value
+	templateFetch      singleFlight
 	upstream           int32
 	upstreams          []*rpc.RPCClient
+	upstreamPriorities []int
+	upstreamHealth     []upstreamHealth // owned by upstream checker goroutine
+	chainId            uint64
+	chainStates        []int32
+	forkStates         []int32
 	backend            *storage.RedisClient
 	diff               string
+	diffRatio          float64
 	policy             *policy.PolicyServer
+	pow                *epochCaches
+	verifier           *verifyPool
+	shareExport        *clickhouse.Exporter
+	headToJob          int64 // ms from last new head announcement to job of the next height
+	headsLive          []int32
+	headsDown          chan struct{}
 	hashrateExpiration time.Duration
 	failsCount         int64
+	idempotencyWindow  time.Duration
+	workerNames        *workerNames
+	ddos               int32
+	tenants            map[string]*storage.RedisClient
+	trustedProxies     []*net.IPNet
+
+	// Accounting storage of shares and blocks by tenant
+	storage  storage.Storage
+	storages map[string]storage.Storage
+
+	longPollMu      sync.Mutex
+	longPoll        chan struct{}
+	longPollTimeout time.Duration
+
+	// Shutdown state
+	draining    int32
+	listenersMu sync.Mutex
+	listeners   []net.Listener
+	servers     []*http.Server
+	submitsMu   sync.RWMutex
 
 	// Stratum
-	sessionsMu sync.RWMutex
-	sessions   map[*Session]struct{}
-	timeout    time.Duration
+	sessions sessionRegistry
+	timeout  time.Duration
+	// Logged in sessions per login, taken before any session shard lock
+	loginMu       sync.Mutex
+	loginSessions map[string]int
+
+	// Header of last job broadcast, broadcasts are serialized
+	broadcastMu   sync.Mutex
+	lastBroadcast string
+
+	// Logins under admin live tail, map[string]bool shared with sessions
+	tails atomic.Value
+
+	// Per-session outbound queue length and write deadline
+	sendQueue    int
+	writeTimeout time.Duration
+
+	highLatency      time.Duration
+	highLatencyFlush time.Duration
+
+	staleWindow    time.Duration
+	staleWindowMax time.Duration
+
+	connLimit *connLimiter
 }
 
 type Session struct {
 	sync.Mutex
-	conn         *net.TCPConn
+	conn         net.Conn
 	ip           string
 	enc          *json.Encoder
+	backend      *storage.RedisClient
 	login        string
 	worker       string
 	lastActivity time.Time
 	lastPing     time.Time
 	pingTimeout  time.Duration
+	// Last measured RTT in ns, accessed atomically
+	rtt int64
+	// Invalid worker name was logged, accessed atomically
+	workerLogged int32
+
+	// Share difficulty and corresponding target, changed by vardiff
+	diffMu sync.RWMutex
+	diff   int64
+	target string
+	// Last job pushed to session, guarded by diffMu
+	lastHeader string
+	lastTarget string
+	// Lowest difficulty recent jobs were pushed with, shares are checked against it, guarded by diffMu
+	jobDiffs map[string]int64
+	jobOrder []string
+	// Difficulty before share queue was full and until when it stays raised, guarded by diffMu
+	busyDiff  int64
+	busyUntil time.Time
+	// Difficulty before DDoS mode raised it and the raised one, guarded by diffMu
+	ddosDiff   int64
+	ddosRaised int64
+	// Difficulty requested by miner or required by its max target is not retargeted, guarded by diffMu
+	staticDiff bool
+
+	// Vardiff state
+	shares       int64
+	lastRetarget time.Time
+
+	// Outgoing messages are buffered for high latency sessions
+	buf        *bufio.Writer
+	flushDelay time.Duration
+	flushTimer *time.Timer
+
+	// Replies collected while serving HTTP JSON-RPC batch
+	batch []*JSONRpcResp
+
+	// Stratum V2 channel this session is mining on
+	sv2 *sv2Channel
+
+	// Share intervals recorded for vardiff tuning
+	sampler *shareSampler
+
+	// Listener of stratum session and difficulty credited since connect, for port hint
+	port         *StratumPort
+	connectedAt  time.Time
+	creditedDiff int64
+	// Owned by port hinter
+	hinted bool
+
+	// Login this session is counted under, guarded by login lock
+	countedLogin string
+
+	// Logins under admin live tail, owned by server
+	tails *atomic.Value
+
+	// *Quirk of miner software, set on login
+	quirk atomic.Value
+
+	// Outbound queue of stratum session, drained by writer goroutine
+	out          chan outMessage
+	stop         chan struct{}
+	stopped      chan struct{}
+	stopOnce     sync.Once
+	writeTimeout time.Duration
 }
 
-func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
-	if len(cfg.Name) == 0 {
-		log.Fatal("You must set instance name")
-	}
+// Config must be checked with Validate before. Shares and blocks are written to store,
+// which is redis backend itself unless another accounting storage is configured.
+func NewProxy(cfg *Config, backend *storage.RedisClient, store storage.Storage) *ProxyServer {
 	policy := policy.Start(&cfg.Proxy.Policy, backend)
+	checkMessages(cfg.Proxy.Messages)
 
-	proxy := &ProxyServer{config: cfg, backend: backend, policy: policy}
+	proxy := &ProxyServer{config: cfg, backend: backend, storage: store, policy: policy}
 	proxy.diff = util.GetTargetHex(cfg.Proxy.Difficulty)
+	proxy.diffRatio = cfg.Proxy.NetworkDiffRatio
+	if cfg.Testnet && proxy.diffRatio == 0 {
+		proxy.diffRatio = testnetDiffRatio
+	}
+	if proxy.diffRatio > 0 {
+		log.Printf("Share difficulty is capped at %v of network difficulty", proxy.diffRatio)
+	}
+	proxy.pow, _ = newEpochCaches(cfg)
+	proxy.startVerifyPool()
+	if cfg.Proxy.ClickHouse.Enabled {
+		proxy.shareExport = clickhouse.NewExporter(&cfg.Proxy.ClickHouse)
+		proxy.shareExport.Start()
+	}
+
+	proxy.workerNames = newWorkerNames(&cfg.Proxy.Worker)
+	if cfg.Proxy.BehindReverseProxy {
+		proxy.trustedProxies = parseTrustedProxies(cfg.Proxy.TrustedProxies)
+	}
+	proxy.initTenants()
+	if cfg.Proxy.LongPoll.Enabled {
+		proxy.initLongPoll()
+	}
 
 	proxy.upstreams = make([]*rpc.RPCClient, len(cfg.Upstream))
+	proxy.upstreamPriorities = make([]int, len(cfg.Upstream))
+	proxy.upstreamHealth = make([]upstreamHealth, len(cfg.Upstream))
 	for i, v := range cfg.Upstream {
-		proxy.upstreams[i] = rpc.NewRPCClient(v.Name, v.Url, v.Timeout)
+		proxy.upstreams[i] = rpc.NewAuthRPCClient(v.Name, v.Url, v.Timeout, v.Auth)
+		proxy.upstreams[i].Flavor = v.Flavor
+		proxy.upstreamPriorities[i] = v.Priority
 		log.Printf("Upstream: %s => %s", v.Name, v.Url)
 	}
+	proxy.upstream = int32(proxy.preferredUpstream())
 	log.Printf("Default upstream: %s => %s", proxy.rpc().Name, proxy.rpc().Url)
+	proxy.initChainCheck()
+	proxy.initForkCheck()
+
+	for _, v := range proxy.upstreams {
+		if len(cfg.Proxy.ExtraData) > 0 && !v.SupportsExtraData() {
+			log.Printf("Extra data can't be set on %v upstream over RPC, set it in %v config", v.Name, v.Flavor)
+		}
+		proxy.setExtraData(v)
+	}
+	proxy.headsLive = make([]int32, len(cfg.Upstream))
+	proxy.headsDown = make(chan struct{}, 1)
+	for i, v := range cfg.Upstream {
+		if len(v.WsUrl) > 0 {
+			go proxy.watchNewHeads(i, v)
+		}
+	}
 
 	if cfg.Proxy.Stratum.Enabled {
-		proxy.sessions = make(map[*Session]struct{})
-		go proxy.ListenTCP()
+		proxy.timeout = util.MustParseDuration(cfg.Proxy.Stratum.Timeout)
+		proxy.writeTimeout = defaultWriteTimeout
+		if len(cfg.Proxy.Stratum.WriteTimeout) > 0 {
+			proxy.writeTimeout = util.MustParseDuration(cfg.Proxy.Stratum.WriteTimeout)
+		}
+		proxy.sendQueue = cfg.Proxy.Stratum.SendQueue
+		if proxy.sendQueue <= 0 {
+			proxy.sendQueue = defaultSendQueue
+		}
+		if cfg.Proxy.Stratum.HighLatency.Enabled {
+			proxy.highLatency = util.MustParseDuration(cfg.Proxy.Stratum.HighLatency.Threshold)
+			proxy.highLatencyFlush = util.MustParseDuration(cfg.Proxy.Stratum.HighLatency.FlushDelay)
+		}
+		proxy.initConnLimiter()
+		for _, port := range proxy.stratumPorts() {
+			go proxy.ListenTCP(port)
+		}
+		if cfg.Proxy.Stratum.WebSocket.Enabled {
+			go proxy.ListenWebSocket()
+		}
+		if cfg.Proxy.Stratum.V2.Enabled {
+			go proxy.ListenSV2()
+		}
+		go proxy.sessionCleaner()
+		go proxy.tailWatcher()
+
+		if cfg.Proxy.VarDiff.Enabled {
+			go proxy.vardiffRetargeter()
+		}
+		if cfg.Proxy.Stratum.PortHint.Enabled {
+			go proxy.portHinter()
+		}
+		if cfg.Proxy.ShareSampling.Enabled {
+			go proxy.shareSampling()
+		}
+	}
+
+	if cfg.Proxy.BlockRetry.Enabled {
+		go proxy.blockResubmitter()
+	}
+
+	if cfg.Proxy.StaleWindow.Enabled {
+		proxy.staleWindow = util.MustParseDuration(cfg.Proxy.StaleWindow.Window)
+		proxy.staleWindowMax = util.MustParseDuration(cfg.Proxy.StaleWindow.Max)
+	}
+
+	if len(cfg.Proxy.DDoS.CheckInterval) > 0 {
+		proxy.checkDDoSMode()
+		go proxy.ddosWatcher()
 	}
 
 	proxy.fetchBlockTemplate()
 
 	proxy.hashrateExpiration = util.MustParseDuration(cfg.Proxy.HashrateExpiration)
 
+	if len(cfg.Proxy.IdempotencyWindow) > 0 {
+		proxy.idempotencyWindow = util.MustParseDuration(cfg.Proxy.IdempotencyWindow)
+	}
+
 	refreshIntv := util.MustParseDuration(cfg.Proxy.BlockRefreshInterval)
 	refreshTimer := time.NewTimer(refreshIntv)
 	log.Printf("Set block refresh every %v", refreshIntv)
+	wsRefreshIntv := refreshIntv
+	if len(cfg.Proxy.WsRefreshInterval) > 0 {
+		wsRefreshIntv = util.MustParseDuration(cfg.Proxy.WsRefreshInterval)
+		log.Printf("Set block refresh every %v while new heads subscription is live", wsRefreshIntv)
+	}
 
 	checkIntv := util.MustParseDuration(cfg.UpstreamCheckInterval)
 	checkTimer := time.NewTimer(checkIntv)
@@ -87,6 +313,15 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 		for {
 			select {
 			case <-refreshTimer.C:
+				proxy.fetchBlockTemplate()
+				// Fall back to regular polling once subscription drops
+				if atomic.LoadInt32(&proxy.headsLive[atomic.LoadInt32(&proxy.upstream)]) == 1 {
+					refreshTimer.Reset(wsRefreshIntv)
+				} else {
+					refreshTimer.Reset(refreshIntv)
+				}
+			case <-proxy.headsDown:
+				// Don't wait out the long interval set while subscription was live
 				proxy.fetchBlockTemplate()
 				refreshTimer.Reset(refreshIntv)
 			}
@@ -110,12 +345,20 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 				t := proxy.currentBlockTemplate()
 				if t != nil {
 					err := backend.WriteNodeState(cfg.Name, t.Height, t.Difficulty)
+					if err == nil {
+						err = backend.WriteNodeMetrics(cfg.Name, proxy.nodeMetrics())
+					}
 					if err != nil {
 						log.Printf("Failed to write node state to backend: %v", err)
 						proxy.markSick()
 					} else {
 						proxy.markOk()
 					}
+					if proxy.isSick() {
+						statuspage.Report(statuspage.Proxy, statuspage.Outage)
+					} else {
+						statuspage.Report(statuspage.Proxy, statuspage.Operational)
+					}
 				}
 				stateUpdateTimer.Reset(stateUpdateIntv)
 			}
@@ -125,41 +368,126 @@ func NewProxy(cfg *Config, backend *storage.RedisClient) *ProxyServer {
 	return proxy
 }
 
+// Ethash cache and share verification status reported with node state
+func (s *ProxyServer) nodeMetrics() map[string]string {
+	epoch, ready, nextReady := s.pow.status()
+	depth, capacity, rejected := s.verifier.stats()
+	if depth*10 >= capacity*9 {
+		log.Printf("Share verification queue is %v/%v full, %v shares rejected since start", depth, capacity, rejected)
+	}
+	return map[string]string{
+		"epoch":          strconv.FormatUint(epoch, 10),
+		"cacheReady":     strconv.FormatBool(ready),
+		"nextCacheReady": strconv.FormatBool(nextReady),
+		"verifyQueue":    strconv.Itoa(depth),
+		"verifyCapacity": strconv.Itoa(capacity),
+		"verifyRejected": strconv.FormatInt(rejected, 10),
+		"headToJob":      strconv.FormatInt(atomic.LoadInt64(&s.headToJob), 10),
+		"forkOutdated":   strconv.Itoa(s.outdatedUpstreams()),
+	}
+}
+
 func (s *ProxyServer) Start() {
 	log.Printf("Starting proxy on %v", s.config.Proxy.Listen)
 	r := mux.NewRouter()
-	r.Handle("/{login:0x[0-9a-fA-F]{40}}/{id:[0-9a-zA-Z-_]{1,8}}", s)
+	if s.config.Proxy.LongPoll.Enabled {
+		r.HandleFunc("/longpoll/{login:0x[0-9a-fA-F]{40}}", s.ServeLongPoll).Methods("GET", "POST")
+		r.HandleFunc("/longpoll/{login:0x[0-9a-fA-F]{40}}/{id}", s.ServeLongPoll).Methods("GET", "POST")
+	}
+	r.Handle("/{login:0x[0-9a-fA-F]{40}}/{id}", s)
 	r.Handle("/{login:0x[0-9a-fA-F]{40}}", s)
+	r.Handle("/{tenant}/{login:0x[0-9a-fA-F]{40}}/{id}", s)
+	r.Handle("/{tenant}/{login:0x[0-9a-fA-F]{40}}", s)
 	srv := &http.Server{
 		Addr:           s.config.Proxy.Listen,
 		Handler:        r,
-		MaxHeaderBytes: s.config.Proxy.LimitHeadersSize,
+		MaxHeaderBytes: int(s.config.Proxy.LimitHeadersSize),
 	}
-	err := srv.ListenAndServe()
+	network := s.config.Proxy.ListenNetwork
+	if len(network) == 0 {
+		network = "tcp"
+	}
+	ln, err := net.Listen(network, s.config.Proxy.Listen)
 	if err != nil {
 		log.Fatalf("Failed to start proxy: %v", err)
 	}
+	s.trackServer(srv)
+	err = srv.Serve(ln)
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Failed to start proxy: %v", err)
+	}
 }
 
+// Every node call of the proxy builds the job, which must come from a single node, or goes
+// to all upstreams, so there are no read-only calls to spread over upstreams round-robin
 func (s *ProxyServer) rpc() *rpc.RPCClient {
 	i := atomic.LoadInt32(&s.upstream)
 	return s.upstreams[i]
 }
 
 func (s *ProxyServer) checkUpstreams() {
-	candidate := int32(0)
-	backup := false
-
+	alive := false
+	results := make([]bool, len(s.upstreams))
+	var height uint64
+	if t := s.currentBlockTemplate(); t != nil {
+		height = t.Height
+	}
 	for i, v := range s.upstreams {
-		if v.Check() && !backup {
-			candidate = int32(i)
-			backup = true
+		sick := v.Sick()
+		ok := v.Check() && s.checkChainId(i) && s.checkFork(i, height)
+		s.upstreamHealth[i].record(ok)
+		results[i] = ok
+		alive = alive || ok
+		// Restarted node forgets extra data
+		if sick && !v.Sick() {
+			s.setExtraData(v)
 		}
 	}
 
-	if s.upstream != candidate {
+	failAfter, failBackAfter := s.config.UpstreamFailAfter, s.config.UpstreamFailBackAfter
+	if failAfter <= 0 {
+		failAfter = 1
+	}
+	if failBackAfter <= 0 {
+		failBackAfter = 1
+	}
+	current := int(atomic.LoadInt32(&s.upstream))
+	candidate := selectUpstream(current, s.upstreamPriorities, s.upstreamHealth, failAfter, failBackAfter)
+	if candidate != current {
 		log.Printf("Switching to %v upstream", s.upstreams[candidate].Name)
-		atomic.StoreInt32(&s.upstream, candidate)
+		atomic.StoreInt32(&s.upstream, int32(candidate))
+	}
+
+	s.writeUpstreamStats(results)
+
+	if !alive {
+		statuspage.Report(statuspage.Upstream, statuspage.Outage)
+	} else if candidate != s.preferredUpstream() {
+		statuspage.Report(statuspage.Upstream, statuspage.Degraded)
+	} else {
+		statuspage.Report(statuspage.Upstream, statuspage.Operational)
+	}
+}
+
+func (s *ProxyServer) preferredUpstream() int {
+	best := 0
+	for i := range s.upstreamPriorities {
+		if preferredUpstream(s.upstreamPriorities, i, best) {
+			best = i
+		}
+	}
+	return best
+}
+
+func (s *ProxyServer) setExtraData(upstream *rpc.RPCClient) {
+	if len(s.config.Proxy.ExtraData) == 0 {
+		return
+	}
+	if !upstream.SupportsExtraData() {
+		return
+	}
+	if err := upstream.SetExtraData(s.config.Proxy.ExtraData); err != nil {
+		log.Printf("Failed to set extra data on %v upstream: %v", upstream.Name, err)
 	}
 }
 
@@ -168,40 +496,64 @@ func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.writeError(w, 405, "rpc: POST method required, received "+r.Method)
 		return
 	}
+	if s.underAttack() {
+		s.writeError(w, http.StatusServiceUnavailable, "rpc: HTTP getwork is disabled, use stratum")
+		return
+	}
+	backend, ok := s.tenantBackend(mux.Vars(r)["tenant"])
+	if !ok {
+		s.writeError(w, http.StatusNotFound, "rpc: unknown pool")
+		return
+	}
 	ip := s.remoteAddr(r)
 	if !s.policy.IsBanned(ip) {
-		s.handleClient(w, r, ip)
+		s.handleClient(w, r, ip, backend)
 	}
 }
 
-func (s *ProxyServer) remoteAddr(r *http.Request) string {
-	if s.config.Proxy.BehindReverseProxy {
-		ip := r.Header.Get("X-Forwarded-For")
-		if len(ip) > 0 && net.ParseIP(ip) != nil {
-			return ip
-		}
+func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip string, backend *storage.RedisClient) {
+	limit := int64(s.config.Proxy.LimitBodySize)
+	if r.ContentLength > limit {
+		s.writeTooLarge(w, ip)
+		return
 	}
-	ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-	return ip
-}
-
-func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip string) {
-	if r.ContentLength > s.config.Proxy.LimitBodySize {
-		log.Printf("Socket flood from %s", ip)
-		s.policy.ApplyMalformedPolicy(ip)
-		http.Error(w, "Request too large", http.StatusExpectationFailed)
+	if s.config.Proxy.StrictRPC && !isJSONContentType(r.Header.Get("Content-Type")) {
+		s.writeError(w, http.StatusUnsupportedMediaType, "rpc: application/json content type required")
 		return
 	}
-	r.Body = http.MaxBytesReader(w, r.Body, s.config.Proxy.LimitBodySize)
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
 	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json")
 
-	cs := &Session{ip: ip, enc: json.NewEncoder(w)}
+	diff, target := s.httpDifficulty()
+	cs := &Session{ip: ip, enc: json.NewEncoder(w), backend: backend, diff: diff, target: target}
 	dec := json.NewDecoder(r.Body)
 	for {
-		var req JSONRpcReq
-		if err := dec.Decode(&req); err == io.EOF {
+		var data json.RawMessage
+		if err := dec.Decode(&data); err == io.EOF {
 			break
 		} else if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				// Body without Content-Length exceeded limit while streaming
+				s.writeTooLarge(w, ip)
+				return
+			}
+			log.Printf("Malformed request from %v: %v", ip, err)
+			s.policy.ApplyMalformedPolicy(ip)
+			if s.config.Proxy.StrictRPC {
+				cs.sendError(nil, &ErrorReply{Code: -32700, Message: "Parse error"})
+			}
+			return
+		}
+		if data[0] == '[' {
+			if !cs.handleBatch(s, r, data) {
+				return
+			}
+			continue
+		}
+		var req JSONRpcReq
+		if err := json.Unmarshal(data, &req); err != nil {
 			log.Printf("Malformed request from %v: %v", ip, err)
 			s.policy.ApplyMalformedPolicy(ip)
 			return
@@ -210,23 +562,68 @@ func (s *ProxyServer) handleClient(w http.ResponseWriter, r *http.Request, ip st
 	}
 }
 
+// Handles JSON-RPC batch, replies are written as a single array
+func (cs *Session) handleBatch(s *ProxyServer, r *http.Request, data json.RawMessage) bool {
+	var reqs []JSONRpcReq
+	if err := json.Unmarshal(data, &reqs); err != nil {
+		log.Printf("Malformed batch request from %v: %v", cs.ip, err)
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		if s.config.Proxy.StrictRPC {
+			cs.sendError(nil, &ErrorReply{Code: -32600, Message: "Invalid Request"})
+		}
+		return false
+	}
+	// Empty batch is answered with a single error in any mode, as the spec requires
+	if len(reqs) == 0 {
+		s.policy.ApplyMalformedPolicy(cs.ip)
+		cs.sendError(nil, &ErrorReply{Code: -32600, Message: "Invalid Request"})
+		return false
+	}
+
+	cs.batch = make([]*JSONRpcResp, 0, len(reqs))
+	for i := range reqs {
+		cs.handleMessage(s, r, &reqs[i])
+	}
+	replies := cs.batch
+	cs.batch = nil
+
+	// Batch of notifications only gets no reply
+	if len(replies) > 0 {
+		cs.enc.Encode(replies)
+	}
+	return true
+}
+
 // Corrected handleMessage implementation
 func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcReq) {
 	if req.Id == nil {
 		s.policy.ApplyMalformedPolicy(cs.ip)
 		return
 	}
+	if s.config.Proxy.StrictRPC {
+		if errReply := validateRequest(req); errReply != nil {
+			s.policy.ApplyMalformedPolicy(cs.ip)
+			// Id is echoed back unless it's the invalid part
+			id := req.Id
+			if !validRequestId(id) {
+				id = nil
+			}
+			cs.sendError(id, errReply)
+			return
+		}
+	}
 
 	vars := mux.Vars(r)
-	login := strings.ToLower(vars["login"])
-
-	if !util.IsValidHexAddress(login) {
-		cs.sendError(req.Id, &ErrorReply{Code: -1, Message: "Invalid login"})
+	login, ok := util.CanonicalAddress(vars["login"])
+	if !ok {
+		cs.login = vars["login"]
+		cs.sendError(req.Id, s.errorReply(cs, -1, msgInvalidLogin))
 		return
 	}
+	cs.login = login
 
 	if !s.policy.ApplyLoginPolicy(login, cs.ip) {
-		cs.sendError(req.Id, &ErrorReply{Code: -1, Message: "You are blacklisted"})
+		cs.sendError(req.Id, s.errorReply(cs, -1, msgBlacklisted))
 		return
 	}
 
@@ -242,18 +639,19 @@ func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcRe
 	case "eth_submitWork":
 		if req.Params == nil {
 			s.policy.ApplyMalformedPolicy(cs.ip)
-			cs.sendError(req.Id, &ErrorReply{Code: -1, Message: "Malformed request"})
+			cs.sendError(req.Id, s.errorReply(cs, -1, msgMalformedRequest))
 			return
 		}
 
 		var params []string
 		if err := json.Unmarshal(req.Params, &params); err != nil {
 			s.policy.ApplyMalformedPolicy(cs.ip)
-			cs.sendError(req.Id, &ErrorReply{Code: -1, Message: "Invalid params"})
+			cs.sendError(req.Id, s.errorReply(cs, -1, msgInvalidParams))
 			return
 		}
 
-		reply, errReply := s.handleTCPSubmitRPC(cs, vars["id"], params)
+		key := r.Header.Get(idempotencyHeader)
+		reply, errReply := s.handleIdempotentSubmitRPC(cs, key, vars["id"], params)
 		if errReply != nil {
 			cs.sendError(req.Id, errReply)
 		} else {
@@ -264,7 +662,17 @@ func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcRe
 		cs.sendResult(req.Id, s.handleGetBlockByNumberRPC())
 
 	case "eth_submitHashrate":
-		cs.sendResult(req.Id, true)
+		var params []string
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			cs.sendError(req.Id, s.errorReply(cs, -1, msgInvalidParams))
+			return
+		}
+		reply, errReply := s.handleSubmitHashrateRPC(cs, vars["id"], params)
+		if errReply != nil {
+			cs.sendError(req.Id, errReply)
+		} else {
+			cs.sendResult(req.Id, reply)
+		}
 
 	default:
 		cs.sendError(req.Id, s.handleUnknownRPC(cs, req.Method))
@@ -273,17 +681,65 @@ func (cs *Session) handleMessage(s *ProxyServer, r *http.Request, req *JSONRpcRe
 
 func (cs *Session) sendResult(id json.RawMessage, result interface{}) error {
 	message := JSONRpcResp{Id: id, Version: "2.0", Error: nil, Result: result}
-	return cs.enc.Encode(&message)
+	return cs.sendHTTPReply(&message)
 }
 
 func (cs *Session) sendError(id json.RawMessage, reply *ErrorReply) error {
 	message := JSONRpcResp{Id: id, Version: "2.0", Error: reply}
-	return cs.enc.Encode(&message)
+	return cs.sendHTTPReply(&message)
+}
+
+func (cs *Session) sendHTTPReply(message *JSONRpcResp) error {
+	if cs.batch != nil {
+		cs.batch = append(cs.batch, message)
+		return nil
+	}
+	return cs.enc.Encode(message)
 }
 
 func (s *ProxyServer) writeError(w http.ResponseWriter, status int, msg string) {
-	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, msg)
+}
+
+// Body above limit, answered with JSON-RPC error so miner can tell it from network failure
+func (s *ProxyServer) writeTooLarge(w http.ResponseWriter, ip string) {
+	log.Printf("Socket flood from %s", ip)
+	s.policy.ApplyMalformedPolicy(ip)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	json.NewEncoder(w).Encode(&JSONRpcResp{Version: "2.0", Error: &ErrorReply{Code: -32600, Message: "Request too large"}})
+}
+
+func isJSONContentType(value string) bool {
+	mediaType, _, err := mime.ParseMediaType(value)
+	return err == nil && mediaType == "application/json"
+}
+
+// Checks request against JSON-RPC 2.0 spec, used in strict mode
+func validateRequest(req *JSONRpcReq) *ErrorReply {
+	if req.Version != "2.0" {
+		return &ErrorReply{Code: -32600, Message: "Invalid Request: jsonrpc must be 2.0"}
+	}
+	if !validRequestId(req.Id) {
+		return &ErrorReply{Code: -32600, Message: "Invalid Request: id must be string or number"}
+	}
+	if len(req.Method) == 0 {
+		return &ErrorReply{Code: -32600, Message: "Invalid Request: method is missing"}
+	}
+	return nil
+}
+
+func validRequestId(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return false
+	}
+	switch id[0] {
+	case '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+	return false
 }
 
 func (s *ProxyServer) currentBlockTemplate() *BlockTemplate {
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/etclabscore/open-etc-pool/policy"
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+func newTestPolicy() *policy.PolicyServer {
+	cfg := &policy.Config{Limits: policy.Limits{Grace: "1m"}, ResetInterval: "1h", RefreshInterval: "1h"}
+	return policy.Start(cfg, storage.NewRedisClient(&storage.Config{Endpoint: "127.0.0.1:6379"}, "test"))
+}
+
+func TestRequestTooLarge(t *testing.T) {
+	s := &ProxyServer{config: &Config{}, policy: newTestPolicy()}
+	s.config.Proxy.LimitBodySize = 64
+	body := `{"jsonrpc":"2.0","id":1,"method":"eth_getWork","params":["` + strings.Repeat("0", 128) + `"]}`
+
+	for name, reader := range map[string]io.Reader{
+		"sized":    strings.NewReader(body),
+		"streamed": io.NopCloser(strings.NewReader(body)),
+	} {
+		w := httptest.NewRecorder()
+		s.handleClient(w, httptest.NewRequest("POST", "/", reader), "10.0.0.1", nil)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Must reject %v body above limit with 413, got %v", name, w.Code)
+		}
+		var reply struct {
+			Error *ErrorReply `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || reply.Error == nil || reply.Error.Code != -32600 {
+			t.Errorf("Must answer %v body above limit with JSON-RPC error, got %q", name, w.Body.String())
+		}
+	}
+}
+
+func postBatch(s *ProxyServer, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest("POST", "/", strings.NewReader(body)), map[string]string{"login": "0xb85150eb365e7df0941f0cf08235f987ba91506a"})
+	s.handleClient(w, r, "10.0.0.1", nil)
+	return w
+}
+
+func TestHandleBatch(t *testing.T) {
+	s := &ProxyServer{config: &Config{}, policy: newTestPolicy()}
+	s.config.Proxy.LimitBodySize = 1024
+
+	w := postBatch(s, `[]`)
+	var reply JSONRpcResp
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || reply.Error == nil || !strings.Contains(w.Body.String(), `"code":-32600`) {
+		t.Errorf("Must answer empty batch with single invalid request error, got %q", w.Body.String())
+	}
+
+	w = postBatch(s, `[{"jsonrpc":"2.0","id":1,"method":"eth_getWork","params":[]},{"jsonrpc":"2.0","method":"eth_getWork","params":[]},{"jsonrpc":"2.0","id":2,"method":"eth_foo","params":[]}]`)
+	var replies []JSONRpcResp
+	if err := json.Unmarshal(w.Body.Bytes(), &replies); err != nil || len(replies) != 2 || string(replies[0].Id) != "1" || string(replies[1].Id) != "2" {
+		t.Errorf("Must answer every request of mixed batch except notification, got %q", w.Body.String())
+	}
+
+	w = postBatch(s, `[{"jsonrpc":"2.0","method":"eth_getWork","params":[]}]`)
+	if w.Body.Len() != 0 {
+		t.Errorf("Must not answer batch of notifications, got %q", w.Body.String())
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	req := &JSONRpcReq{Id: json.RawMessage(`7`), Version: "1.0", Method: "eth_getWork"}
+	if validateRequest(req) == nil {
+		t.Error("Must reject jsonrpc other than 2.0")
+	}
+	if !validRequestId(req.Id) {
+		t.Error("Must echo numeric id on invalid request")
+	}
+	for _, id := range []string{`"a"`, `-1`, `0`} {
+		if !validRequestId(json.RawMessage(id)) {
+			t.Errorf("Must accept id %s", id)
+		}
+	}
+	for _, id := range []string{`null`, `{}`, `[1]`, `true`} {
+		if validRequestId(json.RawMessage(id)) {
+			t.Errorf("Must reply with null id to %s", id)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// HAProxy PROXY protocol, see https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+	proxyV1MaxLength   = 107
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Reads PROXY protocol v1 or v2 header and returns original client IP.
+// Returns nil IP for LOCAL and UNKNOWN connections, such as load balancer health checks.
+func readProxyHeader(r *bufio.Reader) (net.IP, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyHeaderV2(r)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyHeaderV1(r)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+func readProxyHeaderV1(r *bufio.Reader) (net.IP, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > proxyV1MaxLength {
+		return nil, errors.New("PROXY protocol v1 header is too long")
+	}
+	fields := strings.Fields(strings.TrimRight(string(line), "\r\n"))
+	if len(fields) < 2 {
+		return nil, errors.New("malformed PROXY protocol v1 header")
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+		if len(fields) != 6 {
+			return nil, errors.New("malformed PROXY protocol v1 header")
+		}
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return nil, errors.New("invalid source address in PROXY protocol v1 header")
+		}
+		return ip, nil
+	default:
+		return nil, errors.New("unsupported PROXY protocol v1 transport " + fields[1])
+	}
+}
+
+func readProxyHeaderV2(r *bufio.Reader) (net.IP, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, errors.New("unsupported PROXY protocol version")
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0x0f {
+	case 0x0:
+		// LOCAL command, connection established by proxy itself
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, errors.New("unsupported PROXY protocol v2 command")
+	}
+
+	switch family >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, errors.New("short PROXY protocol v2 IPv4 address block")
+		}
+		return net.IPv4(payload[0], payload[1], payload[2], payload[3]), nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, errors.New("short PROXY protocol v2 IPv6 address block")
+		}
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, payload[:16])
+		return ip, nil
+	default:
+		// Unix sockets and unspecified families carry no usable client IP
+		return nil, nil
+	}
+}
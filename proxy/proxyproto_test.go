@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 192.168.0.11 56324 8008\r\n{\"id\":1}\n"))
+	ip, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatalf("Must parse v1 header: %v", err)
+	}
+	if ip.String() != "192.168.0.1" {
+		t.Errorf("Must return source address, got %v", ip)
+	}
+	rest, _ := r.ReadString('\n')
+	if rest != "{\"id\":1}\n" {
+		t.Errorf("Must leave payload in reader, got %q", rest)
+	}
+
+	ip, err = readProxyHeader(bufio.NewReader(strings.NewReader("PROXY UNKNOWN\r\n")))
+	if err != nil || ip != nil {
+		t.Errorf("Must accept UNKNOWN connection without address, got %v %v", ip, err)
+	}
+	ip, err = readProxyHeader(bufio.NewReader(strings.NewReader("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n")))
+	if err != nil || ip.String() != "2001:db8::1" {
+		t.Errorf("Must parse v1 TCP6 header, got %v %v", ip, err)
+	}
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(proxyV2Signature)
+	buf.Write([]byte{0x21, 0x11})
+	binary.Write(&buf, binary.BigEndian, uint16(12))
+	buf.Write([]byte{10, 0, 0, 1, 10, 0, 0, 2, 0x1f, 0x90, 0x1f, 0x48})
+	buf.WriteString("{}\n")
+
+	r := bufio.NewReader(&buf)
+	ip, err := readProxyHeader(r)
+	if err != nil {
+		t.Fatalf("Must parse v2 header: %v", err)
+	}
+	if ip.String() != "10.0.0.1" {
+		t.Errorf("Must return source address, got %v", ip)
+	}
+	rest, _ := r.ReadString('\n')
+	if rest != "{}\n" {
+		t.Errorf("Must leave payload in reader, got %q", rest)
+	}
+}
+
+func TestReadProxyHeaderMissing(t *testing.T) {
+	_, err := readProxyHeader(bufio.NewReader(strings.NewReader("{\"id\":1,\"method\":\"eth_submitLogin\"}\n")))
+	if err == nil {
+		t.Error("Must reject connection without header")
+	}
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Protocol adjustments for miner software, so one firmware doesn't dictate behavior for all.
+// Software is named by third eth_submitLogin param or "agent" field of login request.
+type Quirk struct {
+	// Case-insensitive substring of software name, first matching quirk is applied
+	Match string `json:"match"`
+	// Request ids are echoed as strings, e.g. 5 as "5"
+	StringIds bool `json:"stringIds"`
+	// Id of job notifications instead of 0, e.g. "0x0"
+	PushId string `json:"pushId"`
+	// Rejected requests are answered with "result": false instead of error object
+	BoolErrors bool `json:"boolErrors"`
+	// Skip client.show_message notifications, for firmwares dropping connection on unknown methods
+	NoMessages bool `json:"noMessages"`
+	// Overrides of miner-facing messages, e.g. greeting in language of firmware users
+	Messages map[string]string `json:"messages"`
+}
+
+func validateQuirks(quirks []Quirk, errs *util.ConfigErrors) {
+	for i, q := range quirks {
+		if len(strings.TrimSpace(q.Match)) == 0 {
+			errs.Addf("proxy.stratum.quirks[%d].match: required", i)
+		}
+		for key := range q.Messages {
+			if _, ok := defaultMessages[key]; !ok {
+				errs.Addf("proxy.stratum.quirks[%d].messages: unknown message key %q", i, key)
+			}
+		}
+	}
+}
+
+func (s *ProxyServer) matchQuirk(agent string) *Quirk {
+	agent = strings.ToLower(agent)
+	for i := range s.config.Proxy.Stratum.Quirks {
+		q := &s.config.Proxy.Stratum.Quirks[i]
+		if strings.Contains(agent, strings.ToLower(q.Match)) {
+			return q
+		}
+	}
+	return nil
+}
+
+// Detected on login, sessions of unknown software keep default behavior
+func (s *ProxyServer) applyQuirk(cs *Session, agent string) {
+	if len(agent) == 0 {
+		return
+	}
+	if q := s.matchQuirk(agent); q != nil {
+		log.Printf("Applying %q quirks to %v running %v", q.Match, cs.ip, agent)
+		cs.quirk.Store(q)
+	}
+}
+
+// Nil unless session software has quirks
+func (cs *Session) quirks() *Quirk {
+	q, _ := cs.quirk.Load().(*Quirk)
+	return q
+}
+
+func (q *Quirk) replyId(id json.RawMessage) json.RawMessage {
+	if q == nil || !q.StringIds || len(id) == 0 || id[0] == '"' || string(id) == "null" {
+		return id
+	}
+	data, _ := json.Marshal(string(id))
+	return data
+}
+
+func (q *Quirk) pushId() interface{} {
+	if q == nil || len(q.PushId) == 0 {
+		return 0
+	}
+	return q.PushId
+}
+
+func (q *Quirk) message(key string) (string, bool) {
+	if q == nil {
+		return "", false
+	}
+	msg, ok := q.Messages[key]
+	return msg, ok && len(msg) > 0
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestQuirks(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.config.Proxy.Messages = map[string]string{msgMotd: "Welcome"}
+	s.config.Proxy.Stratum.Quirks = []Quirk{
+		{Match: "lolminer", StringIds: true, PushId: "0x0", Messages: map[string]string{msgMotd: "Bienvenue"}},
+		{Match: "OldFirmware", BoolErrors: true, NoMessages: true},
+	}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, out: make(chan outMessage, 8)}
+
+	s.applyQuirk(cs, "unknown/1.0")
+	if cs.quirks() != nil {
+		t.Fatal("Must keep default behavior for unknown software")
+	}
+	cs.sendTCPResult(json.RawMessage("5"), true)
+	cs.pushNewJob([]string{"0x1"})
+	if msg := string((<-cs.out).data); !strings.Contains(msg, `"id":5`) {
+		t.Errorf("Must echo numeric id by default, got %s", msg)
+	}
+	if msg := string((<-cs.out).data); !strings.Contains(msg, `"id":0`) {
+		t.Errorf("Must push jobs with zero id by default, got %s", msg)
+	}
+
+	s.applyQuirk(cs, "lolMiner 1.76")
+	cs.sendTCPResult(json.RawMessage("5"), true)
+	cs.pushNewJob([]string{"0x1"})
+	if msg := string((<-cs.out).data); !strings.Contains(msg, `"id":"5"`) {
+		t.Errorf("Must echo id as string, got %s", msg)
+	}
+	if msg := string((<-cs.out).data); !strings.Contains(msg, `"id":"0x0"`) {
+		t.Errorf("Must push jobs with quirk id, got %s", msg)
+	}
+	if msg := s.message(cs, msgMotd); msg != "Bienvenue" {
+		t.Errorf("Must prefer quirk message, got %v", msg)
+	}
+	if msg := s.message(cs, msgInvalidShare); msg != "Invalid share" {
+		t.Errorf("Must fall back to default message, got %v", msg)
+	}
+
+	cs = &Session{conn: conn, out: make(chan outMessage, 8)}
+	s.applyQuirk(cs, "oldfirmware-v2")
+	if err := cs.sendTCPError(json.RawMessage("7"), s.errorReply(cs, 23, msgInvalidShare)); err == nil {
+		t.Error("Must still report error to caller")
+	}
+	if msg := string((<-cs.out).data); strings.Contains(msg, `"error"`) || !strings.Contains(msg, `"result":false`) {
+		t.Errorf("Must answer error with false result, got %s", msg)
+	}
+	cs.showMessage(s.message(cs, msgMotd))
+	if len(cs.out) != 0 {
+		t.Error("Must not send show_message to software without support")
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"log"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Zero if start is unknown
+func msSince(from, to time.Time) float64 {
+	if from.IsZero() {
+		return 0
+	}
+	return millis(to.Sub(from))
+}
+
+// Completes report of rejected block with heads of upstreams, so operators can tell
+// propagation losses from invalid solutions
+func (s *ProxyServer) reportBlockRace(race *storage.BlockRace, answers []submitResult) {
+	for _, a := range answers {
+		u := &storage.BlockRaceUpstream{Name: a.upstream.Name, Accepted: a.ok, Latency: millis(a.latency)}
+		if a.err != nil {
+			u.Error = a.err.Error()
+		}
+		if head, err := a.upstream.BlockNumber(); err == nil {
+			u.Head = head
+			race.Stale = race.Stale || head >= race.Height
+		}
+		race.Upstreams = append(race.Upstreams, u)
+	}
+	log.Printf("Block race at height %v by %v: template age %.0fms, verify delay %.0fms, stale %v",
+		race.Height, race.Login, race.TemplateAge, race.VerifyDelay, race.Stale)
+	if err := s.backend.WriteBlockRace(race); err != nil {
+		log.Printf("Failed to write block race report to backend: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+const sessionShards = 64
+
+type sessionShard struct {
+	sync.RWMutex
+	sessions map[*Session]struct{}
+}
+
+// Stratum sessions spread over shards by connection hash, so registrations and
+// broadcasts on large pools don't contend on a single lock. Zero value is ready to use.
+type sessionRegistry struct {
+	shards [sessionShards]sessionShard
+	count  int64
+}
+
+func (r *sessionRegistry) shard(cs *Session) *sessionShard {
+	if cs.conn == nil {
+		return &r.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(cs.conn.RemoteAddr().String()))
+	return &r.shards[h.Sum32()%sessionShards]
+}
+
+func (r *sessionRegistry) add(cs *Session) {
+	sh := r.shard(cs)
+	sh.Lock()
+	defer sh.Unlock()
+	if sh.sessions == nil {
+		sh.sessions = make(map[*Session]struct{})
+	}
+	if _, ok := sh.sessions[cs]; !ok {
+		sh.sessions[cs] = struct{}{}
+		atomic.AddInt64(&r.count, 1)
+	}
+}
+
+func (r *sessionRegistry) remove(cs *Session) {
+	sh := r.shard(cs)
+	sh.Lock()
+	defer sh.Unlock()
+	if _, ok := sh.sessions[cs]; ok {
+		delete(sh.sessions, cs)
+		atomic.AddInt64(&r.count, -1)
+	}
+}
+
+func (r *sessionRegistry) contains(cs *Session) bool {
+	sh := r.shard(cs)
+	sh.RLock()
+	defer sh.RUnlock()
+	_, ok := sh.sessions[cs]
+	return ok
+}
+
+func (r *sessionRegistry) len() int {
+	return int(atomic.LoadInt64(&r.count))
+}
+
+// Sessions of every shard, copied so callers don't hold any lock
+func (r *sessionRegistry) snapshot() [][]*Session {
+	result := make([][]*Session, 0, sessionShards)
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.RLock()
+		if len(sh.sessions) > 0 {
+			sessions := make([]*Session, 0, len(sh.sessions))
+			for cs := range sh.sessions {
+				sessions = append(sessions, cs)
+			}
+			result = append(result, sessions)
+		}
+		sh.RUnlock()
+	}
+	return result
+}
+
+// All sessions in a single list
+func (r *sessionRegistry) all() []*Session {
+	var result []*Session
+	for _, sessions := range r.snapshot() {
+		result = append(result, sessions...)
+	}
+	return result
+}
+
+// Removes and returns sessions matching fn, fn is called with shard lock held
+func (r *sessionRegistry) removeIf(fn func(cs *Session) bool) []*Session {
+	var removed []*Session
+	for i := range r.shards {
+		sh := &r.shards[i]
+		sh.Lock()
+		for cs := range sh.sessions {
+			if fn(cs) {
+				delete(sh.sessions, cs)
+				atomic.AddInt64(&r.count, -1)
+				removed = append(removed, cs)
+			}
+		}
+		sh.Unlock()
+	}
+	return removed
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+)
+
+type addrConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.addr }
+
+func TestSessionRegistry(t *testing.T) {
+	var r sessionRegistry
+	var wg sync.WaitGroup
+	sessions := make([]*Session, 1000)
+	for i := range sessions {
+		addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf("10.0.%d.%d:%d", i/250, i%250, 40000+i))
+		sessions[i] = &Session{conn: &addrConn{addr: addr}}
+	}
+	for _, cs := range sessions {
+		wg.Add(1)
+		go func(cs *Session) {
+			defer wg.Done()
+			r.add(cs)
+			r.add(cs)
+		}(cs)
+	}
+	wg.Wait()
+
+	if r.len() != len(sessions) || len(r.all()) != len(sessions) {
+		t.Fatalf("Must count each session once, got %v", r.len())
+	}
+	if len(r.snapshot()) < sessionShards/2 {
+		t.Errorf("Must spread sessions over shards, got %v shards", len(r.snapshot()))
+	}
+	if !r.contains(sessions[0]) {
+		t.Error("Must find registered session")
+	}
+
+	r.remove(sessions[0])
+	r.remove(sessions[0])
+	if r.contains(sessions[0]) || r.len() != len(sessions)-1 {
+		t.Errorf("Must remove session once, got %v", r.len())
+	}
+	removed := r.removeIf(func(cs *Session) bool { return cs == sessions[1] || cs == sessions[2] })
+	if len(removed) != 2 || r.len() != len(sessions)-3 {
+		t.Errorf("Must remove matching sessions, got %v and %v left", len(removed), r.len())
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+func connRTT(conn *net.TCPConn) (time.Duration, error) {
+	return 0, errors.New("RTT measurement is not supported on this platform")
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/eventbus"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultSamplingInterval = 10 * time.Minute
+	defaultSamplesKept      = 10000
+	// Periods with fewer shares say nothing about distribution
+	minSampleShares = 10
+)
+
+// Share intervals of session accumulated between flushes. Intervals are divided
+// by difficulty, so samples taken across retargets stay comparable.
+type shareSampler struct {
+	sync.Mutex
+	id       string
+	last     time.Time
+	lastDiff int64
+	n        int64
+	sum      float64
+	sumSq    float64
+}
+
+func newShareSampler() *shareSampler {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return &shareSampler{id: hex.EncodeToString(buf)}
+}
+
+func (p *shareSampler) add(now time.Time, diff int64) {
+	p.Lock()
+	defer p.Unlock()
+	if !p.last.IsZero() && diff == p.lastDiff && diff > 0 {
+		x := now.Sub(p.last).Seconds() / float64(diff)
+		p.n++
+		p.sum += x
+		p.sumSq += x * x
+	}
+	p.last, p.lastDiff = now, diff
+}
+
+// Returns sample and resets counters, nil if there are too few shares yet
+func (p *shareSampler) flush(ts int64) *storage.ShareSample {
+	p.Lock()
+	defer p.Unlock()
+	if p.n < minSampleShares || p.sum <= 0 {
+		return nil
+	}
+	mean := p.sum / float64(p.n)
+	variance := math.Max(p.sumSq/float64(p.n)-mean*mean, 0)
+	sample := &storage.ShareSample{
+		Session: p.id, Timestamp: ts, Shares: p.n, Diff: p.lastDiff,
+		Hashrate: 1 / mean, CV: math.Sqrt(variance) / mean,
+	}
+	p.n, p.sum, p.sumSq = 0, 0, 0
+	return sample
+}
+
+func (s *ProxyServer) shareSampling() {
+	cfg := s.config.Proxy.ShareSampling
+	intv := defaultSamplingInterval
+	if len(cfg.Interval) > 0 {
+		intv = util.MustParseDuration(cfg.Interval)
+	}
+	keep := int64(cfg.Keep)
+	if keep <= 0 {
+		keep = defaultSamplesKept
+	}
+	log.Printf("Sampling share intervals every %v for vardiff tuning", intv)
+
+	ticker := time.NewTicker(intv)
+	for range ticker.C {
+		ts := util.MakeTimestamp() / 1000
+		var samples []*storage.ShareSample
+		for _, cs := range s.sessions.all() {
+			if cs.sampler == nil {
+				continue
+			}
+			if sample := cs.sampler.flush(ts); sample != nil {
+				samples = append(samples, sample)
+				eventbus.Publish(eventbus.ShareSample, sample)
+			}
+		}
+		if err := s.backend.WriteShareSamples(samples, keep); err != nil {
+			log.Printf("Failed to write share samples to backend: %v", err)
+		}
+	}
+}
+
+// Vardiff settings derived from recorded share samples
+type VarDiffAdvice struct {
+	Samples         int
+	HashrateP10     float64
+	HashrateP50     float64
+	HashrateP99     float64
+	CVP90           float64
+	SharesPerMinute float64
+	// Shares in retarget window which keep rate noise below variance percent
+	WindowShares     int64
+	RetargetInterval time.Duration
+	MinDiff          int64
+	MaxDiff          int64
+}
+
+// Keeps configured target share rate and variance, sizes retarget window to noise
+// of observed intervals and difficulty range to observed hashrates
+func AdviseVarDiff(samples []*storage.ShareSample, current VarDiff) *VarDiffAdvice {
+	if len(samples) == 0 {
+		return nil
+	}
+	hashrates := make([]float64, len(samples))
+	cvs := make([]float64, len(samples))
+	for i, s := range samples {
+		hashrates[i], cvs[i] = s.Hashrate, s.CV
+	}
+	sort.Float64s(hashrates)
+	sort.Float64s(cvs)
+
+	a := &VarDiffAdvice{
+		Samples:         len(samples),
+		HashrateP10:     percentile(hashrates, 10),
+		HashrateP50:     percentile(hashrates, 50),
+		HashrateP99:     percentile(hashrates, 99),
+		CVP90:           percentile(cvs, 90),
+		SharesPerMinute: current.SharesPerMinute,
+	}
+	if a.SharesPerMinute <= 0 {
+		a.SharesPerMinute = 4
+	}
+	variance := current.VariancePercent
+	if variance <= 0 {
+		variance = 30
+	}
+	// Relative error of rate measured over n shares is cv/sqrt(n)
+	cv := math.Max(a.CVP90, 1)
+	a.WindowShares = int64(math.Ceil(math.Pow(100*cv/variance, 2)))
+	if a.WindowShares < minSampleShares {
+		a.WindowShares = minSampleShares
+	}
+	a.RetargetInterval = time.Duration(float64(a.WindowShares) / a.SharesPerMinute * float64(time.Minute)).Round(time.Second)
+
+	interval := 60 / a.SharesPerMinute
+	a.MinDiff = int64(a.HashrateP10 * interval)
+	// Headroom for farms behind single connection growing further
+	a.MaxDiff = int64(a.HashrateP99 * interval * maxRetargetFactor)
+	return a
+}
+
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
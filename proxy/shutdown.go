@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func (s *ProxyServer) trackListener(ln net.Listener) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.listeners = append(s.listeners, ln)
+}
+
+func (s *ProxyServer) trackServer(srv *http.Server) {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+	s.servers = append(s.servers, srv)
+}
+
+func (s *ProxyServer) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) > 0
+}
+
+// Stops accepting miners, pushes final job to stratum sessions and closes them
+// once they are gone or drain timeout is over. Returns when pending shares are written.
+func (s *ProxyServer) Shutdown() {
+	atomic.StoreInt32(&s.draining, 1)
+
+	var drainTimeout time.Duration
+	if len(s.config.Proxy.DrainTimeout) > 0 {
+		drainTimeout = util.MustParseDuration(s.config.Proxy.DrainTimeout)
+	}
+	deadline := time.Now().Add(drainTimeout)
+	log.Printf("Shutting down proxy, draining sessions for %v", drainTimeout)
+
+	s.listenersMu.Lock()
+	listeners, servers := s.listeners, s.servers
+	s.listenersMu.Unlock()
+
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	// Release long polling miners, otherwise server shutdown waits for them
+	s.notifyLongPoll()
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("HTTP server shutdown error: %v", err)
+		}
+	}
+	cancel()
+
+	s.broadcastNewJobs(true)
+
+	for time.Now().Before(deadline) && s.sessionsCount() > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	removed := s.sessions.removeIf(func(*Session) bool { return true })
+	total := len(removed)
+	var wg sync.WaitGroup
+	for _, cs := range removed {
+		wg.Add(1)
+		go func(cs *Session) {
+			defer wg.Done()
+			cs.stopWriter()
+			cs.Lock()
+			cs.flushLocked()
+			cs.Unlock()
+			cs.conn.Close()
+		}(cs)
+		s.uncount(cs)
+	}
+	wg.Wait()
+
+	s.submitsMu.Lock()
+	if err := s.backend.FlushShares(); err != nil {
+		log.Printf("Failed to flush buffered shares on shutdown: %v", err)
+	}
+	log.Printf("Proxy shutdown complete, closed %v stratum sessions", total)
+}
+
+func (s *ProxyServer) sessionsCount() int {
+	return s.sessions.len()
+}
@@ -0,0 +1,31 @@
+package proxy
+
+import (
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+// Sessions on solo port mine for themselves
+func (cs *Session) solo() bool {
+	return cs.port != nil && cs.port.Solo
+}
+
+func (s *ProxyServer) writeShare(backend *storage.RedisClient, solo bool, login, id string, params []string, diff, actualDiff int64, height uint64) (bool, error) {
+	if solo {
+		return s.accounting(backend).WriteSoloShare(login, id, params, diff, actualDiff, height, s.hashrateExpiration)
+	}
+	return s.accounting(backend).WriteShare(login, id, params, diff, actualDiff, height, s.hashrateExpiration)
+}
+
+func (s *ProxyServer) writeStaleShare(backend *storage.RedisClient, solo bool, login, id string, params []string, diff, actualDiff int64, height uint64) (bool, error) {
+	if solo {
+		return s.accounting(backend).WriteSoloStaleShare(login, id, params, diff, actualDiff, height, s.hashrateExpiration)
+	}
+	return s.accounting(backend).WriteStaleShare(login, id, params, diff, actualDiff, height, s.hashrateExpiration)
+}
+
+func (s *ProxyServer) writeBlock(backend *storage.RedisClient, b *storage.PendingBlock) (bool, error) {
+	if b.Solo {
+		return s.accounting(backend).WriteSoloBlock(b.Login, b.Worker, b.Params, b.Diff, b.ActualDiff, b.RoundDiff, b.Height, b.Window)
+	}
+	return s.accounting(backend).WriteBlock(b.Login, b.Worker, b.Params, b.Diff, b.ActualDiff, b.RoundDiff, b.Height, b.Window)
+}
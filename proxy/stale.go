@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Base window extended by RTT multiplied by factor, never above max
+func staleWindowFor(base, max time.Duration, factor float64, rtt time.Duration) time.Duration {
+	window := base + time.Duration(factor*float64(rtt))
+	if window > max {
+		return max
+	}
+	return window
+}
+
+// Miner learns about new job half RTT after it was sent and its share spends another
+// half on the way back, so share for previous height may be late by no fault of miner
+func (s *ProxyServer) sessionStaleWindow(cs *Session) time.Duration {
+	rtt, err := cs.measureRTT()
+	if err != nil {
+		rtt = time.Duration(atomic.LoadInt64(&cs.rtt))
+	}
+	return staleWindowFor(s.staleWindow, s.staleWindowMax, s.config.Proxy.StaleWindow.RTTFactor, rtt)
+}
+
+// Share for job of height right before current one, submitted within session window
+func (s *ProxyServer) isLateShare(cs *Session, t *BlockTemplate, height uint64, now time.Time) bool {
+	if !s.config.Proxy.StaleWindow.Enabled || height+1 != t.Height || t.heightAt.IsZero() {
+		return false
+	}
+	return now.Sub(t.heightAt) <= s.sessionStaleWindow(cs)
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStaleWindowFor(t *testing.T) {
+	if w := staleWindowFor(200*time.Millisecond, time.Second, 1.5, 200*time.Millisecond); w != 500*time.Millisecond {
+		t.Errorf("Must extend window by RTT times factor, got %v", w)
+	}
+	if w := staleWindowFor(200*time.Millisecond, time.Second, 2, time.Second); w != time.Second {
+		t.Errorf("Must cap window at max, got %v", w)
+	}
+}
+
+func TestIsLateShare(t *testing.T) {
+	s := &ProxyServer{config: &Config{}, staleWindow: 300 * time.Millisecond, staleWindowMax: time.Second}
+	s.config.Proxy.StaleWindow = StaleWindow{Enabled: true, RTTFactor: 1}
+	now := time.Now()
+	tpl := &BlockTemplate{Height: 100, heightAt: now}
+	cs := &Session{rtt: int64(500 * time.Millisecond)}
+
+	if !s.isLateShare(cs, tpl, 99, now.Add(700*time.Millisecond)) {
+		t.Error("Must accept share within window extended by session RTT")
+	}
+	if s.isLateShare(cs, tpl, 99, now.Add(900*time.Millisecond)) {
+		t.Error("Must not accept share after session window")
+	}
+	if s.isLateShare(&Session{}, tpl, 99, now.Add(400*time.Millisecond)) {
+		t.Error("Must not extend window of session without RTT")
+	}
+	if s.isLateShare(cs, tpl, 98, now) {
+		t.Error("Must only accept shares for previous height")
+	}
+	s.config.Proxy.StaleWindow.Enabled = false
+	if s.isLateShare(cs, tpl, 99, now) {
+		t.Error("Must not accept late shares if disabled")
+	}
+}
+
+func TestSessionTCPConn(t *testing.T) {
+	if (&Session{}).tcpConn() != nil {
+		t.Error("Must not probe HTTP session")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if (&Session{conn: conn}).tcpConn() == nil {
+		t.Error("Must probe stratum session")
+	}
+	if (&Session{conn: &sv2Conn{Conn: conn}}).tcpConn() == nil {
+		t.Error("Must probe stratum V2 session")
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var difficultyUnits = map[byte]float64{'K': 1e3, 'M': 1e6, 'G': 1e9, 'T': 1e12}
+
+// Parses difficulty with optional K, M, G or T unit, e.g. 4000 or 8G
+func parseDifficulty(value string) (int64, error) {
+	if len(value) == 0 {
+		return 0, errors.New("empty difficulty")
+	}
+	mult := 1.0
+	if unit, ok := difficultyUnits[strings.ToUpper(value[len(value)-1:])[0]]; ok {
+		mult = unit
+		value = value[:len(value)-1]
+	}
+	diff, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	diff *= mult
+	if diff < 1 || diff > math.MaxInt64 || math.IsNaN(diff) {
+		return 0, errors.New("difficulty out of range")
+	}
+	return int64(diff), nil
+}
+
+// Splits "/d=<diff>" suffix from login, falls back to "d=<diff>" in password,
+// which may also hold other comma, semicolon or space separated options.
+func splitStaticDiff(login, password string) (string, string) {
+	if i := strings.Index(login, "/d="); i >= 0 {
+		return login[:i], login[i+3:]
+	}
+	for _, opt := range strings.FieldsFunc(password, func(r rune) bool { return r == ',' || r == ';' || r == ' ' }) {
+		if strings.HasPrefix(opt, "d=") {
+			return login, opt[2:]
+		}
+	}
+	return login, ""
+}
+
+// Applies difficulty requested by miner clamped to configured bounds
+func (s *ProxyServer) applyStaticDiff(cs *Session, value string) error {
+	diff, err := parseDifficulty(value)
+	if err != nil {
+		return err
+	}
+	cfg := s.config.Proxy.StaticDiff
+	if diff < cfg.MinDiff {
+		diff = cfg.MinDiff
+	}
+	if cfg.MaxDiff > 0 && diff > cfg.MaxDiff {
+		diff = cfg.MaxDiff
+	}
+	cs.setStaticDifficulty(diff)
+	return nil
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestParseDifficulty(t *testing.T) {
+	cases := map[string]int64{"4000": 4000, "8G": 8000000000, "500m": 500000000, "1.5K": 1500}
+	for value, expected := range cases {
+		diff, err := parseDifficulty(value)
+		if err != nil || diff != expected {
+			t.Errorf("Must parse %s as %v, got %v (%v)", value, expected, diff, err)
+		}
+	}
+	for _, value := range []string{"", "G", "-5", "0", "abc", "1e30T"} {
+		if _, err := parseDifficulty(value); err == nil {
+			t.Errorf("Must not parse %q", value)
+		}
+	}
+}
+
+func TestSplitStaticDiff(t *testing.T) {
+	login, diff := splitStaticDiff("0xabc.rig1/d=8G", "x")
+	if login != "0xabc.rig1" || diff != "8G" {
+		t.Errorf("Must split login suffix, got %s and %s", login, diff)
+	}
+	login, diff = splitStaticDiff("0xabc", "x,d=4000")
+	if login != "0xabc" || diff != "4000" {
+		t.Errorf("Must take difficulty from password, got %s and %s", login, diff)
+	}
+	if _, diff = splitStaticDiff("0xabc", "x"); diff != "" {
+		t.Error("Must return empty difficulty if not requested")
+	}
+}
+
+func TestApplyStaticDiff(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.config.Proxy.StaticDiff.MinDiff = 1000
+	cs := &Session{login: "0xabc"}
+	s.sessions.add(cs)
+
+	// Retargeter reads flag while login applies it, checked by race detector
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, cs := range s.sessions.all() {
+				cs.hasStaticDifficulty()
+			}
+		}
+	}()
+	if err := s.applyStaticDiff(cs, "500"); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if diff, _ := cs.difficulty(); diff != 1000 || !cs.hasStaticDifficulty() {
+		t.Errorf("Must fix difficulty clamped to bounds, got %v", diff)
+	}
+}
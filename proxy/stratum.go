@@ -8,6 +8,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/etclabscore/open-etc-pool/util"
@@ -16,65 +17,114 @@ import (
 const (
 	MaxReqSize         = 1024
 	DefaultPingTimeout = 90 * time.Second
-	MaxConcurrentSends = 500
 )
 
-func (s *ProxyServer) ListenTCP() {
-	timeout := util.MustParseDuration(s.config.Proxy.Stratum.Timeout)
-	s.timeout = timeout
+// Configured stratum listeners, single "listen" entry is used if no ports are set
+func (s *ProxyServer) stratumPorts() []StratumPort {
+	cfg := s.config.Proxy.Stratum
+	if len(cfg.Ports) == 0 {
+		return []StratumPort{{Listen: cfg.Listen, Difficulty: s.config.Proxy.Difficulty, MaxConn: cfg.MaxConn}}
+	}
+	ports := make([]StratumPort, len(cfg.Ports))
+	for i, port := range cfg.Ports {
+		if port.Difficulty <= 0 {
+			port.Difficulty = s.config.Proxy.Difficulty
+		}
+		ports[i] = port
+	}
+	return ports
+}
 
-	addr, err := net.ResolveTCPAddr("tcp4", s.config.Proxy.Stratum.Listen)
+func (s *ProxyServer) ListenTCP(port StratumPort) {
+	network := s.config.Proxy.Stratum.ListenNetwork
+	if len(network) == 0 {
+		network = "tcp4"
+	}
+	addr, err := net.ResolveTCPAddr(network, port.Listen)
 	if err != nil {
 		log.Fatalf("Error resolving address: %v", err)
 	}
 
-	server, err := net.ListenTCP("tcp4", addr)
+	server, err := net.ListenTCP(network, addr)
 	if err != nil {
 		log.Fatalf("Error listening: %v", err)
 	}
 	defer server.Close()
+	s.trackListener(server)
 
-	log.Printf("Stratum listening on %s", s.config.Proxy.Stratum.Listen)
+	log.Printf("Stratum listening on %s with difficulty %v", port.Listen, port.Difficulty)
 
-	var acceptSem = make(chan struct{}, s.config.Proxy.Stratum.MaxConn)
-	go s.sessionCleaner()
+	target := util.GetTargetHex(port.Difficulty)
+	var acceptSem = make(chan struct{}, port.MaxConn)
+	limiter := &acceptLimiter{rate: s.config.Proxy.DDoS.AcceptRate}
 
 	for {
 		conn, err := server.AcceptTCP()
-		if err != nil {
+		if err != nil && s.isDraining() {
+			return
+		} else if err != nil {
 			log.Printf("Accept error: %v", err)
 			continue
 		}
 
+		if !s.allowConn(conn) || s.underAttack() && !limiter.allow(time.Now()) {
+			conn.Close()
+			continue
+		}
+
 		conn.SetKeepAlive(true)
 		conn.SetKeepAlivePeriod(30 * time.Second)
 		conn.SetNoDelay(true)
 
-		ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+		acceptSem <- struct{}{}
+		go func() {
+			defer func() { <-acceptSem }()
+			s.handleTCPConn(conn, port, target)
+		}()
+	}
+}
+
+func (s *ProxyServer) handleTCPConn(conn *net.TCPConn, port StratumPort, target string) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	connbuff := bufio.NewReaderSize(conn, MaxReqSize)
 
-		if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
+	if s.config.Proxy.Stratum.ProxyProtocol {
+		conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		clientIP, err := readProxyHeader(connbuff)
+		if err != nil {
+			log.Printf("Failed to read PROXY protocol header from %s: %v", ip, err)
 			conn.Close()
-			continue
+			return
 		}
-
-		acceptSem <- struct{}{}
-		cs := &Session{
-			conn:         conn,
-			ip:           ip,
-			enc:          json.NewEncoder(conn),
-			lastActivity: time.Now(),
-			pingTimeout:  DefaultPingTimeout,
+		if clientIP != nil {
+			ip = clientIP.String()
 		}
+	}
 
-		go func() {
-			defer func() { <-acceptSem }()
-			err := s.handleTCPClient(cs)
-			if err != nil {
-				s.removeSession(cs)
-				conn.Close()
-			}
-		}()
+	if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
+		conn.Close()
+		return
+	}
+
+	backend, _ := s.tenantBackend(port.Tenant)
+	cs := &Session{
+		conn:         conn,
+		ip:           ip,
+		backend:      backend,
+		lastActivity: time.Now(),
+		pingTimeout:  DefaultPingTimeout,
+		diff:         port.Difficulty,
+		target:       target,
+		lastRetarget: time.Now(),
+		port:         &port,
+		connectedAt:  time.Now(),
 	}
+	s.startWriter(cs)
+
+	s.handleTCPClient(cs, connbuff)
+	s.removeSession(cs)
+	cs.stopWriter()
+	conn.Close()
 }
 
 func (s *ProxyServer) sessionCleaner() {
@@ -85,21 +135,18 @@ func (s *ProxyServer) sessionCleaner() {
 }
 
 func (s *ProxyServer) cleanInactiveSessions() {
-	s.sessionsMu.Lock()
-	defer s.sessionsMu.Unlock()
-
 	now := time.Now()
-	for cs := range s.sessions {
-		if now.Sub(cs.lastActivity) > cs.pingTimeout {
-			cs.conn.Close()
-			delete(s.sessions, cs)
-		}
+	removed := s.sessions.removeIf(func(cs *Session) bool {
+		return now.Sub(cs.lastActivity) > cs.pingTimeout
+	})
+	for _, cs := range removed {
+		cs.conn.Close()
+		s.uncount(cs)
 	}
 }
 
-func (s *ProxyServer) handleTCPClient(cs *Session) error {
+func (s *ProxyServer) handleTCPClient(cs *Session, connbuff *bufio.Reader) error {
 	s.registerSession(cs)
-	connbuff := bufio.NewReaderSize(cs.conn, MaxReqSize)
 
 	for {
 		s.setDeadline(cs.conn)
@@ -124,6 +171,7 @@ func (s *ProxyServer) handleTCPClient(cs *Session) error {
 				log.Printf("Malformed stratum request from %s: %v", cs.ip, err)
 				return err
 			}
+			cs.tailMessage("in", data)
 			if err := cs.handleTCPMessage(s, &req); err != nil {
 				return err
 			}
@@ -140,11 +188,19 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			log.Println("Malformed login params from", cs.ip)
 			return err
 		}
+		agent := req.Agent
+		if len(agent) == 0 && len(params) > 2 {
+			agent = params[2]
+		}
+		s.applyQuirk(cs, agent)
 		reply, errReply := s.handleLoginRPC(cs, params, req.Worker)
 		if errReply != nil {
 			return cs.sendTCPError(req.Id, errReply)
 		}
-		return cs.sendTCPResult(req.Id, reply)
+		if err := cs.sendTCPResult(req.Id, reply); err != nil {
+			return err
+		}
+		return cs.showMessage(s.message(cs, msgMotd))
 
 	case "eth_getWork":
 		reply, errReply := s.handleGetWorkRPC(cs)
@@ -160,18 +216,30 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 			return err
 		}
 		reply, errReply := s.handleTCPSubmitRPC(cs, req.Worker, params)
+		// Share dropped by full verifier queue is retried on the same connection
+		if errReply != nil && errReply.Code == codeBusy {
+			return cs.sendTCPErrorReply(req.Id, errReply)
+		}
 		if errReply != nil {
 			return cs.sendTCPError(req.Id, errReply)
 		}
 		return cs.sendTCPResult(req.Id, &reply)
 
 	case "eth_submitHashrate":
-		return cs.sendTCPResult(req.Id, true)
+		var params []string
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return cs.sendTCPError(req.Id, s.errorReply(cs, -1, msgInvalidParams))
+		}
+		reply, errReply := s.handleSubmitHashrateRPC(cs, req.Worker, params)
+		if errReply != nil {
+			return cs.sendTCPError(req.Id, errReply)
+		}
+		return cs.sendTCPResult(req.Id, &reply)
 
 	case "mining.ping":
 		var params []string
 		if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
-			return cs.sendTCPError(req.Id, &ErrorReply{Code: -1, Message: "Invalid ping"})
+			return cs.sendTCPError(req.Id, s.errorReply(cs, -1, msgInvalidPing))
 		}
 		cs.lastPing = time.Now()
 		return cs.sendTCPResult(req.Id, map[string]string{"pong": params[0]})
@@ -183,93 +251,187 @@ func (cs *Session) handleTCPMessage(s *ProxyServer, req *StratumReq) error {
 }
 
 func (cs *Session) sendTCPResult(id json.RawMessage, result interface{}) error {
-	cs.Lock()
-	defer cs.Unlock()
-
-	message := JSONRpcResp{Id: id, Version: "2.0", Error: nil, Result: result}
-	return cs.enc.Encode(&message)
+	message := JSONRpcResp{Id: cs.quirks().replyId(id), Version: "2.0", Error: nil, Result: result}
+	return cs.send(&message, false)
 }
 
-func (cs *Session) pushNewJob(result interface{}) error {
-	cs.Lock()
-	defer cs.Unlock()
+// Shown by miners which support client.show_message, others ignore unknown method
+func (cs *Session) showMessage(msg string) error {
+	if len(msg) == 0 {
+		return nil
+	}
+	if q := cs.quirks(); q != nil && q.NoMessages {
+		return nil
+	}
+	message := JSONNotification{Id: json.RawMessage("null"), Version: "2.0", Method: "client.show_message", Params: []string{msg}}
+	return cs.send(&message, false)
+}
 
-	message := JSONPushMessage{Version: "2.0", Result: result, Id: 0}
-	return cs.enc.Encode(&message)
+func (cs *Session) pushNewJob(job []string) error {
+	if cs.sv2 != nil {
+		return cs.pushSV2Job(job)
+	}
+	message := JSONPushMessage{Version: "2.0", Result: job, Id: cs.quirks().pushId()}
+	return cs.send(&message, true)
 }
 
+// Sends error and ends session
 func (cs *Session) sendTCPError(id json.RawMessage, reply *ErrorReply) error {
-	cs.Lock()
-	defer cs.Unlock()
-
-	message := JSONRpcResp{Id: id, Version: "2.0", Error: reply}
-	err := cs.enc.Encode(&message)
-	if err != nil {
+	if err := cs.sendTCPErrorReply(id, reply); err != nil {
 		return err
 	}
 	return errors.New(reply.Message)
 }
 
-func (s *ProxyServer) setDeadline(conn *net.TCPConn) {
+// Sends error keeping session open
+func (cs *Session) sendTCPErrorReply(id json.RawMessage, reply *ErrorReply) error {
+	message := JSONRpcResp{Id: cs.quirks().replyId(id), Version: "2.0", Error: reply}
+	if q := cs.quirks(); q != nil && q.BoolErrors {
+		message.Error, message.Result = nil, false
+	}
+	return cs.send(&message, false)
+}
+
+func (s *ProxyServer) setDeadline(conn net.Conn) {
 	timeout := s.timeout
-	if len(s.sessions) > 1000 {
+	if s.sessions.len() > 1000 {
 		timeout = timeout / 2
 	}
 	conn.SetDeadline(time.Now().Add(timeout))
 }
 
 func (s *ProxyServer) registerSession(cs *Session) {
-	s.sessionsMu.Lock()
-	defer s.sessionsMu.Unlock()
-	s.sessions[cs] = struct{}{}
+	s.sessions.add(cs)
+}
+
+// Registers session under login, fails if login already has max sessions
+func (s *ProxyServer) registerLogin(cs *Session, login string) bool {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	if cs.countedLogin == login {
+		s.sessions.add(cs)
+		return true
+	}
+	max := s.config.Proxy.Stratum.MaxSessionsPerLogin
+	if max > 0 && s.loginSessions[login] >= max {
+		return false
+	}
+	s.uncountLocked(cs)
+	if s.loginSessions == nil {
+		s.loginSessions = make(map[string]int)
+	}
+	s.loginSessions[login]++
+	cs.countedLogin = login
+	s.sessions.add(cs)
+	return true
 }
 
 func (s *ProxyServer) removeSession(cs *Session) {
-	s.sessionsMu.Lock()
-	defer s.sessionsMu.Unlock()
-	if _, ok := s.sessions[cs]; ok {
-		delete(s.sessions, cs)
+	s.sessions.remove(cs)
+	s.uncount(cs)
+}
+
+func (s *ProxyServer) uncount(cs *Session) {
+	s.loginMu.Lock()
+	defer s.loginMu.Unlock()
+	s.uncountLocked(cs)
+}
+
+// Must be called with login lock held
+func (s *ProxyServer) uncountLocked(cs *Session) {
+	if len(cs.countedLogin) == 0 {
+		return
+	}
+	if s.loginSessions[cs.countedLogin]--; s.loginSessions[cs.countedLogin] <= 0 {
+		delete(s.loginSessions, cs.countedLogin)
 	}
+	cs.countedLogin = ""
 }
 
-func (s *ProxyServer) broadcastNewJobs() {
+// Pushes current job to sessions which don't have it yet, force pushes to all sessions
+func (s *ProxyServer) broadcastNewJobs(force bool) {
 	t := s.currentBlockTemplate()
 	if t == nil || len(t.Header) == 0 || s.isSick() {
 		return
 	}
-	reply := []string{t.Header, t.Seed, s.diff}
-
-	s.sessionsMu.RLock()
-	sessions := make([]*Session, 0, len(s.sessions))
-	for m := range s.sessions {
-		sessions = append(sessions, m)
+	s.broadcastMu.Lock()
+	defer s.broadcastMu.Unlock()
+	if !force && t.Header == s.lastBroadcast {
+		return
 	}
-	s.sessionsMu.RUnlock()
+	s.lastBroadcast = t.Header
 
-	log.Printf("Broadcasting new job to %v stratum miners", len(sessions))
+	log.Printf("Broadcasting new job to %v stratum miners", s.sessions.len())
 
 	start := time.Now()
+	var skipped int64
 	var wg sync.WaitGroup
-	sem := make(chan struct{}, MaxConcurrentSends)
-
-	for _, cs := range sessions {
+	// Jobs are only queued here, writer goroutines deliver them, shards are pushed in parallel
+	for _, sessions := range s.sessions.snapshot() {
 		wg.Add(1)
-		sem <- struct{}{}
-
-		go func(cs *Session) {
+		go func(sessions []*Session) {
 			defer wg.Done()
-			defer func() { <-sem }()
-
-			if err := cs.pushNewJob(&reply); err != nil {
-				log.Printf("Job transmit error to %v@%v: %v", cs.login, cs.ip, err)
-				s.removeSession(cs)
-				cs.conn.Close()
-			} else {
-				s.setDeadline(cs.conn)
+			for _, cs := range sessions {
+				diff, target := cs.difficulty()
+				if !cs.markJob(t.Header, diff, target) && !force {
+					atomic.AddInt64(&skipped, 1)
+					continue
+				}
+				reply := []string{t.Header, t.Seed, target}
+				if err := cs.pushNewJob(reply); err != nil {
+					log.Printf("Job transmit error to %v@%v: %v", cs.login, cs.ip, err)
+					s.removeSession(cs)
+					cs.conn.Close()
+				} else {
+					s.setDeadline(cs.conn)
+				}
 			}
-		}(cs)
+		}(sessions)
 	}
-
 	wg.Wait()
-	log.Printf("Jobs broadcast finished %s", time.Since(start))
+	log.Printf("Jobs broadcast finished %s, %v sessions already had job", time.Since(start), skipped)
+}
+
+// Remembers job pushed to session, false if session already has it
+func (cs *Session) markJob(header string, diff int64, target string) bool {
+	cs.diffMu.Lock()
+	defer cs.diffMu.Unlock()
+	cs.recordJobLocked(header, diff)
+	if cs.lastHeader == header && cs.lastTarget == target {
+		return false
+	}
+	cs.lastHeader = header
+	cs.lastTarget = target
+	return true
+}
+
+// Number of recent jobs whose difficulty is remembered per session
+const sessionJobBacklog = 8
+
+// Keeps lowest difficulty the job was sent with, miner may still be hashing at it after retarget
+func (cs *Session) recordJobLocked(header string, diff int64) {
+	if cs.jobDiffs == nil {
+		cs.jobDiffs = make(map[string]int64)
+	}
+	d, ok := cs.jobDiffs[header]
+	if !ok {
+		cs.jobOrder = append(cs.jobOrder, header)
+		if len(cs.jobOrder) > sessionJobBacklog {
+			delete(cs.jobDiffs, cs.jobOrder[0])
+			cs.jobOrder = cs.jobOrder[1:]
+		}
+	}
+	if !ok || diff < d {
+		cs.jobDiffs[header] = diff
+	}
+}
+
+// Difficulty share for job must meet, current one if job was not pushed to session
+func (cs *Session) jobDifficulty(header string) int64 {
+	cs.diffMu.RLock()
+	defer cs.diffMu.RUnlock()
+	if d, ok := cs.jobDiffs[header]; ok {
+		return d
+	}
+	return cs.diff
 }
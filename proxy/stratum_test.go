@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMaxSessionsPerLogin(t *testing.T) {
+	s := &ProxyServer{}
+	s.config = &Config{}
+	s.config.Proxy.Stratum.MaxSessionsPerLogin = 2
+
+	a, b, c := &Session{}, &Session{}, &Session{}
+	if !s.registerLogin(a, "0xa") || !s.registerLogin(b, "0xa") {
+		t.Fatal("Must register sessions up to limit")
+	}
+	if s.registerLogin(c, "0xa") {
+		t.Error("Must reject session above limit")
+	}
+	if !s.registerLogin(a, "0xa") {
+		t.Error("Must allow relogin of counted session")
+	}
+	if !s.registerLogin(c, "0xb") {
+		t.Error("Must count sessions per login")
+	}
+
+	s.removeSession(b)
+	s.removeSession(b)
+	if !s.registerLogin(c, "0xa") {
+		t.Error("Must free slot of removed session")
+	}
+	if s.loginSessions["0xa"] != 2 || len(s.loginSessions) != 1 {
+		t.Errorf("Must move session between logins, got %v", s.loginSessions)
+	}
+}
+
+func TestBroadcastSkipsKnownJobs(t *testing.T) {
+	s := &ProxyServer{config: &Config{}, sendQueue: 8}
+	s.blockTemplate.Store(&BlockTemplate{Header: "0x1", Seed: "0x2"})
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, target: "0x3", out: make(chan outMessage, 8)}
+	s.sessions.add(cs)
+
+	s.broadcastNewJobs(false)
+	s.broadcastNewJobs(false)
+	if len(cs.out) != 1 {
+		t.Errorf("Must push unchanged job once, got %v pushes", len(cs.out))
+	}
+	if cs.markJob("0x1", 0, "0x3") {
+		t.Error("Must remember pushed job")
+	}
+	if !cs.markJob("0x1", 0, "0x4") {
+		t.Error("Must push job again with new target")
+	}
+	s.broadcastNewJobs(true)
+	if len(cs.out) != 2 {
+		t.Errorf("Must push to all sessions when forced, got %v pushes", len(cs.out))
+	}
+}
+
+func TestMotd(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.blockTemplate.Store(&BlockTemplate{Header: "0x1", Seed: "0x2", Height: 16})
+
+	if reply, _ := s.handleGetWorkRPC(&Session{target: "0x3"}); len(reply) != 3 {
+		t.Errorf("Must not extend reply without motd, got %v", reply)
+	}
+	s.config.Proxy.Messages = map[string]string{"motd": "Fee is 1% for {login}"}
+	reply, _ := s.handleGetWorkRPC(&Session{login: "0xa", target: "0x3"})
+	if len(reply) != 5 || reply[3] != "0x10" || reply[4] != "Fee is 1% for 0xa" {
+		t.Errorf("Must add block number and motd to HTTP reply, got %v", reply)
+	}
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, out: make(chan outMessage, 1)}
+	if reply, _ := s.handleGetWorkRPC(cs); len(reply) != 3 {
+		t.Errorf("Must not extend stratum reply, got %v", reply)
+	}
+	cs.showMessage(s.message(cs, msgMotd))
+	msg := <-cs.out
+	if !strings.Contains(string(msg.data), `"method":"client.show_message"`) {
+		t.Errorf("Must push motd as client.show_message, got %s", msg.data)
+	}
+}
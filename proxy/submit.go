@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"errors"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+type submitResult struct {
+	upstream *rpc.RPCClient
+	ok       bool
+	err      error
+	latency  time.Duration
+}
+
+// Broadcasts block solution to every upstream on expected chain, so a lagging selected node can't lose it.
+// Returns as soon as any node accepts, error only if all of them failed.
+// Answers of all upstreams are returned if block was rejected.
+func (s *ProxyServer) submitBlock(params []string, height uint64) (bool, []submitResult, error) {
+	upstreams := s.verifiedUpstreams()
+	if len(upstreams) == 0 {
+		return false, nil, errors.New("no upstream on expected chain")
+	}
+	return submitBlockTo(upstreams, params, height)
+}
+
+func submitBlockTo(upstreams []*rpc.RPCClient, params []string, height uint64) (bool, []submitResult, error) {
+	start := time.Now()
+	results := make(chan submitResult, len(upstreams))
+	for _, u := range upstreams {
+		go func(u *rpc.RPCClient) {
+			ok, err := u.SubmitBlock(params)
+			results <- submitResult{upstream: u, ok: ok, err: err, latency: time.Since(start)}
+		}(u)
+	}
+
+	var err error
+	rejected := false
+	answers := make([]submitResult, 0, len(upstreams))
+	for i := 0; i < len(upstreams); i++ {
+		res := <-results
+		answers = append(answers, res)
+		if res.err != nil {
+			log.Printf("Block submission to %v failed at height %v: %v", res.upstream.Name, height, res.err)
+			if err == nil {
+				err = res.err
+			}
+			continue
+		}
+		if !res.ok {
+			log.Printf("Block rejected by %v at height %v", res.upstream.Name, height)
+			rejected = true
+			continue
+		}
+		log.Printf("Block at height %v accepted first by %v in %v", height, res.upstream.Name, res.latency)
+		return true, nil, nil
+	}
+	// Rejection is an answer, not a failure
+	if rejected {
+		return false, answers, nil
+	}
+	return false, nil, err
+}
+
+// Upstreams on expected chain and not refused past fork block, rejection of others means nothing
+func (s *ProxyServer) verifiedUpstreams() []*rpc.RPCClient {
+	var result []*rpc.RPCClient
+	for i, u := range s.upstreams {
+		if s.chainVerified(i) && s.forkAllowed(i) {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// Upstreams on expected chain which are not marked sick, all of them if every one is
+func (s *ProxyServer) healthyUpstreams() []*rpc.RPCClient {
+	var result []*rpc.RPCClient
+	verified := s.verifiedUpstreams()
+	for _, u := range verified {
+		if !u.Sick() {
+			result = append(result, u)
+		}
+	}
+	if len(result) == 0 {
+		return verified
+	}
+	return result
+}
+
+// Solutions which failed to reach any upstream are retried from redis by every
+// proxy instance, the one removing it from queue first inserts block candidate
+func (s *ProxyServer) queuePendingBlock(backend *storage.RedisClient, b *storage.PendingBlock) {
+	if err := backend.QueuePendingBlock(b); err != nil {
+		log.Printf("Failed to queue block at height %v for resubmission: %v", b.Height, err)
+		return
+	}
+	log.Printf("Queued block at height %v for resubmission", b.Height)
+}
+
+func (s *ProxyServer) blockResubmitter() {
+	cfg := s.config.Proxy.BlockRetry
+	window := util.MustParseDuration(cfg.Window)
+	intv := util.MustParseDuration(cfg.Interval)
+	log.Printf("Resubmitting failed blocks every %v for %v", intv, window)
+
+	backends := []*storage.RedisClient{s.backend}
+	for _, backend := range s.tenants {
+		backends = append(backends, backend)
+	}
+	ticker := time.NewTicker(intv)
+	for range ticker.C {
+		for _, backend := range backends {
+			s.resubmitBlocks(backend, window)
+		}
+	}
+}
+
+func (s *ProxyServer) resubmitBlocks(backend *storage.RedisClient, window time.Duration) {
+	blocks, err := backend.GetPendingBlocks()
+	if err != nil {
+		log.Printf("Failed to get pending blocks from backend: %v", err)
+		return
+	}
+	for _, b := range blocks {
+		if util.MakeTimestamp()-b.FoundAt > int64(window/time.Millisecond) {
+			if removed, _ := backend.RemovePendingBlock(b); removed {
+				log.Printf("Resubmission of block at height %v by %v expired", b.Height, b.Login)
+			}
+			continue
+		}
+		ok, _, err := submitBlockTo(s.healthyUpstreams(), b.Params, b.Height)
+		if err != nil {
+			continue
+		}
+		removed, err := backend.RemovePendingBlock(b)
+		if err != nil || !removed {
+			continue
+		}
+		if !ok {
+			log.Printf("Resubmitted block at height %v by %v rejected", b.Height, b.Login)
+			continue
+		}
+		log.Printf("Resubmitted block at height %v by %v accepted", b.Height, b.Login)
+		s.fetchBlockTemplate()
+		exist, err := s.writeBlock(backend, b)
+		if err != nil {
+			log.Println("Failed to insert block candidate into backend:", err)
+		} else if !exist {
+			log.Printf("Inserted block %v to backend", b.Height)
+			if !b.Solo {
+				s.creditPPS(backend, b.Login, b.Diff, heightDiffPair{diff: big.NewInt(b.RoundDiff), height: b.Height})
+			}
+		}
+	}
+}
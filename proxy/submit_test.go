@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+)
+
+func submitNode(reply string, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":` + reply + `}`))
+	}))
+}
+
+func TestSubmitBlockToAllUpstreams(t *testing.T) {
+	lagging := submitNode("false", 0)
+	defer lagging.Close()
+	synced := submitNode("true", 50*time.Millisecond)
+	defer synced.Close()
+
+	s := &ProxyServer{upstreams: []*rpc.RPCClient{
+		rpc.NewRPCClient("lagging", lagging.URL, "1s"),
+		rpc.NewRPCClient("synced", synced.URL, "1s"),
+	}}
+	ok, _, err := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1)
+	if !ok || err != nil {
+		t.Errorf("Must accept block submitted to any upstream, got %v %v", ok, err)
+	}
+
+	s.upstreams = s.upstreams[:1]
+	if ok, answers, err := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1); ok || err != nil || len(answers) != 1 {
+		t.Errorf("Must report rejection with answers of upstreams without error, got %v %v %v", ok, answers, err)
+	}
+
+	s.upstreams = []*rpc.RPCClient{rpc.NewRPCClient("down", "http://127.0.0.1:1", "1s")}
+	if ok, _, err := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1); ok || err == nil {
+		t.Errorf("Must return error if all upstreams failed, got %v %v", ok, err)
+	}
+}
+
+func TestSubmitBlockSkipsWrongChain(t *testing.T) {
+	wrong := submitNode("false", 0)
+	defer wrong.Close()
+
+	s := &ProxyServer{upstreams: []*rpc.RPCClient{
+		rpc.NewRPCClient("wrong", wrong.URL, "1s"),
+		rpc.NewRPCClient("down", "http://127.0.0.1:1", "1s"),
+	}}
+	s.chainStates = []int32{chainWrong, chainOk}
+	// Failure is queued for resubmission, rejection would drop the block
+	if ok, answers, err := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1); ok || err == nil || answers != nil {
+		t.Errorf("Must not count rejection of upstream on another chain, got %v %v %v", ok, answers, err)
+	}
+
+	s.upstreams = s.upstreams[:1]
+	s.chainStates = s.chainStates[:1]
+	if ok, answers, err := s.submitBlock([]string{"0x0", "0x0", "0x0"}, 1); ok || err == nil || answers != nil {
+		t.Errorf("Must fail without rejection if no upstream is on expected chain, got %v %v %v", ok, answers, err)
+	}
+}
@@ -0,0 +1,579 @@
+package proxy
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	sv2HeaderLen  = 6
+	sv2MaxPayload = 4096
+	sv2ChannelBit = 0x8000
+	sv2Version    = 2
+
+	// Pool specific extension carrying Ethash jobs and shares, experimental
+	sv2ExtEthash = 0x4554
+
+	defaultSV2MaxChannels = 64
+	sv2JobBacklog         = 8
+)
+
+// Common and mining protocol message types
+const (
+	sv2SetupConnection           = 0x00
+	sv2SetupConnectionSuccess    = 0x01
+	sv2SetupConnectionError      = 0x02
+	sv2OpenStandardChannel       = 0x10
+	sv2OpenStandardChannelOk     = 0x11
+	sv2OpenChannelError          = 0x12
+	sv2CloseChannel              = 0x18
+	sv2SubmitSharesSuccess       = 0x1c
+	sv2SubmitSharesError         = 0x1d
+	sv2SetTarget                 = 0x21
+	sv2EthashNewJob              = 0x01
+	sv2EthashSubmitShares        = 0x02
+	sv2MiningProtocol            = 0
+	sv2RequiresWorkSelectionFlag = 0x2
+)
+
+var (
+	errSV2FrameTooLarge = errors.New("frame too large")
+	errSV2ShortPayload  = errors.New("short payload")
+)
+
+type sv2Frame struct {
+	ext     uint16
+	msgType uint8
+	payload []byte
+}
+
+// Noise encrypted connection, each Write takes a single plain frame
+type sv2Conn struct {
+	net.Conn
+	mu   sync.Mutex
+	send *cipherState
+	recv *cipherState
+}
+
+func (c *sv2Conn) Write(frame []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := c.send.encrypt(nil, frame[:sv2HeaderLen])
+	payload := frame[sv2HeaderLen:]
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > noiseMaxMsg-noiseTagLen {
+			n = noiseMaxMsg - noiseTagLen
+		}
+		out = append(out, c.send.encrypt(nil, payload[:n])...)
+		payload = payload[n:]
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(frame), nil
+}
+
+func (c *sv2Conn) readFrame() (*sv2Frame, error) {
+	buf := make([]byte, sv2HeaderLen+noiseTagLen)
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return nil, err
+	}
+	header, err := c.recv.decrypt(nil, buf)
+	if err != nil {
+		return nil, err
+	}
+	f := &sv2Frame{ext: binary.LittleEndian.Uint16(header), msgType: header[2]}
+	length := int(header[3]) | int(header[4])<<8 | int(header[5])<<16
+	if length > sv2MaxPayload {
+		return nil, errSV2FrameTooLarge
+	}
+	for length > 0 {
+		n := length
+		if n > noiseMaxMsg-noiseTagLen {
+			n = noiseMaxMsg - noiseTagLen
+		}
+		chunk := make([]byte, n+noiseTagLen)
+		if _, err := io.ReadFull(c.Conn, chunk); err != nil {
+			return nil, err
+		}
+		plain, err := c.recv.decrypt(nil, chunk)
+		if err != nil {
+			return nil, err
+		}
+		f.payload = append(f.payload, plain...)
+		length -= n
+	}
+	return f, nil
+}
+
+func sv2Encode(ext uint16, msgType uint8, payload []byte) []byte {
+	frame := make([]byte, sv2HeaderLen, sv2HeaderLen+len(payload))
+	binary.LittleEndian.PutUint16(frame, ext)
+	frame[2] = msgType
+	frame[3], frame[4], frame[5] = byte(len(payload)), byte(len(payload)>>8), byte(len(payload)>>16)
+	return append(frame, payload...)
+}
+
+// Little endian encoder of SV2 data types
+type sv2Buf []byte
+
+func (b *sv2Buf) u8(v uint8) { *b = append(*b, v) }
+
+func (b *sv2Buf) u16(v uint16) { *b = binary.LittleEndian.AppendUint16(*b, v) }
+
+func (b *sv2Buf) u32(v uint32) { *b = binary.LittleEndian.AppendUint32(*b, v) }
+
+func (b *sv2Buf) u64(v uint64) { *b = binary.LittleEndian.AppendUint64(*b, v) }
+
+func (b *sv2Buf) str(s string) {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	*b = append(append(*b, byte(len(s))), s...)
+}
+
+func (b *sv2Buf) bytes32(p []byte) { *b = append(*b, common.LeftPadBytes(p, 32)...) }
+
+func (b *sv2Buf) u256(n *big.Int) {
+	p := n.FillBytes(make([]byte, 32))
+	for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+	*b = append(*b, p...)
+}
+
+// Decoder of SV2 data types, first error sticks
+type sv2Reader struct {
+	data []byte
+	err  error
+}
+
+func (r *sv2Reader) take(n int) []byte {
+	if r.err != nil || len(r.data) < n {
+		r.err = errSV2ShortPayload
+		return make([]byte, n)
+	}
+	p := r.data[:n]
+	r.data = r.data[n:]
+	return p
+}
+
+func (r *sv2Reader) u8() uint8 { return r.take(1)[0] }
+
+func (r *sv2Reader) u16() uint16 { return binary.LittleEndian.Uint16(r.take(2)) }
+
+func (r *sv2Reader) u32() uint32 { return binary.LittleEndian.Uint32(r.take(4)) }
+
+func (r *sv2Reader) u64() uint64 { return binary.LittleEndian.Uint64(r.take(8)) }
+
+func (r *sv2Reader) f32() float32 { return math.Float32frombits(r.u32()) }
+
+func (r *sv2Reader) str() string { return string(r.take(int(r.u8()))) }
+
+func (r *sv2Reader) bytes32() []byte { return r.take(32) }
+
+func (r *sv2Reader) u256() *big.Int {
+	p := append([]byte{}, r.take(32)...)
+	for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+	return new(big.Int).SetBytes(p)
+}
+
+// Standard channel, each one is a separate miner session on shared connection
+type sv2Channel struct {
+	id     uint32
+	mu     sync.Mutex
+	jobID  uint32
+	jobs   map[uint32]string
+	target string
+}
+
+func (ch *sv2Channel) header(jobID uint32) (string, bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	header, ok := ch.jobs[jobID]
+	return header, ok
+}
+
+// Translates getWork job into target update and Ethash job of channel
+func (cs *Session) pushSV2Job(job []string) error {
+	ch := cs.sv2
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if job[2] != ch.target {
+		var b sv2Buf
+		b.u32(ch.id)
+		b.u256(new(big.Int).SetBytes(common.FromHex(job[2])))
+		if err := cs.enqueue(sv2Encode(sv2ChannelBit, sv2SetTarget, b), false); err != nil {
+			return err
+		}
+		ch.target = job[2]
+	}
+
+	ch.jobID++
+	ch.jobs[ch.jobID] = job[0]
+	delete(ch.jobs, ch.jobID-sv2JobBacklog)
+
+	var b sv2Buf
+	b.u32(ch.id)
+	b.u32(ch.jobID)
+	b.bytes32(common.FromHex(job[0]))
+	b.bytes32(common.FromHex(job[1]))
+	return cs.enqueue(sv2Encode(sv2ExtEthash|sv2ChannelBit, sv2EthashNewJob, b), true)
+}
+
+// Loads server static key from file, generating it if file doesn't exist
+func loadNoiseKey(path string) *ecdh.PrivateKey {
+	if len(path) == 0 {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("Failed to generate Stratum V2 key: %v", err)
+		}
+		log.Println("Stratum V2 key is not set, miners will have to pin new key after restart")
+		return key
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("Failed to generate Stratum V2 key: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Bytes())), 0600); err != nil {
+			log.Fatalf("Failed to save Stratum V2 key: %v", err)
+		}
+		return key
+	} else if err != nil {
+		log.Fatalf("Failed to read Stratum V2 key: %v", err)
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Fatalf("Malformed Stratum V2 key: %v", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		log.Fatalf("Malformed Stratum V2 key: %v", err)
+	}
+	return key
+}
+
+func (s *ProxyServer) ListenSV2() {
+	cfg := s.config.Proxy.Stratum.V2
+	network := s.config.Proxy.Stratum.ListenNetwork
+	if len(network) == 0 {
+		network = "tcp4"
+	}
+	addr, err := net.ResolveTCPAddr(network, cfg.Listen)
+	if err != nil {
+		log.Fatalf("Error resolving address: %v", err)
+	}
+	server, err := net.ListenTCP(network, addr)
+	if err != nil {
+		log.Fatalf("Error listening: %v", err)
+	}
+	defer server.Close()
+	s.trackListener(server)
+
+	key := loadNoiseKey(cfg.KeyFile)
+	diff := cfg.Difficulty
+	if diff <= 0 {
+		diff = s.config.Proxy.Difficulty
+	}
+	log.Printf("Stratum V2 listening on %s with difficulty %v, server key %x", cfg.Listen, diff, key.PublicKey().Bytes())
+
+	acceptSem := make(chan struct{}, cfg.MaxConn)
+	limiter := &acceptLimiter{rate: s.config.Proxy.DDoS.AcceptRate}
+
+	for {
+		conn, err := server.AcceptTCP()
+		if err != nil && s.isDraining() {
+			return
+		} else if err != nil {
+			log.Printf("Accept error: %v", err)
+			continue
+		}
+		if !s.allowConn(conn) || s.underAttack() && !limiter.allow(time.Now()) {
+			conn.Close()
+			continue
+		}
+		conn.SetKeepAlive(true)
+		conn.SetKeepAlivePeriod(30 * time.Second)
+		conn.SetNoDelay(true)
+
+		acceptSem <- struct{}{}
+		go func() {
+			defer func() { <-acceptSem }()
+			s.handleSV2Conn(conn, key, diff)
+		}()
+	}
+}
+
+func (s *ProxyServer) handleSV2Conn(conn *net.TCPConn, key *ecdh.PrivateKey, diff int64) {
+	defer conn.Close()
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
+		return
+	}
+
+	s.setDeadline(conn)
+	send, recv, err := noiseAccept(conn, key)
+	if err != nil {
+		log.Printf("Stratum V2 handshake with %s failed: %v", ip, err)
+		s.policy.ApplyMalformedPolicy(ip)
+		return
+	}
+	c := &sv2Conn{Conn: conn, send: send, recv: recv}
+	if err := s.setupSV2Conn(c, ip); err != nil {
+		log.Printf("Stratum V2 setup with %s failed: %v", ip, err)
+		return
+	}
+
+	channels := make(map[uint32]*Session)
+	defer func() {
+		for _, cs := range channels {
+			s.removeSession(cs)
+			cs.stopWriter()
+		}
+	}()
+
+	var lastID uint32
+	for {
+		s.setDeadline(conn)
+		f, err := c.readFrame()
+		if err == io.EOF {
+			log.Printf("Client %s disconnected", ip)
+			return
+		} else if err != nil {
+			log.Printf("Error reading from Stratum V2 socket %s: %v", ip, err)
+			s.policy.ApplyMalformedPolicy(ip)
+			return
+		}
+		for _, cs := range channels {
+			cs.lastActivity = time.Now()
+		}
+
+		ext := f.ext &^ sv2ChannelBit
+		switch {
+		case ext == 0 && f.msgType == sv2OpenStandardChannel:
+			maxChannels := s.config.Proxy.Stratum.V2.MaxChannels
+			if maxChannels <= 0 {
+				maxChannels = defaultSV2MaxChannels
+			}
+			if len(channels) >= maxChannels {
+				err = s.rejectSV2Channel(c, f, "max-channels-reached")
+				break
+			}
+			lastID++
+			var cs *Session
+			if cs, err = s.openSV2Channel(c, ip, lastID, diff, f); cs != nil {
+				channels[cs.sv2.id] = cs
+			}
+		case ext == 0 && f.msgType == sv2CloseChannel:
+			r := &sv2Reader{data: f.payload}
+			id := r.u32()
+			if cs, ok := channels[id]; ok {
+				s.removeSession(cs)
+				cs.stopWriter()
+				delete(channels, id)
+			}
+		case ext == sv2ExtEthash && f.msgType == sv2EthashSubmitShares:
+			err = s.handleSV2Submit(c, channels, f)
+		default:
+			log.Printf("Unsupported Stratum V2 message %#x:%#x from %s", f.ext, f.msgType, ip)
+			s.policy.ApplyMalformedPolicy(ip)
+		}
+		if err != nil {
+			log.Printf("Stratum V2 error from %s: %v", ip, err)
+			return
+		}
+	}
+}
+
+// Connection must start with SetupConnection for mining protocol
+func (s *ProxyServer) setupSV2Conn(c *sv2Conn, ip string) error {
+	f, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	if f.ext != 0 || f.msgType != sv2SetupConnection {
+		return errors.New("SetupConnection expected")
+	}
+	r := &sv2Reader{data: f.payload}
+	protocol, minVersion, maxVersion, flags := r.u8(), r.u16(), r.u16(), r.u32()
+	r.str()
+	r.u16()
+	vendor := r.str()
+	if r.err != nil {
+		return r.err
+	}
+
+	code := ""
+	switch {
+	case protocol != sv2MiningProtocol:
+		code = "unsupported-protocol"
+	case minVersion > sv2Version || maxVersion < sv2Version:
+		code = "protocol-version-mismatch"
+	case flags&sv2RequiresWorkSelectionFlag != 0:
+		code = "unsupported-feature-flags"
+	}
+	var b sv2Buf
+	if len(code) > 0 {
+		b.u32(flags & sv2RequiresWorkSelectionFlag)
+		b.str(code)
+		c.Write(sv2Encode(0, sv2SetupConnectionError, b))
+		return errors.New(code)
+	}
+	b.u16(sv2Version)
+	b.u32(0)
+	if _, err := c.Write(sv2Encode(0, sv2SetupConnectionSuccess, b)); err != nil {
+		return err
+	}
+	log.Printf("Stratum V2 connection from %s, vendor %q", ip, vendor)
+	return nil
+}
+
+func (s *ProxyServer) rejectSV2Channel(c *sv2Conn, f *sv2Frame, code string) error {
+	r := &sv2Reader{data: f.payload}
+	var b sv2Buf
+	b.u32(r.u32())
+	b.str(code)
+	_, err := c.Write(sv2Encode(0, sv2OpenChannelError, b))
+	return err
+}
+
+// Opens channel as miner session logged in with user identity, returns nil session if rejected
+func (s *ProxyServer) openSV2Channel(c *sv2Conn, ip string, id uint32, diff int64, f *sv2Frame) (*Session, error) {
+	r := &sv2Reader{data: f.payload}
+	requestID, identity, hashrate, maxTarget := r.u32(), r.str(), r.f32(), r.u256()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	backend, _ := s.tenantBackend(s.config.Proxy.Stratum.V2.Tenant)
+	cs := &Session{
+		conn:         c,
+		ip:           ip,
+		backend:      backend,
+		lastActivity: time.Now(),
+		pingTimeout:  DefaultPingTimeout,
+		diff:         diff,
+		target:       util.GetTargetHex(diff),
+		lastRetarget: time.Now(),
+		sv2:          &sv2Channel{id: id, jobs: make(map[uint32]string)},
+	}
+	if v := s.config.Proxy.VarDiff; v.Enabled && hashrate > 0 {
+		cs.setDifficulty(clampDifficulty(int64(float64(hashrate)*60/v.SharesPerMinute), v.MinDiff, v.MaxDiff))
+	}
+
+	s.startWriter(cs)
+	if _, errReply := s.handleLoginRPC(cs, []string{identity}, ""); errReply != nil {
+		s.removeSession(cs)
+		cs.stopWriter()
+		return nil, s.rejectSV2Channel(c, f, "unknown-user")
+	}
+
+	// Miner can't take shares above max target, such difficulty is kept by vardiff
+	current, target := cs.difficulty()
+	if maxTarget.Sign() > 0 {
+		if minDiff := util.TargetToDiff(maxTarget); minDiff.IsInt64() && minDiff.Int64() > current {
+			cs.setStaticDifficulty(minDiff.Int64())
+			_, target = cs.difficulty()
+		}
+	}
+	cs.sv2.target = target
+
+	var b sv2Buf
+	b.u32(requestID)
+	b.u32(id)
+	b.u256(new(big.Int).SetBytes(common.FromHex(target)))
+	b.u8(0)
+	b.u32(0)
+	if _, err := c.Write(sv2Encode(0, sv2OpenStandardChannelOk, b)); err != nil {
+		s.removeSession(cs)
+		cs.stopWriter()
+		return nil, err
+	}
+	s.sendJob(cs)
+	return cs, nil
+}
+
+func (s *ProxyServer) handleSV2Submit(c *sv2Conn, channels map[uint32]*Session, f *sv2Frame) error {
+	r := &sv2Reader{data: f.payload}
+	channelID, seq, jobID, nonce, mixDigest := r.u32(), r.u32(), r.u32(), r.u64(), r.bytes32()
+	if r.err != nil {
+		return r.err
+	}
+
+	reject := func(code string) error {
+		var b sv2Buf
+		b.u32(channelID)
+		b.u32(seq)
+		b.str(code)
+		_, err := c.Write(sv2Encode(sv2ChannelBit, sv2SubmitSharesError, b))
+		return err
+	}
+	cs, ok := channels[channelID]
+	if !ok {
+		return reject("invalid-channel-id")
+	}
+	header, ok := cs.sv2.header(jobID)
+	if !ok {
+		return reject("invalid-job-id")
+	}
+
+	params := []string{fmt.Sprintf("0x%016x", nonce), header, "0x" + hex.EncodeToString(mixDigest)}
+	diff, _ := cs.difficulty()
+	valid, errReply := s.handleTCPSubmitRPC(cs, "", params)
+
+	var b sv2Buf
+	b.u32(channelID)
+	b.u32(seq)
+	if !valid {
+		b.str(sv2ErrorCode(errReply))
+		if err := cs.enqueue(sv2Encode(sv2ChannelBit, sv2SubmitSharesError, b), false); err != nil {
+			return err
+		}
+		if errReply != nil && errReply.Code == 23 {
+			return errors.New(errReply.Message)
+		}
+		return nil
+	}
+	b.u32(1)
+	b.u64(uint64(diff))
+	return cs.enqueue(sv2Encode(sv2ChannelBit, sv2SubmitSharesSuccess, b), false)
+}
+
+func sv2ErrorCode(reply *ErrorReply) string {
+	if reply == nil {
+		return "invalid-share"
+	}
+	switch reply.Code {
+	case 22:
+		return "duplicate-share"
+	case 23:
+		return "invalid-share"
+	case 25:
+		return "invalid-channel-id"
+	}
+	return strings.ToLower(strings.ReplaceAll(reply.Message, " ", "-"))
+}
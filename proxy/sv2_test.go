@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Returns server and client ends of established Noise connection
+func sv2Pipe(t *testing.T) (*sv2Conn, *sv2Conn, *ecdh.PrivateKey) {
+	key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	server, client := net.Pipe()
+
+	type result struct {
+		send, recv *cipherState
+		err        error
+	}
+	done := make(chan result)
+	go func() {
+		send, recv, err := noiseAccept(server, key)
+		done <- result{send, recv, err}
+	}()
+	send, recv, rs, err := noiseConnect(client)
+	if err != nil {
+		t.Fatalf("Must complete handshake, got %v", err)
+	}
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("Must accept handshake, got %v", res.err)
+	}
+	if !bytes.Equal(rs, key.PublicKey().Bytes()) {
+		t.Error("Must receive server static key")
+	}
+	return &sv2Conn{Conn: server, send: res.send, recv: res.recv}, &sv2Conn{Conn: client, send: send, recv: recv}, key
+}
+
+func TestSV2Frames(t *testing.T) {
+	server, client, _ := sv2Pipe(t)
+	defer server.Close()
+	defer client.Close()
+
+	var b sv2Buf
+	b.u32(7)
+	b.str("0xabc.rig")
+	go client.Write(sv2Encode(0, sv2OpenStandardChannel, b))
+
+	f, err := server.readFrame()
+	if err != nil {
+		t.Fatalf("Must read frame, got %v", err)
+	}
+	r := &sv2Reader{data: f.payload}
+	if f.msgType != sv2OpenStandardChannel || r.u32() != 7 || r.str() != "0xabc.rig" || r.err != nil {
+		t.Error("Must decode frame sent by client")
+	}
+	if r.u32(); r.err == nil {
+		t.Error("Must fail reading past payload")
+	}
+}
+
+func TestSV2FrameLimit(t *testing.T) {
+	server, client, _ := sv2Pipe(t)
+	defer server.Close()
+	defer client.Close()
+
+	go client.Write(sv2Encode(0, sv2SetupConnection, make([]byte, sv2MaxPayload+1)))
+	if _, err := server.readFrame(); err != errSV2FrameTooLarge {
+		t.Errorf("Must reject oversized frame, got %v", err)
+	}
+}
+
+func TestSV2PushJob(t *testing.T) {
+	server, client, _ := sv2Pipe(t)
+	defer client.Close()
+
+	s := &ProxyServer{sendQueue: 8, writeTimeout: time.Second}
+	cs := &Session{conn: server, sv2: &sv2Channel{id: 3, jobs: make(map[uint32]string)}}
+	s.startWriter(cs)
+
+	header := "0x" + string(bytes.Repeat([]byte("ab"), 32))
+	cs.pushNewJob([]string{header, "0x01", "0x00ff"})
+
+	f, _ := client.readFrame()
+	if f.ext != sv2ChannelBit || f.msgType != sv2SetTarget {
+		t.Fatalf("Must set target before first job, got %#x", f.msgType)
+	}
+	if f.payload[4] != 0xff || f.payload[5] != 0 {
+		t.Error("Must encode target as little endian")
+	}
+	f, _ = client.readFrame()
+	if f.ext != sv2ExtEthash|sv2ChannelBit || f.msgType != sv2EthashNewJob {
+		t.Fatalf("Must push Ethash job, got %#x:%#x", f.ext, f.msgType)
+	}
+	jobID := binary.LittleEndian.Uint32(f.payload[4:])
+	if got, ok := cs.sv2.header(jobID); !ok || got != header {
+		t.Error("Must keep header of pushed job")
+	}
+	if !bytes.Equal(f.payload[8:40], common.FromHex(header)) {
+		t.Error("Must send header hash")
+	}
+	cs.stopWriter()
+	server.Close()
+}
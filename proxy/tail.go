@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Admin tails started through API are picked up within this interval
+const tailCheckInterval = 2 * time.Second
+
+// Stratum message of session under admin live tail
+type TailMessage struct {
+	Timestamp int64  `json:"ts"`
+	Ip        string `json:"ip"`
+	Worker    string `json:"worker"`
+	// "in" for requests of miner, "out" for replies and job pushes
+	Direction string          `json:"dir"`
+	Message   json.RawMessage `json:"message"`
+}
+
+func (s *ProxyServer) tailWatcher() {
+	s.tails.Store(map[string]bool{})
+	ticker := time.NewTicker(tailCheckInterval)
+	for range ticker.C {
+		logins, err := s.backend.GetActiveTails()
+		if err != nil {
+			log.Printf("Failed to get live tails from backend: %v", err)
+			continue
+		}
+		tails := make(map[string]bool, len(logins))
+		for _, login := range logins {
+			tails[login] = true
+		}
+		s.tails.Store(tails)
+	}
+}
+
+// Publishes message if login of session is tailed, synchronously so order is kept
+func (cs *Session) tailMessage(dir string, data []byte) {
+	if cs.tails == nil || len(cs.login) == 0 {
+		return
+	}
+	tails, _ := cs.tails.Load().(map[string]bool)
+	if !tails[cs.login] {
+		return
+	}
+	m := TailMessage{
+		Timestamp: util.MakeTimestamp(), Ip: cs.ip, Worker: cs.worker, Direction: dir,
+		Message: json.RawMessage(data),
+	}
+	payload, err := json.Marshal(&m)
+	if err == nil {
+		err = cs.backend.PublishTail(cs.login, payload)
+	}
+	if err != nil {
+		log.Printf("Failed to publish live tail of %v@%v: %v", cs.login, cs.ip, err)
+	}
+}
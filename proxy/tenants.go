@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"log"
+	"regexp"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+var tenantPattern = regexp.MustCompile("^[0-9a-zA-Z-_]{1,32}$")
+
+func (s *ProxyServer) initTenants() {
+	s.tenants = make(map[string]*storage.RedisClient)
+	s.storages = map[string]storage.Storage{"": s.storage}
+	for _, t := range s.config.Tenants {
+		// Would be shadowed by long polling route
+		if !tenantPattern.MatchString(t.Name) || t.Name == "longpoll" {
+			log.Fatalf("Invalid tenant name %q", t.Name)
+		}
+		if _, ok := s.tenants[t.Name]; ok {
+			log.Fatalf("Duplicate tenant %v", t.Name)
+		}
+		s.tenants[t.Name] = s.backend.Namespace(t.Name)
+		s.storages[t.Name] = s.storage.ForTenant(t.Name)
+		log.Printf("Tenant: %s => %s", t.Name, t.Domain)
+	}
+
+	names := []string{s.config.Proxy.Stratum.WebSocket.Tenant, s.config.Proxy.Stratum.V2.Tenant}
+	for _, port := range s.config.Proxy.Stratum.Ports {
+		names = append(names, port.Tenant)
+	}
+	for _, name := range names {
+		if _, ok := s.tenantBackend(name); !ok {
+			log.Fatalf("Listener refers to unknown tenant %v", name)
+		}
+	}
+}
+
+// Backend of tenant, pool backend for empty name
+func (s *ProxyServer) tenantBackend(name string) (*storage.RedisClient, bool) {
+	if len(name) == 0 {
+		return s.backend, true
+	}
+	backend, ok := s.tenants[name]
+	return backend, ok
+}
+
+func (s *ProxyServer) sessionBackend(cs *Session) *storage.RedisClient {
+	if cs.backend != nil {
+		return cs.backend
+	}
+	return s.backend
+}
+
+// Accounting storage of tenant with given backend, backend itself if there is no other storage
+func (s *ProxyServer) accounting(backend *storage.RedisClient) storage.Storage {
+	if store, ok := s.storages[backend.Tenant()]; ok && store != nil {
+		return store
+	}
+	return backend
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"log"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Share difficulty cap used in testnet mode if networkDiffRatio is not set
+const testnetDiffRatio = 0.1
+
+// Share difficulty is never above fraction of network difficulty,
+// otherwise every share is a block on low difficulty networks
+func (s *ProxyServer) capDifficulty(diff int64) int64 {
+	if s.diffRatio <= 0 {
+		return diff
+	}
+	t := s.currentBlockTemplate()
+	if t == nil || t.Difficulty == nil {
+		return diff
+	}
+	max := int64(float64(t.Difficulty.Int64()) * s.diffRatio)
+	if max < 1 {
+		max = 1
+	}
+	if diff > max {
+		return max
+	}
+	return diff
+}
+
+// DDoS floor first, network cap wins over it
+func (s *ProxyServer) sessionDifficulty(diff int64) int64 {
+	return s.capDifficulty(s.minDifficulty(diff))
+}
+
+// Difficulty and target of HTTP miners
+func (s *ProxyServer) httpDifficulty() (int64, string) {
+	diff := s.capDifficulty(s.config.Proxy.Difficulty)
+	if diff == s.config.Proxy.Difficulty {
+		return diff, s.diff
+	}
+	return diff, util.GetTargetHex(diff)
+}
+
+// Lowers difficulty of stratum sessions after network difficulty dropped,
+// new job is pushed to them by following broadcast
+func (s *ProxyServer) capSessionDifficulty() {
+	total := 0
+	for _, cs := range s.sessions.all() {
+		diff, _ := cs.difficulty()
+		if newDiff := s.capDifficulty(diff); newDiff != diff {
+			cs.setDifficulty(newDiff)
+			total++
+		}
+	}
+	if total > 0 {
+		log.Printf("Capped difficulty of %v stratum sessions to network difficulty", total)
+	}
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCapDifficulty(t *testing.T) {
+	s := &ProxyServer{config: &Config{}, diffRatio: 0.1}
+	s.config.Proxy.Difficulty = 2000000000
+	s.diff = "0x1"
+	if diff := s.capDifficulty(4000); diff != 4000 {
+		t.Errorf("Must not cap difficulty without template, got %v", diff)
+	}
+	s.blockTemplate.Store(&BlockTemplate{Difficulty: big.NewInt(131072)})
+	if diff := s.capDifficulty(2000000000); diff != 13107 {
+		t.Errorf("Must cap difficulty at fraction of network difficulty, got %v", diff)
+	}
+	if diff := s.capDifficulty(4000); diff != 4000 {
+		t.Errorf("Must keep difficulty below cap, got %v", diff)
+	}
+	if diff, target := s.httpDifficulty(); diff != 13107 || target == s.diff {
+		t.Errorf("Must cap difficulty of HTTP miners, got %v %v", diff, target)
+	}
+
+	s.blockTemplate.Store(&BlockTemplate{Difficulty: big.NewInt(5)})
+	if diff := s.capDifficulty(4000); diff != 1 {
+		t.Errorf("Must never cap difficulty below 1, got %v", diff)
+	}
+	s.diffRatio = 0
+	if diff := s.capDifficulty(4000); diff != 4000 {
+		t.Errorf("Must not cap difficulty if disabled, got %v", diff)
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Upstream stats history is kept for an hour
+const upstreamStatsWindow = 3600
+
+// Consecutive results of upstream health checks
+type upstreamHealth struct {
+	fails int
+	oks   int
+	// RPC counters at previous stats sample
+	calls  int64
+	errors int64
+}
+
+func (h *upstreamHealth) record(ok bool) {
+	if ok {
+		h.oks++
+		h.fails = 0
+	} else {
+		h.fails++
+		h.oks = 0
+	}
+}
+
+// Lower priority wins, then order in config
+func preferredUpstream(priorities []int, i, j int) bool {
+	return priorities[i] < priorities[j] || priorities[i] == priorities[j] && i < j
+}
+
+// Current upstream is kept until it fails failAfter checks in a row, then the preferred
+// one which passed last check is taken. Preferred upstream is returned to once it passed
+// failBackAfter checks in a row. Current one is kept if no upstream is alive.
+func selectUpstream(current int, priorities []int, health []upstreamHealth, failAfter, failBackAfter int) int {
+	candidate := current
+	if health[current].fails >= failAfter {
+		candidate = -1
+		for i, h := range health {
+			if h.oks > 0 && (candidate < 0 || preferredUpstream(priorities, i, candidate)) {
+				candidate = i
+			}
+		}
+		if candidate < 0 {
+			return current
+		}
+	}
+	for i, h := range health {
+		if h.oks >= failBackAfter && preferredUpstream(priorities, i, candidate) {
+			candidate = i
+		}
+	}
+	return candidate
+}
+
+// Samples RPC latency, error rate and height lag of every upstream. Heights are only
+// queried from upstreams which passed last check.
+func (s *ProxyServer) writeUpstreamStats(alive []bool) {
+	now := util.MakeTimestamp() / 1000
+	stats := make([]*storage.UpstreamStats, len(s.upstreams))
+	var top uint64
+	for i, v := range s.upstreams {
+		stats[i] = &storage.UpstreamStats{Instance: s.config.Name, Name: v.Name, Timestamp: now}
+		if alive[i] {
+			if block, err := v.GetPendingBlock(); err == nil && block != nil {
+				stats[i].Height, _ = strconv.ParseUint(strings.TrimPrefix(block.Number, "0x"), 16, 64)
+			}
+		}
+		if stats[i].Height > top {
+			top = stats[i].Height
+		}
+		m := v.Metrics()
+		h := &s.upstreamHealth[i]
+		stats[i].Calls, stats[i].Errors = m.Calls, m.Errors
+		stats[i].P50, stats[i].P90, stats[i].P99 = m.P50, m.P90, m.P99
+		if calls := m.Calls - h.calls; calls > 0 {
+			stats[i].ErrorRate = float64(m.Errors-h.errors) / float64(calls)
+		}
+		h.calls, h.errors = m.Calls, m.Errors
+	}
+	for _, v := range stats {
+		if v.Height > 0 {
+			v.Lag = top - v.Height
+		}
+	}
+	if err := s.backend.WriteUpstreamStats(stats, upstreamStatsWindow); err != nil {
+		log.Printf("Failed to write upstream stats to backend: %v", err)
+	}
+}
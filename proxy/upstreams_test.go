@@ -0,0 +1,47 @@
+package proxy
+
+import "testing"
+
+func TestSelectUpstream(t *testing.T) {
+	// Local node first, paid fallback, then backup of lower priority listed before it
+	priorities := []int{0, 1, 2}
+	health := make([]upstreamHealth, 3)
+	check := func(results ...bool) {
+		for i, ok := range results {
+			health[i].record(ok)
+		}
+	}
+
+	check(false, true, true)
+	if i := selectUpstream(0, priorities, health, 2, 3); i != 0 {
+		t.Errorf("Must stick to current upstream before it fails twice, got %v", i)
+	}
+	check(false, true, true)
+	if i := selectUpstream(0, priorities, health, 2, 3); i != 1 {
+		t.Errorf("Must fail over to preferred alive upstream, got %v", i)
+	}
+	check(true, true, true)
+	check(true, true, true)
+	if i := selectUpstream(1, priorities, health, 2, 3); i != 1 {
+		t.Errorf("Must not fail back before enough successful checks, got %v", i)
+	}
+	check(true, true, true)
+	if i := selectUpstream(1, priorities, health, 2, 3); i != 0 {
+		t.Errorf("Must fail back to preferred upstream, got %v", i)
+	}
+	check(false, false, false)
+	check(false, false, false)
+	if i := selectUpstream(0, priorities, health, 2, 3); i != 0 {
+		t.Errorf("Must keep current upstream if none is alive, got %v", i)
+	}
+}
+
+func TestSelectUpstreamPriority(t *testing.T) {
+	health := []upstreamHealth{{fails: 1}, {oks: 1}, {oks: 1}}
+	if i := selectUpstream(0, []int{0, 5, 1}, health, 1, 1); i != 2 {
+		t.Errorf("Must prefer lower priority over order, got %v", i)
+	}
+	if i := selectUpstream(0, []int{0, 0, 0}, health, 1, 1); i != 1 {
+		t.Errorf("Must take first of equal priority, got %v", i)
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/etclabscore/open-etc-pool/rpc"
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Checks whole config before anything is started, reports all problems at once
+func (c *Config) Validate() error {
+	var errs util.ConfigErrors
+
+	if c.Proxy.Enabled {
+		c.validateProxy(&errs)
+	}
+	if len(c.Redis.KeyPrefix(c.Coin)) == 0 {
+		errs.Addf("coin: required as key prefix unless redis.prefix is set")
+	}
+	c.Redis.Validate(&errs)
+	c.Storage.Validate(&errs)
+	c.Rewards.Validate(&errs)
+	c.Retention.Validate(&errs)
+	c.Api.Validate(&errs)
+	c.BlockUnlocker.Validate(&errs)
+	c.Payouts.Validate(&errs)
+	c.StatusPage.Validate(&errs)
+	c.Metrics.Validate(&errs)
+	c.EventBus.Validate(&errs)
+	c.Bookkeeping.Validate(&errs)
+	c.Webhooks.Validate(&errs)
+	return errs.Err()
+}
+
+func (c *Config) validateProxy(errs *util.ConfigErrors) {
+	p := &c.Proxy
+	if len(c.Name) == 0 {
+		errs.Addf("name: instance name is required")
+	}
+	errs.Duration("upstreamCheckInterval", c.UpstreamCheckInterval, false)
+	for i, u := range c.Upstream {
+		errs.Duration(fmt.Sprintf("upstream[%d].timeout", i), u.Timeout, false)
+		if !strings.HasPrefix(u.Url, "http://") && !strings.HasPrefix(u.Url, "https://") && !strings.HasPrefix(u.Url, "ipc://") {
+			errs.Addf("upstream[%d].url: must be http://, https:// or ipc:// URL", i)
+		}
+		if !rpc.ValidFlavor(u.Flavor) {
+			errs.Addf("upstream[%d].flavor: must be geth, besu or nethermind, got %q", i, u.Flavor)
+		}
+	}
+	c.validateForks(errs)
+	if _, err := newEpochCaches(c); err != nil {
+		errs.Addf("network: %v", err)
+	}
+	if len(c.Upstream) == 0 {
+		errs.Addf("upstream: at least one upstream is required")
+	}
+
+	errs.Duration("proxy.blockRefreshInterval", p.BlockRefreshInterval, false)
+	errs.Duration("proxy.wsRefreshInterval", p.WsRefreshInterval, true)
+	errs.Duration("proxy.stateUpdateInterval", p.StateUpdateInterval, false)
+	errs.Duration("proxy.hashrateExpiration", p.HashrateExpiration, false)
+	errs.Duration("proxy.drainTimeout", p.DrainTimeout, true)
+	errs.Duration("proxy.idempotencyWindow", p.IdempotencyWindow, true)
+	errs.Duration("proxy.ddos.checkInterval", p.DDoS.CheckInterval, true)
+	if p.LongPoll.Enabled {
+		errs.Duration("proxy.longPoll.timeout", p.LongPoll.Timeout, true)
+	}
+	p.Policy.Validate(errs)
+	p.ClickHouse.Validate(errs)
+
+	if p.NetworkDiffRatio < 0 || p.NetworkDiffRatio >= 1 {
+		errs.Addf("proxy.networkDiffRatio: must be in [0, 1) range")
+	}
+	if p.LimitBodySize <= 0 {
+		errs.Addf("proxy.limitBodySize: must be positive")
+	}
+	// Backend keeps PoW of 8 recent heights for duplicate check
+	if p.JobBacklog > 8 {
+		errs.Addf("proxy.jobBacklog: can't be more than 8 heights")
+	}
+	if len(p.ExtraData) > maxExtraData {
+		errs.Addf("proxy.extraData: can't be longer than %v bytes", maxExtraData)
+	}
+	if p.VarDiff.Enabled {
+		v := p.VarDiff
+		if v.MinDiff <= 0 || v.MinDiff > v.MaxDiff {
+			errs.Addf("proxy.varDiff: requires 0 < minDiff <= maxDiff, got %v and %v", v.MinDiff, v.MaxDiff)
+		}
+		if v.SharesPerMinute <= 0 {
+			errs.Addf("proxy.varDiff.sharesPerMinute: must be positive")
+		}
+		errs.Duration("proxy.varDiff.retargetInterval", v.RetargetInterval, false)
+	}
+	if p.BlockRetry.Enabled {
+		errs.Duration("proxy.blockRetry.window", p.BlockRetry.Window, false)
+		errs.Duration("proxy.blockRetry.interval", p.BlockRetry.Interval, false)
+	}
+	if p.StaleWindow.Enabled {
+		errs.Duration("proxy.staleWindow.window", p.StaleWindow.Window, false)
+		errs.Duration("proxy.staleWindow.max", p.StaleWindow.Max, false)
+		if p.StaleWindow.RTTFactor < 0 {
+			errs.Addf("proxy.staleWindow.rttFactor: can't be negative")
+		}
+	}
+	if p.ShareSampling.Enabled {
+		errs.Duration("proxy.shareSampling.interval", p.ShareSampling.Interval, true)
+	}
+	errs.Duration("proxy.verifier.backoff", p.Verifier.Backoff, true)
+	if p.Verifier.BusyDiffFactor != 0 && p.Verifier.BusyDiffFactor < 1 {
+		errs.Addf("proxy.verifier.busyDiffFactor: can't be less than 1")
+	}
+	switch p.Worker.Fallback {
+	case "", workerZero, workerReject, workerStrip, workerHash:
+	default:
+		errs.Addf("proxy.worker.fallback: must be zero, reject, strip or hash, got %q", p.Worker.Fallback)
+	}
+	if _, err := charsetClass(p.Worker.Charset); err != nil {
+		errs.Addf("proxy.worker.charset: %v", err)
+	}
+	if p.StaticDiff.Enabled && p.StaticDiff.MinDiff <= 0 {
+		errs.Addf("proxy.staticDiff.minDiff: must be positive")
+	}
+
+	if p.Stratum.Enabled {
+		errs.Duration("proxy.stratum.timeout", p.Stratum.Timeout, false)
+		errs.Duration("proxy.stratum.writeTimeout", p.Stratum.WriteTimeout, true)
+		if p.Stratum.PortHint.Enabled {
+			errs.Duration("proxy.stratum.portHint.window", p.Stratum.PortHint.Window, true)
+		}
+		validateQuirks(p.Stratum.Quirks, errs)
+		if p.Stratum.HighLatency.Enabled {
+			errs.Duration("proxy.stratum.highLatency.threshold", p.Stratum.HighLatency.Threshold, false)
+			errs.Duration("proxy.stratum.highLatency.flushDelay", p.Stratum.HighLatency.FlushDelay, false)
+		}
+	}
+}
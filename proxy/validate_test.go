@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidateExampleConfig(t *testing.T) {
+	data, err := os.ReadFile("../config.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Must parse example config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Example config must be valid, got %v", err)
+	}
+
+	cfg.Proxy.BlockRefreshInterval = "soon"
+	cfg.Proxy.Stratum.Timeout = ""
+	cfg.Api.HashrateWindow = "1x"
+	err = cfg.Validate()
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("Must report all errors at once, got %v", err)
+	}
+}
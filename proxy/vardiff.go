@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Never change difficulty by more than this factor in a single retarget
+const maxRetargetFactor = 4.0
+
+func (cs *Session) difficulty() (int64, string) {
+	cs.diffMu.RLock()
+	defer cs.diffMu.RUnlock()
+	return cs.diff, cs.target
+}
+
+func (cs *Session) setDifficulty(diff int64) {
+	target := util.GetTargetHex(diff)
+	cs.diffMu.Lock()
+	cs.diff = diff
+	cs.target = target
+	cs.diffMu.Unlock()
+}
+
+// Fixes session difficulty, vardiff and port hints leave it alone
+func (cs *Session) setStaticDifficulty(diff int64) {
+	target := util.GetTargetHex(diff)
+	cs.diffMu.Lock()
+	cs.diff = diff
+	cs.target = target
+	cs.staticDiff = true
+	cs.diffMu.Unlock()
+}
+
+func (cs *Session) hasStaticDifficulty() bool {
+	cs.diffMu.RLock()
+	defer cs.diffMu.RUnlock()
+	return cs.staticDiff
+}
+
+func (cs *Session) countShare(diff int64) {
+	atomic.AddInt64(&cs.shares, 1)
+	atomic.AddInt64(&cs.creditedDiff, diff)
+	if cs.sampler != nil {
+		cs.sampler.add(time.Now(), diff)
+	}
+}
+
+func (s *ProxyServer) vardiffRetargeter() {
+	intv := util.MustParseDuration(s.config.Proxy.VarDiff.RetargetInterval)
+	log.Printf("Set vardiff retarget every %v, aiming at %v shares per minute", intv, s.config.Proxy.VarDiff.SharesPerMinute)
+
+	ticker := time.NewTicker(intv)
+	for range ticker.C {
+		now := time.Now()
+		for _, cs := range s.sessions.all() {
+			if len(cs.login) > 0 && !cs.hasStaticDifficulty() {
+				s.retarget(cs, now)
+			}
+		}
+	}
+}
+
+func (s *ProxyServer) retarget(cs *Session, now time.Time) {
+	elapsed := now.Sub(cs.lastRetarget)
+	shares := atomic.SwapInt64(&cs.shares, 0)
+	cs.lastRetarget = now
+
+	diff, _ := cs.difficulty()
+	newDiff := nextDifficulty(diff, shares, elapsed, &s.config.Proxy.VarDiff)
+	newDiff = s.sessionDifficulty(newDiff)
+	if newDiff == diff {
+		return
+	}
+	cs.setDifficulty(newDiff)
+	log.Printf("Vardiff retarget for %v@%v: %v -> %v", cs.login, cs.ip, diff, newDiff)
+	s.sendJob(cs)
+}
+
+// Pushes current job with session target
+func (s *ProxyServer) sendJob(cs *Session) {
+	t := s.currentBlockTemplate()
+	if t == nil || len(t.Header) == 0 || s.isSick() {
+		return
+	}
+	diff, target := cs.difficulty()
+	if !cs.markJob(t.Header, diff, target) {
+		return
+	}
+	reply := []string{t.Header, t.Seed, target}
+	if err := cs.pushNewJob(reply); err != nil {
+		log.Printf("Job transmit error to %v@%v: %v", cs.login, cs.ip, err)
+		s.removeSession(cs)
+		cs.conn.Close()
+	}
+}
+
+// Computes difficulty which brings observed share rate to configured goal.
+// Returns current difficulty if the change is within allowed variance.
+func nextDifficulty(diff, shares int64, elapsed time.Duration, cfg *VarDiff) int64 {
+	if elapsed <= 0 {
+		return diff
+	}
+	rate := float64(shares) / elapsed.Minutes()
+	factor := rate / cfg.SharesPerMinute
+	factor = math.Max(factor, 1/maxRetargetFactor)
+	factor = math.Min(factor, maxRetargetFactor)
+
+	if math.Abs(factor-1)*100 < cfg.VariancePercent {
+		return diff
+	}
+	return clampDifficulty(int64(float64(diff)*factor), cfg.MinDiff, cfg.MaxDiff)
+}
+
+func clampDifficulty(diff, minDiff, maxDiff int64) int64 {
+	if diff < minDiff {
+		return minDiff
+	}
+	if diff > maxDiff {
+		return maxDiff
+	}
+	return diff
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/storage"
+)
+
+var testVarDiff = VarDiff{
+	MinDiff:         1000,
+	MaxDiff:         1000000,
+	SharesPerMinute: 4,
+	VariancePercent: 30,
+}
+
+func TestNextDifficulty(t *testing.T) {
+	// 8 shares/min is twice the goal
+	if d := nextDifficulty(10000, 16, 2*time.Minute, &testVarDiff); d != 20000 {
+		t.Errorf("Must double difficulty, got %v", d)
+	}
+	// 1 share/min is a quarter of the goal
+	if d := nextDifficulty(10000, 2, 2*time.Minute, &testVarDiff); d != 2500 {
+		t.Errorf("Must reduce difficulty 4 times, got %v", d)
+	}
+	// Within variance
+	if d := nextDifficulty(10000, 9, 2*time.Minute, &testVarDiff); d != 10000 {
+		t.Errorf("Must keep difficulty within variance, got %v", d)
+	}
+}
+
+func TestNextDifficultyLimits(t *testing.T) {
+	if d := nextDifficulty(10000, 1000, time.Minute, &testVarDiff); d != 40000 {
+		t.Errorf("Must limit single retarget step, got %v", d)
+	}
+	if d := nextDifficulty(10000, 0, time.Minute, &testVarDiff); d != 2500 {
+		t.Errorf("Must lower difficulty without shares, got %v", d)
+	}
+	if d := nextDifficulty(1200, 0, time.Minute, &testVarDiff); d != 1000 {
+		t.Errorf("Must clamp to min difficulty, got %v", d)
+	}
+	if d := nextDifficulty(900000, 1000, time.Minute, &testVarDiff); d != 1000000 {
+		t.Errorf("Must clamp to max difficulty, got %v", d)
+	}
+	if d := nextDifficulty(10000, 10, 0, &testVarDiff); d != 10000 {
+		t.Errorf("Must not retarget without elapsed time, got %v", d)
+	}
+}
+
+func TestRetargetKeepsJobDifficulty(t *testing.T) {
+	s := &ProxyServer{config: &Config{}, sendQueue: 8}
+	s.config.Proxy.VarDiff = testVarDiff
+	s.blockTemplate.Store(&BlockTemplate{Header: "0x1", Seed: "0x2"})
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, out: make(chan outMessage, 8)}
+	cs.setDifficulty(1000)
+	s.sendJob(cs)
+
+	now := time.Now()
+	cs.shares, cs.lastRetarget = 100, now.Add(-time.Minute)
+	s.retarget(cs, now)
+	if diff, _ := cs.difficulty(); diff != 4000 || len(cs.out) != 2 {
+		t.Fatalf("Must raise difficulty and push job with new target, got %v and %v pushes", diff, len(cs.out))
+	}
+	// Share for old target mined before miner got new job is still valid
+	if diff := cs.jobDifficulty("0x1"); diff != 1000 {
+		t.Errorf("Must check share of job sent before retarget against its difficulty, got %v", diff)
+	}
+	if diff := cs.jobDifficulty("0x9"); diff != 4000 {
+		t.Errorf("Must use session difficulty for unknown job, got %v", diff)
+	}
+	for i := 2; i <= sessionJobBacklog+1; i++ {
+		cs.markJob(fmt.Sprintf("0x%x", i), 4000, "0x3")
+	}
+	if diff := cs.jobDifficulty("0x1"); diff != 4000 || len(cs.jobDiffs) != sessionJobBacklog {
+		t.Errorf("Must forget old jobs, got %v for %v jobs", diff, len(cs.jobDiffs))
+	}
+}
+
+func TestShareSampler(t *testing.T) {
+	p := newShareSampler()
+	now := time.Now()
+	for i := 0; i <= minSampleShares; i++ {
+		p.add(now.Add(time.Duration(i)*10*time.Second), 1000)
+	}
+	// Interval across retarget is skipped
+	p.add(now.Add(time.Hour), 2000)
+	sample := p.flush(1)
+	if sample == nil || sample.Shares != minSampleShares || math.Abs(sample.Hashrate-100) > 1e-6 || sample.CV > 1e-6 || sample.Diff != 2000 {
+		t.Fatalf("Must compute hashrate from intervals per difficulty, got %+v", sample)
+	}
+	if p.flush(2) != nil {
+		t.Error("Must reset counters after flush")
+	}
+}
+
+func TestAdviseVarDiff(t *testing.T) {
+	if AdviseVarDiff(nil, VarDiff{}) != nil {
+		t.Error("Must not advise without samples")
+	}
+	var samples []*storage.ShareSample
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, &storage.ShareSample{Hashrate: float64(i) * 1e6, CV: 1})
+	}
+	a := AdviseVarDiff(samples, VarDiff{SharesPerMinute: 4, VariancePercent: 20})
+	if a.WindowShares != 25 || a.RetargetInterval != 375*time.Second {
+		t.Errorf("Must size window to keep noise below variance, got %v shares in %v", a.WindowShares, a.RetargetInterval)
+	}
+	if a.MinDiff != 150e6 || a.MaxDiff != 99e6*15*4 {
+		t.Errorf("Must derive difficulty range from hashrates, got %v - %v", a.MinDiff, a.MaxDiff)
+	}
+}
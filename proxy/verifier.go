@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultQueuePerWorker = 64
+	defaultBusyBackoff    = time.Second
+	defaultBusyDiffFactor = 2
+	// How long difficulty of session stays raised after its share was dropped
+	busyDiffPeriod = time.Minute
+	// EIP-1474 limit exceeded, miners may retry after backoff
+	codeBusy = -32005
+)
+
+type shareJob struct {
+	cs     *Session
+	id     string
+	t      *BlockTemplate
+	params []string
+	// When share was handed to verification, for block race reports
+	received time.Time
+	done     chan shareResult
+}
+
+type shareResult struct {
+	exist bool
+	valid bool
+}
+
+// Fixed number of goroutines verifying shares, so bursts from big farms queue up
+// instead of spawning unbounded work on connection goroutines
+type verifyPool struct {
+	jobs       chan *shareJob
+	workers    int
+	rejected   int64
+	backoff    time.Duration
+	diffFactor float64
+}
+
+func (s *ProxyServer) startVerifyPool() {
+	cfg := s.config.Proxy.Verifier
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	queue := cfg.Queue
+	if queue <= 0 {
+		queue = workers * defaultQueuePerWorker
+	}
+	s.verifier = &verifyPool{jobs: make(chan *shareJob, queue), workers: workers, backoff: defaultBusyBackoff, diffFactor: cfg.BusyDiffFactor}
+	if len(cfg.Backoff) > 0 {
+		s.verifier.backoff = util.MustParseDuration(cfg.Backoff)
+	}
+	if s.verifier.diffFactor <= 0 {
+		s.verifier.diffFactor = defaultBusyDiffFactor
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range s.verifier.jobs {
+				exist, valid := s.processShare(job.cs, job.id, job.t, job.params, job.received)
+				job.done <- shareResult{exist: exist, valid: valid}
+			}
+		}()
+	}
+	log.Printf("Verifying shares with %v workers, queue of %v", workers, queue)
+}
+
+// Waits for share verification, queued is false if pool is saturated and share was dropped
+func (s *ProxyServer) verifyShare(cs *Session, id string, t *BlockTemplate, params []string) (exist, valid, queued bool) {
+	received := time.Now()
+	if s.verifier == nil {
+		exist, valid = s.processShare(cs, id, t, params, received)
+		return exist, valid, true
+	}
+	job := &shareJob{cs: cs, id: id, t: t, params: params, received: received, done: make(chan shareResult, 1)}
+	select {
+	case s.verifier.jobs <- job:
+	default:
+		atomic.AddInt64(&s.verifier.rejected, 1)
+		return false, false, false
+	}
+	result := <-job.done
+	return result.exist, result.valid, true
+}
+
+// Queue depth, capacity and number of shares rejected since start
+func (p *verifyPool) stats() (int, int, int64) {
+	return len(p.jobs), cap(p.jobs), atomic.LoadInt64(&p.rejected)
+}
+
+// Retry-able error with suggested backoff in ms
+func (s *ProxyServer) busyReply(cs *Session) *ErrorReply {
+	reply := s.errorReply(cs, codeBusy, msgBusy)
+	reply.Data = map[string]interface{}{"retry": true, "backoff": int64(s.verifier.backoff / time.Millisecond)}
+	return reply
+}
+
+// Raises difficulty of stratum session whose share was dropped, so it submits less
+// while queue drains. Raised difficulty is kept for busyDiffPeriod since last drop.
+func (s *ProxyServer) throttleSession(cs *Session, now time.Time) {
+	if cs.conn == nil || s.verifier.diffFactor <= 1 {
+		return
+	}
+	cs.diffMu.Lock()
+	raised := cs.busyDiff > 0
+	if !raised {
+		cs.busyDiff = cs.diff
+	}
+	cs.busyUntil = now.Add(busyDiffPeriod)
+	diff := cs.busyDiff
+	cs.diffMu.Unlock()
+	if raised {
+		return
+	}
+	newDiff := s.sessionDifficulty(int64(float64(diff) * s.verifier.diffFactor))
+	if newDiff > diff {
+		cs.setDifficulty(newDiff)
+		log.Printf("Share queue is full, raised difficulty of %v@%v: %v -> %v", cs.login, cs.ip, diff, newDiff)
+		s.sendJob(cs)
+	}
+}
+
+// Restores difficulty once busy period is over, unless vardiff changed it meanwhile
+func (s *ProxyServer) restoreDifficulty(cs *Session, now time.Time) {
+	cs.diffMu.Lock()
+	if cs.busyDiff == 0 || now.Before(cs.busyUntil) {
+		cs.diffMu.Unlock()
+		return
+	}
+	diff, current := cs.busyDiff, cs.diff
+	cs.busyDiff = 0
+	cs.diffMu.Unlock()
+
+	newDiff := s.sessionDifficulty(int64(float64(diff) * s.verifier.diffFactor))
+	if current != newDiff {
+		return
+	}
+	if diff = s.sessionDifficulty(diff); diff != current {
+		cs.setDifficulty(diff)
+		s.sendJob(cs)
+	}
+}
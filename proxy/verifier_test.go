@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyPool(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.config.Proxy.Verifier = Verifier{Workers: 2, Queue: 4}
+	s.startVerifyPool()
+
+	tpl := &BlockTemplate{headers: make(map[string]heightDiffPair)}
+	exist, valid, queued := s.verifyShare(&Session{}, "0", tpl, []string{"0x0", "0x1", "0x2"})
+	if exist || valid || !queued {
+		t.Errorf("Must verify share on worker, got %v %v %v", exist, valid, queued)
+	}
+	if depth, capacity, _ := s.verifier.stats(); depth != 0 || capacity != 4 {
+		t.Errorf("Must report queue depth and capacity, got %v/%v", depth, capacity)
+	}
+}
+
+func TestVerifyPoolSaturated(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	// No workers, queue is never drained
+	s.verifier = &verifyPool{jobs: make(chan *shareJob, 1)}
+	s.verifier.jobs <- &shareJob{}
+
+	if _, _, queued := s.verifyShare(&Session{}, "0", nil, nil); queued {
+		t.Error("Must drop share when queue is full")
+	}
+	if depth, _, rejected := s.verifier.stats(); depth != 1 || rejected != 1 {
+		t.Errorf("Must count rejected shares, got %v %v", depth, rejected)
+	}
+}
+
+func TestBusyBackpressure(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.verifier = &verifyPool{jobs: make(chan *shareJob, 1), backoff: 500 * time.Millisecond, diffFactor: 2}
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	cs := &Session{conn: conn}
+	cs.setDifficulty(1000)
+
+	reply := s.busyReply(cs)
+	data, _ := reply.Data.(map[string]interface{})
+	if reply.Code != codeBusy || data["retry"] != true || data["backoff"] != int64(500) {
+		t.Errorf("Must reply with retry-able error and backoff, got %+v", reply)
+	}
+
+	now := time.Now()
+	s.throttleSession(cs, now)
+	s.throttleSession(cs, now.Add(time.Second))
+	if diff, _ := cs.difficulty(); diff != 2000 {
+		t.Errorf("Must raise difficulty once while queue is full, got %v", diff)
+	}
+	s.restoreDifficulty(cs, now.Add(busyDiffPeriod))
+	if diff, _ := cs.difficulty(); diff != 2000 {
+		t.Errorf("Must keep difficulty raised since last drop, got %v", diff)
+	}
+	s.restoreDifficulty(cs, now.Add(time.Second+busyDiffPeriod))
+	if diff, _ := cs.difficulty(); diff != 1000 {
+		t.Errorf("Must restore difficulty after busy period, got %v", diff)
+	}
+
+	s.throttleSession(cs, now)
+	cs.setDifficulty(3000)
+	s.restoreDifficulty(cs, now.Add(2*busyDiffPeriod))
+	if diff, _ := cs.difficulty(); diff != 3000 {
+		t.Errorf("Must keep difficulty changed by vardiff, got %v", diff)
+	}
+}
+
+func TestThrottledSessionSurvives(t *testing.T) {
+	s := &ProxyServer{config: &Config{}}
+	s.blockTemplate.Store(&BlockTemplate{Header: "0x1", Seed: "0x2", headers: make(map[string]heightDiffPair)})
+	// No workers, queue is never drained
+	s.verifier = &verifyPool{jobs: make(chan *shareJob, 1), backoff: time.Second, diffFactor: 2}
+	s.verifier.jobs <- &shareJob{}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, login: "0xa", out: make(chan outMessage, 4)}
+	cs.setDifficulty(1000)
+	s.sessions.add(cs)
+
+	params, _ := json.Marshal([]string{"0x0000000000000001", "0x" + strings.Repeat("1", 64), "0x" + strings.Repeat("2", 64)})
+	req := &StratumReq{JSONRpcReq: JSONRpcReq{Id: json.RawMessage("7"), Method: "eth_submitWork", Params: params}}
+	if err := cs.handleTCPMessage(s, req); err != nil {
+		t.Fatalf("Must keep session open when share is dropped, got %v", err)
+	}
+	// Throttled session gets new job first
+	var reply string
+	for len(cs.out) > 0 {
+		reply = string((<-cs.out).data)
+	}
+	if !strings.Contains(reply, `"id":7`) || !strings.Contains(reply, `"code":-32005`) {
+		t.Errorf("Must reply busy to submission, got %s", reply)
+	}
+	if diff, _ := cs.difficulty(); diff != 2000 {
+		t.Errorf("Must throttle session, got difficulty %v", diff)
+	}
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Adapts WebSocket to stratum line protocol: every incoming message is read
+// as a single line and every line written is sent as a separate text message.
+type wsConn struct {
+	*websocket.Conn
+	reader  io.Reader
+	pending []byte
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, r, err := c.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return 0, io.EOF
+			} else if err != nil {
+				return 0, err
+			}
+			c.reader = io.MultiReader(r, strings.NewReader("\n"))
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.pending = append(c.pending, p...)
+	for {
+		i := bytes.IndexByte(c.pending, '\n')
+		if i < 0 {
+			break
+		}
+		if err := c.WriteMessage(websocket.TextMessage, c.pending[:i]); err != nil {
+			return 0, err
+		}
+		c.pending = c.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// Underlying TCP connection, used for RTT measurement
+func (c *wsConn) tcpConn() *net.TCPConn {
+	conn := c.UnderlyingConn()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcp, _ := conn.(*net.TCPConn)
+	return tcp
+}
+
+func (s *ProxyServer) ListenWebSocket() {
+	cfg := s.config.Proxy.Stratum.WebSocket
+	path := cfg.Path
+	if len(path) == 0 {
+		path = "/"
+	}
+	diff := cfg.Difficulty
+	if diff <= 0 {
+		diff = s.config.Proxy.Difficulty
+	}
+	target := util.GetTargetHex(diff)
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    MaxReqSize,
+		EnableCompression: true,
+		CheckOrigin:       s.checkWebSocketOrigin,
+	}
+	acceptSem := make(chan struct{}, cfg.MaxConn)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case acceptSem <- struct{}{}:
+			defer func() { <-acceptSem }()
+		default:
+			http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+			return
+		}
+
+		ip := s.remoteAddr(r)
+		if s.policy.IsBanned(ip) || !s.policy.ApplyLimitPolicy(ip) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("WebSocket upgrade error from %s: %v", ip, err)
+			return
+		}
+		ws.SetReadLimit(MaxReqSize)
+		// Compression is only enabled for high latency sessions
+		ws.EnableWriteCompression(false)
+		s.handleWebSocketConn(&wsConn{Conn: ws}, ip, diff, target)
+	})
+
+	network := s.config.Proxy.Stratum.ListenNetwork
+	if len(network) == 0 {
+		network = "tcp4"
+	}
+	ln, err := net.Listen(network, cfg.Listen)
+	if err != nil {
+		log.Fatalf("Error listening: %v", err)
+	}
+	log.Printf("Stratum WebSocket listening on %s%s with difficulty %v", cfg.Listen, path, diff)
+
+	srv := &http.Server{Handler: mux}
+	s.trackServer(srv)
+	if len(cfg.CertFile) > 0 {
+		err = srv.ServeTLS(ln, cfg.CertFile, cfg.KeyFile)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		return
+	}
+	log.Fatalf("Stratum WebSocket server failure: %v", err)
+}
+
+func (s *ProxyServer) checkWebSocketOrigin(r *http.Request) bool {
+	origins := s.config.Proxy.Stratum.WebSocket.AllowedOrigins
+	if len(origins) == 0 {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	for _, o := range origins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ProxyServer) handleWebSocketConn(conn *wsConn, ip string, diff int64, target string) {
+	backend, _ := s.tenantBackend(s.config.Proxy.Stratum.WebSocket.Tenant)
+	cs := &Session{
+		conn:         conn,
+		ip:           ip,
+		backend:      backend,
+		lastActivity: time.Now(),
+		pingTimeout:  DefaultPingTimeout,
+		diff:         diff,
+		target:       target,
+		lastRetarget: time.Now(),
+	}
+	s.startWriter(cs)
+
+	s.handleTCPClient(cs, bufio.NewReaderSize(conn, MaxReqSize))
+	s.removeSession(cs)
+	cs.stopWriter()
+	conn.Close()
+}
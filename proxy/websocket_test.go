@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketConnLines(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := &wsConn{Conn: ws}
+		reader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			line, _, err := reader.ReadLine()
+			if err != nil {
+				return
+			}
+			// Split line across writes, must be sent as one message
+			conn.Write(line[:2])
+			conn.Write(append(line[2:], '\n'))
+		}
+		ws.Close()
+	}))
+	defer srv.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, msg := range []string{`{"id":1}`, `{"id":2}`} {
+		client.WriteMessage(websocket.TextMessage, []byte(msg))
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != msg {
+			t.Errorf("Must echo %s as single message, got %s", msg, data)
+		}
+	}
+}
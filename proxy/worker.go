@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Fallbacks for worker names not matching rules
+const (
+	workerZero   = "zero"
+	workerReject = "reject"
+	workerStrip  = "strip"
+	workerHash   = "hash"
+)
+
+type workerNames struct {
+	pattern   *regexp.Regexp
+	invalid   *regexp.Regexp
+	maxLength int
+	fallback  string
+}
+
+func newWorkerNames(cfg *WorkerRules) *workerNames {
+	w := &workerNames{maxLength: cfg.MaxLength, fallback: cfg.Fallback}
+	if w.maxLength <= 0 {
+		w.maxLength = 8
+	}
+	charset := cfg.Charset
+	if len(charset) == 0 {
+		charset = "0-9a-zA-Z-_"
+	}
+	class, err := charsetClass(charset)
+	if err != nil {
+		log.Fatalf("Invalid worker charset %q: %v", charset, err)
+	}
+	w.pattern = regexp.MustCompile(fmt.Sprintf("^[%s]{1,%d}$", class, w.maxLength))
+	w.invalid = regexp.MustCompile(fmt.Sprintf("[^%s]", class))
+	if len(w.fallback) == 0 {
+		w.fallback = workerZero
+		// Former option only rejected shares
+		if cfg.Reject {
+			w.fallback = workerReject
+		}
+	}
+	return w
+}
+
+// Builds regexp character class body from allowed chars and "a-z" like ranges,
+// every char is escaped so "]", "^" or "\\" in charset are taken literally
+func charsetClass(charset string) (string, error) {
+	chars := []rune(charset)
+	var class strings.Builder
+	for i := 0; i < len(chars); i++ {
+		if i+2 < len(chars) && chars[i+1] == '-' {
+			if chars[i] > chars[i+2] {
+				return "", fmt.Errorf("invalid range %c-%c", chars[i], chars[i+2])
+			}
+			class.WriteString(escapeClassChar(chars[i]) + "-" + escapeClassChar(chars[i+2]))
+			i += 2
+			continue
+		}
+		class.WriteString(escapeClassChar(chars[i]))
+	}
+	return class.String(), nil
+}
+
+func escapeClassChar(c rune) string {
+	if c < utf8.RuneSelf && (unicode.IsPunct(c) || unicode.IsSymbol(c)) {
+		return `\` + string(c)
+	}
+	return string(c)
+}
+
+// Returns name shares are credited to, false if name must be rejected.
+// Missing name is the default worker "0".
+func (w *workerNames) normalize(name string) (string, bool) {
+	if len(name) == 0 {
+		return "0", true
+	}
+	if w.pattern.MatchString(name) {
+		return name, true
+	}
+	result := "0"
+	switch w.fallback {
+	case workerReject:
+		return "", false
+	case workerStrip:
+		runes := []rune(w.invalid.ReplaceAllString(name, ""))
+		if len(runes) > w.maxLength {
+			runes = runes[:w.maxLength]
+		}
+		result = string(runes)
+	case workerHash:
+		// Misnamed rigs stay apart with stable names
+		sum := sha256.Sum256([]byte(name))
+		result = hex.EncodeToString(sum[:])
+		if len(result) > w.maxLength {
+			result = result[:w.maxLength]
+		}
+	}
+	if !w.pattern.MatchString(result) {
+		return "0", true
+	}
+	return result, true
+}
+
+// Normalizes worker name of session, original of invalid name is logged once per session
+func (s *ProxyServer) workerName(cs *Session, login, name string) (string, bool) {
+	result, ok := s.workerNames.normalize(name)
+	if len(name) > 0 && result != name && atomic.CompareAndSwapInt32(&cs.workerLogged, 0, 1) {
+		if ok {
+			log.Printf("Invalid worker name %q of %v@%v, using %q", name, login, cs.ip, result)
+		} else {
+			log.Printf("Invalid worker name %q of %v@%v, rejecting", name, login, cs.ip)
+		}
+	}
+	return result, ok
+}
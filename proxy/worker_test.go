@@ -0,0 +1,52 @@
+package proxy
+
+import "testing"
+
+func TestWorkerFallback(t *testing.T) {
+	names := newWorkerNames(&WorkerRules{})
+	if name, ok := names.normalize("rig-1"); !ok || name != "rig-1" {
+		t.Errorf("Must keep valid name, got %v", name)
+	}
+	if name, ok := names.normalize(""); !ok || name != "0" {
+		t.Errorf("Must use default worker for missing name, got %v", name)
+	}
+	if name, ok := names.normalize("rig 1"); !ok || name != "0" {
+		t.Errorf("Must rename invalid name to 0 by default, got %v", name)
+	}
+
+	names = newWorkerNames(&WorkerRules{Reject: true})
+	if _, ok := names.normalize("rig 1"); ok {
+		t.Error("Must reject invalid name with deprecated option")
+	}
+
+	names = newWorkerNames(&WorkerRules{Fallback: workerStrip})
+	if name, _ := names.normalize("rig #1.farm/east"); name != "rig1farm" {
+		t.Errorf("Must strip invalid chars and truncate, got %v", name)
+	}
+	if name, _ := names.normalize("###"); name != "0" {
+		t.Errorf("Must rename to 0 if nothing is left, got %v", name)
+	}
+
+	names = newWorkerNames(&WorkerRules{Fallback: workerHash})
+	a, _ := names.normalize("rig #1")
+	b, _ := names.normalize("rig #2")
+	if len(a) != 8 || a == b || !names.pattern.MatchString(a) {
+		t.Errorf("Must hash invalid names apart, got %v and %v", a, b)
+	}
+	if c, _ := names.normalize("rig #1"); c != a {
+		t.Errorf("Must hash names stable, got %v and %v", a, c)
+	}
+}
+
+func TestWorkerCharset(t *testing.T) {
+	names := newWorkerNames(&WorkerRules{Charset: "a-z]^\\", MaxLength: 8})
+	if name, ok := names.normalize("a]^\\b"); !ok || name != "a]^\\b" {
+		t.Errorf("Must take special chars literally, got %v", name)
+	}
+	if name, _ := names.normalize("rig-1"); name != "0" {
+		t.Errorf("Must not allow chars outside of charset, got %v", name)
+	}
+	if _, err := charsetClass("z-a"); err == nil {
+		t.Error("Must reject reversed range")
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	defaultSendQueue    = 32
+	defaultWriteTimeout = 10 * time.Second
+)
+
+var errSendQueueFull = errors.New("send queue overflow")
+
+type outMessage struct {
+	data []byte
+	// Job pushes are flushed at once, other messages of buffered sessions wait for flush timer
+	push bool
+}
+
+// Starts goroutine writing queued messages to session connection,
+// so slow receivers never block repliers and job broadcaster
+func (s *ProxyServer) startWriter(cs *Session) {
+	cs.out = make(chan outMessage, s.sendQueue)
+	cs.stop = make(chan struct{})
+	cs.stopped = make(chan struct{})
+	cs.writeTimeout = s.writeTimeout
+	cs.tails = &s.tails
+	go cs.writeLoop()
+}
+
+func (cs *Session) writeLoop() {
+	defer close(cs.stopped)
+	for {
+		select {
+		case m := <-cs.out:
+			if err := cs.write(m, len(cs.out) == 0); err != nil {
+				log.Printf("Write error to %v@%v: %v", cs.login, cs.ip, err)
+				cs.conn.Close()
+				return
+			}
+		case <-cs.stop:
+			// Deliver what is already queued, usually last error reply or final job
+			for {
+				select {
+				case m := <-cs.out:
+					if err := cs.write(m, len(cs.out) == 0); err != nil {
+						return
+					}
+				default:
+					cs.Lock()
+					cs.flushLocked()
+					cs.Unlock()
+					return
+				}
+			}
+		}
+	}
+}
+
+// Buffered sessions are flushed once queue is empty, replies go out with the next job push
+// or when flush timer fires, so a new job is never held back
+func (cs *Session) write(m outMessage, last bool) error {
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.conn.SetWriteDeadline(time.Now().Add(cs.writeTimeout))
+	if cs.buf == nil {
+		_, err := cs.conn.Write(m.data)
+		return err
+	}
+	if _, err := cs.buf.Write(m.data); err != nil {
+		return err
+	}
+	if !last {
+		return nil
+	}
+	if m.push {
+		return cs.flushLocked()
+	}
+	cs.scheduleFlushLocked()
+	return nil
+}
+
+// Queues message without blocking, session is disconnected if receiver doesn't keep up
+func (cs *Session) send(message interface{}, push bool) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	cs.tailMessage("out", data)
+	return cs.enqueue(append(data, '\n'), push)
+}
+
+func (cs *Session) enqueue(data []byte, push bool) error {
+	select {
+	case cs.out <- outMessage{data: data, push: push}:
+		return nil
+	default:
+		cs.conn.Close()
+		return errSendQueueFull
+	}
+}
+
+// Waits for queued messages to be written, safe to call more than once
+func (cs *Session) stopWriter() {
+	cs.stopOnce.Do(func() { close(cs.stop) })
+	<-cs.stopped
+}
@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendQueueOverflow(t *testing.T) {
+	s := &ProxyServer{sendQueue: 2, writeTimeout: time.Second}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn}
+	s.startWriter(cs)
+
+	// Peer never reads, writer blocks on the first message
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = cs.pushNewJob([]string{"0x0", "0x0", "0x0"})
+	}
+	if err != errSendQueueFull {
+		t.Errorf("Must fail with queue overflow, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cs.stopWriter()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Must stop writer of overflowed session")
+	}
+}
+
+func TestSendQueueOrder(t *testing.T) {
+	s := &ProxyServer{sendQueue: 8, writeTimeout: time.Second}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn}
+	s.startWriter(cs)
+
+	cs.sendTCPResult([]byte("1"), true)
+	cs.sendTCPError([]byte("2"), &ErrorReply{Code: -1, Message: "x"})
+
+	r := bufio.NewReader(peer)
+	first, _ := r.ReadString('\n')
+	second, _ := r.ReadString('\n')
+	if first != `{"id":1,"jsonrpc":"2.0","result":true}`+"\n" {
+		t.Errorf("Must deliver result first, got %q", first)
+	}
+	if second != `{"id":2,"jsonrpc":"2.0","result":null,"error":{"code":-1,"message":"x"}}`+"\n" {
+		t.Errorf("Must deliver error second, got %q", second)
+	}
+	cs.stopWriter()
+}
+
+func TestBufferedSessionFlushesJobs(t *testing.T) {
+	s := &ProxyServer{sendQueue: 8, writeTimeout: time.Second}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	cs := &Session{conn: conn, buf: bufio.NewWriter(conn), flushDelay: time.Hour}
+	s.startWriter(cs)
+
+	cs.sendTCPResult([]byte("1"), true)
+	r := bufio.NewReader(peer)
+	peer.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if line, err := r.ReadString('\n'); err == nil {
+		t.Errorf("Must hold reply of buffered session, got %q", line)
+	}
+
+	cs.pushNewJob([]string{"0x1", "0x2", "0x3"})
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	reply, _ := r.ReadString('\n')
+	job, err := r.ReadString('\n')
+	if reply != `{"id":1,"jsonrpc":"2.0","result":true}`+"\n" || !strings.Contains(job, `"result":["0x1","0x2","0x3"]`) {
+		t.Errorf("Must flush job push at once together with held reply, got %q %q %v", reply, job, err)
+	}
+	cs.stopWriter()
+}
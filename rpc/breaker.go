@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"time"
+)
+
+const (
+	// Consecutive failed calls which open circuit
+	breakerFailures = 5
+	// Open circuit lets a single probe through after cooldown, cooldown doubles
+	// every time probe fails
+	breakerCooldown    = 5 * time.Second
+	breakerMaxCooldown = time.Minute
+
+	retryAttempts = 3
+	retryBackoff  = 50 * time.Millisecond
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Node calls which must not be repeated on transport failure
+var nonIdempotent = map[string]bool{
+	"eth_submitWork":      true,
+	"eth_submitHashrate":  true,
+	"eth_sendTransaction": true,
+	// Resent transaction is refused as already known
+	"eth_sendRawTransaction": true,
+}
+
+// Error reply of node, call reached it and retry would get the same answer
+type replyError struct {
+	message string
+}
+
+func (e *replyError) Error() string {
+	return e.message
+}
+
+// Node answered batch with single error, it doesn't take batches
+var errBatchRejected = &replyError{"batch requests are not supported"}
+
+type breaker struct {
+	state    breakerState
+	failures int
+	openedAt time.Time
+	cooldown time.Duration
+}
+
+// Attempts call may make now, 0 while circuit is open. Open circuit turns half-open
+// after cooldown and lets a single attempt through as probe.
+func (r *RPCClient) allow(now time.Time) int {
+	r.Lock()
+	defer r.Unlock()
+	b := &r.breaker
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return 0
+		}
+		b.state = breakerHalfOpen
+		return 1
+	case breakerHalfOpen:
+		// Probe is in flight
+		return 0
+	}
+	return retryAttempts
+}
+
+// Counts outcome of call once its retries are used up. Error reply means node is up.
+func (r *RPCClient) markCall(err error) {
+	var reply *replyError
+	if err == nil || errors.As(err, &reply) {
+		r.markAlive()
+	} else {
+		r.markSick()
+	}
+}
+
+func (r *RPCClient) markSick() {
+	r.Lock()
+	defer r.Unlock()
+	b := &r.breaker
+	b.failures++
+	switch b.state {
+	case breakerHalfOpen:
+		b.cooldown *= 2
+		if b.cooldown > breakerMaxCooldown {
+			b.cooldown = breakerMaxCooldown
+		}
+		b.state, b.openedAt = breakerOpen, time.Now()
+	case breakerClosed:
+		if b.failures >= breakerFailures {
+			b.state, b.openedAt, b.cooldown = breakerOpen, time.Now(), breakerCooldown
+			log.Printf("Circuit of %v upstream is open after %v failures", r.Name, b.failures)
+		}
+	}
+}
+
+func (r *RPCClient) markAlive() {
+	r.Lock()
+	defer r.Unlock()
+	b := &r.breaker
+	if b.state != breakerClosed {
+		log.Printf("Circuit of %v upstream is closed", r.Name)
+	}
+	b.state, b.failures = breakerClosed, 0
+}
+
+// Jittered exponential backoff before retry of idempotent call on transport failure
+func retryDelay(attempt int) time.Duration {
+	d := retryBackoff << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+func retryable(method string, err error) bool {
+	var reply *replyError
+	return !nonIdempotent[method] && err != ErrCircuitOpen && !errors.As(err, &reply)
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	requests, healthy, replyError := 0, false, false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if replyError {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":0,"error":{"code":-32000,"message":"no work"}}`))
+			return
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":["0x1","0x2","0x3"]}`))
+	}))
+	defer srv.Close()
+	r := NewRPCClient("test", srv.URL, "1s")
+
+	if _, err := r.GetWork(); err == nil || requests != retryAttempts {
+		t.Errorf("Must retry idempotent call, got %v requests (%v)", requests, err)
+	}
+	if r.breaker.failures != 1 {
+		t.Errorf("Must count retried call once, got %v failures", r.breaker.failures)
+	}
+	replyError = true
+	if _, err := r.GetWork(); err == nil || r.breaker.failures != 0 {
+		t.Errorf("Must not count error reply of node, got %v failures (%v)", r.breaker.failures, err)
+	}
+	replyError = false
+	requests = 0
+	for i := 0; i < breakerFailures-1; i++ {
+		r.SubmitBlock([]string{"0x0", "0x0", "0x0"})
+	}
+	if _, err := r.SubmitBlock([]string{"0x0", "0x0", "0x0"}); err == nil || requests != breakerFailures {
+		t.Errorf("Must not retry block submission, got %v requests", requests)
+	}
+	if !r.Sick() {
+		t.Error("Must open circuit after repeated failures")
+	}
+	requests = 0
+	if _, err := r.GetWork(); err != ErrCircuitOpen || requests != 0 {
+		t.Errorf("Must fail fast while circuit is open, got %v requests (%v)", requests, err)
+	}
+
+	r.breaker.openedAt = time.Now().Add(-breakerCooldown)
+	if r.Check() || requests != 1 || r.breaker.cooldown != 2*breakerCooldown {
+		t.Errorf("Must probe once and double cooldown, got %v requests, %v", requests, r.breaker.cooldown)
+	}
+
+	healthy = true
+	r.breaker.openedAt = time.Now().Add(-r.breaker.cooldown)
+	if !r.Check() || r.Sick() {
+		t.Error("Must close circuit after successful probe")
+	}
+}
@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Node clients whose RPC differs from core-geth
+const (
+	FlavorGeth       = "geth"
+	FlavorBesu       = "besu"
+	FlavorNethermind = "nethermind"
+)
+
+func ValidFlavor(flavor string) bool {
+	switch flavor {
+	case "", FlavorGeth, FlavorBesu, FlavorNethermind:
+		return true
+	}
+	return false
+}
+
+// Only geth has miner_setExtra, others take extra data from node config
+func (r *RPCClient) SupportsExtraData() bool {
+	return len(r.Flavor) == 0 || r.Flavor == FlavorGeth
+}
+
+// Besu has no pending block and answers with latest one for "pending" tag
+func (r *RPCClient) pendingBlockTag() string {
+	if r.Flavor == FlavorBesu {
+		return "latest"
+	}
+	return "pending"
+}
+
+// Height of block being mined on top of latest block
+func nextBlockNumber(number string) string {
+	n, err := strconv.ParseUint(strings.TrimPrefix(number, "0x"), 16, 64)
+	if err != nil {
+		return number
+	}
+	return "0x" + strconv.FormatUint(n+1, 16)
+}
+
+// Nethermind and Besu name block beneficiary author and don't zero-pad nonce
+func normalizeBlock(b *GetBlockReply) {
+	if b == nil {
+		return
+	}
+	if len(b.Miner) == 0 {
+		b.Miner = b.Author
+	}
+	if nonce := strings.TrimPrefix(b.Nonce, "0x"); len(b.Nonce) > 0 && len(nonce) < 16 {
+		b.Nonce = "0x" + strings.Repeat("0", 16-len(nonce)) + nonce
+	}
+}
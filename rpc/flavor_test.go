@@ -0,0 +1,39 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBesuPendingBlock(t *testing.T) {
+	var tag string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		tag, _ = req.Params[0].(string)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":{"number":"0xff","difficulty":"0x1"}}`))
+	}))
+	defer srv.Close()
+
+	r := NewRPCClient("besu", srv.URL, "1s")
+	r.Flavor = FlavorBesu
+	block, err := r.GetPendingBlock()
+	if err != nil || tag != "latest" || block.Number != "0x100" {
+		t.Errorf("Must mine on top of latest block, got %v %v (%v)", tag, block, err)
+	}
+	if r.SupportsExtraData() {
+		t.Error("Must not set extra data on besu")
+	}
+}
+
+func TestNormalizeBlock(t *testing.T) {
+	b := &GetBlockReply{Author: "0xa", Nonce: "0x1a"}
+	normalizeBlock(b)
+	if b.Miner != "0xa" || b.Nonce != "0x000000000000001a" {
+		t.Errorf("Must take miner from author and pad nonce, got %v %v", b.Miner, b.Nonce)
+	}
+}
@@ -0,0 +1,64 @@
+package rpc
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Percentiles are taken from latency of this many recent calls
+const latencySamples = 1024
+
+type callStats struct {
+	sync.Mutex
+	latencies []time.Duration
+	next      int
+	calls     int64
+	errors    int64
+}
+
+// Calls and errors since start, latency percentiles of recent calls in ms
+type Metrics struct {
+	Calls  int64
+	Errors int64
+	P50    float64
+	P90    float64
+	P99    float64
+}
+
+// Deferred with pointer to named error of call
+func (r *RPCClient) record(start time.Time, err *error) {
+	s := &r.stats
+	s.Lock()
+	defer s.Unlock()
+	s.calls++
+	if *err != nil {
+		s.errors++
+	}
+	if len(s.latencies) < latencySamples {
+		s.latencies = append(s.latencies, time.Since(start))
+	} else {
+		s.latencies[s.next] = time.Since(start)
+		s.next = (s.next + 1) % latencySamples
+	}
+}
+
+func (r *RPCClient) Metrics() Metrics {
+	s := &r.stats
+	s.Lock()
+	m := Metrics{Calls: s.calls, Errors: s.errors}
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	s.Unlock()
+
+	if len(sorted) == 0 {
+		return m
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p int) float64 {
+		i := (len(sorted)*p+99)/100 - 1
+		return float64(sorted[i]) / float64(time.Millisecond)
+	}
+	m.P50, m.P90, m.P99 = percentile(50), percentile(90), percentile(99)
+	return m
+}
@@ -0,0 +1,27 @@
+package rpc
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	r := &RPCClient{}
+	var ok, failed error = nil, errors.New("timeout")
+	for i := 0; i < latencySamples+10; i++ {
+		r.record(time.Now(), &ok)
+	}
+	r.record(time.Now(), &failed)
+
+	m := r.Metrics()
+	if m.Calls != latencySamples+11 || m.Errors != 1 {
+		t.Errorf("Must count calls and errors, got %v", m)
+	}
+	if len(r.stats.latencies) != latencySamples {
+		t.Errorf("Must keep only recent latencies, got %v", len(r.stats.latencies))
+	}
+	if m.P50 > m.P90 || m.P90 > m.P99 {
+		t.Errorf("Must order percentiles, got %v", m)
+	}
+}
@@ -6,11 +6,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"math/big"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
 
@@ -19,12 +22,16 @@ import (
 
 type RPCClient struct {
 	sync.RWMutex
-	Url         string
-	Name        string
-	sick        bool
-	sickRate    int
-	successRate int
-	client      *http.Client
+	Url     string
+	Name    string
+	Flavor  string // node client, geth if empty
+	breaker breaker
+	client  *http.Client
+	stats   callStats
+	auth    *Auth
+	ipcPath string
+	// Set once node rejected batch request, batches are sent as single calls then
+	noBatch int32
 }
 
 type GetBlockReply struct {
@@ -32,11 +39,13 @@ type GetBlockReply struct {
 	Hash         string   `json:"hash"`
 	Nonce        string   `json:"nonce"`
 	Miner        string   `json:"miner"`
+	Author       string   `json:"author"`
 	Difficulty   string   `json:"difficulty"`
 	GasLimit     string   `json:"gasLimit"`
 	GasUsed      string   `json:"gasUsed"`
 	Transactions []Tx     `json:"transactions"`
 	Uncles       []string `json:"uncles"`
+	ExtraData    string   `json:"extraData"`
 	// https://github.com/ethereum/EIPs/issues/95
 	SealFields []string `json:"sealFields"`
 }
@@ -44,15 +53,27 @@ type GetBlockReply struct {
 type GetBlockReplyPart struct {
 	Number     string `json:"number"`
 	Difficulty string `json:"difficulty"`
+	// Missing on chains without EIP-1559
+	BaseFeePerGas string `json:"baseFeePerGas"`
 }
 
 const receiptStatusSuccessful = "0x1"
 
 type TxReceipt struct {
-	TxHash    string `json:"transactionHash"`
-	GasUsed   string `json:"gasUsed"`
-	BlockHash string `json:"blockHash"`
-	Status    string `json:"status"`
+	TxHash      string `json:"transactionHash"`
+	GasUsed     string `json:"gasUsed"`
+	BlockHash   string `json:"blockHash"`
+	BlockNumber string `json:"blockNumber"`
+	Status      string `json:"status"`
+	// Missing on nodes before EIP-1559 support
+	EffectiveGasPrice string `json:"effectiveGasPrice"`
+	Logs              []Log  `json:"logs"`
+}
+
+type Log struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
 }
 
 func (r *TxReceipt) Confirmed() bool {
@@ -68,9 +89,14 @@ func (r *TxReceipt) Successful() bool {
 }
 
 type Tx struct {
-	Gas      string `json:"gas"`
-	GasPrice string `json:"gasPrice"`
-	Hash     string `json:"hash"`
+	Gas       string `json:"gas"`
+	GasPrice  string `json:"gasPrice"`
+	Hash      string `json:"hash"`
+	Nonce     string `json:"nonce"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Value     string `json:"value"`
+	BlockHash string `json:"blockHash"`
 }
 
 type JSONRpcResp struct {
@@ -79,6 +105,17 @@ type JSONRpcResp struct {
 	Error  map[string]interface{} `json:"error"`
 }
 
+// Single request of JSON-RPC batch, reply is unmarshaled into Result unless Error is set
+type BatchElem struct {
+	Method string
+	Params interface{}
+	Result interface{}
+	Error  error
+}
+
+// Nodes limit number of requests in batch
+const maxBatchSize = 100
+
 func NewRPCClient(name, url, timeout string) *RPCClient {
 	rpcClient := &RPCClient{Name: name, Url: url}
 	timeoutIntv := util.MustParseDuration(timeout)
@@ -95,17 +132,24 @@ func (r *RPCClient) GetWork() ([]string, error) {
 	}
 	var reply []string
 	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err == nil && len(reply) < 3 {
+		err = errors.New("incomplete work package")
+	}
 	return reply, err
 }
 
 func (r *RPCClient) GetPendingBlock() (*GetBlockReplyPart, error) {
-	rpcResp, err := r.doPost(r.Url, "eth_getBlockByNumber", []interface{}{"pending", false})
+	tag := r.pendingBlockTag()
+	rpcResp, err := r.doPost(r.Url, "eth_getBlockByNumber", []interface{}{tag, false})
 	if err != nil {
 		return nil, err
 	}
 	if rpcResp.Result != nil {
 		var reply *GetBlockReplyPart
 		err = json.Unmarshal(*rpcResp.Result, &reply)
+		if err == nil && reply != nil && tag != "pending" {
+			reply.Number = nextBlockNumber(reply.Number)
+		}
 		return reply, err
 	}
 	return nil, nil
@@ -134,11 +178,46 @@ func (r *RPCClient) getBlockBy(method string, params []interface{}) (*GetBlockRe
 	if rpcResp.Result != nil {
 		var reply *GetBlockReply
 		err = json.Unmarshal(*rpcResp.Result, &reply)
+		normalizeBlock(reply)
 		return reply, err
 	}
 	return nil, nil
 }
 
+// Blocks with transactions in one round trip, nil for heights above node head
+func (r *RPCClient) GetBlocksByHeight(heights []int64) ([]*GetBlockReply, error) {
+	batch := make([]*BatchElem, len(heights))
+	for i, height := range heights {
+		batch[i] = &BatchElem{Method: "eth_getBlockByNumber", Params: []interface{}{fmt.Sprintf("0x%x", height), true}}
+	}
+	return r.batchBlocks(batch)
+}
+
+func (r *RPCClient) GetUnclesByBlockNumber(height int64, count int) ([]*GetBlockReply, error) {
+	batch := make([]*BatchElem, count)
+	for i := range batch {
+		batch[i] = &BatchElem{Method: "eth_getUncleByBlockNumberAndIndex", Params: []interface{}{fmt.Sprintf("0x%x", height), fmt.Sprintf("0x%x", i)}}
+	}
+	return r.batchBlocks(batch)
+}
+
+func (r *RPCClient) batchBlocks(batch []*BatchElem) ([]*GetBlockReply, error) {
+	blocks := make([]*GetBlockReply, len(batch))
+	for i := range batch {
+		batch[i].Result = &blocks[i]
+	}
+	if err := r.BatchCall(batch); err != nil {
+		return nil, err
+	}
+	for i, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+		normalizeBlock(blocks[i])
+	}
+	return blocks, nil
+}
+
 func (r *RPCClient) GetTxReceipt(hash string) (*TxReceipt, error) {
 	rpcResp, err := r.doPost(r.Url, "eth_getTransactionReceipt", []string{hash})
 	if err != nil {
@@ -152,6 +231,43 @@ func (r *RPCClient) GetTxReceipt(hash string) (*TxReceipt, error) {
 	return nil, nil
 }
 
+// Receipts in one round trip, nil for transactions not mined yet
+func (r *RPCClient) GetTxReceipts(hashes []string) ([]*TxReceipt, error) {
+	receipts := make([]*TxReceipt, len(hashes))
+	batch := make([]*BatchElem, len(hashes))
+	for i, hash := range hashes {
+		batch[i] = &BatchElem{Method: "eth_getTransactionReceipt", Params: []string{hash}, Result: &receipts[i]}
+	}
+	if err := r.BatchCall(batch); err != nil {
+		return nil, err
+	}
+	for _, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+	}
+	return receipts, nil
+}
+
+// Transactions in one round trip, nil for unknown ones. Nodes without full
+// transaction index only know recent transactions.
+func (r *RPCClient) GetTransactions(hashes []string) ([]*Tx, error) {
+	txs := make([]*Tx, len(hashes))
+	batch := make([]*BatchElem, len(hashes))
+	for i, hash := range hashes {
+		batch[i] = &BatchElem{Method: "eth_getTransactionByHash", Params: []string{hash}, Result: &txs[i]}
+	}
+	if err := r.BatchCall(batch); err != nil {
+		return nil, err
+	}
+	for _, elem := range batch {
+		if elem.Error != nil {
+			return nil, elem.Error
+		}
+	}
+	return txs, nil
+}
+
 func (r *RPCClient) SubmitBlock(params []string) (bool, error) {
 	rpcResp, err := r.doPost(r.Url, "eth_submitWork", params)
 	if err != nil {
@@ -162,6 +278,20 @@ func (r *RPCClient) SubmitBlock(params []string) (bool, error) {
 	return reply, err
 }
 
+// Sets extra data of blocks mined by node, supported by geth compatible nodes
+func (r *RPCClient) SetExtraData(extra string) error {
+	rpcResp, err := r.doPost(r.Url, "miner_setExtra", []string{extra})
+	if err != nil {
+		return err
+	}
+	var reply bool
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err == nil && !reply {
+		err = errors.New("Node refused to set extra data")
+	}
+	return err
+}
+
 func (r *RPCClient) GetBalance(address string) (*big.Int, error) {
 	rpcResp, err := r.doPost(r.Url, "eth_getBalance", []string{address, "latest"})
 	if err != nil {
@@ -176,8 +306,7 @@ func (r *RPCClient) GetBalance(address string) (*big.Int, error) {
 }
 
 func (r *RPCClient) Sign(from string, s string) (string, error) {
-	hash := sha256.Sum256([]byte(s))
-	rpcResp, err := r.doPost(r.Url, "eth_sign", []string{from, hexutil.Encode(hash[:])})
+	rpcResp, err := r.doPost(r.Url, "eth_sign", signParams(from, s))
 	var reply string
 	if err != nil {
 		return reply, err
@@ -186,9 +315,96 @@ func (r *RPCClient) Sign(from string, s string) (string, error) {
 	if err != nil {
 		return reply, err
 	}
+	return reply, checkSignature(reply)
+}
+
+func signParams(from string, s string) []string {
+	hash := sha256.Sum256([]byte(s))
+	return []string{from, hexutil.Encode(hash[:])}
+}
+
+func checkSignature(reply string) error {
 	if util.IsZeroHash(reply) {
-		err = errors.New("Can't sign message, perhaps account is locked")
+		return errors.New("Can't sign message, perhaps account is locked")
+	}
+	return nil
+}
+
+// Node checks done before every payment, each with own error
+type AccountState struct {
+	Peers      int64
+	PeersErr   error
+	SignErr    error
+	Balance    *big.Int
+	BalanceErr error
+	ChainId    uint64
+	ChainIdErr error
+}
+
+// Peer count, account unlock, balance and chain ID in one round trip
+func (r *RPCClient) GetAccountState(address string) (*AccountState, error) {
+	var peers, signature, balance, chainId string
+	batch := []*BatchElem{
+		{Method: "net_peerCount", Result: &peers},
+		{Method: "eth_sign", Params: signParams(address, "0x0"), Result: &signature},
+		{Method: "eth_getBalance", Params: []string{address, "latest"}, Result: &balance},
+		{Method: "eth_chainId", Result: &chainId},
+	}
+	if err := r.BatchCall(batch); err != nil {
+		return nil, err
+	}
+	state := &AccountState{PeersErr: batch[0].Error, SignErr: batch[1].Error, BalanceErr: batch[2].Error, ChainIdErr: batch[3].Error}
+	if state.ChainIdErr == nil {
+		state.ChainId, state.ChainIdErr = strconv.ParseUint(strings.TrimPrefix(chainId, "0x"), 16, 64)
+	}
+	if state.PeersErr == nil {
+		state.Peers, state.PeersErr = strconv.ParseInt(strings.Replace(peers, "0x", "", -1), 16, 64)
+	}
+	if state.SignErr == nil {
+		state.SignErr = checkSignature(signature)
+	}
+	if state.BalanceErr == nil {
+		state.Balance = util.String2Big(balance)
+	}
+	return state, nil
+}
+
+// EIP-695 chain ID. Network ID of net_version can't tell ETC from ETH, both are 1.
+func (r *RPCClient) GetChainId() (uint64, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_chainId", nil)
+	if err != nil {
+		return 0, err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return 0, err
 	}
+	return strconv.ParseUint(strings.TrimPrefix(reply, "0x"), 16, 64)
+}
+
+// Height of the latest block known to node
+func (r *RPCClient) BlockNumber() (uint64, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_blockNumber", nil)
+	if err != nil {
+		return 0, err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimPrefix(reply, "0x"), 16, 64)
+}
+
+// Client name and version, e.g. CoreGeth/v1.12.19-stable-b8ad5dd8/linux-amd64/go1.21.6
+func (r *RPCClient) ClientVersion() (string, error) {
+	rpcResp, err := r.doPost(r.Url, "web3_clientVersion", nil)
+	if err != nil {
+		return "", err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
 	return reply, err
 }
 
@@ -205,17 +421,80 @@ func (r *RPCClient) GetPeerCount() (int64, error) {
 	return strconv.ParseInt(strings.Replace(reply, "0x", "", -1), 16, 64)
 }
 
-func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoGas bool) (string, error) {
+// Base fee of latest block, error if chain doesn't have one
+func (r *RPCClient) GetBaseFee() (*big.Int, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_getBlockByNumber", []interface{}{"latest", false})
+	if err != nil {
+		return nil, err
+	}
+	var reply *GetBlockReplyPart
+	if rpcResp.Result != nil {
+		if err := json.Unmarshal(*rpcResp.Result, &reply); err != nil {
+			return nil, err
+		}
+	}
+	if reply == nil || len(reply.BaseFeePerGas) == 0 {
+		return nil, errors.New("latest block has no base fee, chain doesn't support EIP-1559")
+	}
+	return hexutil.DecodeBig(reply.BaseFeePerGas)
+}
+
+// Transaction signed by node wallet, all values are hex encoded. Blank gas fields are left to node.
+// It is EIP-1559 transaction if MaxFeePerGas is set, GasPrice is ignored then.
+type TxRequest struct {
+	From  string
+	To    string
+	Value string
+	// Input of contract call
+	Data                 string
+	Gas                  string
+	GasPrice             string
+	MaxFeePerGas         string
+	MaxPriorityFeePerGas string
+	// Required by external signer, node fills them in otherwise
+	Nonce   string
+	ChainId string
+}
+
+func (req *TxRequest) params() map[string]string {
 	params := map[string]string{
-		"from":  from,
-		"to":    to,
-		"value": value,
+		"from":  req.From,
+		"to":    req.To,
+		"value": req.Value,
+	}
+	if len(req.Data) > 0 {
+		params["data"] = req.Data
+	}
+	if len(req.Gas) > 0 {
+		params["gas"] = req.Gas
+	}
+	if len(req.MaxFeePerGas) > 0 {
+		params["type"] = "0x2"
+		params["maxFeePerGas"] = req.MaxFeePerGas
+		params["maxPriorityFeePerGas"] = req.MaxPriorityFeePerGas
+	} else if len(req.GasPrice) > 0 {
+		params["gasPrice"] = req.GasPrice
+	}
+	if len(req.Nonce) > 0 {
+		params["nonce"] = req.Nonce
+	}
+	if len(req.ChainId) > 0 {
+		params["chainId"] = req.ChainId
 	}
+	return params
+}
+
+func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoGas bool) (string, error) {
+	req := &TxRequest{From: from, To: to, Value: value}
 	if !autoGas {
-		params["gas"] = gas
-		params["gasPrice"] = gasPrice
+		req.Gas = gas
+		req.GasPrice = gasPrice
 	}
-	rpcResp, err := r.doPost(r.Url, "eth_sendTransaction", []interface{}{params})
+	return r.Send(req)
+}
+
+func (r *RPCClient) Send(req *TxRequest) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_sendTransaction", []interface{}{req.params()})
 	var reply string
 	if err != nil {
 		return reply, err
@@ -234,67 +513,241 @@ func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoG
 	return reply, err
 }
 
-func (r *RPCClient) doPost(url string, method string, params interface{}) (*JSONRpcResp, error) {
-	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 0}
-	data, _ := json.Marshal(jsonReq)
+// Broadcasts transaction signed elsewhere, node needs no keys
+func (r *RPCClient) SendRawTransaction(raw string) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_sendRawTransaction", []string{raw})
+	var reply string
+	if err != nil {
+		return reply, err
+	}
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+// Signs transaction without sending it, used with external signer such as Clef or Web3Signer.
+// Clef replies with object holding raw transaction, Web3Signer with raw transaction itself.
+func (r *RPCClient) SignTransaction(req *TxRequest) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_signTransaction", []interface{}{req.params()})
+	if err != nil {
+		return "", err
+	}
+	var raw string
+	if json.Unmarshal(*rpcResp.Result, &raw) != nil {
+		var reply struct {
+			Raw string `json:"raw"`
+		}
+		if err := json.Unmarshal(*rpcResp.Result, &reply); err != nil {
+			return "", err
+		}
+		raw = reply.Raw
+	}
+	if len(raw) == 0 {
+		return "", errors.New("signer returned no transaction")
+	}
+	return raw, nil
+}
+
+// Next nonce of address, "pending" includes transactions in pool of node, "latest" only mined ones
+func (r *RPCClient) GetTransactionCount(address, tag string) (uint64, error) {
+	return r.getQuantity("eth_getTransactionCount", []string{address, tag})
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
-	req.Header.Set("Content-Length", (string)(len(data)))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+// Code of address at block tag, "0x" for accounts without code
+func (r *RPCClient) GetCode(address, tag string) (string, error) {
+	rpcResp, err := r.doPost(r.Url, "eth_getCode", []string{address, tag})
+	if err != nil {
+		return "", err
+	}
+	var reply string
+	err = json.Unmarshal(*rpcResp.Result, &reply)
+	return reply, err
+}
+
+func (r *RPCClient) GasPrice() (*big.Int, error) {
+	return r.getBig("eth_gasPrice", nil)
+}
+
+func (r *RPCClient) EstimateGas(req *TxRequest) (uint64, error) {
+	return r.getQuantity("eth_estimateGas", []interface{}{req.params()})
+}
 
-	resp, err := r.client.Do(req)
+func (r *RPCClient) getBig(method string, params interface{}) (*big.Int, error) {
+	rpcResp, err := r.doPost(r.Url, method, params)
 	if err != nil {
-		r.markSick()
 		return nil, err
 	}
-	defer resp.Body.Close()
+	var reply string
+	if err := json.Unmarshal(*rpcResp.Result, &reply); err != nil {
+		return nil, err
+	}
+	return hexutil.DecodeBig(reply)
+}
+
+func (r *RPCClient) getQuantity(method string, params interface{}) (uint64, error) {
+	n, err := r.getBig(method, params)
+	if err != nil {
+		return 0, err
+	}
+	if !n.IsUint64() {
+		return 0, fmt.Errorf("%s: %v is out of range", method, n)
+	}
+	return n.Uint64(), nil
+}
+
+func (r *RPCClient) doPost(url string, method string, params interface{}) (resp *JSONRpcResp, err error) {
+	defer r.record(time.Now(), &err)
+	attempts := r.allow(time.Now())
+	if attempts == 0 {
+		return nil, ErrCircuitOpen
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err = r.post(url, method, params)
+		if err == nil || attempt == attempts || !retryable(method, err) {
+			break
+		}
+		time.Sleep(retryDelay(attempt - 1))
+	}
+	r.markCall(err)
+	return resp, err
+}
+
+func (r *RPCClient) post(url string, method string, params interface{}) (*JSONRpcResp, error) {
+	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 0}
+	data, _ := json.Marshal(jsonReq)
 
 	var rpcResp *JSONRpcResp
-	err = json.NewDecoder(resp.Body).Decode(&rpcResp)
+	err := r.roundTrip(url, data, &rpcResp)
 	if err != nil {
-		r.markSick()
 		return nil, err
 	}
 	if rpcResp.Error != nil {
-		r.markSick()
-		return nil, errors.New(rpcResp.Error["message"].(string))
+		msg, _ := rpcResp.Error["message"].(string)
+		return nil, &replyError{msg}
 	}
 	return rpcResp, err
 }
 
+// Sends requests as JSON-RPC batch in chunks of maxBatchSize. Error is returned if
+// batch failed as a whole, failures of single requests are set on their elements.
+// Requests go one by one to nodes which reject batches.
+func (r *RPCClient) BatchCall(batch []*BatchElem) error {
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > maxBatchSize {
+			n = maxBatchSize
+		}
+		if atomic.LoadInt32(&r.noBatch) == 1 {
+			if err := r.callEach(batch[:n]); err != nil {
+				return err
+			}
+			batch = batch[n:]
+			continue
+		}
+		err := r.doBatch(batch[:n])
+		if err == errBatchRejected {
+			log.Printf("Upstream %v rejected batch request, sending calls one by one", r.Name)
+			atomic.StoreInt32(&r.noBatch, 1)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		batch = batch[n:]
+	}
+	return nil
+}
+
+// Batch sent as single calls, only failure to reach node fails it as a whole
+func (r *RPCClient) callEach(batch []*BatchElem) error {
+	for _, elem := range batch {
+		resp, err := r.doPost(r.Url, elem.Method, elem.Params)
+		var reply *replyError
+		if errors.As(err, &reply) {
+			elem.Error = errors.New(reply.message)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if resp.Result != nil && elem.Result != nil {
+			elem.Error = json.Unmarshal(*resp.Result, elem.Result)
+		}
+	}
+	return nil
+}
+
+// Batches are read only, so retried on transport failure
+func (r *RPCClient) doBatch(batch []*BatchElem) (err error) {
+	defer r.record(time.Now(), &err)
+	attempts := r.allow(time.Now())
+	if attempts == 0 {
+		return ErrCircuitOpen
+	}
+	for attempt := 1; ; attempt++ {
+		err = r.postBatch(batch)
+		if err == nil || attempt == attempts || !retryable("", err) {
+			break
+		}
+		time.Sleep(retryDelay(attempt - 1))
+	}
+	r.markCall(err)
+	return err
+}
+
+func (r *RPCClient) postBatch(batch []*BatchElem) error {
+	reqs := make([]map[string]interface{}, len(batch))
+	for i, elem := range batch {
+		reqs[i] = map[string]interface{}{"jsonrpc": "2.0", "method": elem.Method, "params": elem.Params, "id": i}
+	}
+	data, _ := json.Marshal(reqs)
+
+	var raw json.RawMessage
+	if err := r.roundTrip(r.Url, data, &raw); err != nil {
+		return err
+	}
+	// Single error reply instead of array
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		return errBatchRejected
+	}
+	var replies []*JSONRpcResp
+	if err := json.Unmarshal(raw, &replies); err != nil {
+		return err
+	}
+	// Replies may come in any order
+	received := make([]bool, len(batch))
+	for _, reply := range replies {
+		var id int
+		if reply.Id == nil || json.Unmarshal(*reply.Id, &id) != nil || id < 0 || id >= len(batch) {
+			continue
+		}
+		elem := batch[id]
+		received[id] = true
+		if reply.Error != nil {
+			msg, _ := reply.Error["message"].(string)
+			elem.Error = errors.New(msg)
+		} else if reply.Result != nil && elem.Result != nil {
+			elem.Error = json.Unmarshal(*reply.Result, elem.Result)
+		}
+	}
+	for i, elem := range batch {
+		if !received[i] {
+			elem.Error = errors.New("no reply to " + elem.Method + " in batch")
+		}
+	}
+	return nil
+}
+
 func (r *RPCClient) Check() bool {
 	_, err := r.GetWork()
 	if err != nil {
 		return false
 	}
-	r.markAlive()
 	return !r.Sick()
 }
 
+// Circuit is open or probing
 func (r *RPCClient) Sick() bool {
 	r.RLock()
 	defer r.RUnlock()
-	return r.sick
-}
-
-func (r *RPCClient) markSick() {
-	r.Lock()
-	r.sickRate++
-	r.successRate = 0
-	if r.sickRate >= 5 {
-		r.sick = true
-	}
-	r.Unlock()
-}
-
-func (r *RPCClient) markAlive() {
-	r.Lock()
-	r.successRate++
-	if r.successRate >= 5 {
-		r.sick = false
-		r.sickRate = 0
-		r.successRate = 0
-	}
-	r.Unlock()
+	return r.breaker.state != breakerClosed
 }
@@ -0,0 +1,169 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func batchServer(t *testing.T, handle func(method string, params []interface{}) (interface{}, string)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqs []struct {
+			Id     int           `json:"id"`
+			Method string        `json:"method"`
+			Params []interface{} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+			t.Errorf("Must send batch, got %v", err)
+		}
+		var replies []map[string]interface{}
+		// Reversed, replies may come in any order
+		for i := len(reqs) - 1; i >= 0; i-- {
+			result, errMsg := handle(reqs[i].Method, reqs[i].Params)
+			reply := map[string]interface{}{"jsonrpc": "2.0", "id": reqs[i].Id, "result": result}
+			if len(errMsg) > 0 {
+				reply["error"] = map[string]interface{}{"code": -32000, "message": errMsg}
+			}
+			replies = append(replies, reply)
+		}
+		json.NewEncoder(w).Encode(replies)
+	}))
+}
+
+func TestBatchCall(t *testing.T) {
+	requests := 0
+	srv := batchServer(t, func(method string, params []interface{}) (interface{}, string) {
+		requests++
+		if method == "eth_fail" {
+			return nil, "method not found"
+		}
+		return params[0], ""
+	})
+	defer srv.Close()
+	r := NewRPCClient("test", srv.URL, "1s")
+
+	batch := make([]*BatchElem, 150)
+	results := make([]string, len(batch))
+	for i := range batch {
+		batch[i] = &BatchElem{Method: "eth_echo", Params: []string{fmt.Sprint(i)}, Result: &results[i]}
+	}
+	batch[7].Method = "eth_fail"
+	if err := r.BatchCall(batch); err != nil {
+		t.Fatalf("Must call batch, got %v", err)
+	}
+	if requests != 150 {
+		t.Errorf("Must send every request, got %v", requests)
+	}
+	if batch[7].Error == nil || batch[7].Error.Error() != "method not found" {
+		t.Errorf("Must set error of failed request, got %v", batch[7].Error)
+	}
+	for i, v := range results {
+		if i != 7 && (v != fmt.Sprint(i) || batch[i].Error != nil) {
+			t.Errorf("Must match reply %v by id, got %v (%v)", i, v, batch[i].Error)
+		}
+	}
+}
+
+func TestBatchFallback(t *testing.T) {
+	batches, calls := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		if body[0] == '[' {
+			batches++
+			w.Write([]byte(`{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch not supported"}}`))
+			return
+		}
+		calls++
+		var req struct {
+			Method string   `json:"method"`
+			Params []string `json:"params"`
+		}
+		json.Unmarshal(body, &req)
+		if req.Method == "eth_fail" {
+			w.Write([]byte(`{"jsonrpc":"2.0","id":0,"error":{"code":-32601,"message":"method not found"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 0, "result": req.Params[0]})
+	}))
+	defer srv.Close()
+	r := NewRPCClient("test", srv.URL, "1s")
+
+	results := make([]string, 2)
+	batch := []*BatchElem{{Method: "eth_echo", Params: []string{"a"}, Result: &results[0]}, {Method: "eth_fail", Params: []string{"b"}, Result: &results[1]}}
+	if err := r.BatchCall(batch); err != nil {
+		t.Fatalf("Must fall back to single calls, got %v", err)
+	}
+	if results[0] != "a" || batch[1].Error == nil || batch[1].Error.Error() != "method not found" {
+		t.Errorf("Must set results and errors of single calls, got %v %v", results, batch[1].Error)
+	}
+	r.BatchCall([]*BatchElem{{Method: "eth_echo", Params: []string{"c"}}})
+	if batches != 1 || calls != 3 || r.Sick() {
+		t.Errorf("Must remember node rejects batches, got %v batches and %v calls", batches, calls)
+	}
+}
+
+func TestGetAccountState(t *testing.T) {
+	srv := batchServer(t, func(method string, params []interface{}) (interface{}, string) {
+		switch method {
+		case "net_peerCount":
+			return "0x19", ""
+		case "eth_sign":
+			return nil, "authentication needed: password or unlock"
+		case "eth_chainId":
+			return "0x3d", ""
+		}
+		return "0xde0b6b3a7640000", ""
+	})
+	defer srv.Close()
+
+	state, err := NewRPCClient("test", srv.URL, "1s").GetAccountState("0x0")
+	if err != nil {
+		t.Fatalf("Must query account state, got %v", err)
+	}
+	if state.Peers != 25 || state.PeersErr != nil {
+		t.Errorf("Must parse peer count, got %v (%v)", state.Peers, state.PeersErr)
+	}
+	if state.SignErr == nil {
+		t.Error("Must report locked account")
+	}
+	if state.BalanceErr != nil || state.Balance.String() != "1000000000000000000" {
+		t.Errorf("Must parse balance, got %v (%v)", state.Balance, state.BalanceErr)
+	}
+	if state.ChainId != 61 || state.ChainIdErr != nil {
+		t.Errorf("Must parse chain ID, got %v (%v)", state.ChainId, state.ChainIdErr)
+	}
+}
+
+func TestSignTransaction(t *testing.T) {
+	var reply string
+	var nonce interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Params []map[string]interface{} `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		nonce = req.Params[0]["nonce"]
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":` + reply + `}`))
+	}))
+	defer srv.Close()
+	r := NewRPCClient("signer", srv.URL, "1s")
+	req := &TxRequest{From: "0x1", To: "0x2", Value: "0x3", Nonce: "0x4", ChainId: "0x3d"}
+
+	// Clef
+	reply = `{"raw":"0xf86c","tx":{}}`
+	if raw, err := r.SignTransaction(req); err != nil || raw != "0xf86c" || nonce != "0x4" {
+		t.Errorf("Must take raw tx from reply object, got %v %v (%v)", raw, nonce, err)
+	}
+	// Web3Signer
+	reply = `"0x02f870"`
+	if raw, err := r.SignTransaction(req); err != nil || raw != "0x02f870" {
+		t.Errorf("Must take raw tx from reply, got %v (%v)", raw, err)
+	}
+	reply = `{}`
+	if _, err := r.SignTransaction(req); err == nil {
+		t.Error("Must fail if signer returned no tx")
+	}
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const ipcScheme = "ipc://"
+
+// Credentials of managed node providers, either basic auth or bearer token.
+// Client certificate and CA are used for https:// endpoints.
+type Auth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Token    string `json:"token"`
+	CAFile   string `json:"caFile"`
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+}
+
+func (a *Auth) tlsConfig() (*tls.Config, error) {
+	if len(a.CAFile) == 0 && len(a.CertFile) == 0 {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(a.CAFile) > 0 {
+		pem, err := os.ReadFile(a.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", a.CAFile)
+		}
+	}
+	if len(a.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Client of ipc:///path/geth.ipc or http(s):// endpoint, auth may be nil
+func NewAuthRPCClient(name, url, timeout string, auth *Auth) *RPCClient {
+	r := NewRPCClient(name, url, timeout)
+	if strings.HasPrefix(url, ipcScheme) {
+		r.ipcPath = strings.TrimPrefix(url, ipcScheme)
+		return r
+	}
+	if auth == nil {
+		return r
+	}
+	r.auth = auth
+	tlsConfig, err := auth.tlsConfig()
+	if err != nil {
+		log.Fatalf("Invalid TLS config of upstream %v: %v", name, err)
+	}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		r.client.Transport = transport
+	}
+	return r
+}
+
+// Sends JSON-RPC request body and decodes reply
+func (r *RPCClient) roundTrip(url string, data []byte, reply interface{}) error {
+	if len(r.ipcPath) > 0 {
+		return r.ipcRoundTrip(data, reply)
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if r.auth != nil {
+		if len(r.auth.Token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+r.auth.Token)
+		} else if len(r.auth.Username) > 0 {
+			req.SetBasicAuth(r.auth.Username, r.auth.Password)
+		}
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("upstream replied %v", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// Node IPC is a stream of JSON values, connection is opened per request
+func (r *RPCClient) ipcRoundTrip(data []byte, reply interface{}) error {
+	conn, err := net.DialTimeout("unix", r.ipcPath, r.client.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.client.Timeout))
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	return json.NewDecoder(conn).Decode(reply)
+}
@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestIPCClient(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geth.ipc")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Must listen on socket, got %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var req map[string]interface{}
+			json.NewDecoder(conn).Decode(&req)
+			json.NewEncoder(conn).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 0, "result": []string{req["method"].(string), "0x0", "0x0"}})
+			conn.Close()
+		}
+	}()
+
+	r := NewAuthRPCClient("local", "ipc://"+path, "1s", nil)
+	work, err := r.GetWork()
+	if err != nil || len(work) != 3 || work[0] != "eth_getWork" {
+		t.Errorf("Must call node over IPC, got %v (%v)", work, err)
+	}
+}
+
+func TestAuthClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":["0x1","0x2","0x3"]}`))
+	}))
+	defer srv.Close()
+
+	if _, err := NewAuthRPCClient("provider", srv.URL, "1s", &Auth{Token: "secret"}).GetWork(); err != nil {
+		t.Errorf("Must send bearer token, got %v", err)
+	}
+	if _, err := NewAuthRPCClient("provider", srv.URL, "1s", &Auth{Token: "wrong"}).GetWork(); err == nil || err.Error() != "upstream replied 401 Unauthorized" {
+		t.Errorf("Must report rejected credentials, got %v", err)
+	}
+}
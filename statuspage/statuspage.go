@@ -0,0 +1,166 @@
+package statuspage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+type Config struct {
+	Enabled bool `json:"enabled"`
+	// "statuspage" for statuspage.io or "cachet"
+	Provider string `json:"provider"`
+	Url      string `json:"url"`
+	PageId   string `json:"pageId"`
+	ApiKey   string `json:"apiKey"`
+	Timeout  string `json:"timeout"`
+	// Module name => component id on status page
+	Components map[string]string `json:"components"`
+}
+
+type Status int
+
+const (
+	Operational Status = iota
+	Degraded
+	Outage
+)
+
+func (s Status) String() string {
+	switch s {
+	case Operational:
+		return "operational"
+	case Degraded:
+		return "degraded"
+	default:
+		return "outage"
+	}
+}
+
+// Component names reported by pool modules
+const (
+	Proxy    = "proxy"
+	Upstream = "upstream"
+	Unlocker = "unlocker"
+	Payouts  = "payouts"
+)
+
+type update struct {
+	component string
+	status    Status
+}
+
+type Reporter struct {
+	config  *Config
+	client  *http.Client
+	mu      sync.Mutex
+	last    map[string]Status
+	updates chan update
+}
+
+var reporter *Reporter
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if c.Provider != "statuspage" && c.Provider != "cachet" {
+		errs.Addf("statusPage.provider: unknown provider %q", c.Provider)
+	}
+	errs.Duration("statusPage.timeout", c.Timeout, false)
+}
+
+// Starts default reporter used by Report
+func Start(cfg *Config) {
+	r := &Reporter{
+		config:  cfg,
+		client:  &http.Client{Timeout: util.MustParseDuration(cfg.Timeout)},
+		last:    make(map[string]Status),
+		updates: make(chan update, 64),
+	}
+	go r.run()
+	reporter = r
+	log.Printf("Reporting component status to %s", cfg.Provider)
+}
+
+// Pushes component status to status page if it has changed since last report
+func Report(component string, status Status) {
+	if reporter != nil {
+		reporter.report(component, status)
+	}
+}
+
+func (r *Reporter) report(component string, status Status) {
+	if _, ok := r.config.Components[component]; !ok {
+		return
+	}
+	r.mu.Lock()
+	last, ok := r.last[component]
+	r.last[component] = status
+	r.mu.Unlock()
+	if ok && last == status {
+		return
+	}
+	select {
+	case r.updates <- update{component, status}:
+	default:
+		log.Printf("Status page update queue is full, dropping %s status %v", component, status)
+	}
+}
+
+func (r *Reporter) run() {
+	for u := range r.updates {
+		err := r.push(r.config.Components[u.component], u.status)
+		if err != nil {
+			log.Printf("Failed to push %s status %v to status page: %v", u.component, u.status, err)
+			// Forget state so next report retries
+			r.mu.Lock()
+			delete(r.last, u.component)
+			r.mu.Unlock()
+		} else {
+			log.Printf("Pushed %s status %v to status page", u.component, u.status)
+		}
+	}
+}
+
+func (r *Reporter) push(id string, status Status) error {
+	var req *http.Request
+	var err error
+	url := strings.TrimRight(r.config.Url, "/")
+
+	switch r.config.Provider {
+	case "statuspage":
+		values := map[Status]string{Operational: "operational", Degraded: "degraded_performance", Outage: "major_outage"}
+		body, _ := json.Marshal(map[string]interface{}{"component": map[string]string{"status": values[status]}})
+		req, err = http.NewRequest("PATCH", fmt.Sprintf("%s/pages/%s/components/%s", url, r.config.PageId, id), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "OAuth "+r.config.ApiKey)
+	case "cachet":
+		values := map[Status]int{Operational: 1, Degraded: 2, Outage: 4}
+		body, _ := json.Marshal(map[string]int{"status": values[status]})
+		req, err = http.NewRequest("PUT", fmt.Sprintf("%s/api/v1/components/%s", url, id), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Cachet-Token", r.config.ApiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package statuspage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type pushed struct {
+	method string
+	path   string
+	auth   string
+	body   map[string]interface{}
+}
+
+func newTestReporter(t *testing.T, provider string) (*Reporter, chan pushed) {
+	requests := make(chan pushed, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := pushed{method: r.Method, path: r.URL.Path, auth: r.Header.Get("Authorization") + r.Header.Get("X-Cachet-Token")}
+		json.NewDecoder(r.Body).Decode(&p.body)
+		requests <- p
+	}))
+	t.Cleanup(srv.Close)
+	cfg := &Config{
+		Enabled:    true,
+		Provider:   provider,
+		Url:        srv.URL + "/",
+		PageId:     "page",
+		ApiKey:     "key",
+		Components: map[string]string{Upstream: "c1"},
+	}
+	r := &Reporter{config: cfg, client: srv.Client(), last: make(map[string]Status), updates: make(chan update, 64)}
+	go r.run()
+	t.Cleanup(func() { close(r.updates) })
+	return r, requests
+}
+
+func receive(t *testing.T, requests chan pushed) pushed {
+	select {
+	case p := <-requests:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("Must push status change")
+	}
+	return pushed{}
+}
+
+func TestStatusPagePush(t *testing.T) {
+	r, requests := newTestReporter(t, "statuspage")
+	for status, value := range map[Status]string{Operational: "operational", Degraded: "degraded_performance", Outage: "major_outage"} {
+		r.report(Upstream, status)
+		p := receive(t, requests)
+		if p.method != "PATCH" || p.path != "/pages/page/components/c1" || p.auth != "OAuth key" {
+			t.Errorf("Must patch component, got %v %v %v", p.method, p.path, p.auth)
+		}
+		component, _ := p.body["component"].(map[string]interface{})
+		if component["status"] != value {
+			t.Errorf("Must push %v as %v, got %v", status, value, p.body)
+		}
+	}
+}
+
+func TestCachetPush(t *testing.T) {
+	r, requests := newTestReporter(t, "cachet")
+	for status, value := range map[Status]float64{Operational: 1, Degraded: 2, Outage: 4} {
+		r.report(Upstream, status)
+		p := receive(t, requests)
+		if p.method != "PUT" || p.path != "/api/v1/components/c1" || p.auth != "key" {
+			t.Errorf("Must put component, got %v %v %v", p.method, p.path, p.auth)
+		}
+		if p.body["status"] != value {
+			t.Errorf("Must push %v as %v, got %v", status, value, p.body)
+		}
+	}
+}
+
+func TestReportOnlyChanges(t *testing.T) {
+	r, requests := newTestReporter(t, "cachet")
+	r.report(Upstream, Degraded)
+	receive(t, requests)
+	r.report(Upstream, Degraded)
+	r.report(Proxy, Outage)
+	select {
+	case p := <-requests:
+		t.Errorf("Must not push unchanged status or unmapped component, got %v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,540 @@
+package storage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// Block states in archive
+const (
+	ArchiveCandidate = "candidate"
+	ArchiveImmature  = "immature"
+	ArchiveMatured   = "matured"
+)
+
+const archiveVersion = 1
+
+// Ledger source account of imported balances
+const importAccount = "import"
+
+// Balances, payments and blocks of pool or tenant in backend independent form,
+// moves accounting between servers and storage drivers
+type Archive struct {
+	Version   int               `json:"version"`
+	Timestamp int64             `json:"timestamp"`
+	Accounts  []*ArchiveAccount `json:"accounts"`
+	Payments  []*Payment        `json:"payments"`
+	Blocks    []*ArchiveBlock   `json:"blocks"`
+}
+
+// Miner accounts in Shannon
+type ArchiveAccount struct {
+	Login    string `json:"login"`
+	Balance  int64  `json:"balance"`
+	Immature int64  `json:"immature"`
+	Pending  int64  `json:"pending"`
+	Paid     int64  `json:"paid"`
+}
+
+type ArchiveBlock struct {
+	State          string `json:"state"`
+	Height         int64  `json:"height"`
+	UncleHeight    int64  `json:"uncleHeight"`
+	Orphan         bool   `json:"orphan"`
+	Nonce          string `json:"nonce"`
+	PowHash        string `json:"powHash"`
+	MixDigest      string `json:"mixDigest"`
+	Hash           string `json:"hash"`
+	Timestamp      int64  `json:"timestamp"`
+	Difficulty     int64  `json:"difficulty"`
+	TotalShares    int64  `json:"totalShares"`
+	Reward         string `json:"reward"`
+	ExtraData      string `json:"extraData"`
+	BestShare      int64  `json:"bestShare"`
+	BestShareLogin string `json:"bestShareLogin"`
+	Solver         string `json:"solver,omitempty"`
+	// Round shares by login, unlocker needs them until block matures
+	Shares map[string]int64 `json:"shares,omitempty"`
+	// Immature credits of immature block or final ones of matured block by login
+	Credits map[string]int64 `json:"credits,omitempty"`
+}
+
+// Number of written records, those already present in storage are skipped
+type ImportResult struct {
+	Accounts int `json:"accounts"`
+	Payments int `json:"payments"`
+	Blocks   int `json:"blocks"`
+}
+
+type ArchiveStorage interface {
+	ExportArchive() (*Archive, error)
+	// Account fields are brought to archived values with ledger entries,
+	// so import of the same archive twice changes nothing
+	ImportArchive(a *Archive) (*ImportResult, error)
+}
+
+func newArchiveBlock(state string, b *BlockData, shares, credits map[string]int64) *ArchiveBlock {
+	if len(shares) == 0 {
+		shares = nil
+	}
+	if len(credits) == 0 {
+		credits = nil
+	}
+	return &ArchiveBlock{
+		State: state, Height: b.Height, UncleHeight: b.UncleHeight, Orphan: b.Orphan, Nonce: b.Nonce,
+		PowHash: b.PowHash, MixDigest: b.MixDigest, Hash: b.Hash, Timestamp: b.Timestamp, Difficulty: b.Difficulty,
+		TotalShares: b.TotalShares, Reward: b.RewardString, ExtraData: b.ExtraData,
+		BestShare: b.BestShare, BestShareLogin: b.BestShareLogin, Solver: b.Solver, Shares: shares, Credits: credits,
+	}
+}
+
+func (ab *ArchiveBlock) blockData() *BlockData {
+	b := &BlockData{
+		Height: ab.Height, RoundHeight: ab.Height, UncleHeight: ab.UncleHeight, Uncle: ab.UncleHeight > 0, Orphan: ab.Orphan,
+		Nonce: ab.Nonce, PowHash: ab.PowHash, MixDigest: ab.MixDigest, Hash: ab.Hash, Timestamp: ab.Timestamp,
+		Difficulty: ab.Difficulty, TotalShares: ab.TotalShares, RewardString: ab.Reward, ImmatureReward: ab.Reward,
+		ExtraData: ab.ExtraData, BestShare: ab.BestShare, BestShareLogin: ab.BestShareLogin, Solver: ab.Solver,
+	}
+	b.Reward, _ = new(big.Int).SetString(ab.Reward, 10)
+	return b
+}
+
+func (a *ArchiveAccount) fields() map[string]int64 {
+	return map[string]int64{"balance": a.Balance, "immature": a.Immature, "pending": a.Pending, "paid": a.Paid}
+}
+
+func (a *Archive) validate() error {
+	if a.Version != archiveVersion {
+		return fmt.Errorf("unsupported archive version %v", a.Version)
+	}
+	for i, account := range a.Accounts {
+		if len(account.Login) == 0 {
+			return fmt.Errorf("account #%v: login is required", i+1)
+		}
+		// Pending amount has no payment record to be resolved by
+		if account.Pending != 0 {
+			return fmt.Errorf("account %s: pending payment must be resolved before export", account.Login)
+		}
+	}
+	for i, payment := range a.Payments {
+		if len(payment.Address) == 0 || len(payment.TxHash) == 0 {
+			return fmt.Errorf("payment #%v: address and tx are required", i+1)
+		}
+	}
+	for i, block := range a.Blocks {
+		switch block.State {
+		case ArchiveCandidate, ArchiveImmature, ArchiveMatured:
+		default:
+			return fmt.Errorf("block #%v: unknown state %q", i+1, block.State)
+		}
+		if len(block.Nonce) == 0 {
+			return fmt.Errorf("block #%v: nonce is required", i+1)
+		}
+		if block.State != ArchiveCandidate && len(block.Hash) == 0 {
+			return fmt.Errorf("block #%v: hash is required", i+1)
+		}
+		if _, ok := new(big.Int).SetString(block.Reward, 10); !ok && block.State != ArchiveCandidate {
+			return fmt.Errorf("block #%v: malformed reward %q", i+1, block.Reward)
+		}
+	}
+	return nil
+}
+
+// Ledger entries bringing miner account fields from current to archived values
+func importEntries(ms int64, account *ArchiveAccount, current map[string]int64) []*LedgerEntry {
+	var entries []*LedgerEntry
+	target := account.fields()
+	for _, field := range []string{"balance", "immature", "pending", "paid"} {
+		delta := target[field] - current[field]
+		if delta == 0 {
+			continue
+		}
+		e := &LedgerEntry{Timestamp: ms, Kind: LedgerImport, Debit: importAccount, Credit: field + ":" + account.Login, Amount: delta}
+		if delta < 0 {
+			e.Debit, e.Credit, e.Amount = e.Credit, e.Debit, -delta
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// JSON file if path ends with .json, otherwise directory with accounts.csv, payments.csv and blocks.csv
+func SaveArchive(path string, a *Archive) error {
+	if strings.HasSuffix(path, ".json") {
+		data, err := json.MarshalIndent(a, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0600)
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return err
+	}
+	for _, name := range []string{"accounts", "payments", "blocks"} {
+		file, err := os.OpenFile(filepath.Join(path, name+".csv"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		err = a.WriteCSV(file, name)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("%s.csv: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func LoadArchive(path string) (*Archive, error) {
+	if strings.HasSuffix(path, ".json") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var a Archive
+		if err := json.Unmarshal(data, &a); err != nil {
+			return nil, err
+		}
+		return &a, nil
+	}
+	a := &Archive{Version: archiveVersion}
+	for _, name := range []string{"accounts", "payments", "blocks"} {
+		file, err := os.Open(filepath.Join(path, name+".csv"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		err = a.ReadCSV(file, name)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s.csv: %v", name, err)
+		}
+	}
+	return a, nil
+}
+
+var archiveColumns = map[string][]string{
+	"accounts": {"login", "balance", "immature", "pending", "paid"},
+	"payments": {"timestamp", "tx", "address", "amount"},
+	"blocks": {"state", "height", "uncleHeight", "orphan", "nonce", "powHash", "mixDigest", "hash", "timestamp",
+		"difficulty", "totalShares", "reward", "extraData", "bestShare", "bestShareLogin", "shares", "credits"},
+}
+
+// Writes one section of archive with header row, round shares and credits of blocks are JSON objects
+func (a *Archive) WriteCSV(w io.Writer, section string) error {
+	columns, ok := archiveColumns[section]
+	if !ok {
+		return fmt.Errorf("unknown archive section %q", section)
+	}
+	i64 := func(n int64) string { return strconv.FormatInt(n, 10) }
+	out := csv.NewWriter(w)
+	out.Write(columns)
+	switch section {
+	case "accounts":
+		for _, v := range a.Accounts {
+			out.Write([]string{v.Login, i64(v.Balance), i64(v.Immature), i64(v.Pending), i64(v.Paid)})
+		}
+	case "payments":
+		for _, v := range a.Payments {
+			out.Write([]string{i64(v.Timestamp), v.TxHash, v.Address, i64(v.Amount)})
+		}
+	case "blocks":
+		for _, v := range a.Blocks {
+			shares, _ := json.Marshal(v.Shares)
+			credits, _ := json.Marshal(v.Credits)
+			out.Write([]string{v.State, i64(v.Height), i64(v.UncleHeight), strconv.FormatBool(v.Orphan), v.Nonce, v.PowHash,
+				v.MixDigest, v.Hash, i64(v.Timestamp), i64(v.Difficulty), i64(v.TotalShares), v.Reward, v.ExtraData,
+				i64(v.BestShare), v.BestShareLogin, string(shares), string(credits)})
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Appends records of section read from CSV written by WriteCSV
+func (a *Archive) ReadCSV(r io.Reader, section string) error {
+	columns, ok := archiveColumns[section]
+	if !ok {
+		return fmt.Errorf("unknown archive section %q", section)
+	}
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = len(columns)
+	if _, err := in.Read(); err != nil {
+		return err
+	}
+	for line := 2; ; line++ {
+		row, err := in.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var failed error
+		i64 := func(s string) int64 {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil && failed == nil {
+				failed = err
+			}
+			return n
+		}
+		switch section {
+		case "accounts":
+			a.Accounts = append(a.Accounts, &ArchiveAccount{Login: row[0], Balance: i64(row[1]), Immature: i64(row[2]),
+				Pending: i64(row[3]), Paid: i64(row[4])})
+		case "payments":
+			a.Payments = append(a.Payments, &Payment{Timestamp: i64(row[0]), TxHash: row[1], Address: row[2], Amount: i64(row[3])})
+		case "blocks":
+			b := &ArchiveBlock{State: row[0], Height: i64(row[1]), UncleHeight: i64(row[2]), Nonce: row[4], PowHash: row[5],
+				MixDigest: row[6], Hash: row[7], Timestamp: i64(row[8]), Difficulty: i64(row[9]), TotalShares: i64(row[10]),
+				Reward: row[11], ExtraData: row[12], BestShare: i64(row[13]), BestShareLogin: row[14]}
+			b.Orphan, _ = strconv.ParseBool(row[3])
+			if err := json.Unmarshal([]byte(row[15]), &b.Shares); err != nil && failed == nil {
+				failed = err
+			}
+			if err := json.Unmarshal([]byte(row[16]), &b.Credits); err != nil && failed == nil {
+				failed = err
+			}
+			a.Blocks = append(a.Blocks, b)
+		}
+		if failed != nil {
+			return fmt.Errorf("line %v: %v", line, failed)
+		}
+	}
+}
+
+func (r *RedisClient) ExportArchive() (*Archive, error) {
+	a := &Archive{Version: archiveVersion, Timestamp: r.timestamp() / 1000, Accounts: []*ArchiveAccount{}, Blocks: []*ArchiveBlock{}}
+	logins, err := r.GetPayees()
+	if err != nil {
+		return nil, err
+	}
+	for _, login := range logins {
+		values, err := r.client.HMGet(r.formatKey("miners", login), "balance", "immature", "pending", "paid").Result()
+		if err != nil {
+			return nil, err
+		}
+		var n [4]int64
+		for i, v := range values {
+			if s, ok := v.(string); ok {
+				n[i], _ = strconv.ParseInt(s, 10, 64)
+			}
+		}
+		if n != [4]int64{} {
+			a.Accounts = append(a.Accounts, &ArchiveAccount{Login: login, Balance: n[0], Immature: n[1], Pending: n[2], Paid: n[3]})
+		}
+	}
+	if a.Payments, err = r.GetAllPayments(); err != nil {
+		return nil, err
+	}
+
+	candidates := r.client.ZRangeWithScores(r.formatKey("blocks", "candidates"), 0, -1)
+	immature := r.client.ZRangeWithScores(r.formatKey("blocks", "immature"), 0, -1)
+	matured := r.client.ZRangeWithScores(r.formatKey("blocks", "matured"), 0, -1)
+	for _, cmd := range []*redis.ZSliceCmd{candidates, immature, matured} {
+		if err := cmd.Err(); err != nil {
+			return nil, err
+		}
+	}
+	lists := map[string][]*BlockData{
+		ArchiveCandidate: convertCandidateResults(candidates),
+		ArchiveImmature:  convertBlockResults(immature),
+		ArchiveMatured:   convertBlockResults(matured),
+	}
+	if err := r.loadExtraData(lists[ArchiveImmature], lists[ArchiveMatured]); err != nil {
+		return nil, err
+	}
+	if err := r.loadBestShares(lists[ArchiveCandidate], lists[ArchiveImmature], lists[ArchiveMatured]); err != nil {
+		return nil, err
+	}
+	for _, state := range []string{ArchiveCandidate, ArchiveImmature, ArchiveMatured} {
+		for _, b := range lists[state] {
+			var shares, credits map[string]int64
+			if state != ArchiveMatured {
+				if shares, err = r.GetRoundShares(b.Height, b.Nonce); err != nil {
+					return nil, err
+				}
+			}
+			switch state {
+			case ArchiveImmature:
+				credits, err = r.hashInts(r.formatKey("credits", "immature", b.Height, b.Hash))
+			case ArchiveMatured:
+				credits, err = r.hashInts(r.formatKey("credits", b.Height, b.Hash))
+			}
+			if err != nil {
+				return nil, err
+			}
+			a.Blocks = append(a.Blocks, newArchiveBlock(state, b, shares, credits))
+		}
+	}
+	return a, nil
+}
+
+func (r *RedisClient) hashInts(key string) (map[string]int64, error) {
+	values, err := r.client.HGetAllMap(key).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int64, len(values))
+	for k, v := range values {
+		result[k], _ = strconv.ParseInt(v, 10, 64)
+	}
+	return result, nil
+}
+
+// Must not run while unlocker or payouts are active on the same storage
+func (r *RedisClient) ImportArchive(a *Archive) (*ImportResult, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	ms := r.timestamp()
+	result := &ImportResult{}
+	for _, account := range a.Accounts {
+		values, err := r.client.HMGet(r.formatKey("miners", account.Login), "balance", "immature", "pending", "paid").Result()
+		if err != nil {
+			return result, err
+		}
+		current := make(map[string]int64)
+		for i, field := range []string{"balance", "immature", "pending", "paid"} {
+			if s, ok := values[i].(string); ok {
+				current[field], _ = strconv.ParseInt(s, 10, 64)
+			}
+		}
+		entries := importEntries(ms, account, current)
+		if len(entries) == 0 {
+			continue
+		}
+		tx := r.client.Multi()
+		_, err = tx.Exec(func() error {
+			for _, e := range entries {
+				r.post(tx, e)
+			}
+			return nil
+		})
+		tx.Close()
+		if err != nil {
+			return result, err
+		}
+		result.Accounts++
+	}
+
+	for _, payment := range a.Payments {
+		score := float64(payment.Timestamp)
+		added, err := r.client.ZAdd(r.formatKey("payments", "all"),
+			redis.Z{Score: score, Member: join(payment.TxHash, payment.Address, payment.Amount)}).Result()
+		if err != nil {
+			return result, err
+		}
+		if added == 0 {
+			continue
+		}
+		err = r.client.ZAdd(r.formatKey("payments", payment.Address), redis.Z{Score: score, Member: join(payment.TxHash, payment.Amount)}).Err()
+		if err != nil {
+			return result, err
+		}
+		result.Payments++
+	}
+
+	for _, ab := range a.Blocks {
+		added, err := r.importBlock(ab)
+		if err != nil {
+			return result, err
+		}
+		if added {
+			result.Blocks++
+		}
+	}
+	if result.Accounts+result.Payments+result.Blocks > 0 {
+		r.logEvent(&Event{Timestamp: ms, Type: EventImport, Archive: a})
+	}
+	return result, nil
+}
+
+// False if block is present already
+func (r *RedisClient) importBlock(ab *ArchiveBlock) (bool, error) {
+	b := ab.blockData()
+	member := b.key()
+	key := r.formatKey("blocks", ab.State)
+	if ab.State == ArchiveCandidate {
+		member = b.candidateMember()
+		key = r.formatKey("blocks", "candidates")
+	}
+	added, err := r.client.ZAdd(key, redis.Z{Score: float64(b.Height), Member: member}).Result()
+	if err != nil || added == 0 {
+		return false, err
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err = tx.Exec(func() error {
+		for login, diff := range ab.Shares {
+			tx.HSetNX(r.formatRound(b.Height, b.Nonce), login, strconv.FormatInt(diff, 10))
+		}
+		if len(b.ExtraData) > 0 {
+			tx.HSet(r.formatKey("blocks", "extra"), b.Hash, b.ExtraData)
+		}
+		if b.BestShare > 0 {
+			tx.HSetNX(r.formatKey("blocks", "best"), b.Nonce, join(b.BestShare, b.BestShareLogin))
+		}
+		credits := r.formatKey("credits", "immature", b.Height, b.Hash)
+		if ab.State == ArchiveMatured {
+			credits = r.formatKey("credits", b.Height, b.Hash)
+			if !b.Orphan {
+				tx.ZAdd(r.formatKey("credits", "all"), redis.Z{Score: float64(b.Height), Member: join(b.Hash, b.Timestamp, b.Reward)})
+			}
+		}
+		for login, amount := range ab.Credits {
+			tx.HSetNX(credits, login, strconv.FormatInt(amount, 10))
+		}
+		return nil
+	})
+	return err == nil, err
+}
+
+// Writes archive of storage to path, see SaveArchive
+func ExportTo(s Storage, path string) (*Archive, error) {
+	a, err := s.ExportArchive()
+	if err != nil {
+		return nil, err
+	}
+	return a, SaveArchive(path, a)
+}
+
+// Loads archive from path and merges it into storage, see ImportPaused
+func ImportFrom(s Storage, control *PayoutsControl, path string) (*ImportResult, error) {
+	a, err := LoadArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(a.Accounts)+len(a.Payments)+len(a.Blocks) == 0 {
+		return nil, errors.New("archive is empty")
+	}
+	return ImportPaused(s, control, a)
+}
+
+// Imports archive only while payouts are paused, holding payouts lock for the whole import
+// so no payment is in flight. Unlocker must be stopped by operator.
+func ImportPaused(s Storage, control *PayoutsControl, a *Archive) (*ImportResult, error) {
+	if !control.Paused {
+		return nil, errors.New("payouts must be paused for import")
+	}
+	if err := s.LockPayouts("import", 0); err != nil {
+		return nil, fmt.Errorf("payout is in progress or unresolved: %v", err)
+	}
+	result, err := s.ImportArchive(a)
+	if uerr := s.UnlockPayouts(); uerr != nil && err == nil {
+		err = uerr
+	}
+	return result, err
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"encoding/json"
+)
+
+// Admin API requests kept for review, newest first
+const maxAdminAudit = 1000
+
+// Admin API request with acting operator
+type AdminAction struct {
+	Timestamp int64  `json:"timestamp"`
+	Operator  string `json:"operator"`
+	Role      string `json:"role"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Remote    string `json:"remote"`
+	Status    int    `json:"status"`
+}
+
+// Audit trail is shared by pool and tenants, admin API manages whole deployment
+func (r *RedisClient) WriteAdminAction(a *AdminAction) error {
+	data, _ := json.Marshal(a)
+	tx := r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		tx.LPush(r.formatRootKey("admin", "audit"), string(data))
+		tx.LTrim(r.formatRootKey("admin", "audit"), 0, maxAdminAudit-1)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisClient) GetAdminActions(limit int64) ([]*AdminAction, error) {
+	rows, err := r.client.LRange(r.formatRootKey("admin", "audit"), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*AdminAction, 0, len(rows))
+	for _, row := range rows {
+		var a AdminAction
+		if err := json.Unmarshal([]byte(row), &a); err == nil {
+			result = append(result, &a)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+)
+
+// Block was moved by another unlocker instance meanwhile
+var ErrAlreadyProcessed = errors.New("block already processed")
+
+// Balance moves run as server-side scripts, so reading state, checking it and posting
+// ledger entries can't interleave with unlocker or payer on another host.
+// Scripts get key prefix in ARGV[1] and timestamp in ms in ARGV[2], post() mirrors RedisClient.post.
+const ledgerLua = `
+local prefix, ts = ARGV[1], ARGV[2]
+local function key(...)
+	return table.concat({prefix, ...}, ':')
+end
+-- Amounts stay strings, Lua numbers lose precision of big integers
+local function neg(amount)
+	if string.sub(amount, 1, 1) == '-' then
+		return string.sub(amount, 2)
+	end
+	return '-' .. amount
+end
+local function apply(account, amount, member)
+	local field, login = string.match(account, '^(%a+):(.+)$')
+	if field == 'immature' or field == 'balance' or field == 'pending' or field == 'paid' then
+		redis.call('HINCRBY', key('miners', login), field, amount)
+		redis.call('HINCRBY', key('finances'), field, amount)
+		redis.call('ZADD', key('ledger', login), ts, member)
+	elseif account == 'pps' then
+		redis.call('HINCRBY', key('finances'), 'ppsBuffer', amount)
+	end
+end
+-- Same encoding as LedgerEntry, accounts and refs are hex logins and hashes
+local function post(kind, debit, credit, amount, ref)
+	local id = redis.call('INCR', key('sequence', 'ledger'))
+	local member = '{"id":' .. id .. ',"ts":' .. ts .. ',"kind":"' .. kind .. '","debit":"' .. debit .. '","credit":"' .. credit .. '","amount":' .. amount
+	if ref ~= '' then
+		member = member .. ',"ref":"' .. ref .. '"'
+	end
+	member = member .. '}'
+	redis.call('ZADD', key('ledger'), ts, member)
+	apply(debit, neg(amount), member)
+	apply(credit, amount, member)
+end
+`
+
+// Debits balance into pending payment, never below zero
+var payoutScript = ledgerLua + `
+local balance = redis.call('HGET', KEYS[1], 'balance')
+if tonumber(balance or '0') < tonumber(ARGV[4]) then
+	return redis.error_reply('insufficient balance for payout of ' .. ARGV[4])
+end
+post('payout', 'balance:' .. ARGV[3], 'pending:' .. ARGV[3], ARGV[4], '')
+redis.call('ZADD', KEYS[2], ARGV[5], ARGV[6])
+return 1
+`
+
+// Returns pending payment to balance once, finished payment can't be refunded
+var refundScript = ledgerLua + `
+if redis.call('ZREM', KEYS[1], ARGV[5]) == 0 then
+	return redis.error_reply('no pending payment of ' .. ARGV[4])
+end
+post('refund', 'pending:' .. ARGV[3], 'balance:' .. ARGV[3], ARGV[4], '')
+return 1
+`
+
+// Transaction is already sent, so payment is recorded even if pending entry is gone.
+// Payouts lock is released if its key is passed.
+var paymentScript = ledgerLua + `
+post('payment', 'pending:' .. ARGV[3], 'paid:' .. ARGV[3], ARGV[4], ARGV[5])
+redis.call('ZADD', KEYS[2], ARGV[6], ARGV[7])
+redis.call('ZADD', KEYS[3], ARGV[6], ARGV[8])
+redis.call('ZREM', KEYS[1], ARGV[9])
+if KEYS[4] then
+	redis.call('DEL', KEYS[4])
+end
+return 1
+`
+
+// Moves whole Shannon of accrued PPS credits to balances, returns login and amount pairs
+var settlePPSScript = ledgerLua + `
+local accrued = redis.call('HGETALL', KEYS[1])
+local credits = {}
+for i = 1, #accrued, 2 do
+	local n = math.floor(tonumber(accrued[i + 1]))
+	if n > 0 then
+		local amount = string.format('%d', n)
+		post('pps', 'pps', 'balance:' .. accrued[i], amount, '')
+		redis.call('HINCRBYFLOAT', KEYS[1], accrued[i], '-' .. amount)
+		table.insert(credits, accrued[i])
+		table.insert(credits, amount)
+	end
+end
+return credits
+`
+
+// Moves candidate to immature blocks and credits immature rewards, 0 if candidate was taken
+var immatureScript = ledgerLua + `
+if ARGV[3] ~= '' and redis.call('ZREM', KEYS[1], ARGV[3]) == 0 then
+	return 0
+end
+if KEYS[3] ~= KEYS[4] and redis.call('EXISTS', KEYS[3]) == 1 then
+	redis.call('RENAME', KEYS[3], KEYS[4])
+end
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[5])
+if ARGV[7] ~= '' then
+	redis.call('HSET', KEYS[5], ARGV[6], ARGV[7])
+end
+for i = 9, #ARGV, 2 do
+	post('immature', ARGV[8], 'immature:' .. ARGV[i], ARGV[i + 1], ARGV[6])
+	redis.call('HSETNX', KEYS[6], ARGV[i], ARGV[i + 1])
+end
+return 1
+`
+
+// Moves immature block to matured ones returning its immature credits, then credits
+// final rewards unless block is orphaned. 0 if block was taken.
+var maturedScript = ledgerLua + `
+if ARGV[3] ~= '' and redis.call('ZREM', KEYS[1], ARGV[3]) == 0 then
+	return 0
+end
+redis.call('DEL', KEYS[3])
+redis.call('ZADD', KEYS[2], ARGV[4], ARGV[5])
+local credits = redis.call('HGETALL', KEYS[4])
+for i = 1, #credits, 2 do
+	post(ARGV[8], 'immature:' .. credits[i], ARGV[7], credits[i + 1], ARGV[6])
+end
+redis.call('DEL', KEYS[4])
+if ARGV[9] == '' then
+	return 1
+end
+redis.call('ZADD', KEYS[5], ARGV[4], ARGV[9])
+for i = 11, #ARGV, 2 do
+	post('credit', ARGV[7], 'balance:' .. ARGV[i], ARGV[i + 1], ARGV[6])
+	redis.call('HSETNX', KEYS[6], ARGV[i], ARGV[i + 1])
+end
+redis.call('HSET', KEYS[7], 'lastCreditHeight', ARGV[4])
+redis.call('HSET', KEYS[7], 'lastCreditHash', ARGV[6])
+redis.call('HINCRBY', KEYS[7], 'totalMined', ARGV[10])
+return 1
+`
+
+// Runs balance script at ms, false if script found its block already processed
+func (r *RedisClient) runLedgerScript(script string, ms int64, keys []string, args ...string) (bool, error) {
+	args = append([]string{r.prefix, strconv.FormatInt(ms, 10)}, args...)
+	val, err := r.client.Eval(script, keys, args).Result()
+	if err != nil {
+		return false, err
+	}
+	done, _ := val.(int64)
+	return done == 1, nil
+}
+
+// Logins are sorted, so replaying events posts ledger entries in the same order
+func rewardArgs(args []string, roundRewards map[string]int64) []string {
+	logins := make([]string, 0, len(roundRewards))
+	for login := range roundRewards {
+		logins = append(logins, login)
+	}
+	sort.Strings(logins)
+	for _, login := range logins {
+		args = append(args, login, strconv.FormatInt(roundRewards[login], 10))
+	}
+	return args
+}
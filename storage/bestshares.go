@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// Leaderboards returned with pool stats
+const maxBestShares = 10
+
+// Best shares are counted by difficulty a hash actually achieved, not the credited one
+type BestShare struct {
+	Login      string `json:"login"`
+	Difficulty int64  `json:"difficulty"`
+}
+
+// Keeps maximum achieved difficulty per login in current round and all time
+var bestShareScript = `
+for _, key in ipairs(KEYS) do
+	local best = redis.call('ZSCORE', key, ARGV[2])
+	if not best or tonumber(ARGV[1]) > tonumber(best) then
+		redis.call('ZADD', key, ARGV[1], ARGV[2])
+	end
+end
+return 0
+`
+
+// Must be called inside share write transaction
+func (r *RedisClient) writeBestShare(tx *redis.Multi, login string, actualDiff int64) {
+	if actualDiff <= 0 {
+		return
+	}
+	keys := []string{r.formatKey("best", "round"), r.formatKey("best", "all")}
+	tx.Eval(bestShareScript, keys, []string{strconv.FormatInt(actualDiff, 10), login})
+}
+
+func convertBestShares(raw *redis.ZSliceCmd) []*BestShare {
+	result := make([]*BestShare, 0, len(raw.Val()))
+	for _, v := range raw.Val() {
+		result = append(result, &BestShare{Login: v.Member.(string), Difficulty: int64(v.Score)})
+	}
+	return result
+}
+
+// Best share of round is stored by block nonce as "difficulty:login", height
+// isn't used since it changes when unlocker finds block in another height
+func (r *RedisClient) loadBestShares(lists ...[]*BlockData) error {
+	var blocks []*BlockData
+	var nonces []string
+	for _, list := range lists {
+		for _, block := range list {
+			blocks = append(blocks, block)
+			nonces = append(nonces, block.Nonce)
+		}
+	}
+	if len(nonces) == 0 {
+		return nil
+	}
+	values, err := r.client.HMGet(r.formatKey("blocks", "best"), nonces...).Result()
+	if err != nil {
+		return err
+	}
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			parts := strings.SplitN(s, ":", 2)
+			blocks[i].BestShare, _ = strconv.ParseInt(parts[0], 10, 64)
+			if len(parts) == 2 {
+				blocks[i].BestShareLogin = parts[1]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package storage
+
+// Bookkeeping entries waiting for delivery by id, shared by pool and tenants.
+// Entry queued twice under the same id is kept once.
+func (r *RedisClient) QueueBookkeeping(id, data string) error {
+	return r.client.HSetNX(r.formatRootKey("bookkeeping"), id, data).Err()
+}
+
+func (r *RedisClient) GetBookkeeping() (map[string]string, error) {
+	return r.client.HGetAllMap(r.formatRootKey("bookkeeping")).Result()
+}
+
+func (r *RedisClient) RemoveBookkeeping(id string) error {
+	return r.client.HDel(r.formatRootKey("bookkeeping"), id).Err()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const dialTimeout = 5 * time.Second
+
+// TLS connection to managed Redis, system CAs are used if CA file is not set
+type TLSConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CAFile     string `json:"caFile"`
+	CertFile   string `json:"certFile"`
+	KeyFile    string `json:"keyFile"`
+	ServerName string `json:"serverName"`
+	// Don't verify server certificate, only for testing or self-signed endpoints in private network
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+func (c *TLSConfig) load(endpoint string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: c.ServerName, MinVersion: tls.VersionTLS12, InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.InsecureSkipVerify {
+		log.Printf("WARNING: redis server certificate is not verified, connection is open to interception")
+	}
+	// Host of master resolved through sentinels is verified on dial
+	if len(cfg.ServerName) == 0 && len(endpoint) > 0 {
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	if len(c.CAFile) > 0 {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.CAFile)
+		}
+	}
+	if len(c.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Dials with TLS and authenticates with ACL username, client only selects database after it.
+// Master is resolved through sentinels if resolver is set.
+func newDialer(cfg *Config, tlsConfig *tls.Config, sentinel *sentinelResolver) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		addr, connTLS := cfg.Endpoint, tlsConfig
+		if sentinel != nil {
+			var err error
+			if addr, err = sentinel.resolve(); err != nil {
+				return nil, err
+			}
+			connTLS = sentinelTLS(tlsConfig, addr)
+		}
+		var conn net.Conn
+		var err error
+		if connTLS != nil {
+			conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, connTLS)
+		} else {
+			conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.Username) > 0 {
+			if err := authenticate(conn, cfg.Username, cfg.Password); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		if sentinel != nil {
+			conn = sentinel.track(conn, addr)
+		}
+		return conn, nil
+	}
+}
+
+// AUTH <username> <password> of Redis 6 ACL, older client only sends password
+func authenticate(conn net.Conn, username, password string) error {
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	cmd := fmt.Sprintf("*3\r\n$4\r\nAUTH\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(username), username, len(password), password)
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return err
+	}
+	// Read byte by byte, nothing after reply line may be consumed here
+	var line []byte
+	buf := make([]byte, 1)
+	for len(line) < 512 {
+		if _, err := conn.Read(buf); err != nil {
+			return err
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	reply := strings.TrimSpace(string(line))
+	if reply != "+OK" {
+		return errors.New("redis auth failed: " + strings.TrimPrefix(reply, "-"))
+	}
+	return nil
+}
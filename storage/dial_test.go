@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+)
+
+func fakeAuthServer(t *testing.T, reply string) net.Conn {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		r := bufio.NewReader(server)
+		// *3, then AUTH, username and password as bulk strings
+		for i := 0; i < 7; i++ {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+		}
+		io.WriteString(server, reply)
+	}()
+	return client
+}
+
+func TestAuthenticate(t *testing.T) {
+	conn := fakeAuthServer(t, "+OK\r\n")
+	if err := authenticate(conn, "pool", "secret"); err != nil {
+		t.Errorf("Must accept OK reply, got %v", err)
+	}
+	conn.Close()
+
+	conn = fakeAuthServer(t, "-WRONGPASS invalid username-password pair\r\n")
+	if err := authenticate(conn, "pool", "wrong"); err == nil || err.Error() != "redis auth failed: WRONGPASS invalid username-password pair" {
+		t.Errorf("Must report server error, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg, err := (&TLSConfig{Enabled: true}).load("redis.example.com:6380")
+	if err != nil || cfg.ServerName != "redis.example.com" {
+		t.Errorf("Must verify endpoint host by default, got %v", err)
+	}
+	if _, err := (&TLSConfig{Enabled: true, CAFile: "missing.pem"}).load("localhost:6380"); err == nil {
+		t.Error("Must fail on missing CA file")
+	}
+	if cfg, err = (&TLSConfig{Enabled: true, InsecureSkipVerify: true}).load("localhost:6380"); err != nil || !cfg.InsecureSkipVerify {
+		t.Errorf("Must skip verification if configured, got %v", err)
+	}
+}
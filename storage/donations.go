@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+
+	"gopkg.in/redis.v3"
+)
+
+// Voluntary donation of miner, deducted from round rewards by unlocker
+type Donation struct {
+	// Percent of round reward, 0 if miner doesn't donate
+	Percent float64 `json:"percent"`
+	// Donated so far in Shannon
+	Total int64 `json:"total"`
+}
+
+type DonationStorage interface {
+	// Zero percent stops donating, total is kept
+	SetDonation(login string, percent float64) error
+	// Percents of donating miners
+	GetDonations() (map[string]float64, error)
+	// Adds amounts donated from matured round to totals
+	WriteDonations(donated map[string]int64) error
+	GetDonation(login string) (*Donation, error)
+	// Donated by all miners
+	GetDonationsTotal() (int64, error)
+}
+
+func (r *RedisClient) SetDonation(login string, percent float64) error {
+	if percent == 0 {
+		return r.client.HDel(r.formatKey("donations"), login).Err()
+	}
+	return r.client.HSet(r.formatKey("donations"), login, strconv.FormatFloat(percent, 'f', -1, 64)).Err()
+}
+
+func (r *RedisClient) GetDonations() (map[string]float64, error) {
+	rows, err := r.client.HGetAllMap(r.formatKey("donations")).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64, len(rows))
+	for login, v := range rows {
+		result[login], _ = strconv.ParseFloat(v, 64)
+	}
+	return result, nil
+}
+
+func (r *RedisClient) WriteDonations(donated map[string]int64) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		for login, amount := range donated {
+			tx.HIncrBy(r.formatKey("donations", "totals"), login, amount)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisClient) GetDonation(login string) (*Donation, error) {
+	var donation Donation
+	percent, err := r.client.HGet(r.formatKey("donations"), login).Float64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	donation.Percent = percent
+	total, err := r.client.HGet(r.formatKey("donations", "totals"), login).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	donation.Total = total
+	return &donation, nil
+}
+
+func (r *RedisClient) GetDonationsTotal() (int64, error) {
+	rows, err := r.client.HVals(r.formatKey("donations", "totals")).Result()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, v := range rows {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		total += n
+	}
+	return total, nil
+}
+
+func (p *PostgresClient) SetDonation(login string, percent float64) error {
+	_, err := p.db.Exec(`INSERT INTO donations (pool, login, percent) VALUES ($1, $2, $3)
+		ON CONFLICT (pool, login) DO UPDATE SET percent = EXCLUDED.percent`, p.pool, login, percent)
+	return err
+}
+
+func (p *PostgresClient) GetDonations() (map[string]float64, error) {
+	rows, err := p.db.Query(`SELECT login, percent FROM donations WHERE pool = $1 AND percent > 0`, p.pool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]float64)
+	for rows.Next() {
+		var login string
+		var percent float64
+		if err := rows.Scan(&login, &percent); err != nil {
+			return nil, err
+		}
+		result[login] = percent
+	}
+	return result, rows.Err()
+}
+
+func (p *PostgresClient) WriteDonations(donated map[string]int64) error {
+	return p.inTx(func(tx *sql.Tx) error {
+		for login, amount := range donated {
+			_, err := tx.Exec(`INSERT INTO donations (pool, login, total) VALUES ($1, $2, $3)
+				ON CONFLICT (pool, login) DO UPDATE SET total = donations.total + EXCLUDED.total`, p.pool, login, amount)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *PostgresClient) GetDonation(login string) (*Donation, error) {
+	var donation Donation
+	err := p.db.QueryRow(`SELECT percent, total FROM donations WHERE pool = $1 AND login = $2`, p.pool, login).
+		Scan(&donation.Percent, &donation.Total)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &donation, nil
+}
+
+func (p *PostgresClient) GetDonationsTotal() (int64, error) {
+	var total int64
+	err := p.db.QueryRow(`SELECT COALESCE(SUM(total), 0) FROM donations WHERE pool = $1`, p.pool).Scan(&total)
+	return total, err
+}
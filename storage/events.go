@@ -0,0 +1,314 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/etclabscore/open-etc-pool/eventbus"
+)
+
+// Accounting event types
+const (
+	EventShare          = "share"
+	EventBlock          = "block"
+	EventImmature       = "immature"
+	EventMatured        = "matured"
+	EventOrphan         = "orphan"
+	EventPendingOrphans = "pendingOrphans"
+	EventPayout         = "payout"
+	EventRefund         = "refund"
+	EventPayment        = "payment"
+	EventAdjustment     = "adjustment"
+	EventImport         = "import"
+	EventPPSCredit      = "ppsCredit"
+	EventPPSFund        = "ppsFund"
+)
+
+// State-mutating event, replaying all of them in order rebuilds accounting state
+type Event struct {
+	Timestamp  int64            `json:"ts"`
+	Type       string           `json:"type"`
+	Login      string           `json:"login,omitempty"`
+	Worker     string           `json:"worker,omitempty"`
+	Params     []string         `json:"params,omitempty"`
+	Diff       int64            `json:"diff,omitempty"`
+	ActualDiff int64            `json:"actualDiff,omitempty"`
+	RoundDiff  int64            `json:"roundDiff,omitempty"`
+	Height     uint64           `json:"height,omitempty"`
+	Window     time.Duration    `json:"window,omitempty"`
+	Solo       bool             `json:"solo,omitempty"`
+	Stale      bool             `json:"stale,omitempty"`
+	Amount     int64            `json:"amount,omitempty"`
+	TxHash     string           `json:"txHash,omitempty"`
+	Reason     string           `json:"reason,omitempty"`
+	Block      *blockRecord     `json:"block,omitempty"`
+	Blocks     []*blockRecord   `json:"blocks,omitempty"`
+	Rewards    map[string]int64 `json:"rewards,omitempty"`
+	Tenant     string           `json:"tenant,omitempty"`
+	Archive    *Archive         `json:"archive,omitempty"`
+}
+
+// BlockData with all fields serialized, including redis members it was read from
+type blockRecord struct {
+	Height         int64  `json:"height"`
+	Timestamp      int64  `json:"timestamp"`
+	Difficulty     int64  `json:"difficulty"`
+	TotalShares    int64  `json:"shares"`
+	Uncle          bool   `json:"uncle"`
+	UncleHeight    int64  `json:"uncleHeight"`
+	Orphan         bool   `json:"orphan"`
+	Hash           string `json:"hash"`
+	Nonce          string `json:"nonce"`
+	PowHash        string `json:"powHash"`
+	MixDigest      string `json:"mixDigest"`
+	Reward         string `json:"reward"`
+	ExtraReward    string `json:"extraReward"`
+	ImmatureReward string `json:"immatureReward"`
+	RoundHeight    int64  `json:"roundHeight"`
+	CandidateKey   string `json:"candidateKey"`
+	ImmatureKey    string `json:"immatureKey"`
+	ExtraData      string `json:"extraData,omitempty"`
+	Solver         string `json:"solver,omitempty"`
+}
+
+func newBlockRecord(b *BlockData) *blockRecord {
+	rec := &blockRecord{
+		Height: b.Height, Timestamp: b.Timestamp, Difficulty: b.Difficulty, TotalShares: b.TotalShares,
+		Uncle: b.Uncle, UncleHeight: b.UncleHeight, Orphan: b.Orphan, Hash: b.Hash, Nonce: b.Nonce,
+		PowHash: b.PowHash, MixDigest: b.MixDigest, ImmatureReward: b.ImmatureReward,
+		RoundHeight: b.RoundHeight, CandidateKey: b.candidateKey, ImmatureKey: b.immatureKey,
+		ExtraData: b.ExtraData, Solver: b.Solver,
+	}
+	if b.Reward != nil {
+		rec.Reward = b.Reward.String()
+	}
+	if b.ExtraReward != nil {
+		rec.ExtraReward = b.ExtraReward.String()
+	}
+	return rec
+}
+
+func (rec *blockRecord) blockData() *BlockData {
+	b := &BlockData{
+		Height: rec.Height, Timestamp: rec.Timestamp, Difficulty: rec.Difficulty, TotalShares: rec.TotalShares,
+		Uncle: rec.Uncle, UncleHeight: rec.UncleHeight, Orphan: rec.Orphan, Hash: rec.Hash, Nonce: rec.Nonce,
+		PowHash: rec.PowHash, MixDigest: rec.MixDigest, ImmatureReward: rec.ImmatureReward,
+		RoundHeight: rec.RoundHeight, candidateKey: rec.CandidateKey, immatureKey: rec.ImmatureKey,
+		ExtraData: rec.ExtraData, Solver: rec.Solver,
+	}
+	b.Reward, _ = new(big.Int).SetString(rec.Reward, 10)
+	b.ExtraReward, _ = new(big.Int).SetString(rec.ExtraReward, 10)
+	return b
+}
+
+// Events of all modules and tenants go to one redis stream, so every instance shares
+// the same history in order of writes
+type EventLogConfig struct {
+	Enabled bool `json:"enabled"`
+	// Stream is trimmed to about this many events, 0 keeps all of them
+	MaxLen int64 `json:"maxLen"`
+}
+
+// Page size of stream export
+const eventExportBatch = 1000
+
+type eventLog struct {
+	maxLen int64
+}
+
+func newEventLog(cfg *EventLogConfig) *eventLog {
+	log.Printf("Writing accounting events to redis stream")
+	return &eventLog{maxLen: cfg.MaxLen}
+}
+
+// Accounting events published on event bus
+var busEvents = map[string]string{
+	EventBlock:    eventbus.Block,
+	EventImmature: eventbus.Immature,
+	EventMatured:  eventbus.Matured,
+	EventOrphan:   eventbus.Orphan,
+	EventPayment:  eventbus.Payment,
+}
+
+func (r *RedisClient) logEvent(e *Event) {
+	e.Tenant = r.tenant
+	r.queueWatchNotifications(e)
+	if t, ok := busEvents[e.Type]; ok && r.replayTs == 0 {
+		eventbus.Publish(t, e)
+	}
+	if r.events == nil {
+		return
+	}
+	data, _ := json.Marshal(e)
+
+	args := []interface{}{"XADD", r.formatRootKey("events")}
+	if r.events.maxLen > 0 {
+		args = append(args, "MAXLEN", "~", r.events.maxLen)
+	}
+	cmd := redis.NewCmd(append(args, "*", "event", string(data))...)
+	r.client.Process(cmd)
+	if err := cmd.Err(); err != nil {
+		log.Printf("Failed to write %s event to log: %v", e.Type, err)
+	}
+}
+
+// Writes events of stream to file, one JSON object per line, for replay or offline copy
+func (r *RedisClient) ExportEvents(path string) (int, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	total := 0
+	start := "-"
+	for {
+		cmd := redis.NewCmd("XRANGE", r.formatRootKey("events"), start, "+", "COUNT", eventExportBatch)
+		r.client.Process(cmd)
+		if err := cmd.Err(); err != nil {
+			return total, err
+		}
+		entries, _ := cmd.Val().([]interface{})
+		for _, v := range entries {
+			entry, _ := v.([]interface{})
+			if len(entry) != 2 {
+				return total, errors.New("malformed stream entry")
+			}
+			id, _ := entry[0].(string)
+			fields, _ := entry[1].([]interface{})
+			for i := 0; i+1 < len(fields); i += 2 {
+				if fields[i] == "event" {
+					data, _ := fields[i+1].(string)
+					w.WriteString(data)
+					w.WriteByte('\n')
+					total++
+				}
+			}
+			start = nextStreamId(id)
+		}
+		if len(entries) < eventExportBatch {
+			break
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return total, err
+	}
+	return total, file.Close()
+}
+
+// Smallest stream id after given one
+func nextStreamId(id string) string {
+	parts := strings.SplitN(id, "-", 2)
+	seq, _ := strconv.ParseUint(parts[len(parts)-1], 10, 64)
+	return fmt.Sprintf("%s-%d", parts[0], seq+1)
+}
+
+// Applies events from log in order, must be called on empty database with event log disabled
+func (r *RedisClient) ReplayEvents(path string) (int, error) {
+	if r.events != nil {
+		return 0, errors.New("event log must be disabled for replay")
+	}
+	keys, err := r.client.Keys(r.formatRootKey("*")).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Stream is left in place, it may be what the log was exported from
+	for i, k := range keys {
+		if k == r.formatRootKey("events") {
+			keys = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) > 0 {
+		return 0, fmt.Errorf("database has %v keys with %s prefix, replay requires empty one", len(keys), r.prefix)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	total := 0
+	tenants := make(map[string]*RedisClient)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return total, fmt.Errorf("malformed event #%v: %v", total+1, err)
+		}
+		target := r
+		if len(e.Tenant) > 0 {
+			if tenants[e.Tenant] == nil {
+				tenants[e.Tenant] = r.Namespace(e.Tenant)
+			}
+			target = tenants[e.Tenant]
+		}
+		target.replayTs = e.Timestamp
+		if err := target.applyEvent(&e); err != nil {
+			return total, fmt.Errorf("failed to apply %s event #%v: %v", e.Type, total+1, err)
+		}
+		total++
+	}
+	r.replayTs = 0
+	return total, scanner.Err()
+}
+
+func (r *RedisClient) applyEvent(e *Event) error {
+	var err error
+	switch e.Type {
+	case EventShare:
+		if e.Solo {
+			_, err = r.writeSoloShare(e.Login, e.Worker, e.Params, e.Diff, e.ActualDiff, e.Height, e.Window, e.Stale)
+		} else {
+			_, err = r.writeRoundShare(e.Login, e.Worker, e.Params, e.Diff, e.ActualDiff, e.Height, e.Window, e.Stale)
+		}
+	case EventBlock:
+		if e.Solo {
+			_, err = r.WriteSoloBlock(e.Login, e.Worker, e.Params, e.Diff, e.ActualDiff, e.RoundDiff, e.Height, e.Window)
+		} else {
+			_, err = r.WriteBlock(e.Login, e.Worker, e.Params, e.Diff, e.ActualDiff, e.RoundDiff, e.Height, e.Window)
+		}
+	case EventImmature:
+		err = r.WriteImmatureBlock(e.Block.blockData(), e.Rewards)
+	case EventMatured:
+		err = r.WriteMaturedBlock(e.Block.blockData(), e.Rewards)
+	case EventOrphan:
+		err = r.WriteOrphan(e.Block.blockData())
+	case EventPendingOrphans:
+		blocks := make([]*BlockData, len(e.Blocks))
+		for i, rec := range e.Blocks {
+			blocks[i] = rec.blockData()
+		}
+		err = r.WritePendingOrphans(blocks)
+	case EventPayout:
+		err = r.UpdateBalance(e.Login, e.Amount)
+	case EventRefund:
+		err = r.RollbackBalance(e.Login, e.Amount)
+	case EventPayment:
+		err = r.WritePayment(e.Login, e.TxHash, e.Amount)
+	case EventAdjustment:
+		err = r.WriteAdjustment(e.Login, e.Reason, e.Amount)
+	case EventImport:
+		_, err = r.ImportArchive(e.Archive)
+	case EventPPSCredit:
+		err = r.replayPPSCredits(e.Rewards)
+	case EventPPSFund:
+		err = r.FundPPS(e.Block.blockData(), e.Amount)
+	default:
+		err = errors.New("unknown event type")
+	}
+	return err
+}
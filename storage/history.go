@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// Hourly share totals of a worker, kept for CSV export
+type WorkerHistory struct {
+	Hour       int64
+	Worker     string
+	Shares     int64
+	Difficulty int64
+}
+
+// Average hashrate over the hour
+func (h *WorkerHistory) Hashrate() int64 {
+	return h.Difficulty / 3600
+}
+
+// Must be called inside share write transaction
+func (r *RedisClient) writeHistory(tx *redis.Multi, ts int64, login, id string, diff int64) {
+	if r.history <= 0 {
+		return
+	}
+	hour := ts - ts%3600
+	key := r.formatKey("history", login, hour)
+	tx.HIncrBy(key, "shares:"+id, 1)
+	tx.HIncrBy(key, "diff:"+id, diff)
+	tx.Expire(key, r.history)
+}
+
+// Hourly worker stats for hours in [from, to] range, timestamps in seconds
+func (r *RedisClient) GetWorkerHistory(login string, from, to int64) ([]*WorkerHistory, error) {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	from -= from % 3600
+	var hours []int64
+	cmds, err := tx.Exec(func() error {
+		for hour := from; hour <= to; hour += 3600 {
+			hours = append(hours, hour)
+			tx.HGetAllMap(r.formatKey("history", login, hour))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*WorkerHistory
+	for i, cmd := range cmds {
+		fields, _ := cmd.(*redis.StringStringMapCmd).Result()
+		workers := make(map[string]*WorkerHistory)
+		var ids []string
+		for field, value := range fields {
+			parts := strings.SplitN(field, ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			row, ok := workers[parts[1]]
+			if !ok {
+				row = &WorkerHistory{Hour: hours[i], Worker: parts[1]}
+				workers[parts[1]] = row
+				ids = append(ids, parts[1])
+			}
+			n, _ := strconv.ParseInt(value, 10, 64)
+			switch parts[0] {
+			case "shares":
+				row.Shares = n
+			case "diff":
+				row.Difficulty = n
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			result = append(result, workers[id])
+		}
+	}
+	return result, nil
+}
+
+// Digest of token giving access to history export of login
+func (r *RedisClient) SetExportToken(login, digest string) error {
+	return r.client.HSet(r.formatKey("exportTokens"), login, digest).Err()
+}
+
+func (r *RedisClient) GetExportToken(login string) (string, error) {
+	digest, err := r.client.HGet(r.formatKey("exportTokens"), login).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return digest, err
+}
+
+func (r *RedisClient) DeleteExportToken(login string) error {
+	return r.client.HDel(r.formatKey("exportTokens"), login).Err()
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"strconv"
+)
+
+// Replaces metrics of last run of scheduled module
+func (r *RedisClient) WriteJobMetrics(job string, values map[string]float64) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	key := r.formatKey("jobs", job)
+	_, err := tx.Exec(func() error {
+		tx.Del(key)
+		for name, v := range values {
+			tx.HSet(key, name, strconv.FormatFloat(v, 'f', -1, 64))
+		}
+		tx.SAdd(r.formatKey("jobs"), job)
+		return nil
+	})
+	return err
+}
+
+// Metrics of last run keyed by job name
+func (r *RedisClient) GetJobMetrics() (map[string]map[string]float64, error) {
+	jobs, err := r.client.SMembers(r.formatKey("jobs")).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]map[string]float64, len(jobs))
+	for _, job := range jobs {
+		raw, err := r.client.HGetAllMap(r.formatKey("jobs", job)).Result()
+		if err != nil {
+			return nil, err
+		}
+		values := make(map[string]float64, len(raw))
+		for name, v := range raw {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				values[name] = f
+			}
+		}
+		result[job] = values
+	}
+	return result, nil
+}
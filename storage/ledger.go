@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+// Ledger entry kinds
+const (
+	LedgerImmature   = "immature"
+	LedgerMature     = "mature"
+	LedgerCredit     = "credit"
+	LedgerOrphan     = "orphan"
+	LedgerPayout     = "payout"
+	LedgerRefund     = "refund"
+	LedgerPayment    = "payment"
+	LedgerAdjustment = "adjustment"
+	LedgerImport     = "import"
+	LedgerPPS        = "pps"
+)
+
+// Double-entry ledger record, moves amount from debit account to credit account.
+// Miner accounts are "immature:<login>", "balance:<login>", "pending:<login>" and "paid:<login>",
+// funds come from "block:<hash>", "adjustment:<reason>" and "import" source accounts.
+// Pool account "pps" is buffer of PPS schemes.
+type LedgerEntry struct {
+	// Sequence number, keeps entries with equal fields apart in sorted sets
+	Id        int64  `json:"id"`
+	Timestamp int64  `json:"ts"`
+	Kind      string `json:"kind"`
+	Debit     string `json:"debit"`
+	Credit    string `json:"credit"`
+	Amount    int64  `json:"amount"`
+	Ref       string `json:"ref,omitempty"`
+}
+
+// Miner account field in miners and finances hashes
+func ledgerAccount(account string) (field, login string, ok bool) {
+	parts := strings.SplitN(account, ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	switch parts[0] {
+	case "immature", "balance", "pending", "paid":
+		return parts[0], parts[1], true
+	}
+	return "", "", false
+}
+
+// Records entry and applies it to miner and pool balances, the only way balances are changed
+func (r *RedisClient) post(tx *redis.Multi, e *LedgerEntry) {
+	// Taken outside of transaction, failed one leaves a gap
+	e.Id = r.client.Incr(r.formatKey("sequence", "ledger")).Val()
+	data, _ := json.Marshal(e)
+	member := redis.Z{Score: float64(e.Timestamp), Member: string(data)}
+	tx.ZAdd(r.formatKey("ledger"), member)
+
+	if field, login, ok := ledgerAccount(e.Debit); ok {
+		tx.HIncrBy(r.formatKey("miners", login), field, -e.Amount)
+		tx.HIncrBy(r.formatKey("finances"), field, -e.Amount)
+		tx.ZAdd(r.formatKey("ledger", login), member)
+	}
+	if field, login, ok := ledgerAccount(e.Credit); ok {
+		tx.HIncrBy(r.formatKey("miners", login), field, e.Amount)
+		tx.HIncrBy(r.formatKey("finances"), field, e.Amount)
+		tx.ZAdd(r.formatKey("ledger", login), member)
+	}
+	if field, ok := poolAccount(e.Debit); ok {
+		tx.HIncrBy(r.formatKey("finances"), field, -e.Amount)
+	}
+	if field, ok := poolAccount(e.Credit); ok {
+		tx.HIncrBy(r.formatKey("finances"), field, e.Amount)
+	}
+}
+
+// Manual credit or debit of miner balance, negative amount debits
+func (r *RedisClient) WriteAdjustment(login, reason string, amount int64) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	e := &LedgerEntry{Timestamp: r.timestamp(), Kind: LedgerAdjustment, Debit: "adjustment:" + reason, Credit: "balance:" + login, Amount: amount}
+	if amount < 0 {
+		e.Debit, e.Credit, e.Amount = e.Credit, e.Debit, -amount
+	}
+	_, err := tx.Exec(func() error {
+		r.post(tx, e)
+		return nil
+	})
+	if err == nil {
+		r.logEvent(&Event{Timestamp: e.Timestamp, Type: EventAdjustment, Login: login, Reason: reason, Amount: amount})
+	}
+	return err
+}
+
+// Returns ledger entries in [from, to] ms range, for whole pool if login is empty
+func (r *RedisClient) GetLedger(login string, from, to int64) ([]*LedgerEntry, error) {
+	key := r.formatKey("ledger")
+	if len(login) > 0 {
+		key = r.formatKey("ledger", login)
+	}
+	option := redis.ZRangeByScore{Min: strconv.FormatInt(from, 10), Max: strconv.FormatInt(to, 10)}
+	raw, err := r.client.ZRangeByScore(key, option).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*LedgerEntry, 0, len(raw))
+	for _, v := range raw {
+		var e LedgerEntry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
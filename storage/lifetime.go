@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// Daily difficulty is kept this long for lifetime contribution of account
+const DailyHistoryRetention = 366 * 24 * time.Hour
+
+// Difficulty credited to login during UTC day
+type DailyDifficulty struct {
+	Day        int64 `json:"day"`
+	Difficulty int64 `json:"difficulty"`
+}
+
+func monthStart(ts int64) int64 {
+	t := time.Unix(ts, 0).UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()
+}
+
+// Days are fields of monthly hash, so a year of account takes 13 keys.
+// Must be called inside share write transaction.
+func (r *RedisClient) writeDaily(tx *redis.Multi, ts int64, login string, diff int64) {
+	key := r.formatKey("daily", login, monthStart(ts))
+	tx.HIncrBy(key, strconv.FormatInt(ts-ts%86400, 10), diff)
+	tx.Expire(key, DailyHistoryRetention)
+}
+
+// Daily difficulty of login for days in [from, to] range, oldest first, timestamps in seconds
+func (r *RedisClient) GetDailyDifficulty(login string, from, to int64) ([]*DailyDifficulty, error) {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	from -= from % 86400
+	cmds, err := tx.Exec(func() error {
+		for month := monthStart(from); month <= to; month = time.Unix(month, 0).UTC().AddDate(0, 1, 0).Unix() {
+			tx.HGetAllMap(r.formatKey("daily", login, month))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*DailyDifficulty
+	for _, cmd := range cmds {
+		fields, _ := cmd.(*redis.StringStringMapCmd).Result()
+		for field, value := range fields {
+			day, _ := strconv.ParseInt(field, 10, 64)
+			if day < from || day > to {
+				continue
+			}
+			diff, _ := strconv.ParseInt(value, 10, 64)
+			result = append(result, &DailyDifficulty{Day: day, Difficulty: diff})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Day < result[j].Day })
+	return result, nil
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"gopkg.in/redis.v3"
+)
+
+// Reward parameters unlocker runs with, published for miners to verify
+type UnlockerParams struct {
+	// In percent
+	PoolFee        float64 `json:"poolFee"`
+	PoolFeeAddress string  `json:"poolFeeAddress"`
+	Depth          int64   `json:"depth"`
+	ImmatureDepth  int64   `json:"immatureDepth"`
+	KeepTxFees     bool    `json:"keepTxFees"`
+	// Fee of solo blocks in percent
+	SoloFee float64 `json:"soloFee"`
+	// Recipients of pool fee instead of poolFeeAddress
+	FeeSplit []FeeRecipient `json:"feeSplit,omitempty"`
+	// Receives donations of miners, pool fee recipients if blank
+	DonationAddress string `json:"donationAddress,omitempty"`
+	// When unlocker started with these parameters
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Account credited with part of pool fee, paid out like miner balance
+type FeeRecipient struct {
+	// What the part is for, e.g. operator, servers or dev fund
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	// Percent of pool fee
+	Percent float64 `json:"percent"`
+}
+
+// Pool fee in effect since timestamp
+type FeeChange struct {
+	Fee       float64 `json:"fee"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// Replaces parameters, fee is appended to history if it differs from the last one
+func (r *RedisClient) WriteUnlockerParams(params *UnlockerParams) error {
+	history, err := r.GetFeeHistory()
+	if err != nil {
+		return err
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	data, _ := json.Marshal(params)
+	change, _ := json.Marshal(&FeeChange{Fee: params.PoolFee, Timestamp: params.Timestamp})
+	_, err = tx.Exec(func() error {
+		tx.Set(r.formatKey("unlocker", "params"), string(data), 0)
+		if len(history) == 0 || history[len(history)-1].Fee != params.PoolFee {
+			tx.RPush(r.formatKey("unlocker", "fees"), string(change))
+		}
+		return nil
+	})
+	return err
+}
+
+// Nil if unlocker never started
+func (r *RedisClient) GetUnlockerParams() (*UnlockerParams, error) {
+	data, err := r.client.Get(r.formatKey("unlocker", "params")).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var params UnlockerParams
+	err = json.Unmarshal([]byte(data), &params)
+	return &params, err
+}
+
+// Fee changes, oldest first
+func (r *RedisClient) GetFeeHistory() ([]*FeeChange, error) {
+	rows, err := r.client.LRange(r.formatKey("unlocker", "fees"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	history := make([]*FeeChange, 0, len(rows))
+	for _, row := range rows {
+		var change FeeChange
+		if err := json.Unmarshal([]byte(row), &change); err == nil {
+			history = append(history, &change)
+		}
+	}
+	return history, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+)
+
+type Payment struct {
+	Timestamp int64  `json:"timestamp"`
+	TxHash    string `json:"tx"`
+	Address   string `json:"address"`
+	// In Shannon
+	Amount int64 `json:"amount"`
+}
+
+// Payment which doesn't match its transaction on chain
+type PaymentDiscrepancy struct {
+	Payment
+	Reason string `json:"reason"`
+}
+
+// Result of the last run of payment verification
+type PaymentsReport struct {
+	Timestamp     int64                 `json:"timestamp"`
+	Checked       int                   `json:"checked"`
+	Discrepancies []*PaymentDiscrepancy `json:"discrepancies"`
+}
+
+// Payment to contract kept on balance until it is reviewed and allowed
+type HeldPayment struct {
+	// Last payout run which held it
+	Timestamp int64  `json:"timestamp"`
+	Address   string `json:"login"`
+	Amount    int64  `json:"amount"`
+}
+
+// Whole payments history, oldest first
+func (r *RedisClient) GetAllPayments() ([]*Payment, error) {
+	rows, err := r.client.ZRangeWithScores(r.formatKey("payments", "all"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	payments := make([]*Payment, 0, len(rows))
+	for _, v := range rows {
+		fields := strings.Split(v.Member.(string), ":")
+		if len(fields) != 3 {
+			continue
+		}
+		amount, _ := strconv.ParseInt(fields[2], 10, 64)
+		payments = append(payments, &Payment{Timestamp: int64(v.Score), TxHash: fields[0], Address: fields[1], Amount: amount})
+	}
+	return payments, nil
+}
+
+func (r *RedisClient) WriteHeldPayment(login string, amount int64) error {
+	return r.client.HSet(r.formatKey("payments", "held"), login, join(r.timestamp()/1000, amount)).Err()
+}
+
+func (r *RedisClient) DeleteHeldPayment(login string) error {
+	return r.client.HDel(r.formatKey("payments", "held"), login).Err()
+}
+
+// Most recently held first
+func (r *RedisClient) GetHeldPayments() ([]*HeldPayment, error) {
+	raw, err := r.client.HGetAllMap(r.formatKey("payments", "held")).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*HeldPayment, 0, len(raw))
+	for login, v := range raw {
+		fields := strings.Split(v, ":")
+		if len(fields) != 2 {
+			continue
+		}
+		payment := &HeldPayment{Address: login}
+		payment.Timestamp, _ = strconv.ParseInt(fields[0], 10, 64)
+		payment.Amount, _ = strconv.ParseInt(fields[1], 10, 64)
+		result = append(result, payment)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Timestamp != result[j].Timestamp {
+			return result[i].Timestamp > result[j].Timestamp
+		}
+		return result[i].Address < result[j].Address
+	})
+	return result, nil
+}
+
+func (r *RedisClient) WritePaymentsReport(report *PaymentsReport) error {
+	data, _ := json.Marshal(report)
+	return r.client.Set(r.formatKey("payments", "report"), string(data), 0).Err()
+}
+
+// Nil if payments were never verified
+func (r *RedisClient) GetPaymentsReport() (*PaymentsReport, error) {
+	data, err := r.client.Get(r.formatKey("payments", "report")).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var report PaymentsReport
+	err = json.Unmarshal([]byte(data), &report)
+	return &report, err
+}
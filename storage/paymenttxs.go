@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+// States of payment transaction signed by pool
+const (
+	// Nonce is taken, nothing was signed
+	PaymentTxAllocated = "allocated"
+	// Signed and recorded before broadcast, it may have reached node or not
+	PaymentTxSigned = "signed"
+	// Accepted by node, waiting to be mined
+	PaymentTxSent = "sent"
+)
+
+// Payment transaction tracked by nonce from allocation until it is mined, so payouts
+// resume it after crash instead of paying again or leaving nonce gap
+type PaymentTx struct {
+	Nonce uint64 `json:"nonce"`
+	State string `json:"state"`
+	// Miner paid, or multisend contract with recipients of batch
+	Login      string           `json:"login"`
+	Amount     int64            `json:"amount"`
+	Recipients map[string]int64 `json:"recipients,omitempty"`
+	// Unsigned transaction of the latest broadcast, replacements are signed from it
+	Request json.RawMessage `json:"request"`
+	// Hashes of signed transactions of this nonce, replacements last
+	Hashes []string `json:"hashes,omitempty"`
+	Raw    string   `json:"raw,omitempty"`
+	Bumps  int      `json:"bumps"`
+	SentAt int64    `json:"sentAt"`
+}
+
+type PaymentTxStorage interface {
+	WritePaymentTx(tx *PaymentTx) error
+	// Tracked transactions, lowest nonce first
+	GetPaymentTxs() ([]*PaymentTx, error)
+	RemovePaymentTx(nonce uint64) error
+}
+
+func sortPaymentTxs(txs []*PaymentTx) {
+	sort.Slice(txs, func(i, j int) bool { return txs[i].Nonce < txs[j].Nonce })
+}
+
+func (r *RedisClient) WritePaymentTx(tx *PaymentTx) error {
+	data, _ := json.Marshal(tx)
+	return r.client.HSet(r.formatKey("payments", "txs"), strconv.FormatUint(tx.Nonce, 10), string(data)).Err()
+}
+
+func (r *RedisClient) GetPaymentTxs() ([]*PaymentTx, error) {
+	raw, err := r.client.HGetAllMap(r.formatKey("payments", "txs")).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*PaymentTx, 0, len(raw))
+	for _, v := range raw {
+		var tx PaymentTx
+		if err := json.Unmarshal([]byte(v), &tx); err != nil {
+			return nil, err
+		}
+		result = append(result, &tx)
+	}
+	sortPaymentTxs(result)
+	return result, nil
+}
+
+func (r *RedisClient) RemovePaymentTx(nonce uint64) error {
+	return r.client.HDel(r.formatKey("payments", "txs"), strconv.FormatUint(nonce, 10)).Err()
+}
+
+func (p *PostgresClient) WritePaymentTx(tx *PaymentTx) error {
+	data, _ := json.Marshal(tx)
+	_, err := p.db.Exec(`INSERT INTO payment_txs (pool, nonce, data) VALUES ($1, $2, $3)
+		ON CONFLICT (pool, nonce) DO UPDATE SET data = EXCLUDED.data`, p.pool, int64(tx.Nonce), string(data))
+	return err
+}
+
+func (p *PostgresClient) GetPaymentTxs() ([]*PaymentTx, error) {
+	rows, err := p.db.Query(`SELECT data FROM payment_txs WHERE pool = $1 ORDER BY nonce`, p.pool)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []*PaymentTx
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var tx PaymentTx
+		if err := json.Unmarshal([]byte(data), &tx); err != nil {
+			return nil, err
+		}
+		result = append(result, &tx)
+	}
+	return result, rows.Err()
+}
+
+func (p *PostgresClient) RemovePaymentTx(nonce uint64) error {
+	_, err := p.db.Exec(`DELETE FROM payment_txs WHERE pool = $1 AND nonce = $2`, p.pool, int64(nonce))
+	return err
+}
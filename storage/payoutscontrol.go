@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Actions of admin API and -payouts command
+const (
+	PayoutsRun    = "run"
+	PayoutsPause  = "pause"
+	PayoutsResume = "resume"
+)
+
+// Pause and manual runs of payouts set by admin, shared by pool and tenants
+type PayoutsControl struct {
+	Paused   bool   `json:"paused"`
+	PausedBy string `json:"pausedBy,omitempty"`
+	PausedAt int64  `json:"pausedAt,omitempty"`
+	// Time of the latest manual run request in ms, every payouts processor runs once for it
+	RunRequested int64  `json:"runRequested,omitempty"`
+	RequestedBy  string `json:"requestedBy,omitempty"`
+}
+
+func (r *RedisClient) GetPayoutsControl() (*PayoutsControl, error) {
+	fields, err := r.client.HGetAllMap(r.formatRootKey("payouts", "control")).Result()
+	if err != nil {
+		return nil, err
+	}
+	c := &PayoutsControl{PausedBy: fields["pausedBy"], RequestedBy: fields["requestedBy"]}
+	c.Paused = fields["paused"] == "1"
+	c.PausedAt, _ = strconv.ParseInt(fields["pausedAt"], 10, 64)
+	c.RunRequested, _ = strconv.ParseInt(fields["runRequested"], 10, 64)
+	return c, nil
+}
+
+func (r *RedisClient) SetPayoutsPaused(paused bool, operator string) error {
+	key := r.formatRootKey("payouts", "control")
+	if !paused {
+		return r.client.HMSet(key, "paused", "0", "pausedBy", operator, "pausedAt", "0").Err()
+	}
+	return r.client.HMSet(key, "paused", "1", "pausedBy", operator,
+		"pausedAt", strconv.FormatInt(util.MakeTimestamp(), 10)).Err()
+}
+
+// Returns time of request in ms
+func (r *RedisClient) RequestPayoutsRun(operator string) (int64, error) {
+	ms := util.MakeTimestamp()
+	err := r.client.HMSet(r.formatRootKey("payouts", "control"), "runRequested", strconv.FormatInt(ms, 10),
+		"requestedBy", operator).Err()
+	return ms, err
+}
+
+func (r *RedisClient) ControlPayouts(action, operator string) error {
+	switch action {
+	case PayoutsRun:
+		_, err := r.RequestPayoutsRun(operator)
+		return err
+	case PayoutsPause:
+		return r.SetPayoutsPaused(true, operator)
+	case PayoutsResume:
+		return r.SetPayoutsPaused(false, operator)
+	}
+	return fmt.Errorf("unknown action %q, must be run, pause or resume", action)
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Block solution which failed to reach any upstream, kept for resubmission
+type PendingBlock struct {
+	Login      string        `json:"login"`
+	Worker     string        `json:"worker"`
+	Params     []string      `json:"params"`
+	Height     uint64        `json:"height"`
+	Diff       int64         `json:"diff"`
+	ActualDiff int64         `json:"actualDiff"`
+	RoundDiff  int64         `json:"roundDiff"`
+	Window     time.Duration `json:"window"`
+	Solo       bool          `json:"solo,omitempty"`
+	// Time of the first submission in ms
+	FoundAt int64 `json:"foundAt"`
+}
+
+func (b *PendingBlock) field() string {
+	return strings.Join(b.Params, ":")
+}
+
+func (r *RedisClient) QueuePendingBlock(b *PendingBlock) error {
+	data, _ := json.Marshal(b)
+	return r.client.HSet(r.formatKey("blocks", "pending"), b.field(), string(data)).Err()
+}
+
+func (r *RedisClient) GetPendingBlocks() ([]*PendingBlock, error) {
+	raw, err := r.client.HGetAllMap(r.formatKey("blocks", "pending")).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*PendingBlock, 0, len(raw))
+	for _, v := range raw {
+		var b PendingBlock
+		if err := json.Unmarshal([]byte(v), &b); err == nil {
+			result = append(result, &b)
+		}
+	}
+	return result, nil
+}
+
+// Returns false if another proxy instance has already removed it
+func (r *RedisClient) RemovePendingBlock(b *PendingBlock) (bool, error) {
+	n, err := r.client.HDel(r.formatKey("blocks", "pending"), b.field()).Result()
+	return n > 0, err
+}
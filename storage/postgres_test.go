@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"database/sql"
+	"math/big"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestStorageConfigValidate(t *testing.T) {
+	var errs util.ConfigErrors
+	(&StorageConfig{}).Validate(&errs)
+	(&StorageConfig{Driver: "postgres", Postgres: PostgresConfig{DSN: "postgres://127.0.0.1/pool"}}).Validate(&errs)
+	if len(errs) != 0 {
+		t.Errorf("Must accept redis and postgres with dsn, got %v", errs)
+	}
+	(&StorageConfig{Driver: "postgres"}).Validate(&errs)
+	(&StorageConfig{Driver: "mysql"}).Validate(&errs)
+	if len(errs) != 2 {
+		t.Errorf("Must reject postgres without dsn and unknown driver, got %v", errs)
+	}
+}
+
+var (
+	testPostgresOnce sync.Once
+	testPostgresDSN  string
+	testPostgresErr  string
+	// Removes container started for tests, called by TestMain
+	stopTestPostgres = func() {}
+)
+
+// Runs against a scratch database given by POSTGRES_DSN, or a throwaway docker container
+// without it. Rows of every table with pool column are wiped.
+func newTestPostgres(t *testing.T) *PostgresClient {
+	testPostgresOnce.Do(func() {
+		testPostgresDSN = os.Getenv("POSTGRES_DSN")
+		if len(testPostgresDSN) == 0 {
+			testPostgresDSN, testPostgresErr = startTestPostgres()
+		}
+	})
+	if len(testPostgresDSN) == 0 {
+		t.Skip(testPostgresErr)
+	}
+	p := NewPostgresClient(&PostgresConfig{DSN: testPostgresDSN}, prefix)
+	rows, err := p.db.Query(`SELECT table_name FROM information_schema.columns WHERE column_name = 'pool' AND table_schema = current_schema()`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		rows.Scan(&table)
+		tables = append(tables, table)
+	}
+	rows.Close()
+	for _, table := range tables {
+		if _, err := p.db.Exec(`DELETE FROM `+table+` WHERE pool LIKE $1`, prefix+"%"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.db.Exec(`DELETE FROM pow`)
+	return p
+}
+
+// Starts postgres in docker, returns DSN or reason tests are skipped
+func startTestPostgres() (string, string) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", "POSTGRES_DSN is not set and docker is not available"
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-e", "POSTGRES_PASSWORD=pool", "-p", "127.0.0.1::5432", "postgres:16-alpine").Output()
+	if err != nil {
+		return "", "Failed to start postgres container: " + err.Error()
+	}
+	id := strings.TrimSpace(string(out))
+	stopTestPostgres = func() { exec.Command("docker", "stop", id).Run() }
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		return "", "Failed to get postgres container port: " + err.Error()
+	}
+	addr := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	dsn := "postgres://postgres:pool@" + addr + "/postgres?sslmode=disable"
+	db, _ := sql.Open("postgres", dsn)
+	defer db.Close()
+	// Init server of image listens on socket only, so TCP connection means it is ready
+	for i := 0; i < 60; i++ {
+		if db.Ping() == nil {
+			return dsn, ""
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return "", "Postgres container didn't start"
+}
+
+func TestPostgresAccounting(t *testing.T) {
+	p := newTestPostgres(t)
+
+	p.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1000, time.Hour)
+	if exist, _ := p.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1000, time.Hour); !exist {
+		t.Error("Must reject duplicate share")
+	}
+	p.WriteBlock("0xb", "rig1", []string{"0x1", "0x0", "0x0"}, 30, 40, 100, 1000, time.Hour)
+
+	candidates, err := p.GetCandidates(1000)
+	if err != nil || len(candidates) != 1 || candidates[0].TotalShares != 40 || candidates[0].BestShareLogin != "0xb" {
+		t.Fatalf("Must insert block candidate with round shares, got %v (%v)", candidates, err)
+	}
+	shares, _ := p.GetRoundShares(1000, "0x1")
+	if shares["0xa"] != 10 || shares["0xb"] != 30 {
+		t.Errorf("Must close round under block, got %v", shares)
+	}
+
+	block := candidates[0]
+	block.Height, block.Hash, block.Reward = 1001, "0xabc", big.NewInt(5000000000)
+	if err := p.WriteImmatureBlock(block, map[string]int64{"0xa": 1, "0xb": 3}); err != nil {
+		t.Fatal(err)
+	}
+	immature, _ := p.GetImmatureBlocks(1001)
+	if len(immature) != 1 || immature[0].RoundHeight != 1001 {
+		t.Fatalf("Must move block to immature at its height, got %v", immature)
+	}
+	block = immature[0]
+	block.Reward = big.NewInt(5000000000)
+	if err := p.WriteMaturedBlock(block, map[string]int64{"0xa": 1, "0xb": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if balance, _ := p.GetBalance("0xb"); balance != 3 {
+		t.Errorf("Must credit balance of matured block, got %v", balance)
+	}
+	stats, _ := p.GetMinerStats("0xb", 10)
+	if m := stats["stats"].(map[string]interface{}); m["immature"] != int64(0) || m["blocksFound"] != int64(1) {
+		t.Errorf("Must return immature credits and count blocks found, got %v", m)
+	}
+
+	if err := p.LockPayouts("0xb", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.LockPayouts("0xb", 3); err == nil {
+		t.Error("Must not acquire payouts lock twice")
+	}
+	p.UpdateBalance("0xb", 3)
+	if pending := p.GetPendingPayments(); len(pending) != 1 || pending[0].Amount != 3 {
+		t.Errorf("Must keep pending payment, got %v", pending)
+	}
+	p.WritePayment("0xb", "0xtx", 3)
+	if locked, _ := p.IsPayoutsLocked(); locked {
+		t.Error("Must unlock payouts after payment")
+	}
+	payments, _ := p.GetAllPayments()
+	if len(payments) != 1 || payments[0].TxHash != "0xtx" || len(p.GetPendingPayments()) != 0 {
+		t.Errorf("Must record payment, got %v", payments)
+	}
+	stats, _ = p.GetMinerStats("0xb", 10)
+	if m := stats["stats"].(map[string]interface{}); m["balance"] != int64(0) || m["paid"] != int64(3) {
+		t.Errorf("Must move balance to paid, got %v", m)
+	}
+
+	p.WriteHeldPayment("0xa", 1)
+	p.WriteHeldPayment("0xb", 2)
+	p.DeleteHeldPayment("0xb")
+	if held, err := p.GetHeldPayments(); err != nil || len(held) != 1 || held[0].Address != "0xa" {
+		t.Errorf("Must keep held payments until removed, got %v (%v)", held, err)
+	}
+
+	stats, err = p.CollectStats(time.Hour, 10, 10)
+	if err != nil || stats["maturedTotal"] != int64(1) || stats["minersTotal"] != 2 || stats["paymentsTotal"] != int64(1) {
+		t.Errorf("Must collect pool stats, got %v (%v)", stats, err)
+	}
+}
+
+func TestPostgresPrunePoW(t *testing.T) {
+	p := newTestPostgres(t)
+
+	p.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1000, time.Hour)
+	p.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 10, 10, 1000+powBacklog+1, time.Hour)
+	if err := p.prunePoW(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	p.db.QueryRow(`SELECT COUNT(*) FROM pow`).Scan(&n)
+	if n != 1 {
+		t.Errorf("Must prune PoW below backlog, got %v rows", n)
+	}
+}
+
+func TestPostgresTenants(t *testing.T) {
+	p := newTestPostgres(t)
+
+	p.ForTenant("alpha").WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1000, time.Hour)
+	if exist, _ := p.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1000, time.Hour); !exist {
+		t.Error("Must reject share submitted to another tenant")
+	}
+	if exists, _ := p.IsMinerExists("0xa"); exists {
+		t.Error("Must keep miners of tenant apart")
+	}
+	if exists, _ := p.ForTenant("alpha").IsMinerExists("0xa"); !exists {
+		t.Error("Must store miner of tenant")
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Reward schemes, shares of round are credited unless PPLNS is set.
+// PPS credits shares at expected value from buffer funded by blocks,
+// PPS+ does so with block subsidy only and splits the rest by round shares.
+const (
+	SchemePROP    = "PROP"
+	SchemePPLNS   = "PPLNS"
+	SchemePPS     = "PPS"
+	SchemePPSPlus = "PPS+"
+)
+
+// How block rewards are split among miners
+type RewardsConfig struct {
+	// PROP, PPLNS, PPS or PPS+, PROP if not set
+	Scheme string      `json:"scheme"`
+	PPLNS  PPLNSWindow `json:"pplns"`
+	PPS    PPSConfig   `json:"pps"`
+}
+
+type PPSConfig struct {
+	// Percent charged on share value, unlocker poolFee if not set
+	Fee float64 `json:"fee"`
+}
+
+// Shares credited by PPLNS block, one of limits is required.
+// Each share counts with its difficulty.
+type PPLNSWindow struct {
+	// Last accepted shares of pool
+	Shares int64 `json:"shares,omitempty"`
+	// Shares accepted within this period before block
+	Period string `json:"period,omitempty"`
+}
+
+func (c *RewardsConfig) Validate(errs *util.ConfigErrors) {
+	switch c.Scheme {
+	case "", SchemePROP:
+	case SchemePPS, SchemePPSPlus:
+		if c.PPS.Fee < 0 || c.PPS.Fee >= 100 {
+			errs.Addf("rewards.pps.fee: must be in [0, 100) percent")
+		}
+	case SchemePPLNS:
+		if (c.PPLNS.Shares > 0) == (len(c.PPLNS.Period) > 0) {
+			errs.Addf("rewards.pplns: exactly one of shares or period is required")
+		}
+		if c.PPLNS.Shares < 0 {
+			errs.Addf("rewards.pplns.shares: can't be negative")
+		}
+		errs.Duration("rewards.pplns.period", c.PPLNS.Period, true)
+	default:
+		errs.Addf("rewards.scheme: unknown scheme %q", c.Scheme)
+	}
+}
+
+func (c *RewardsConfig) SchemeName() string {
+	if len(c.Scheme) == 0 {
+		return SchemePROP
+	}
+	return c.Scheme
+}
+
+// Shares are credited at expected value when accepted
+func (c *RewardsConfig) PayPerShare() bool {
+	return c.Scheme == SchemePPS || c.Scheme == SchemePPSPlus
+}
+
+// Window of PPLNS scheme, nil for others
+func (c *RewardsConfig) window() *PPLNSWindow {
+	if c == nil || c.Scheme != SchemePPLNS {
+		return nil
+	}
+	return &c.PPLNS
+}
+
+// Oldest share timestamp in ms kept by period window at ms, 0 for window by count
+func (w *PPLNSWindow) cutoff(ms int64) int64 {
+	if len(w.Period) == 0 {
+		return 0
+	}
+	return ms - int64(util.MustParseDuration(w.Period)/1e6)
+}
+
+// Credits are split by PPLNS window from now on
+func (r *RedisClient) SetRewards(cfg *RewardsConfig) {
+	r.pplns = cfg.window()
+}
+
+// Window is a sorted set of shares by timestamp, trimmed on every write
+func (r *RedisClient) writePPLNS(tx *redis.Multi, ms int64, login, id string, diff int64) {
+	key := r.formatKey("pplns")
+	tx.ZAdd(key, redis.Z{Score: float64(ms), Member: join(diff, login, id, ms)})
+	if cutoff := r.pplns.cutoff(ms); cutoff > 0 {
+		tx.ZRemRangeByScore(key, "-inf", fmt.Sprint("(", cutoff))
+	} else {
+		tx.ZRemRangeByRank(key, 0, -r.pplns.Shares-1)
+	}
+}
+
+// Difficulty of shares in window by login
+func convertPPLNSWindow(members []string) map[string]int64 {
+	result := make(map[string]int64)
+	for _, member := range members {
+		// diff:login:id:ms
+		fields := strings.SplitN(member, ":", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		diff, _ := strconv.ParseInt(fields[0], 10, 64)
+		result[fields[1]] += diff
+	}
+	return result
+}
+
+// Replaces round shares with window, so unlocker credits it
+func (r *RedisClient) writePPLNSRound(height int64, nonce string, members []string) error {
+	shares := convertPPLNSWindow(members)
+	round := r.formatRound(height, nonce)
+	tx := r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		tx.Del(round)
+		for login, diff := range shares {
+			tx.HSet(round, login, strconv.FormatInt(diff, 10))
+		}
+		return nil
+	})
+	return err
+}
+
+// Credits are split by PPLNS window from now on
+func (p *PostgresClient) SetRewards(cfg *RewardsConfig) {
+	p.pplns = cfg.window()
+}
+
+// Difficulty of shares in window by login, inserted as round of block
+func (p *PostgresClient) writePPLNSRound(tx *sql.Tx, height uint64, nonce string, ms int64) error {
+	if _, err := tx.Exec(`DELETE FROM round_shares WHERE pool = $1 AND height = $2 AND nonce = $3`, p.pool, height, nonce); err != nil {
+		return err
+	}
+	var err error
+	if cutoff := p.pplns.cutoff(ms); cutoff > 0 {
+		_, err = tx.Exec(`INSERT INTO round_shares (pool, height, nonce, login, diff)
+			SELECT $1, $2, $3, login, SUM(diff) FROM pplns_shares WHERE pool = $1 AND ts >= $4 GROUP BY login`,
+			p.pool, height, nonce, cutoff/1000)
+	} else {
+		_, err = tx.Exec(`INSERT INTO round_shares (pool, height, nonce, login, diff)
+			SELECT $1, $2, $3, login, SUM(diff) FROM
+			(SELECT login, diff FROM pplns_shares WHERE pool = $1 ORDER BY id DESC LIMIT $4) w GROUP BY login`,
+			p.pool, height, nonce, p.pplns.Shares)
+	}
+	return err
+}
+
+// Transaction or connection pool
+type pgExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Rows out of window are removed when block is written and stats are flushed
+func (p *PostgresClient) trimPPLNS(q pgExecer, ms int64) error {
+	var err error
+	if cutoff := p.pplns.cutoff(ms); cutoff > 0 {
+		_, err = q.Exec(`DELETE FROM pplns_shares WHERE pool = $1 AND ts < $2`, p.pool, cutoff/1000)
+	} else {
+		_, err = q.Exec(`DELETE FROM pplns_shares WHERE pool = $1 AND id <= (
+			SELECT id FROM pplns_shares WHERE pool = $1 ORDER BY id DESC OFFSET $2 LIMIT 1)`, p.pool, p.pplns.Shares)
+	}
+	return err
+}
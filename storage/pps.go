@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Pool account of PPS buffer, funded by matured blocks and debited by share credits
+const PPSAccount = "pps"
+
+type PPSStorage interface {
+	// Adds expected value of accepted share in Shannon to unsettled credit of login
+	AccruePPS(login string, value float64) error
+	// Moves whole Shannon of unsettled credits from buffer to balances, returns credited amounts
+	SettlePPS() (map[string]int64, error)
+	// Credits part of matured block reward to buffer
+	FundPPS(block *BlockData, amount int64) error
+	// Negative if share credits exceeded block rewards so far
+	GetPPSBuffer() (int64, error)
+}
+
+// Pool account field in finances
+func poolAccount(account string) (string, bool) {
+	if account == PPSAccount {
+		return "ppsBuffer", true
+	}
+	return "", false
+}
+
+func ppsCredit(ms int64, login string, amount int64) *LedgerEntry {
+	return &LedgerEntry{Timestamp: ms, Kind: LedgerPPS, Debit: PPSAccount, Credit: "balance:" + login, Amount: amount}
+}
+
+func ppsFunding(ms int64, block *BlockData, amount int64) *LedgerEntry {
+	return &LedgerEntry{Timestamp: ms, Kind: LedgerPPS, Debit: block.ledgerAccount(), Credit: PPSAccount, Amount: amount, Ref: block.Hash}
+}
+
+// Whole Shannon of unsettled credits
+func settledCredits(accrued map[string]float64) map[string]int64 {
+	credits := make(map[string]int64)
+	for login, value := range accrued {
+		if n := int64(value); n > 0 {
+			credits[login] = n
+		}
+	}
+	return credits
+}
+
+// Unsettled credits are not part of event log, they are lost if redis state is rebuilt
+func (r *RedisClient) AccruePPS(login string, value float64) error {
+	return r.client.HIncrByFloat(r.formatKey("pps", "accrued"), login, value).Err()
+}
+
+// Settled amount is subtracted by script, so shares accrued meanwhile are kept
+func (r *RedisClient) SettlePPS() (map[string]int64, error) {
+	ms := r.timestamp()
+	val, err := r.client.Eval(settlePPSScript, []string{r.formatKey("pps", "accrued")},
+		[]string{r.prefix, strconv.FormatInt(ms, 10)}).Result()
+	if err != nil {
+		return nil, err
+	}
+	rows, _ := val.([]interface{})
+	credits := make(map[string]int64)
+	for i := 0; i+1 < len(rows); i += 2 {
+		login, _ := rows[i].(string)
+		amount, _ := rows[i+1].(string)
+		credits[login], _ = strconv.ParseInt(amount, 10, 64)
+	}
+	if len(credits) == 0 {
+		return credits, nil
+	}
+	r.logEvent(&Event{Timestamp: ms, Type: EventPPSCredit, Rewards: credits})
+	return credits, nil
+}
+
+// Settled credits of event log
+func (r *RedisClient) replayPPSCredits(credits map[string]int64) error {
+	ms := r.timestamp()
+	tx := r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		for login, amount := range credits {
+			r.post(tx, ppsCredit(ms, login, amount))
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *RedisClient) FundPPS(block *BlockData, amount int64) error {
+	ms := r.timestamp()
+	tx := r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		r.post(tx, ppsFunding(ms, block, amount))
+		return nil
+	})
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventPPSFund, Block: newBlockRecord(block), Amount: amount})
+	}
+	return err
+}
+
+func (r *RedisClient) GetPPSBuffer() (int64, error) {
+	v, err := r.client.HGet(r.formatKey("finances"), "ppsBuffer").Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (p *PostgresClient) AccruePPS(login string, value float64) error {
+	_, err := p.db.Exec(`INSERT INTO pps_accrued (pool, login, value) VALUES ($1, $2, $3)
+		ON CONFLICT (pool, login) DO UPDATE SET value = pps_accrued.value + EXCLUDED.value`, p.pool, login, value)
+	return err
+}
+
+func (p *PostgresClient) SettlePPS() (map[string]int64, error) {
+	var credits map[string]int64
+	err := p.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT login, value FROM pps_accrued WHERE pool = $1 AND value >= 1 FOR UPDATE`, p.pool)
+		if err != nil {
+			return err
+		}
+		accrued := make(map[string]float64)
+		for rows.Next() {
+			var login string
+			var value float64
+			if err := rows.Scan(&login, &value); err != nil {
+				rows.Close()
+				return err
+			}
+			accrued[login] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		credits = settledCredits(accrued)
+		ms := util.MakeTimestamp()
+		for login, amount := range credits {
+			if err := p.post(tx, ppsCredit(ms, login, amount)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`UPDATE pps_accrued SET value = value - $3 WHERE pool = $1 AND login = $2`, p.pool, login, amount)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return credits, err
+}
+
+func (p *PostgresClient) FundPPS(block *BlockData, amount int64) error {
+	return p.inTx(func(tx *sql.Tx) error {
+		return p.post(tx, ppsFunding(util.MakeTimestamp(), block, amount))
+	})
+}
+
+func (p *PostgresClient) GetPPSBuffer() (int64, error) {
+	var v int64
+	err := p.db.QueryRow(`SELECT pps_buffer FROM finances WHERE pool = $1`, p.pool).Scan(&v)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return v, err
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"encoding/json"
+)
+
+// Number of block race reports kept
+const blockRacesLimit = 1000
+
+// Timings of block solution rejected by upstreams, times in ms
+type BlockRace struct {
+	Instance string `json:"instance"`
+	Tenant   string `json:"tenant,omitempty"`
+	Login    string `json:"login"`
+	Worker   string `json:"worker"`
+	Height   uint64 `json:"height"`
+	// When share was received by proxy
+	ReceivedAt int64 `json:"receivedAt"`
+	// Since first job of this height was fetched until share was received
+	TemplateAge float64 `json:"templateAge"`
+	// Since share was received until it was submitted
+	VerifyDelay float64 `json:"verifyDelay"`
+	// Node already had block at solution height
+	Stale     bool                 `json:"stale"`
+	Upstreams []*BlockRaceUpstream `json:"upstreams"`
+}
+
+type BlockRaceUpstream struct {
+	Name     string  `json:"name"`
+	Accepted bool    `json:"accepted"`
+	Error    string  `json:"error,omitempty"`
+	Latency  float64 `json:"latency"`
+	// Latest block of node right after rejection, 0 if unknown
+	Head uint64 `json:"head"`
+}
+
+// Reports of all proxy instances and tenants, newest first
+func (r *RedisClient) WriteBlockRace(race *BlockRace) error {
+	data, _ := json.Marshal(race)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		tx.LPush(r.formatRootKey("blockraces"), string(data))
+		tx.LTrim(r.formatRootKey("blockraces"), 0, blockRacesLimit-1)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisClient) GetBlockRaces(limit int64) ([]*BlockRace, error) {
+	rows, err := r.client.LRange(r.formatRootKey("blockraces"), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	races := make([]*BlockRace, 0, len(rows))
+	for _, row := range rows {
+		var race BlockRace
+		if json.Unmarshal([]byte(row), &race) == nil {
+			races = append(races, &race)
+		}
+	}
+	return races, nil
+}
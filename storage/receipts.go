@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"encoding/json"
+)
+
+// Notification type of mined payment transaction
+const WatchReceipt = "receipt"
+
+// Outcome of payment transaction, recorded by payouts once it is mined
+type PaymentReceipt struct {
+	TxHash    string `json:"tx"`
+	Login     string `json:"login"`
+	Amount    int64  `json:"amount"`
+	BlockHash string `json:"block"`
+	GasUsed   uint64 `json:"gasUsed"`
+	// Effective gas price and fee paid by pool, in Wei
+	GasPrice  string `json:"gasPrice"`
+	Fee       string `json:"fee"`
+	Success   bool   `json:"success"`
+	Timestamp int64  `json:"timestamp"`
+	// Miners paid by batch transaction, login is multisend contract then
+	Recipients map[string]int64 `json:"recipients,omitempty"`
+}
+
+// Stores receipt and notifies watcher of paid address
+func (r *RedisClient) WritePaymentReceipt(receipt *PaymentReceipt) error {
+	data, _ := json.Marshal(receipt)
+	if err := r.client.HSet(r.formatKey("payments", "receipts"), receipt.TxHash, string(data)).Err(); err != nil {
+		return err
+	}
+	recipients := receipt.Recipients
+	if len(recipients) == 0 {
+		recipients = map[string]int64{receipt.Login: receipt.Amount}
+	}
+	var notifications []*WatchNotification
+	for login, amount := range recipients {
+		notifications = append(notifications, &WatchNotification{
+			Timestamp: receipt.Timestamp * 1000, Type: WatchReceipt, Login: login, Amount: amount,
+			TxHash: receipt.TxHash, Block: receipt.BlockHash, GasUsed: receipt.GasUsed, Fee: receipt.Fee,
+		})
+	}
+	r.queueWatch(notifications)
+	return nil
+}
+
+// Receipts by tx hash, payments not mined yet are missing
+func (r *RedisClient) GetPaymentReceipts(txHashes []string) (map[string]*PaymentReceipt, error) {
+	result := make(map[string]*PaymentReceipt)
+	if len(txHashes) == 0 {
+		return result, nil
+	}
+	rows, err := r.client.HMGet(r.formatKey("payments", "receipts"), txHashes...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if s, ok := row.(string); ok {
+			var receipt PaymentReceipt
+			if err := json.Unmarshal([]byte(s), &receipt); err == nil {
+				result[receipt.TxHash] = &receipt
+			}
+		}
+	}
+	return result, nil
+}
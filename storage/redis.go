@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"crypto/tls"
 	"fmt"
+	"log"
 	"math/big"
+	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/redis.v3"
@@ -14,14 +18,78 @@ import (
 
 type Config struct {
 	Endpoint string `json:"endpoint"`
-	Password string `json:"password"`
-	Database int64  `json:"database"`
-	PoolSize int    `json:"poolSize"`
+	// Prefix of all keys of this pool, coin if not set.
+	// Pools sharing one redis deployment must have distinct prefixes.
+	Prefix string `json:"prefix"`
+	// ACL user of Redis 6+, password alone authenticates default user
+	Username string    `json:"username"`
+	Password string    `json:"password"`
+	Database int64     `json:"database"`
+	PoolSize int       `json:"poolSize"`
+	TLS      TLSConfig `json:"tls"`
+	// Follow master of Sentinel deployment instead of fixed endpoint
+	Sentinel SentinelConfig `json:"sentinel"`
+	// Append accounting events to redis stream, they can be exported and replayed to rebuild redis state
+	EventLog EventLogConfig `json:"eventLog"`
+	// Keep hourly share totals per worker this long for CSV export, blank to disable
+	WorkerHistory string `json:"workerHistory"`
+	// Store hashrate samples in RedisTimeSeries module if it is loaded
+	TimeSeries bool `json:"timeSeries"`
+	// Batch share writes into one transaction per interval
+	ShareBuffer ShareBufferConfig `json:"shareBuffer"`
+}
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if strings.ContainsAny(c.Prefix, ":*?[] \t") {
+		errs.Addf("redis.prefix: can't contain separator, glob or space characters")
+	}
+	errs.Duration("redis.workerHistory", c.WorkerHistory, true)
+	errs.Duration("redis.shareBuffer.interval", c.ShareBuffer.Interval, true)
+	if c.ShareBuffer.MaxBatch < 0 {
+		errs.Addf("redis.shareBuffer.maxBatch: can't be negative")
+	}
+	if len(c.Username) > 0 && len(c.Password) == 0 {
+		errs.Addf("redis.password: required with username")
+	}
+	if (len(c.TLS.CertFile) > 0) != (len(c.TLS.KeyFile) > 0) {
+		errs.Addf("redis.tls: certFile and keyFile must be set together")
+	}
+	if c.TLS.InsecureSkipVerify && len(c.TLS.CAFile) > 0 {
+		errs.Addf("redis.tls: caFile can't be used with insecureSkipVerify")
+	}
+	if c.Sentinel.Enabled() {
+		if len(c.Sentinel.Addrs) == 0 {
+			errs.Addf("redis.sentinel.addrs: required with masterName")
+		}
+		for _, addr := range c.Sentinel.Addrs {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				errs.Addf("redis.sentinel.addrs: invalid address %q", addr)
+			}
+		}
+	} else if len(c.Sentinel.Addrs) > 0 {
+		errs.Addf("redis.sentinel.masterName: required with addrs")
+	}
 }
 
 type RedisClient struct {
 	client *redis.Client
 	prefix string
+	// Prefix of keys shared by all tenants, same as prefix unless namespaced
+	root   string
+	tenant string
+	events *eventLog
+	// Fixed clock used while replaying events
+	replayTs int64
+	// Retention of hourly worker stats
+	history time.Duration
+	// RedisTimeSeries module detected and enabled
+	timeSeries bool
+	// Login to hour of last compaction rule check
+	tsSeries *sync.Map
+	// Write-behind buffer of accepted shares, nil if shares are written right away
+	shares *shareBuffer
+	// Window of PPLNS scheme, nil if round shares are credited
+	pplns *PPLNSWindow
 }
 
 type BlockData struct {
@@ -41,8 +109,18 @@ type BlockData struct {
 	ImmatureReward string   `json:"-"`
 	RewardString   string   `json:"reward"`
 	RoundHeight    int64    `json:"-"`
+	ExtraData      string   `json:"extraData,omitempty"`
+	// Round shares relative to block difficulty, 1.0 is expected
+	Effort float64 `json:"effort"`
+	// Finder of solo block, who gets whole reward
+	Solver string `json:"solver,omitempty"`
+	// Highest difficulty achieved by a share of the round
+	BestShare      int64  `json:"bestShare,omitempty"`
+	BestShareLogin string `json:"bestShareLogin,omitempty"`
 	candidateKey   string
 	immatureKey    string
+	// Row of block in PostgreSQL storage
+	rowId int64
 }
 
 func (b *BlockData) RewardInShannon() int64 {
@@ -58,12 +136,36 @@ func (b *BlockData) serializeHash() string {
 	}
 }
 
+func (b *BlockData) setEffort() {
+	if b.Difficulty > 0 {
+		b.Effort = float64(b.TotalShares) / float64(b.Difficulty)
+	}
+}
+
 func (b *BlockData) RoundKey() string {
 	return join(b.RoundHeight, b.Hash)
 }
 
+// Ledger source account of block reward
+func (b *BlockData) ledgerAccount() string {
+	return "block:" + b.Hash
+}
+
+// Solver is appended to solo blocks only, so members of pool blocks keep their format
 func (b *BlockData) key() string {
-	return join(b.UncleHeight, b.Orphan, b.Nonce, b.serializeHash(), b.Timestamp, b.Difficulty, b.TotalShares, b.Reward)
+	key := join(b.UncleHeight, b.Orphan, b.Nonce, b.serializeHash(), b.Timestamp, b.Difficulty, b.TotalShares, b.Reward)
+	if len(b.Solver) > 0 {
+		key = join(key, b.Solver)
+	}
+	return key
+}
+
+func (b *BlockData) candidateMember() string {
+	member := join(b.Nonce, b.PowHash, b.MixDigest, b.Timestamp, b.Difficulty, b.TotalShares)
+	if len(b.Solver) > 0 {
+		member = join(member, b.Solver)
+	}
+	return member
 }
 
 type Miner struct {
@@ -75,17 +177,87 @@ type Miner struct {
 
 type Worker struct {
 	Miner
-	TotalHR int64 `json:"hr2"`
+	TotalHR     int64 `json:"hr2"`
+	StaleShares int64 `json:"staleShares"`
+	// Hashrate reported by mining software within small window
+	ReportedHR int64  `json:"reportedHr"`
+	ClientId   string `json:"clientId,omitempty"`
+}
+
+// Prefix of keys, which is coin unless set explicitly
+func (c *Config) KeyPrefix(coin string) string {
+	if len(c.Prefix) > 0 {
+		return c.Prefix
+	}
+	return coin
 }
 
 func NewRedisClient(cfg *Config, prefix string) *RedisClient {
-	client := redis.NewClient(&redis.Options{
+	opts := &redis.Options{
 		Addr:     cfg.Endpoint,
 		Password: cfg.Password,
 		DB:       cfg.Database,
 		PoolSize: cfg.PoolSize,
-	})
-	return &RedisClient{client: client, prefix: prefix}
+	}
+	if cfg.TLS.Enabled || len(cfg.Username) > 0 || cfg.Sentinel.Enabled() {
+		var tlsConfig *tls.Config
+		if cfg.TLS.Enabled {
+			var err error
+			endpoint := cfg.Endpoint
+			if cfg.Sentinel.Enabled() {
+				endpoint = ""
+			}
+			if tlsConfig, err = cfg.TLS.load(endpoint); err != nil {
+				log.Fatalf("Failed to load redis TLS config: %v", err)
+			}
+		}
+		var sentinel *sentinelResolver
+		if cfg.Sentinel.Enabled() {
+			sentinel = newSentinelResolver(&cfg.Sentinel)
+			opts.Addr = "sentinel:" + cfg.Sentinel.MasterName
+			go sentinel.watch()
+		}
+		opts.Dialer = newDialer(cfg, tlsConfig, sentinel)
+		// Dialer authenticates with username
+		if len(cfg.Username) > 0 {
+			opts.Password = ""
+		}
+	}
+	client := redis.NewClient(opts)
+	r := &RedisClient{client: client, prefix: prefix, root: prefix}
+	if cfg.EventLog.Enabled {
+		r.events = newEventLog(&cfg.EventLog)
+	}
+	if len(cfg.WorkerHistory) > 0 {
+		r.history = util.MustParseDuration(cfg.WorkerHistory)
+	}
+	r.initTimeSeries(cfg.TimeSeries)
+	if cfg.ShareBuffer.Enabled {
+		var intv time.Duration
+		r.shares, intv = newShareBuffer(&cfg.ShareBuffer)
+		go r.shares.run(intv)
+	}
+	return r
+}
+
+// Client for keys of a tenant under <prefix>:tenant:<name>, sharing connection pool and event log.
+// Recent PoW, node states, lists and DDoS mode stay global, so a share can't be credited twice.
+func (r *RedisClient) Namespace(name string) *RedisClient {
+	return &RedisClient{client: r.client, prefix: join(r.root, "tenant", name), root: r.root, tenant: name, events: r.events, history: r.history,
+		timeSeries: r.timeSeries, tsSeries: r.tsSeries, shares: r.shares, pplns: r.pplns}
+}
+
+// Name of tenant, empty for pool itself
+func (r *RedisClient) Tenant() string {
+	return r.tenant
+}
+
+// Timestamp in ms used by accounting writes, taken from event while replaying
+func (r *RedisClient) timestamp() int64 {
+	if r.replayTs > 0 {
+		return r.replayTs
+	}
+	return util.MakeTimestamp()
 }
 
 func (r *RedisClient) Client() *redis.Client {
@@ -102,7 +274,7 @@ func (r *RedisClient) BgSave() (string, error) {
 
 // Always returns list of addresses. If Redis fails it will return empty list.
 func (r *RedisClient) GetBlacklist() ([]string, error) {
-	cmd := r.client.SMembers(r.formatKey("blacklist"))
+	cmd := r.client.SMembers(r.formatRootKey("blacklist"))
 	if cmd.Err() != nil {
 		return []string{}, cmd.Err()
 	}
@@ -111,7 +283,7 @@ func (r *RedisClient) GetBlacklist() ([]string, error) {
 
 // Always returns list of IPs. If Redis fails it will return empty list.
 func (r *RedisClient) GetWhitelist() ([]string, error) {
-	cmd := r.client.SMembers(r.formatKey("whitelist"))
+	cmd := r.client.SMembers(r.formatRootKey("whitelist"))
 	if cmd.Err() != nil {
 		return []string{}, cmd.Err()
 	}
@@ -123,19 +295,92 @@ func (r *RedisClient) WriteNodeState(id string, height uint64, diff *big.Int) er
 	defer tx.Close()
 
 	now := util.MakeTimestamp() / 1000
+	minute := now - now%60
+
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatRootKey("nodes"), join(id, "name"), id)
+		tx.HSet(r.formatRootKey("nodes"), join(id, "height"), strconv.FormatUint(height, 10))
+		tx.HSet(r.formatRootKey("nodes"), join(id, "difficulty"), diff.String())
+		tx.HSet(r.formatRootKey("nodes"), join(id, "lastBeat"), strconv.FormatInt(now, 10))
+		// One entry per minute with heartbeat, used for uptime history
+		tx.ZAdd(r.formatRootKey("nodes", "history", id), redis.Z{Score: float64(minute), Member: strconv.FormatInt(minute, 10)})
+		return nil
+	})
+	return err
+}
+
+// Runtime metrics of node, served with node state in stats
+func (r *RedisClient) WriteNodeMetrics(id string, metrics map[string]string) error {
+	tx := r.client.Multi()
+	defer tx.Close()
 
 	_, err := tx.Exec(func() error {
-		tx.HSet(r.formatKey("nodes"), join(id, "name"), id)
-		tx.HSet(r.formatKey("nodes"), join(id, "height"), strconv.FormatUint(height, 10))
-		tx.HSet(r.formatKey("nodes"), join(id, "difficulty"), diff.String())
-		tx.HSet(r.formatKey("nodes"), join(id, "lastBeat"), strconv.FormatInt(now, 10))
+		for name, value := range metrics {
+			tx.HSet(r.formatRootKey("nodes"), join(id, name), value)
+		}
 		return nil
 	})
 	return err
 }
 
+func (r *RedisClient) getNodeNames() ([]string, error) {
+	keys, err := r.client.HKeys(r.formatRootKey("nodes")).Result()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, key := range keys {
+		parts := strings.Split(key, ":")
+		if len(parts) == 2 && parts[1] == "name" {
+			names = append(names, parts[0])
+		}
+	}
+	return names, nil
+}
+
+// Returns minutes (unix timestamps) with node heartbeat since given timestamp for each node
+func (r *RedisClient) GetNodeHistory(since int64) (map[string][]int64, error) {
+	names, err := r.getNodeNames()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]int64)
+	option := redis.ZRangeByScore{Min: strconv.FormatInt(since, 10), Max: "+inf"}
+	for _, name := range names {
+		minutes, err := r.client.ZRangeByScore(r.formatRootKey("nodes", "history", name), option).Result()
+		if err != nil {
+			return nil, err
+		}
+		history := make([]int64, 0, len(minutes))
+		for _, v := range minutes {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			history = append(history, n)
+		}
+		result[name] = history
+	}
+	return result, nil
+}
+
+func (r *RedisClient) FlushNodeHistory(window time.Duration) (int64, error) {
+	names, err := r.getNodeNames()
+	if err != nil {
+		return 0, err
+	}
+	now := util.MakeTimestamp() / 1000
+	max := fmt.Sprint("(", now-int64(window/time.Second))
+	total := int64(0)
+	for _, name := range names {
+		n, err := r.client.ZRemRangeByScore(r.formatRootKey("nodes", "history", name), "-inf", max).Result()
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
 func (r *RedisClient) GetNodeStates() ([]map[string]interface{}, error) {
-	cmd := r.client.HGetAllMap(r.formatKey("nodes"))
+	cmd := r.client.HGetAllMap(r.formatRootKey("nodes"))
 	if cmd.Err() != nil {
 		return nil, cmd.Err()
 	}
@@ -159,14 +404,40 @@ func (r *RedisClient) GetNodeStates() ([]map[string]interface{}, error) {
 	return v, nil
 }
 
+// Heights of PoW kept for duplicate check, proxies keep at most that many job heights
+const powBacklog = 8
+
+// Sweeps PoW of old heights and adds share in one step, so concurrent submits
+// to different proxy instances can't both pass
+var checkPoWScript = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[2])
+return redis.call('ZADD', KEYS[1], ARGV[1], ARGV[3])
+`
+
+// Set of submitted PoW is shared by all proxy instances and tenants
 func (r *RedisClient) checkPoWExist(height uint64, params []string) (bool, error) {
-	// Sweep PoW backlog for previous blocks, we have 3 templates back in RAM
-	r.client.ZRemRangeByScore(r.formatKey("pow"), "-inf", fmt.Sprint("(", height-8))
-	val, err := r.client.ZAdd(r.formatKey("pow"), redis.Z{Score: float64(height), Member: strings.Join(params, ":")}).Result()
-	return val == 0, err
+	var minHeight uint64
+	if height > powBacklog {
+		minHeight = height - powBacklog
+	}
+	args := []string{strconv.FormatUint(height, 10), strconv.FormatUint(minHeight, 10), strings.Join(params, ":")}
+	val, err := r.client.Eval(checkPoWScript, []string{r.formatRootKey("pow")}, args).Result()
+	if err != nil {
+		return false, err
+	}
+	added, _ := val.(int64)
+	return added == 0, nil
+}
+
+func (r *RedisClient) WriteShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeRoundShare(login, id, params, diff, actualDiff, height, window, false)
+}
+
+func (r *RedisClient) WriteStaleShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeRoundShare(login, id, params, diff, actualDiff, height, window, true)
 }
 
-func (r *RedisClient) WriteShare(login, id string, params []string, diff int64, height uint64, window time.Duration) (bool, error) {
+func (r *RedisClient) writeRoundShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration, stale bool) (bool, error) {
 	exist, err := r.checkPoWExist(height, params)
 	if err != nil {
 		return false, err
@@ -175,21 +446,34 @@ func (r *RedisClient) WriteShare(login, id string, params []string, diff int64,
 	if exist {
 		return true, nil
 	}
+	ms := r.timestamp()
+	ts := ms / 1000
+
+	// Replay writes right away, it depends on order of events only
+	if r.shares != nil && r.replayTs == 0 {
+		r.shares.add(&bufferedShare{r: r, ms: ms, login: login, id: id, params: params, diff: diff,
+			actualDiff: actualDiff, height: height, window: window, stale: stale})
+		return false, nil
+	}
 	tx := r.client.Multi()
 	defer tx.Close()
 
-	ms := util.MakeTimestamp()
-	ts := ms / 1000
-
 	_, err = tx.Exec(func() error {
 		r.writeShare(tx, ms, ts, login, id, diff, window)
+		r.writeBestShare(tx, login, actualDiff)
 		tx.HIncrBy(r.formatKey("stats"), "roundShares", diff)
+		if stale {
+			r.countStaleShare(tx, login, id, window)
+		}
 		return nil
 	})
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventShare, Login: login, Worker: id, Params: params, Diff: diff, ActualDiff: actualDiff, Height: height, Window: window, Stale: stale})
+	}
 	return false, err
 }
 
-func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+func (r *RedisClient) WriteBlock(login, id string, params []string, diff, actualDiff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
 	exist, err := r.checkPoWExist(height, params)
 	if err != nil {
 		return false, err
@@ -198,50 +482,147 @@ func (r *RedisClient) WriteBlock(login, id string, params []string, diff, roundD
 	if exist {
 		return true, nil
 	}
+	// Buffered shares belong to the round this block closes, failed flush is retried
+	// by buffer and must not lose the block
+	if err := r.FlushShares(); err != nil {
+		log.Printf("Failed to flush buffered shares before block of %v: %v", login, err)
+	}
 	tx := r.client.Multi()
 	defer tx.Close()
 
-	ms := util.MakeTimestamp()
+	ms := r.timestamp()
 	ts := ms / 1000
 
+	var pplns *redis.StringSliceCmd
 	cmds, err := tx.Exec(func() error {
 		r.writeShare(tx, ms, ts, login, id, diff, window)
+		if r.pplns != nil {
+			pplns = tx.ZRange(r.formatKey("pplns"), 0, -1)
+		}
+		r.writeBestShare(tx, login, actualDiff)
 		tx.HSet(r.formatKey("stats"), "lastBlockFound", strconv.FormatInt(ts, 10))
 		tx.HDel(r.formatKey("stats"), "roundShares")
 		tx.ZIncrBy(r.formatKey("finders"), 1, login)
 		tx.HIncrBy(r.formatKey("miners", login), "blocksFound", 1)
 		tx.Rename(r.formatKey("shares", "roundCurrent"), r.formatRound(int64(height), params[0]))
+		tx.ZRevRangeWithScores(r.formatKey("best", "round"), 0, 0)
+		tx.Del(r.formatKey("best", "round"))
 		tx.HGetAllMap(r.formatRound(int64(height), params[0]))
 		return nil
 	})
 	if err != nil {
 		return false, err
 	} else {
-		sharesMap, _ := cmds[10].(*redis.StringStringMapCmd).Result()
+		// Number of share write commands depends on storage options
+		sharesMap, _ := cmds[len(cmds)-1].(*redis.StringStringMapCmd).Result()
+		best := convertBestShares(cmds[len(cmds)-3].(*redis.ZSliceCmd))
 		totalShares := int64(0)
 		for _, v := range sharesMap {
 			n, _ := strconv.ParseInt(v, 10, 64)
 			totalShares += n
 		}
-		hashHex := strings.Join(params, ":")
-		s := join(hashHex, ts, roundDiff, totalShares)
-		cmd := r.client.ZAdd(r.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: s})
+		// Effort is still computed from shares of round
+		if pplns != nil {
+			if err := r.writePPLNSRound(int64(height), params[0], pplns.Val()); err != nil {
+				return false, err
+			}
+		}
+		candidate := &BlockData{Nonce: params[0], PowHash: params[1], MixDigest: params[2], Timestamp: ts,
+			Difficulty: roundDiff, TotalShares: totalShares}
+		cmd := r.client.ZAdd(r.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: candidate.candidateMember()})
+		if cmd.Err() == nil && len(best) > 0 {
+			r.client.HSet(r.formatKey("blocks", "best"), params[0], join(best[0].Difficulty, best[0].Login))
+		}
+		if cmd.Err() == nil {
+			r.logEvent(&Event{Timestamp: ms, Type: EventBlock, Login: login, Worker: id, Params: params, Diff: diff, ActualDiff: actualDiff, RoundDiff: roundDiff, Height: height, Window: window})
+		}
 		return false, cmd.Err()
 	}
 }
 
+// Stale shares are credited as regular ones, this counts them per miner and worker
+func (r *RedisClient) countStaleShare(tx *redis.Multi, login, id string, window time.Duration) {
+	tx.HIncrBy(r.formatKey("miners", login), "staleShares", 1)
+	tx.HIncrBy(r.formatKey("stale", login), id, 1)
+	tx.Expire(r.formatKey("stale", login), window)
+	tx.HIncrBy(r.formatKey("stats"), "staleShares", 1)
+}
+
+// Hashrate reported by mining software, kept per worker with client id
+func (r *RedisClient) WriteReportedHashrate(login, id string, hashrate int64, clientId string, expire time.Duration) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ts := util.MakeTimestamp() / 1000
+	_, err := tx.Exec(func() error {
+		tx.HSet(r.formatKey("reported", login), id, join(hashrate, ts, clientId))
+		tx.Expire(r.formatKey("reported", login), expire)
+		return nil
+	})
+	return err
+}
+
 func (r *RedisClient) writeShare(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
 	tx.HIncrBy(r.formatKey("shares", "roundCurrent"), login, diff)
-	tx.ZAdd(r.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms)})
-	tx.ZAdd(r.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: join(diff, id, ms)})
-	tx.Expire(r.formatKey("hashrate", login), expire) // Will delete hashrates for miners that gone
+	if r.pplns != nil {
+		r.writePPLNS(tx, ms, login, id, diff)
+	}
+	r.writeHashrate(tx, ms, ts, login, id, diff, expire)
+}
+
+// Hashrate, last share and history of worker, all that solo shares write
+func (r *RedisClient) writeHashrate(tx *redis.Multi, ms, ts int64, login, id string, diff int64, expire time.Duration) {
+	if r.timeSeries {
+		r.writeShareTS(tx, ms, login, id, diff, expire)
+	} else {
+		tx.ZAdd(r.formatKey("hashrate"), redis.Z{Score: float64(ts), Member: join(diff, login, id, ms)})
+		tx.ZAdd(r.formatKey("hashrate", login), redis.Z{Score: float64(ts), Member: join(diff, id, ms)})
+		tx.Expire(r.formatKey("hashrate", login), expire) // Will delete hashrates for miners that gone
+	}
 	tx.HSet(r.formatKey("miners", login), "lastShare", strconv.FormatInt(ts, 10))
+	r.writeHistory(tx, ts, login, id, diff)
+	r.writeDaily(tx, ts, login, diff)
+}
+
+// DDoS mode is switched on by admin and expires automatically
+func (r *RedisClient) SetDDoSMode(expire time.Duration) error {
+	return r.client.Set(r.formatRootKey("ddos"), util.MakeTimestamp(), expire).Err()
+}
+
+func (r *RedisClient) ClearDDoSMode() error {
+	return r.client.Del(r.formatRootKey("ddos")).Err()
+}
+
+// Returns time left until DDoS mode expires, zero if it is off
+func (r *RedisClient) GetDDoSMode() (time.Duration, error) {
+	ttl, err := r.client.TTL(r.formatRootKey("ddos")).Result()
+	if err != nil || ttl < 0 {
+		return 0, err
+	}
+	return ttl, nil
+}
+
+// Returns empty string if there is no reply stored for this key
+func (r *RedisClient) GetIdempotentReply(login, key, nonce string) (string, error) {
+	val, err := r.client.Get(r.formatKey("idempotency", login, key, nonce)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+func (r *RedisClient) WriteIdempotentReply(login, key, nonce, reply string, expire time.Duration) error {
+	return r.client.Set(r.formatKey("idempotency", login, key, nonce), reply, expire).Err()
 }
 
 func (r *RedisClient) formatKey(args ...interface{}) string {
 	return join(r.prefix, join(args...))
 }
 
+func (r *RedisClient) formatRootKey(args ...interface{}) string {
+	return join(r.root, join(args...))
+}
+
 func (r *RedisClient) formatRound(height int64, nonce string) string {
 	return r.formatKey("shares", "round"+strconv.FormatInt(height, 10), nonce)
 }
@@ -323,7 +704,7 @@ func (r *RedisClient) GetPayees() ([]string, error) {
 			return nil, err
 		}
 		for _, row := range keys {
-			login := strings.Split(row, ":")[2]
+			login := strings.TrimPrefix(row, r.formatKey("miners", ""))
 			payees[login] = struct{}{}
 		}
 		if c == 0 {
@@ -393,145 +774,106 @@ func (r *RedisClient) GetPendingPayments() []*PendingPayment {
 	return result
 }
 
-// Deduct miner's balance for payment
+// Deduct miner's balance for payment, fails if balance is lower than amount
 func (r *RedisClient) UpdateBalance(login string, amount int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
-
-	ts := util.MakeTimestamp() / 1000
+	ms := r.timestamp()
+	ts := ms / 1000
 
-	_, err := tx.Exec(func() error {
-		tx.HIncrBy(r.formatKey("miners", login), "balance", (amount * -1))
-		tx.HIncrBy(r.formatKey("miners", login), "pending", amount)
-		tx.HIncrBy(r.formatKey("finances"), "balance", (amount * -1))
-		tx.HIncrBy(r.formatKey("finances"), "pending", amount)
-		tx.ZAdd(r.formatKey("payments", "pending"), redis.Z{Score: float64(ts), Member: join(login, amount)})
-		return nil
-	})
+	keys := []string{r.formatKey("miners", login), r.formatKey("payments", "pending")}
+	_, err := r.runLedgerScript(payoutScript, ms, keys, login, strconv.FormatInt(amount, 10),
+		strconv.FormatInt(ts, 10), join(login, amount))
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventPayout, Login: login, Amount: amount})
+	}
 	return err
 }
 
+// Returns pending payment to balance, fails if there is no such pending payment
 func (r *RedisClient) RollbackBalance(login string, amount int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	ms := r.timestamp()
 
-	_, err := tx.Exec(func() error {
-		tx.HIncrBy(r.formatKey("miners", login), "balance", amount)
-		tx.HIncrBy(r.formatKey("miners", login), "pending", (amount * -1))
-		tx.HIncrBy(r.formatKey("finances"), "balance", amount)
-		tx.HIncrBy(r.formatKey("finances"), "pending", (amount * -1))
-		tx.ZRem(r.formatKey("payments", "pending"), join(login, amount))
-		return nil
-	})
+	keys := []string{r.formatKey("payments", "pending")}
+	_, err := r.runLedgerScript(refundScript, ms, keys, login, strconv.FormatInt(amount, 10), join(login, amount))
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventRefund, Login: login, Amount: amount})
+	}
 	return err
 }
 
+// Records payment and unlocks payouts
 func (r *RedisClient) WritePayment(login, txHash string, amount int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
+	return r.writePayment(login, txHash, amount, true)
+}
 
-	ts := util.MakeTimestamp() / 1000
+// Records one transfer of batch transaction, payouts stay locked until whole batch is written
+func (r *RedisClient) WriteBatchPayment(login, txHash string, amount int64) error {
+	return r.writePayment(login, txHash, amount, false)
+}
 
-	_, err := tx.Exec(func() error {
-		tx.HIncrBy(r.formatKey("miners", login), "pending", (amount * -1))
-		tx.HIncrBy(r.formatKey("miners", login), "paid", amount)
-		tx.HIncrBy(r.formatKey("finances"), "pending", (amount * -1))
-		tx.HIncrBy(r.formatKey("finances"), "paid", amount)
-		tx.ZAdd(r.formatKey("payments", "all"), redis.Z{Score: float64(ts), Member: join(txHash, login, amount)})
-		tx.ZAdd(r.formatKey("payments", login), redis.Z{Score: float64(ts), Member: join(txHash, amount)})
-		tx.ZRem(r.formatKey("payments", "pending"), join(login, amount))
-		tx.Del(r.formatKey("payments", "lock"))
-		return nil
-	})
+func (r *RedisClient) writePayment(login, txHash string, amount int64, unlock bool) error {
+	ms := r.timestamp()
+	ts := ms / 1000
+
+	keys := []string{r.formatKey("payments", "pending"), r.formatKey("payments", "all"), r.formatKey("payments", login)}
+	if unlock {
+		keys = append(keys, r.formatKey("payments", "lock"))
+	}
+	_, err := r.runLedgerScript(paymentScript, ms, keys, login, strconv.FormatInt(amount, 10), txHash,
+		strconv.FormatInt(ts, 10), join(txHash, login, amount), join(txHash, amount), join(login, amount))
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventPayment, Login: login, TxHash: txHash, Amount: amount})
+	}
 	return err
 }
 
+// ErrAlreadyProcessed if candidate was moved by another unlocker
 func (r *RedisClient) WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error {
-	tx := r.client.Multi()
-	defer tx.Close()
-
-	_, err := tx.Exec(func() error {
-		r.writeImmatureBlock(tx, block)
-		total := int64(0)
-		for login, amount := range roundRewards {
-			total += amount
-			tx.HIncrBy(r.formatKey("miners", login), "immature", amount)
-			tx.HSetNX(r.formatKey("credits", "immature", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
-		}
-		tx.HIncrBy(r.formatKey("finances"), "immature", total)
-		return nil
-	})
+	ms := r.timestamp()
+
+	keys := []string{r.formatKey("blocks", "candidates"), r.formatKey("blocks", "immature"),
+		r.formatRound(block.RoundHeight, block.Nonce), r.formatRound(block.Height, block.Nonce),
+		r.formatKey("blocks", "extra"), r.formatKey("credits", "immature", block.Height, block.Hash)}
+	args := rewardArgs([]string{block.candidateKey, strconv.FormatInt(block.Height, 10), block.key(), block.Hash,
+		block.ExtraData, block.ledgerAccount()}, roundRewards)
+	done, err := r.runLedgerScript(immatureScript, ms, keys, args...)
+	if err == nil && !done {
+		return ErrAlreadyProcessed
+	}
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventImmature, Block: newBlockRecord(block), Rewards: roundRewards})
+	}
 	return err
 }
 
+// Immature credits are returned to block, final rewards may differ.
+// ErrAlreadyProcessed if block was moved by another unlocker.
 func (r *RedisClient) WriteMaturedBlock(block *BlockData, roundRewards map[string]int64) error {
-	creditKey := r.formatKey("credits", "immature", block.RoundHeight, block.Hash)
-	tx, err := r.client.Watch(creditKey)
-	// Must decrement immatures using existing log entry
-	immatureCredits := tx.HGetAllMap(creditKey)
-	if err != nil {
-		return err
-	}
-	defer tx.Close()
-
-	ts := util.MakeTimestamp() / 1000
+	ms := r.timestamp()
+	ts := ms / 1000
 	value := join(block.Hash, ts, block.Reward)
 
-	_, err = tx.Exec(func() error {
-		r.writeMaturedBlock(tx, block)
-		tx.ZAdd(r.formatKey("credits", "all"), redis.Z{Score: float64(block.Height), Member: value})
-
-		// Decrement immature balances
-		totalImmature := int64(0)
-		for login, amountString := range immatureCredits.Val() {
-			amount, _ := strconv.ParseInt(amountString, 10, 64)
-			totalImmature += amount
-			tx.HIncrBy(r.formatKey("miners", login), "immature", (amount * -1))
-		}
-
-		// Increment balances
-		total := int64(0)
-		for login, amount := range roundRewards {
-			total += amount
-			// NOTICE: Maybe expire round reward entry in 604800 (a week)?
-			tx.HIncrBy(r.formatKey("miners", login), "balance", amount)
-			tx.HSetNX(r.formatKey("credits", block.Height, block.Hash), login, strconv.FormatInt(amount, 10))
-		}
-		tx.Del(creditKey)
-		tx.HIncrBy(r.formatKey("finances"), "balance", total)
-		tx.HIncrBy(r.formatKey("finances"), "immature", (totalImmature * -1))
-		tx.HSet(r.formatKey("finances"), "lastCreditHeight", strconv.FormatInt(block.Height, 10))
-		tx.HSet(r.formatKey("finances"), "lastCreditHash", block.Hash)
-		tx.HIncrBy(r.formatKey("finances"), "totalMined", block.RewardInShannon())
-		return nil
-	})
+	done, err := r.runLedgerScript(maturedScript, ms, r.maturedKeys(block), r.maturedArgs(block, LedgerMature, value, roundRewards)...)
+	if err == nil && !done {
+		return ErrAlreadyProcessed
+	}
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventMatured, Block: newBlockRecord(block), Rewards: roundRewards})
+	}
 	return err
 }
 
+// Immature credits are returned to orphaned block.
+// ErrAlreadyProcessed if block was moved by another unlocker.
 func (r *RedisClient) WriteOrphan(block *BlockData) error {
-	creditKey := r.formatKey("credits", "immature", block.RoundHeight, block.Hash)
-	tx, err := r.client.Watch(creditKey)
-	// Must decrement immatures using existing log entry
-	immatureCredits := tx.HGetAllMap(creditKey)
-	if err != nil {
-		return err
-	}
-	defer tx.Close()
-
-	_, err = tx.Exec(func() error {
-		r.writeMaturedBlock(tx, block)
+	ms := r.timestamp()
 
-		// Decrement immature balances
-		totalImmature := int64(0)
-		for login, amountString := range immatureCredits.Val() {
-			amount, _ := strconv.ParseInt(amountString, 10, 64)
-			totalImmature += amount
-			tx.HIncrBy(r.formatKey("miners", login), "immature", (amount * -1))
-		}
-		tx.Del(creditKey)
-		tx.HIncrBy(r.formatKey("finances"), "immature", (totalImmature * -1))
-		return nil
-	})
+	done, err := r.runLedgerScript(maturedScript, ms, r.maturedKeys(block), r.maturedArgs(block, LedgerOrphan, "", nil)...)
+	if err == nil && !done {
+		return ErrAlreadyProcessed
+	}
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventOrphan, Block: newBlockRecord(block)})
+	}
 	return err
 }
 
@@ -545,6 +887,13 @@ func (r *RedisClient) WritePendingOrphans(blocks []*BlockData) error {
 		}
 		return nil
 	})
+	if err == nil && len(blocks) > 0 {
+		records := make([]*blockRecord, len(blocks))
+		for i, block := range blocks {
+			records[i] = newBlockRecord(block)
+		}
+		r.logEvent(&Event{Timestamp: r.timestamp(), Type: EventPendingOrphans, Blocks: records})
+	}
 	return err
 }
 
@@ -555,12 +904,46 @@ func (r *RedisClient) writeImmatureBlock(tx *redis.Multi, block *BlockData) {
 	}
 	tx.ZRem(r.formatKey("blocks", "candidates"), block.candidateKey)
 	tx.ZAdd(r.formatKey("blocks", "immature"), redis.Z{Score: float64(block.Height), Member: block.key()})
+	if len(block.ExtraData) > 0 {
+		tx.HSet(r.formatKey("blocks", "extra"), block.Hash, block.ExtraData)
+	}
+}
+
+func (r *RedisClient) maturedKeys(block *BlockData) []string {
+	return []string{r.formatKey("blocks", "immature"), r.formatKey("blocks", "matured"),
+		r.formatRound(block.RoundHeight, block.Nonce), r.formatKey("credits", "immature", block.RoundHeight, block.Hash),
+		r.formatKey("credits", "all"), r.formatKey("credits", block.Height, block.Hash), r.formatKey("finances")}
 }
 
-func (r *RedisClient) writeMaturedBlock(tx *redis.Multi, block *BlockData) {
-	tx.Del(r.formatRound(block.RoundHeight, block.Nonce))
-	tx.ZRem(r.formatKey("blocks", "immature"), block.immatureKey)
-	tx.ZAdd(r.formatKey("blocks", "matured"), redis.Z{Score: float64(block.Height), Member: block.key()})
+// Credits value is empty for orphans, they credit no rewards
+func (r *RedisClient) maturedArgs(block *BlockData, kind, value string, roundRewards map[string]int64) []string {
+	return rewardArgs([]string{block.immatureKey, strconv.FormatInt(block.Height, 10), block.key(), block.Hash,
+		block.ledgerAccount(), kind, value, strconv.FormatInt(block.RewardInShannon(), 10)}, roundRewards)
+}
+
+// Fills extra data tags recorded by unlocker
+func (r *RedisClient) loadExtraData(lists ...[]*BlockData) error {
+	var blocks []*BlockData
+	var hashes []string
+	for _, list := range lists {
+		for _, block := range list {
+			blocks = append(blocks, block)
+			hashes = append(hashes, block.Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+	tags, err := r.client.HMGet(r.formatKey("blocks", "extra"), hashes...).Result()
+	if err != nil {
+		return err
+	}
+	for i, tag := range tags {
+		if s, ok := tag.(string); ok {
+			blocks[i].ExtraData = s
+		}
+	}
+	return nil
 }
 
 func (r *RedisClient) IsMinerExists(login string) (bool, error) {
@@ -578,6 +961,8 @@ func (r *RedisClient) GetMinerStats(login string, maxPayments int64) (map[string
 		tx.ZRevRangeWithScores(r.formatKey("payments", login), 0, maxPayments-1)
 		tx.ZCard(r.formatKey("payments", login))
 		tx.HGet(r.formatKey("shares", "roundCurrent"), login)
+		tx.ZScore(r.formatKey("best", "round"), login)
+		tx.ZScore(r.formatKey("best", "all"), login)
 		return nil
 	})
 
@@ -591,6 +976,8 @@ func (r *RedisClient) GetMinerStats(login string, maxPayments int64) (map[string
 		stats["paymentsTotal"] = cmds[2].(*redis.IntCmd).Val()
 		roundShares, _ := cmds[3].(*redis.StringCmd).Int64()
 		stats["roundShares"] = roundShares
+		stats["roundBestShare"] = int64(cmds[4].(*redis.FloatCmd).Val())
+		stats["bestShare"] = int64(cmds[5].(*redis.FloatCmd).Val())
 	}
 
 	return stats, nil
@@ -630,7 +1017,7 @@ func (r *RedisClient) FlushStaleStats(window, largeWindow time.Duration) (int64,
 			return total, err
 		}
 		for _, row := range keys {
-			login := strings.Split(row, ":")[2]
+			login := strings.TrimPrefix(row, r.formatKey("hashrate", ""))
 			if _, ok := miners[login]; !ok {
 				n, err := r.client.ZRemRangeByScore(r.formatKey("hashrate", login), "-inf", max).Result()
 				if err != nil {
@@ -668,6 +1055,8 @@ func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPaym
 		tx.ZCard(r.formatKey("blocks", "matured"))
 		tx.ZCard(r.formatKey("payments", "all"))
 		tx.ZRevRangeWithScores(r.formatKey("payments", "all"), 0, maxPayments-1)
+		tx.ZRevRangeWithScores(r.formatKey("best", "round"), 0, maxBestShares-1)
+		tx.ZRevRangeWithScores(r.formatKey("best", "all"), 0, maxBestShares-1)
 		return nil
 	})
 
@@ -689,13 +1078,29 @@ func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPaym
 	stats["matured"] = matured
 	stats["maturedTotal"] = cmds[8].(*redis.IntCmd).Val()
 
+	if err := r.loadExtraData(immature, matured); err != nil {
+		return nil, err
+	}
+	if err := r.loadBestShares(candidates, immature, matured); err != nil {
+		return nil, err
+	}
+	stats["roundBestShares"] = convertBestShares(cmds[11].(*redis.ZSliceCmd))
+	stats["bestShares"] = convertBestShares(cmds[12].(*redis.ZSliceCmd))
+
 	payments := convertPaymentsResults(cmds[10].(*redis.ZSliceCmd))
 	stats["payments"] = payments
 	stats["paymentsTotal"] = cmds[9].(*redis.IntCmd).Val()
 
-	totalHashrate, miners := convertMinersStats(window, cmds[1].(*redis.ZSliceCmd))
+	hashrates := cmds[1].(*redis.ZSliceCmd).Val()
+	if r.timeSeries {
+		if hashrates, err = r.tsPoolShares(now - window); err != nil {
+			return nil, err
+		}
+	}
+	totalHashrate, miners := convertMinersStats(window, hashrates)
 	stats["miners"] = miners
 	stats["minersTotal"] = len(miners)
+	stats["workersTotal"] = countWorkers(hashrates)
 	stats["hashrate"] = totalHashrate
 	return stats, nil
 }
@@ -703,7 +1108,6 @@ func (r *RedisClient) CollectStats(smallWindow time.Duration, maxBlocks, maxPaym
 func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login string) (map[string]interface{}, error) {
 	smallWindow := int64(sWindow / time.Second)
 	largeWindow := int64(lWindow / time.Second)
-	stats := make(map[string]interface{})
 
 	tx := r.client.Multi()
 	defer tx.Close()
@@ -713,18 +1117,35 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 	cmds, err := tx.Exec(func() error {
 		tx.ZRemRangeByScore(r.formatKey("hashrate", login), "-inf", fmt.Sprint("(", now-largeWindow))
 		tx.ZRangeWithScores(r.formatKey("hashrate", login), 0, -1)
+		tx.HGetAllMap(r.formatKey("stale", login))
+		tx.HGetAllMap(r.formatKey("reported", login))
 		return nil
 	})
 
 	if err != nil {
 		return nil, err
 	}
+	staleShares, _ := cmds[2].(*redis.StringStringMapCmd).Result()
+	reported, _ := cmds[3].(*redis.StringStringMapCmd).Result()
+	hashrates := cmds[1].(*redis.ZSliceCmd).Val()
+	if r.timeSeries {
+		if hashrates, err = r.tsLoginShares(now-largeWindow, login); err != nil {
+			return nil, err
+		}
+	}
+	return collectWorkersStats(smallWindow, largeWindow, hashrates, staleShares, reported), nil
+}
 
+// Hashrate entries are "diff:id:ms", reported hashrate is "hashrate:ts:clientId" per worker
+func collectWorkersStats(smallWindow, largeWindow int64, hashrates []redis.Z, staleShares, reported map[string]string) map[string]interface{} {
+	stats := make(map[string]interface{})
+	now := util.MakeTimestamp() / 1000
+	reportedHashrate := int64(0)
 	totalHashrate := int64(0)
 	currentHashrate := int64(0)
 	online := int64(0)
 	offline := int64(0)
-	workers := convertWorkersStats(smallWindow, cmds[1].(*redis.ZSliceCmd))
+	workers := convertWorkersStats(smallWindow, hashrates)
 
 	for id, worker := range workers {
 		timeOnline := now - worker.startedAt
@@ -743,6 +1164,16 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 			boundary = largeWindow
 		}
 		worker.TotalHR = worker.TotalHR / boundary
+		worker.StaleShares, _ = strconv.ParseInt(staleShares[id], 10, 64)
+
+		if fields := strings.Split(reported[id], ":"); len(fields) == 3 {
+			ts, _ := strconv.ParseInt(fields[1], 10, 64)
+			if ts >= now-smallWindow {
+				worker.ReportedHR, _ = strconv.ParseInt(fields[0], 10, 64)
+				worker.ClientId = fields[2]
+				reportedHashrate += worker.ReportedHR
+			}
+		}
 
 		if worker.LastBeat < (now - smallWindow/2) {
 			worker.Offline = true
@@ -761,7 +1192,8 @@ func (r *RedisClient) CollectWorkersStats(sWindow, lWindow time.Duration, login
 	stats["workersOffline"] = offline
 	stats["hashrate"] = totalHashrate
 	stats["currentHashrate"] = currentHashrate
-	return stats, nil
+	stats["reportedHashrate"] = reportedHashrate
+	return stats
 }
 
 func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, error) {
@@ -781,7 +1213,12 @@ func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, e
 		return stats, err
 	}
 	blocks := convertBlockResults(cmds[0].(*redis.ZSliceCmd), cmds[1].(*redis.ZSliceCmd))
+	return collectLuckStats(blocks, windows), nil
+}
 
+// Blocks are ordered by height, newest first
+func collectLuckStats(blocks []*BlockData, windows []int) map[string]interface{} {
+	stats := make(map[string]interface{})
 	calcLuck := func(max int) (int, float64, float64, float64) {
 		var total int
 		var sharesDiff, uncles, orphans float64
@@ -815,13 +1252,13 @@ func (r *RedisClient) CollectLuckStats(windows []int) (map[string]interface{}, e
 			break
 		}
 	}
-	return stats, nil
+	return stats
 }
 
 func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
 	var result []*BlockData
 	for _, v := range raw.Val() {
-		// "nonce:powHash:mixDigest:timestamp:diff:totalShares"
+		// "nonce:powHash:mixDigest:timestamp:diff:totalShares[:solver]"
 		block := BlockData{}
 		block.Height = int64(v.Score)
 		block.RoundHeight = block.Height
@@ -832,6 +1269,10 @@ func convertCandidateResults(raw *redis.ZSliceCmd) []*BlockData {
 		block.Timestamp, _ = strconv.ParseInt(fields[3], 10, 64)
 		block.Difficulty, _ = strconv.ParseInt(fields[4], 10, 64)
 		block.TotalShares, _ = strconv.ParseInt(fields[5], 10, 64)
+		if len(fields) > 6 {
+			block.Solver = fields[6]
+		}
+		block.setEffort()
 		block.candidateKey = v.Member.(string)
 		result = append(result, &block)
 	}
@@ -842,7 +1283,7 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 	var result []*BlockData
 	for _, row := range rows {
 		for _, v := range row.Val() {
-			// "uncleHeight:orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInWei"
+			// "uncleHeight:orphan:nonce:blockHash:timestamp:diff:totalShares:rewardInWei[:solver]"
 			block := BlockData{}
 			block.Height = int64(v.Score)
 			block.RoundHeight = block.Height
@@ -855,8 +1296,12 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 			block.Timestamp, _ = strconv.ParseInt(fields[4], 10, 64)
 			block.Difficulty, _ = strconv.ParseInt(fields[5], 10, 64)
 			block.TotalShares, _ = strconv.ParseInt(fields[6], 10, 64)
+			block.setEffort()
 			block.RewardString = fields[7]
 			block.ImmatureReward = fields[7]
+			if len(fields) > 8 {
+				block.Solver = fields[8]
+			}
 			block.immatureKey = v.Member.(string)
 			result = append(result, &block)
 		}
@@ -866,11 +1311,11 @@ func convertBlockResults(rows ...*redis.ZSliceCmd) []*BlockData {
 
 // Build per login workers's total shares map {'rig-1': 12345, 'rig-2': 6789, ...}
 // TS => diff, id, ms
-func convertWorkersStats(window int64, raw *redis.ZSliceCmd) map[string]Worker {
+func convertWorkersStats(window int64, raw []redis.Z) map[string]Worker {
 	now := util.MakeTimestamp() / 1000
 	workers := make(map[string]Worker)
 
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		parts := strings.Split(v.Member.(string), ":")
 		share, _ := strconv.ParseInt(parts[0], 10, 64)
 		id := parts[1]
@@ -896,12 +1341,24 @@ func convertWorkersStats(window int64, raw *redis.ZSliceCmd) map[string]Worker {
 	return workers
 }
 
-func convertMinersStats(window int64, raw *redis.ZSliceCmd) (int64, map[string]Miner) {
+// Distinct login and worker pairs of hashrate entries
+func countWorkers(raw []redis.Z) int {
+	workers := make(map[string]struct{})
+	for _, v := range raw {
+		parts := strings.SplitN(v.Member.(string), ":", 4)
+		if len(parts) > 2 {
+			workers[parts[1]+":"+parts[2]] = struct{}{}
+		}
+	}
+	return len(workers)
+}
+
+func convertMinersStats(window int64, raw []redis.Z) (int64, map[string]Miner) {
 	now := util.MakeTimestamp() / 1000
 	miners := make(map[string]Miner)
 	totalHashrate := int64(0)
 
-	for _, v := range raw.Val() {
+	for _, v := range raw {
 		parts := strings.Split(v.Member.(string), ":")
 		share, _ := strconv.ParseInt(parts[0], 10, 64)
 		id := parts[1]
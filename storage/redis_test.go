@@ -1,12 +1,18 @@
 package storage
 
 import (
+	"encoding/json"
+	"math/big"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
 	"gopkg.in/redis.v3"
+
+	"github.com/etclabscore/open-etc-pool/util"
 )
 
 var r *RedisClient
@@ -18,34 +24,51 @@ func TestMain(m *testing.M) {
 	reset()
 	c := m.Run()
 	reset()
+	stopTestPostgres()
 	os.Exit(c)
 }
 
 func TestWriteShareCheckExist(t *testing.T) {
 	reset()
 
-	exist, _ := r.WriteShare("x", "x", []string{"0x0", "0x0", "0x0"}, 10, 1008, 0)
+	exist, _ := r.WriteShare("x", "x", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x1", "0x0"}, 10, 1008, 0)
+	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x1", "0x0"}, 10, 10, 1008, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1010, 0)
+	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 100, 1010, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
-	exist, _ = r.WriteShare("z", "x", []string{"0x0", "0x0", "0x1"}, 100, 1016, 0)
+	exist, _ = r.WriteShare("z", "x", []string{"0x0", "0x0", "0x1"}, 100, 100, 1016, 0)
 	if !exist {
 		t.Error("PoW must exist")
 	}
-	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 1025, 0)
+	exist, _ = r.WriteShare("x", "x", []string{"0x0", "0x0", "0x1"}, 100, 100, 1025, 0)
 	if exist {
 		t.Error("PoW must not exist")
 	}
 }
 
+func TestDuplicateShareAcrossInstances(t *testing.T) {
+	reset()
+
+	other := NewRedisClient(&Config{Endpoint: "127.0.0.1:6379"}, prefix)
+	params := []string{"0x1", "0x2", "0x3"}
+	if exist, err := r.WriteShare("x", "x", params, 10, 10, 3, 0); exist || err != nil {
+		t.Fatalf("PoW must not exist, got %v", err)
+	}
+	if exist, _ := other.WriteShare("y", "x", params, 10, 10, 3, 0); !exist {
+		t.Error("PoW submitted to another instance must exist")
+	}
+	if exist, _ := r.Namespace("tenant").WriteShare("y", "x", params, 10, 10, 3, 0); !exist {
+		t.Error("PoW must exist for all tenants")
+	}
+}
+
 func TestGetPayees(t *testing.T) {
 	reset()
 
@@ -176,9 +199,9 @@ func TestRollbackBalance(t *testing.T) {
 		r.formatKey("finances"),
 		map[string]string{"paid": "500", "balance": "10000", "pending": "250"},
 	)
-	r.client.ZAdd(r.formatKey("payments:pending"), redis.Z{Score: 1, Member: "xx"})
-
 	amount := int64(250)
+	r.client.ZAdd(r.formatKey("payments:pending"), redis.Z{Score: 1, Member: join("x", amount)})
+
 	r.RollbackBalance("x", amount)
 	result := r.client.HGetAllMap(r.formatKey("miners:x")).Val()
 	if result["paid"] != "100" {
@@ -261,6 +284,15 @@ func TestWritePayment(t *testing.T) {
 	if err == redis.Nil {
 		t.Error("Must add payment to set")
 	}
+
+	r.LockPayouts("x", 10)
+	r.WriteBatchPayment("x", "0x1", 10)
+	if locked, _ := r.IsPayoutsLocked(); !locked {
+		t.Error("Must keep lock while batch is written")
+	}
+	if r.client.ZRank(r.formatKey("payments:all"), join("0x1", "x", int64(10))).Err() == redis.Nil {
+		t.Error("Must add batch payment to set")
+	}
 }
 
 func TestGetPendingPayments(t *testing.T) {
@@ -268,7 +300,7 @@ func TestGetPendingPayments(t *testing.T) {
 
 	r.client.HMSetMap(
 		r.formatKey("miners:x"),
-		map[string]string{"paid": "100", "balance": "750", "pending": "250"},
+		map[string]string{"paid": "100", "balance": "1000", "pending": "250"},
 	)
 
 	amount := int64(1000)
@@ -321,9 +353,974 @@ func TestCollectLuckStats(t *testing.T) {
 	}
 }
 
+func TestNodeHistory(t *testing.T) {
+	reset()
+
+	r.WriteNodeState("main", 100, big.NewInt(1000))
+	r.WriteNodeState("main", 101, big.NewInt(1000))
+
+	history, err := r.GetNodeHistory(0)
+	if err != nil {
+		t.Errorf("Must return history: %v", err)
+	}
+	if len(history["main"]) != 1 {
+		t.Error("Must record single heartbeat per minute")
+	}
+
+	r.client.ZAdd(r.formatKey("nodes:history:main"), redis.Z{Score: 60, Member: "60"})
+	n, _ := r.FlushNodeHistory(time.Hour)
+	if n != 1 {
+		t.Error("Must flush heartbeats out of window")
+	}
+}
+
 func reset() {
 	keys := r.client.Keys(r.prefix + ":*").Val()
 	for _, k := range keys {
 		r.client.Del(k)
 	}
 }
+
+func TestDDoSMode(t *testing.T) {
+	reset()
+
+	if ttl, _ := r.GetDDoSMode(); ttl != 0 {
+		t.Error("Must be off by default")
+	}
+	r.SetDDoSMode(time.Hour)
+	if ttl, _ := r.GetDDoSMode(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Must be on with expiry, got %v", ttl)
+	}
+	r.ClearDDoSMode()
+	if ttl, _ := r.GetDDoSMode(); ttl != 0 {
+		t.Error("Must be off after clear")
+	}
+}
+
+func TestWriteStaleShare(t *testing.T) {
+	reset()
+
+	r.WriteStaleShare("x", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteStaleShare("x", "rig1", []string{"0x1", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+
+	stats, _ := r.GetMinerStats("x", 10)
+	if v := stats["stats"].(map[string]interface{})["staleShares"]; v != int64(2) {
+		t.Errorf("Must count 2 stale shares, got %v", v)
+	}
+	if v, _ := r.client.HGet(r.formatKey("stale", "x"), "rig1").Int64(); v != 2 {
+		t.Errorf("Must count stale shares of worker, got %v", v)
+	}
+	roundShares, _ := r.client.HGet(r.formatKey("shares", "roundCurrent"), "x").Int64()
+	if roundShares != 20 {
+		t.Errorf("Must credit stale shares as regular ones, got %v", roundShares)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	reset()
+
+	block := &BlockData{Height: 100, RoundHeight: 100, Hash: "0xb", Nonce: "0x1", Reward: big.NewInt(10000000000)}
+	r.WriteImmatureBlock(block, map[string]int64{"x": 60, "y": 30})
+	r.WriteMaturedBlock(block, map[string]int64{"x": 65, "y": 32})
+	r.UpdateBalance("x", 50)
+	r.WritePayment("x", "0xtx", 50)
+	r.WriteAdjustment("y", "fix", -2)
+
+	entries, _ := r.GetLedger("x", 0, util.MakeTimestamp())
+	if len(entries) != 5 {
+		t.Errorf("Must record 5 entries for miner, got %v", len(entries))
+	}
+	sums := make(map[string]int64)
+	entries, _ = r.GetLedger("", 0, util.MakeTimestamp())
+	for _, e := range entries {
+		sums[e.Debit] -= e.Amount
+		sums[e.Credit] += e.Amount
+	}
+	for _, login := range []string{"x", "y"} {
+		for _, field := range []string{"immature", "balance", "pending", "paid"} {
+			v, _ := r.client.HGet(r.formatKey("miners", login), field).Int64()
+			if v != sums[field+":"+login] {
+				t.Errorf("Must match ledger for %s:%s, got %v and %v", field, login, v, sums[field+":"+login])
+			}
+		}
+	}
+	if sums["block:0xb"] != -97 {
+		t.Errorf("Must debit block for credited rewards, got %v", sums["block:0xb"])
+	}
+
+	// Same entry twice in one ms, as in replay
+	r.replayTs = util.MakeTimestamp()
+	r.WriteAdjustment("y", "fix", 1)
+	r.WriteAdjustment("y", "fix", 1)
+	r.replayTs = 0
+	if entries, _ = r.GetLedger("y", r.replayTs, util.MakeTimestamp()); len(entries) != 6 {
+		t.Errorf("Must keep identical entries apart, got %v", len(entries))
+	}
+
+	removed, err := r.Prune(map[string]int64{RetentionLedger: util.MakeTimestamp()/1000 + 1})
+	if err != nil || removed[RetentionLedger] != 11 {
+		t.Errorf("Must prune ledger entries by time, got %v %v", removed, err)
+	}
+	if balance, _ := r.GetBalance("y"); balance != 32 {
+		t.Errorf("Must keep balances when ledger is pruned, got %v", balance)
+	}
+}
+
+func TestBalanceScripts(t *testing.T) {
+	reset()
+
+	r.WriteBlock("0xa", "rig", []string{"0x1", "0x0", "0x0"}, 100, 100, 5000, 1008, time.Hour)
+	candidates, _ := r.GetCandidates(2000)
+	if len(candidates) != 1 {
+		t.Fatalf("Must write block candidate, got %v", candidates)
+	}
+	block := candidates[0]
+	block.Hash = "0xb"
+	block.Reward = big.NewInt(10000000000)
+	if err := r.WriteImmatureBlock(block, map[string]int64{"0xa": 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteImmatureBlock(block, map[string]int64{"0xa": 60}); err != ErrAlreadyProcessed {
+		t.Errorf("Must not credit candidate twice, got %v", err)
+	}
+
+	immature, _ := r.GetImmatureBlocks(2000)
+	if len(immature) != 1 {
+		t.Fatalf("Must move candidate to immature blocks, got %v", immature)
+	}
+	immature[0].Reward = block.Reward
+	if err := r.WriteMaturedBlock(immature[0], map[string]int64{"0xa": 65}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.WriteMaturedBlock(immature[0], map[string]int64{"0xa": 65}); err != ErrAlreadyProcessed {
+		t.Errorf("Must not credit matured block twice, got %v", err)
+	}
+	if err := r.WriteOrphan(immature[0]); err != ErrAlreadyProcessed {
+		t.Errorf("Must not orphan matured block, got %v", err)
+	}
+	miner := r.client.HGetAllMap(r.formatKey("miners", "0xa")).Val()
+	if miner["balance"] != "65" || miner["immature"] != "0" {
+		t.Errorf("Must credit rewards once, got %v", miner)
+	}
+
+	if err := r.UpdateBalance("0xa", 66); err == nil {
+		t.Error("Must not debit more than balance")
+	}
+	if err := r.RollbackBalance("0xa", 65); err == nil {
+		t.Error("Must not refund payment which is not pending")
+	}
+	r.UpdateBalance("0xa", 65)
+	if err := r.RollbackBalance("0xa", 65); err != nil {
+		t.Errorf("Must refund pending payment, got %v", err)
+	}
+	if err := r.RollbackBalance("0xa", 65); err == nil {
+		t.Error("Must refund pending payment once")
+	}
+	if balance, _ := r.GetBalance("0xa"); balance != 65 {
+		t.Errorf("Must restore balance, got %v", balance)
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	reset()
+
+	path := filepath.Join(t.TempDir(), "events.log")
+	r.events = newEventLog(&EventLogConfig{Enabled: true})
+	r.WriteShare("x", "rig", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, 0)
+	block := &BlockData{Height: 100, RoundHeight: 100, Hash: "0xb", Nonce: "0x1", Reward: big.NewInt(10000000000)}
+	r.WriteImmatureBlock(block, map[string]int64{"x": 60, "y": 30})
+	r.WriteMaturedBlock(block, map[string]int64{"x": 65, "y": 32})
+	r.UpdateBalance("x", 50)
+	r.WritePayment("x", "0xtx", 50)
+	r.WriteAdjustment("y", "fix", -2)
+	r.events = nil
+	if n, err := r.ExportEvents(path); err != nil || n != 6 {
+		t.Fatalf("Must export 6 events, got %v: %v", n, err)
+	}
+
+	snapshot := func() []interface{} {
+		return []interface{}{
+			r.client.HGetAllMap(r.formatKey("miners", "x")).Val(),
+			r.client.HGetAllMap(r.formatKey("miners", "y")).Val(),
+			r.client.HGetAllMap(r.formatKey("finances")).Val(),
+			r.client.ZRange(r.formatKey("ledger"), 0, -1).Val(),
+			r.client.ZRange(r.formatKey("payments", "all"), 0, -1).Val(),
+		}
+	}
+	expected := snapshot()
+
+	if _, err := r.ReplayEvents(path); err == nil {
+		t.Error("Must refuse to replay into non-empty database")
+	}
+	// Stream the log was exported from doesn't count
+	for _, k := range r.client.Keys(r.prefix + ":*").Val() {
+		if k != r.formatRootKey("events") {
+			r.client.Del(k)
+		}
+	}
+	total, err := r.ReplayEvents(path)
+	if err != nil || total != 6 {
+		t.Errorf("Must replay 6 events, got %v: %v", total, err)
+	}
+	if actual := snapshot(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Must rebuild same state, got %v instead of %v", actual, expected)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	reset()
+
+	tenant := r.Namespace("brand")
+	tenant.WriteShare("0xa", "rig", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, 0)
+	exist, _ := r.WriteShare("0xb", "rig", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, 0)
+	if !exist {
+		t.Error("PoW must be shared by tenants")
+	}
+	r.WriteShare("0xb", "rig", []string{"0x1", "0x0", "0x0"}, 10, 10, 1008, 0)
+
+	payees, _ := tenant.GetPayees()
+	if len(payees) != 1 || payees[0] != "0xa" {
+		t.Errorf("Must list only tenant miners, got %v", payees)
+	}
+	payees, _ = r.GetPayees()
+	if len(payees) != 1 || payees[0] != "0xb" {
+		t.Errorf("Must not list tenant miners for main pool, got %v", payees)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	reset()
+
+	cfg := &Config{Endpoint: "127.0.0.1:6379", Prefix: "mordor"}
+	other := NewRedisClient(cfg, cfg.KeyPrefix(prefix))
+	defer func() {
+		for _, k := range other.client.Keys("mordor:*").Val() {
+			other.client.Del(k)
+		}
+	}()
+	other.WriteShare("0xa", "rig", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, 0)
+	exist, _ := r.WriteShare("0xb", "rig", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, 0)
+	if exist {
+		t.Error("PoW must not be shared by pools with distinct prefixes")
+	}
+	payees, _ := other.GetPayees()
+	if len(payees) != 1 || payees[0] != "0xa" {
+		t.Errorf("Must list only miners of own pool, got %v", payees)
+	}
+	payees, _ = r.GetPayees()
+	if len(payees) != 1 || payees[0] != "0xb" {
+		t.Errorf("Must not list miners of other pool, got %v", payees)
+	}
+	if p := (&Config{}).KeyPrefix("etc"); p != "etc" {
+		t.Errorf("Must fall back to coin, got %v", p)
+	}
+
+	var errs util.ConfigErrors
+	(&Config{Prefix: "etc:main"}).Validate(&errs)
+	if errs.Err() == nil {
+		t.Error("Must reject prefix with separator")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	reset()
+
+	r.WriteAdjustment("0xa", "promo", 100)
+	a := &Archive{
+		Version:  archiveVersion,
+		Accounts: []*ArchiveAccount{{Login: "0xa", Balance: 300, Immature: 50, Paid: 1000}},
+		Payments: []*Payment{{Timestamp: 1000, TxHash: "0x1", Address: "0xa", Amount: 1000}},
+		Blocks: []*ArchiveBlock{
+			{State: ArchiveCandidate, Height: 12, Nonce: "0xn3", PowHash: "0xp", MixDigest: "0xm", Timestamp: 3, Difficulty: 10,
+				TotalShares: 8, Shares: map[string]int64{"0xa": 8}},
+			{State: ArchiveImmature, Height: 11, Nonce: "0xn2", Hash: "0xh2", Timestamp: 2, Difficulty: 10, TotalShares: 12,
+				Reward: "5000000000", Shares: map[string]int64{"0xa": 12}, Credits: map[string]int64{"0xa": 50}},
+			{State: ArchiveMatured, Height: 10, Nonce: "0xn1", Hash: "0xh1", Timestamp: 1, Difficulty: 10, TotalShares: 9,
+				Reward: "5000000000", ExtraData: "pool", BestShare: 30, BestShareLogin: "0xa", Credits: map[string]int64{"0xa": 4}},
+		},
+	}
+	result, err := r.ImportArchive(a)
+	if err != nil || *result != (ImportResult{Accounts: 1, Payments: 1, Blocks: 3}) {
+		t.Fatalf("Must import all records, got %v %v", result, err)
+	}
+	if balance, _ := r.GetBalance("0xa"); balance != 300 {
+		t.Errorf("Must set balance to archived one, got %v", balance)
+	}
+	entries, _ := r.GetLedger("0xa", 0, util.MakeTimestamp())
+	imported := 0
+	for _, e := range entries {
+		if e.Kind == LedgerImport {
+			imported++
+		}
+	}
+	if len(entries) != 4 || imported != 3 {
+		t.Errorf("Must record import in ledger, got %v", entries)
+	}
+	if result, _ = r.ImportArchive(a); *result != (ImportResult{}) {
+		t.Errorf("Must skip records present already, got %v", result)
+	}
+	candidates, _ := r.GetCandidates(100)
+	if len(candidates) != 1 || candidates[0].candidateKey != "0xn3:0xp:0xm:3:10:8" {
+		t.Errorf("Must restore candidate, got %v", candidates)
+	}
+	if shares, _ := r.GetRoundShares(11, "0xn2"); shares["0xa"] != 12 {
+		t.Errorf("Must restore round shares of immature block, got %v", shares)
+	}
+
+	exported, err := r.ExportArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported.Timestamp = 0
+	if !reflect.DeepEqual(exported, a) {
+		data, _ := json.Marshal(exported)
+		t.Errorf("Must export imported records, got %s", data)
+	}
+
+	dir := filepath.Join(t.TempDir(), "archive")
+	if err := SaveArchive(dir, exported); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadArchive(dir)
+	if err != nil || !reflect.DeepEqual(loaded, exported) {
+		t.Errorf("Must read back CSV archive, got %v %v", loaded, err)
+	}
+
+	a.Version = 2
+	if _, err := r.ImportArchive(a); err == nil {
+		t.Error("Must reject unknown archive version")
+	}
+	a.Version = archiveVersion
+
+	if _, err := ImportPaused(r, &PayoutsControl{}, a); err == nil {
+		t.Error("Must refuse import while payouts run")
+	}
+	r.LockPayouts("0xa", 10)
+	if _, err := ImportPaused(r, &PayoutsControl{Paused: true}, a); err == nil {
+		t.Error("Must refuse import while payment is in flight")
+	}
+	r.UnlockPayouts()
+	if _, err := ImportPaused(r, &PayoutsControl{Paused: true}, a); err != nil {
+		t.Errorf("Must import while payouts are paused, got %v", err)
+	}
+	if locked, _ := r.IsPayoutsLocked(); locked {
+		t.Error("Must release payouts lock after import")
+	}
+	a.Accounts[0].Pending = 10
+	if _, err := r.ImportArchive(a); err == nil {
+		t.Error("Must reject account with pending payment")
+	}
+}
+
+func TestCollectStatsWorkers(t *testing.T) {
+	reset()
+
+	r.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteShare("0xa", "rig2", []string{"0x1", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteShare("0xa", "rig2", []string{"0x2", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteShare("0xb", "rig1", []string{"0x3", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+
+	stats, _ := r.CollectStats(time.Hour, 10, 10)
+	if stats["minersTotal"] != 2 || stats["workersTotal"] != 3 {
+		t.Errorf("Must count 2 miners and 3 workers, got %v and %v", stats["minersTotal"], stats["workersTotal"])
+	}
+}
+
+func TestBlockExtraData(t *testing.T) {
+	reset()
+
+	block := &BlockData{Height: 100, RoundHeight: 100, Hash: "0xabc", Nonce: "0x1", Reward: big.NewInt(5), ExtraData: "open-etc-pool"}
+	if err := r.WriteImmatureBlock(block, map[string]int64{"0xa": 5}); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := r.CollectStats(time.Hour, 10, 10)
+	immature := stats["immature"].([]*BlockData)
+	if len(immature) != 1 || immature[0].ExtraData != "open-etc-pool" {
+		t.Errorf("Must return extra data of immature block, got %v", immature)
+	}
+}
+
+func TestBestShares(t *testing.T) {
+	reset()
+
+	r.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 500, 1008, time.Hour)
+	r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 10, 20, 1008, time.Hour)
+	r.WriteShare("0xb", "rig1", []string{"0x2", "0x0", "0x0"}, 10, 300, 1008, time.Hour)
+
+	stats, _ := r.CollectStats(time.Hour, 10, 10)
+	best := stats["roundBestShares"].([]*BestShare)
+	if len(best) != 2 || *best[0] != (BestShare{"0xa", 500}) || *best[1] != (BestShare{"0xb", 300}) {
+		t.Errorf("Must keep best share per login, got %v", best)
+	}
+
+	r.WriteBlock("0xb", "rig1", []string{"0x3", "0x0", "0x0"}, 10, 100, 80, 1008, time.Hour)
+	miner, _ := r.GetMinerStats("0xa", 10)
+	if miner["roundBestShare"] != int64(0) || miner["bestShare"] != int64(500) {
+		t.Errorf("Must reset round best share on block, got %v and %v", miner["roundBestShare"], miner["bestShare"])
+	}
+	stats, _ = r.CollectStats(time.Hour, 10, 10)
+	candidates := stats["candidates"].([]*BlockData)
+	if len(candidates) != 1 || candidates[0].BestShare != 500 || candidates[0].BestShareLogin != "0xa" {
+		t.Errorf("Must store best share of round with block, got %+v", candidates)
+	}
+	if candidates[0].Effort != 0.5 {
+		t.Errorf("Must compute round effort, got %v", candidates[0].Effort)
+	}
+}
+
+func TestPendingBlocks(t *testing.T) {
+	reset()
+
+	b := &PendingBlock{Login: "0xa", Worker: "rig1", Params: []string{"0x1", "0x2", "0x3"}, Height: 100, Diff: 10, RoundDiff: 80}
+	if err := r.QueuePendingBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	blocks, _ := r.GetPendingBlocks()
+	if len(blocks) != 1 || !reflect.DeepEqual(blocks[0], b) {
+		t.Fatalf("Must return queued block, got %v", blocks)
+	}
+	if removed, _ := r.RemovePendingBlock(b); !removed {
+		t.Error("Must remove pending block")
+	}
+	if removed, _ := r.RemovePendingBlock(b); removed {
+		t.Error("Must remove pending block once")
+	}
+}
+
+func TestReportedHashrate(t *testing.T) {
+	reset()
+
+	r.WriteShare("0xa", "rig1", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteShare("0xa", "rig2", []string{"0x1", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteReportedHashrate("0xa", "rig1", 500, "0xc1", time.Hour)
+
+	stats, _ := r.CollectWorkersStats(time.Hour, 3*time.Hour, "0xa")
+	workers := stats["workers"].(map[string]Worker)
+	if workers["rig1"].ReportedHR != 500 || workers["rig1"].ClientId != "0xc1" {
+		t.Errorf("Must return reported hashrate of worker, got %v", workers["rig1"])
+	}
+	if workers["rig2"].ReportedHR != 0 {
+		t.Errorf("Must not report hashrate for silent worker, got %v", workers["rig2"].ReportedHR)
+	}
+	if stats["reportedHashrate"] != int64(500) {
+		t.Errorf("Must sum reported hashrate, got %v", stats["reportedHashrate"])
+	}
+}
+
+func TestWorkerHistory(t *testing.T) {
+	reset()
+	r.history = time.Hour
+	defer func() { r.history = 0 }()
+
+	r.WriteShare("0xa", "rig2", []string{"0x0", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteShare("0xa", "rig1", []string{"0x2", "0x0", "0x0"}, 20, 20, 1008, time.Hour)
+
+	now := util.MakeTimestamp() / 1000
+	rows, err := r.GetWorkerHistory("0xa", now-3600, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Worker != "rig1" || rows[1].Worker != "rig2" {
+		t.Fatalf("Must return sorted rows of current hour, got %v", rows)
+	}
+	if rows[0].Shares != 2 || rows[0].Difficulty != 30 || rows[0].Hour != now-now%3600 {
+		t.Errorf("Must sum shares and difficulty per hour, got %+v", rows[0])
+	}
+}
+
+func TestHashrateChartFromHistory(t *testing.T) {
+	reset()
+	r.history = time.Hour
+	defer func() { r.history = 0 }()
+
+	r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 3600, 3600, 1008, time.Hour)
+	r.WriteShare("0xa", "rig2", []string{"0x2", "0x0", "0x0"}, 7200, 7200, 1008, time.Hour)
+
+	now := util.MakeTimestamp() / 1000
+	points, err := r.GetHashrateChart("0xa", now-3600, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].Timestamp != now-now%3600 || points[0].Hashrate != 3 {
+		t.Errorf("Must sum hashrate of workers per hour, got %v", points)
+	}
+}
+
+func TestDailyDifficulty(t *testing.T) {
+	reset()
+
+	r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 100, 100, 1008, time.Hour)
+	r.WriteShare("0xa", "rig2", []string{"0x2", "0x0", "0x0"}, 200, 200, 1008, time.Hour)
+	r.WriteShare("0xb", "rig1", []string{"0x3", "0x0", "0x0"}, 500, 500, 1008, time.Hour)
+
+	now := util.MakeTimestamp() / 1000
+	days, err := r.GetDailyDifficulty("0xa", now-int64(DailyHistoryRetention/time.Second), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 1 || days[0].Day != now-now%86400 || days[0].Difficulty != 300 {
+		t.Errorf("Must sum difficulty of login per day, got %v", days)
+	}
+	if days, _ = r.GetDailyDifficulty("0xa", now-2*86400, now-86400); len(days) != 0 {
+		t.Errorf("Must only return days in range, got %v", days)
+	}
+}
+
+func TestUnlockerParams(t *testing.T) {
+	reset()
+
+	if params, err := r.GetUnlockerParams(); params != nil || err != nil {
+		t.Errorf("Must return nil params before unlocker started, got %v %v", params, err)
+	}
+	r.WriteUnlockerParams(&UnlockerParams{PoolFee: 1, Depth: 120, Timestamp: 100})
+	r.WriteUnlockerParams(&UnlockerParams{PoolFee: 1, Depth: 240, Timestamp: 200})
+	r.WriteUnlockerParams(&UnlockerParams{PoolFee: 0.5, Depth: 240, Timestamp: 300})
+
+	params, _ := r.GetUnlockerParams()
+	if params == nil || params.PoolFee != 0.5 || params.Depth != 240 {
+		t.Errorf("Must replace params, got %v", params)
+	}
+	fees, _ := r.GetFeeHistory()
+	if len(fees) != 2 || fees[0].Timestamp != 100 || fees[1].Fee != 0.5 || fees[1].Timestamp != 300 {
+		t.Errorf("Must append only fee changes to history, got %v", fees)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	reset()
+	r.history = time.Hour
+	defer func() { r.history = 0 }()
+
+	now := util.MakeTimestamp() / 1000
+	r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 100, 100, 1008, time.Hour)
+	r.WriteReportedHashrate("0xa", "rig1", 1000, "", time.Hour)
+	r.WritePayment("0xa", "0xtx", 50)
+	old := &BlockData{Height: 100, RoundHeight: 100, Hash: "0xb1", Nonce: "0x1", Timestamp: now - 7200, Reward: big.NewInt(10000000000), ExtraData: "pool"}
+	recent := &BlockData{Height: 200, RoundHeight: 200, Hash: "0xb2", Nonce: "0x2", Timestamp: now, Reward: big.NewInt(10000000000), ExtraData: "pool"}
+	for _, block := range []*BlockData{old, recent} {
+		r.client.HSet(r.formatKey("blocks", "best"), block.Nonce, join(int64(5000), "0xa"))
+		r.WriteImmatureBlock(block, map[string]int64{"0xa": 60})
+		r.WriteMaturedBlock(block, map[string]int64{"0xa": 60})
+	}
+
+	removed, err := r.Prune(map[string]int64{RetentionBlocks: now - 3600})
+	if err != nil || removed[RetentionBlocks] != 1 || len(removed) != 1 {
+		t.Errorf("Must prune only configured class, got %v %v", removed, err)
+	}
+	if blocks := r.client.ZCard(r.formatKey("blocks", "matured")).Val(); blocks != 1 {
+		t.Errorf("Must keep recent matured block, got %v blocks", blocks)
+	}
+	if r.client.Exists(r.formatKey("credits", "100", "0xb1")).Val() || !r.client.Exists(r.formatKey("credits", "200", "0xb2")).Val() {
+		t.Error("Must remove credits of pruned block only")
+	}
+	if tags := r.client.HKeys(r.formatKey("blocks", "extra")).Val(); len(tags) != 1 || tags[0] != "0xb2" {
+		t.Errorf("Must remove extra data of pruned block only, got %v", tags)
+	}
+	if best := r.client.HKeys(r.formatKey("blocks", "best")).Val(); len(best) != 1 || best[0] != "0x2" {
+		t.Errorf("Must remove best share of pruned block only, got %v", best)
+	}
+
+	future := now + 7200
+	removed, err = r.Prune(map[string]int64{RetentionWorkers: future, RetentionPayments: future, RetentionCharts: future})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Global and login hashrate samples and reported hashrate of worker
+	if removed[RetentionWorkers] != 3 || removed[RetentionPayments] != 2 || removed[RetentionCharts] != 1 {
+		t.Errorf("Must prune workers, payments and charts, got %v", removed)
+	}
+	if r.client.Exists(r.formatKey("reported", "0xa")).Val() || r.client.Exists(r.formatKey("payments", "0xa")).Val() {
+		t.Error("Must remove stale worker and payment entries")
+	}
+	if balance, _ := r.GetBalance("0xa"); balance != 120 {
+		t.Errorf("Must never prune balances, got %v", balance)
+	}
+}
+
+func TestBlockRaces(t *testing.T) {
+	reset()
+	for i := uint64(1); i <= 3; i++ {
+		r.WriteBlockRace(&BlockRace{Login: "0xa", Height: i, Upstreams: []*BlockRaceUpstream{{Name: "main", Head: i}}})
+	}
+	races, err := r.GetBlockRaces(2)
+	if err != nil || len(races) != 2 {
+		t.Fatalf("Must return limited number of races, got %v %v", races, err)
+	}
+	if races[0].Height != 3 || races[0].Upstreams[0].Head != 3 {
+		t.Errorf("Must return newest race first, got %v", races[0])
+	}
+}
+
+func TestShareBuffer(t *testing.T) {
+	reset()
+	r.shares, _ = newShareBuffer(&ShareBufferConfig{MaxBatch: 10})
+	defer func() { r.shares = nil }()
+	tenant := r.Namespace("other")
+
+	r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 100, 100, 1008, time.Hour)
+	tenant.WriteShare("0xa", "rig1", []string{"0x2", "0x0", "0x0"}, 50, 50, 1008, time.Hour)
+	if exist, _ := r.WriteShare("0xa", "rig1", []string{"0x1", "0x0", "0x0"}, 100, 100, 1008, time.Hour); !exist {
+		t.Error("Must detect duplicate of buffered share right away")
+	}
+	if n := r.client.HGet(r.formatKey("shares", "roundCurrent"), "0xa").Val(); n != "" {
+		t.Errorf("Must not credit share before flush, got %v", n)
+	}
+
+	if err := r.FlushShares(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.client.HGet(r.formatKey("shares", "roundCurrent"), "0xa").Int64(); n != 100 {
+		t.Errorf("Must credit buffered share on flush, got %v", n)
+	}
+	if n, _ := tenant.client.HGet(tenant.formatKey("shares", "roundCurrent"), "0xa").Int64(); n != 50 {
+		t.Errorf("Must credit buffered share to its tenant, got %v", n)
+	}
+
+	r.WriteShare("0xa", "rig1", []string{"0x3", "0x0", "0x0"}, 100, 100, 1008, time.Hour)
+	r.WriteBlock("0xa", "rig1", []string{"0x4", "0x0", "0x0"}, 100, 100, 5000, 1008, time.Hour)
+	if n, _ := r.client.HGet(r.formatRound(1008, "0x4"), "0xa").Int64(); n != 300 {
+		t.Errorf("Must credit buffered shares to round of found block, got %v", n)
+	}
+
+	b, _ := newShareBuffer(&ShareBufferConfig{MaxBatch: 1})
+	for i := 0; i < shareBufferBatches+2; i++ {
+		b.add(&bufferedShare{ms: int64(i)})
+	}
+	if len(b.pending) != shareBufferBatches || b.dropped != 2 || b.pending[0].ms != 2 {
+		t.Errorf("Must drop oldest shares above limit, got %v shares, %v dropped", len(b.pending), b.dropped)
+	}
+}
+
+func TestExportToken(t *testing.T) {
+	reset()
+
+	r.SetExportToken("0xa", "digest")
+	if v, _ := r.GetExportToken("0xa"); v != "digest" {
+		t.Errorf("Must return stored digest, got %q", v)
+	}
+	r.DeleteExportToken("0xa")
+	if v, err := r.GetExportToken("0xa"); v != "" || err != nil {
+		t.Errorf("Must return empty digest after revoke, got %q %v", v, err)
+	}
+}
+
+func TestWatchNotifications(t *testing.T) {
+	reset()
+	r.WriteAdjustment("0xa", "fix", 100)
+	r.WriteAdjustment("0xb", "fix", 200)
+
+	r.SetWatch("0xa", &Watch{Url: "https://example.com/hook", Token: "token"})
+	if w, _ := r.GetWatch("0xa"); w == nil || w.Url != "https://example.com/hook" {
+		t.Errorf("Must return stored watch, got %v", w)
+	}
+	if w, err := r.GetWatch("0xb"); w != nil || err != nil {
+		t.Errorf("Must return nil for unwatched address, got %v %v", w, err)
+	}
+
+	r.UpdateBalance("0xa", 100)
+	r.UpdateBalance("0xb", 200)
+	r.WritePayment("0xa", "0x1", 100)
+
+	n, _ := r.PopWatchNotification(time.Second)
+	if n == nil || n.Type != EventPayout || n.Login != "0xa" || n.Amount != -100 {
+		t.Fatalf("Must notify about payout of watched address first, got %v", n)
+	}
+	n, _ = r.PopWatchNotification(time.Second)
+	if n == nil || n.Type != EventPayment || n.TxHash != "0x1" || n.Amount != 100 {
+		t.Fatalf("Must notify about payment of watched address, got %v", n)
+	}
+	if n, _ = r.PopWatchNotification(time.Second); n != nil {
+		t.Errorf("Must not notify about unwatched address, got %v", n)
+	}
+
+	r.DeleteWatch("0xa")
+	r.RollbackBalance("0xa", 100)
+	if n, _ = r.PopWatchNotification(time.Second); n != nil {
+		t.Errorf("Must not notify after watch removal, got %v", n)
+	}
+}
+
+func TestPaymentReceipts(t *testing.T) {
+	reset()
+	r.SetWatch("0xa", &Watch{Url: "https://example.com/hook", Token: "token"})
+
+	receipt := &PaymentReceipt{TxHash: "0x1", Login: "0xa", Amount: 100, BlockHash: "0xb", GasUsed: 21000,
+		GasPrice: "1000000000", Fee: "21000000000000", Success: true, Timestamp: 1}
+	if err := r.WritePaymentReceipt(receipt); err != nil {
+		t.Fatalf("Must write receipt, got %v", err)
+	}
+	receipts, err := r.GetPaymentReceipts([]string{"0x1", "0x2"})
+	if err != nil || len(receipts) != 1 || !reflect.DeepEqual(receipts["0x1"], receipt) {
+		t.Errorf("Must return only mined receipts, got %v %v", receipts, err)
+	}
+	n, _ := r.PopWatchNotification(time.Second)
+	if n == nil || n.Type != WatchReceipt || n.TxHash != "0x1" || n.GasUsed != 21000 || n.Fee != "21000000000000" {
+		t.Errorf("Must notify watcher about mined payment, got %v", n)
+	}
+}
+
+func TestPPLNS(t *testing.T) {
+	reset()
+	r.SetRewards(&RewardsConfig{Scheme: SchemePPLNS, PPLNS: PPLNSWindow{Shares: 3}})
+	defer r.SetRewards(&RewardsConfig{})
+
+	for i, login := range []string{"0xa", "0xa", "0xb", "0xb"} {
+		r.WriteShare(login, "rig", []string{strconv.Itoa(i), "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+		time.Sleep(2 * time.Millisecond)
+	}
+	r.WriteBlock("0xc", "rig", []string{"0x9", "0x0", "0x0"}, 10, 10, 50, 1008, time.Hour)
+
+	shares, _ := r.GetRoundShares(1008, "0x9")
+	if !reflect.DeepEqual(shares, map[string]int64{"0xb": 20, "0xc": 10}) {
+		t.Errorf("Must credit last shares of window, got %v", shares)
+	}
+	candidates, _ := r.GetCandidates(1008)
+	if len(candidates) != 1 || candidates[0].TotalShares != 50 {
+		t.Errorf("Must keep shares of round for effort, got %v", candidates)
+	}
+	if n := r.client.ZCard(r.formatKey("pplns")).Val(); n != 3 {
+		t.Errorf("Must trim window to its size, got %v", n)
+	}
+
+	var errs util.ConfigErrors
+	(&RewardsConfig{Scheme: SchemePPLNS}).Validate(&errs)
+	(&RewardsConfig{Scheme: SchemePPLNS, PPLNS: PPLNSWindow{Shares: 10, Period: "1h"}}).Validate(&errs)
+	(&RewardsConfig{Scheme: "DGM"}).Validate(&errs)
+	(&RewardsConfig{Scheme: SchemePPS, PPS: PPSConfig{Fee: 100}}).Validate(&errs)
+	if len(errs) != 4 {
+		t.Errorf("Must require exactly one window limit, PPS fee below 100%% and known scheme, got %v", errs)
+	}
+}
+
+func TestSoloBlock(t *testing.T) {
+	reset()
+	r.WriteShare("0xa", "rig", []string{"0x1", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteSoloShare("0xs", "rig", []string{"0x2", "0x0", "0x0"}, 10, 10, 1008, time.Hour)
+	r.WriteSoloBlock("0xs", "rig2", []string{"0x3", "0x0", "0x0"}, 10, 10, 50, 1008, time.Hour)
+
+	if shares := r.client.HGetAllMap(r.formatKey("shares", "roundCurrent")).Val(); !reflect.DeepEqual(shares, map[string]string{"0xa": "10"}) {
+		t.Errorf("Must keep solo shares out of pool round, got %v", shares)
+	}
+	if n := r.client.ZCard(r.formatKey("hashrate", "0xs")).Val(); n != 2 {
+		t.Errorf("Must count solo shares for hashrate, got %v", n)
+	}
+	candidates, _ := r.GetCandidates(1008)
+	if len(candidates) != 1 || candidates[0].Solver != "0xs" {
+		t.Fatalf("Must record solver on candidate, got %v", candidates)
+	}
+	block := candidates[0]
+	block.Hash = "0xh"
+	block.Reward = big.NewInt(5000000000000000000)
+	r.WriteImmatureBlock(block, map[string]int64{"0xs": 4900000000})
+	immature, _ := r.GetImmatureBlocks(1008)
+	if len(immature) != 1 || immature[0].Solver != "0xs" {
+		t.Errorf("Must keep solver on immature block, got %v", immature)
+	}
+}
+
+func TestPPS(t *testing.T) {
+	reset()
+	r.AccruePPS("0xa", 1.5)
+	r.AccruePPS("0xa", 1)
+	r.AccruePPS("0xb", 0.4)
+
+	credits, err := r.SettlePPS()
+	if err != nil || !reflect.DeepEqual(credits, map[string]int64{"0xa": 2}) {
+		t.Fatalf("Must settle whole Shannon only, got %v %v", credits, err)
+	}
+	if balance, _ := r.GetBalance("0xa"); balance != 2 {
+		t.Errorf("Must credit balance, got %v", balance)
+	}
+	if v := r.client.HGet(r.formatKey("pps", "accrued"), "0xa").Val(); v != "0.5" {
+		t.Errorf("Must keep fraction unsettled, got %v", v)
+	}
+	entries, _ := r.GetLedger("0xa", 0, util.MakeTimestamp())
+	credit := ppsCredit(entries[0].Timestamp, "0xa", 2)
+	credit.Id = entries[0].Id
+	if len(entries) != 1 || *entries[0] != *credit {
+		t.Errorf("Must post PPS credit to ledger, got %v", entries)
+	}
+	r.FundPPS(&BlockData{Height: 10, Hash: "0xh"}, 10)
+	if buffer, _ := r.GetPPSBuffer(); buffer != 8 {
+		t.Errorf("Must track buffer funded by blocks and debited by credits, got %v", buffer)
+	}
+}
+
+func TestShareSamples(t *testing.T) {
+	reset()
+
+	r.WriteShareSamples([]*ShareSample{{Session: "a"}, {Session: "b"}, {Session: "c"}}, 2)
+	samples, err := r.GetShareSamples()
+	if err != nil || len(samples) != 2 || samples[0].Session != "c" {
+		t.Errorf("Must keep most recent samples, got %v %v", len(samples), err)
+	}
+}
+
+func TestLiveTail(t *testing.T) {
+	reset()
+
+	r.StartTail("0xa", time.Minute)
+	r.StartTail("0xb", -time.Minute)
+	tails, err := r.GetActiveTails()
+	if err != nil || !reflect.DeepEqual(tails, []string{"0xa"}) {
+		t.Errorf("Must return only unexpired tails, got %v (%v)", tails, err)
+	}
+
+	pubsub, err := r.SubscribeTail("0xa")
+	if err != nil {
+		t.Fatalf("Must subscribe to tail, got %v", err)
+	}
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(); err != nil {
+		t.Fatalf("Must confirm subscription, got %v", err)
+	}
+	r.PublishTail("0xb", []byte("other"))
+	r.PublishTail("0xa", []byte("message"))
+	m, err := pubsub.ReceiveMessage()
+	if err != nil || m.Payload != "message" {
+		t.Errorf("Must receive tail of login, got %v (%v)", m, err)
+	}
+}
+
+func TestUpstreamStats(t *testing.T) {
+	reset()
+
+	r.WriteUpstreamStats([]*UpstreamStats{{Instance: "us1", Name: "main", Timestamp: 100, Height: 10}}, 60)
+	r.WriteUpstreamStats([]*UpstreamStats{{Instance: "us1", Name: "main", Timestamp: 200, Height: 12, Lag: 1}}, 60)
+	stats, history, err := r.GetUpstreamStats(150)
+	if err != nil || len(stats) != 1 || stats[0].Height != 12 || stats[0].Lag != 1 {
+		t.Fatalf("Must return latest upstream stats, got %v (%v)", stats, err)
+	}
+	if samples := history["us1:main"]; len(samples) != 1 || samples[0].Timestamp != 200 {
+		t.Errorf("Must keep only samples within window, got %v", samples)
+	}
+	stats, _, _ = r.GetUpstreamStats(300)
+	if len(stats) != 0 {
+		t.Errorf("Must skip upstreams not reported since given time, got %v", stats)
+	}
+}
+
+func TestPaymentsReport(t *testing.T) {
+	reset()
+
+	r.WritePayment("0xa", "0x1", 100)
+	r.WritePayment("0xb", "0x2", 200)
+	payments, err := r.GetAllPayments()
+	if err != nil || len(payments) != 2 || payments[0].TxHash != "0x1" || payments[1].Address != "0xb" || payments[1].Amount != 200 {
+		t.Fatalf("Must return all payments, got %v (%v)", payments, err)
+	}
+
+	if report, err := r.GetPaymentsReport(); report != nil || err != nil {
+		t.Errorf("Must return no report before verification, got %v (%v)", report, err)
+	}
+	r.WritePaymentsReport(&PaymentsReport{Checked: 2, Discrepancies: []*PaymentDiscrepancy{{Payment: *payments[0], Reason: "transaction not found"}}})
+	report, err := r.GetPaymentsReport()
+	if err != nil || report.Checked != 2 || len(report.Discrepancies) != 1 || report.Discrepancies[0].TxHash != "0x1" {
+		t.Errorf("Must store payments report, got %v (%v)", report, err)
+	}
+}
+
+func TestHeldPayments(t *testing.T) {
+	reset()
+
+	if held, err := r.GetHeldPayments(); err != nil || len(held) != 0 {
+		t.Errorf("Must return no held payments, got %v (%v)", held, err)
+	}
+	r.WriteHeldPayment("0xa", 100)
+	r.WriteHeldPayment("0xb", 200)
+	r.WriteHeldPayment("0xa", 150)
+	r.DeleteHeldPayment("0xb")
+	held, err := r.GetHeldPayments()
+	if err != nil || len(held) != 1 || held[0].Address != "0xa" || held[0].Amount != 150 || held[0].Timestamp == 0 {
+		t.Errorf("Must keep last amount of each held login, got %v (%v)", held, err)
+	}
+}
+
+func TestJobMetrics(t *testing.T) {
+	reset()
+
+	r.WriteJobMetrics("unlocker", map[string]float64{"success": 1, "blocks_matured": 2})
+	r.WriteJobMetrics("unlocker", map[string]float64{"success": 0})
+	r.Namespace("alpha").WriteJobMetrics("payouts", map[string]float64{"miners_paid": 3})
+	jobs, err := r.GetJobMetrics()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("Must return only jobs of pool itself, got %v (%v)", jobs, err)
+	}
+	if values := jobs["unlocker"]; len(values) != 1 || values["success"] != 0 {
+		t.Errorf("Must replace metrics of previous run, got %v", values)
+	}
+	jobs, _ = r.Namespace("alpha").GetJobMetrics()
+	if jobs["payouts"]["miners_paid"] != 3 {
+		t.Errorf("Must keep job metrics of tenant apart, got %v", jobs)
+	}
+}
+
+func TestPaymentTxs(t *testing.T) {
+	reset()
+
+	r.WritePaymentTx(&PaymentTx{Nonce: 12, State: PaymentTxAllocated, Login: "x", Amount: 100})
+	r.WritePaymentTx(&PaymentTx{Nonce: 9, State: PaymentTxSent, Login: "y", Amount: 200, Hashes: []string{"0x1"}})
+	r.WritePaymentTx(&PaymentTx{Nonce: 12, State: PaymentTxSigned, Login: "x", Amount: 100, Hashes: []string{"0x2"}})
+	txs, err := r.GetPaymentTxs()
+	if err != nil || len(txs) != 2 {
+		t.Fatalf("Must track payment tx by nonce, got %v (%v)", txs, err)
+	}
+	if txs[0].Nonce != 9 || txs[1].State != PaymentTxSigned || txs[1].Hashes[0] != "0x2" {
+		t.Errorf("Must return latest state, lowest nonce first, got %v %v", txs[0], txs[1])
+	}
+	r.RemovePaymentTx(9)
+	if txs, _ = r.GetPaymentTxs(); len(txs) != 1 || txs[0].Nonce != 12 {
+		t.Errorf("Must remove mined payment tx, got %v", txs)
+	}
+}
+
+func TestPayoutsControl(t *testing.T) {
+	reset()
+
+	if c, err := r.GetPayoutsControl(); err != nil || c.Paused || c.RunRequested != 0 {
+		t.Fatalf("Must not pause payouts by default, got %v (%v)", c, err)
+	}
+	r.SetPayoutsPaused(true, "ops")
+	ms, _ := r.RequestPayoutsRun("finance")
+	c, _ := r.Namespace("other").GetPayoutsControl()
+	if !c.Paused || c.PausedBy != "ops" || c.RunRequested != ms || c.RequestedBy != "finance" {
+		t.Errorf("Must share control with tenants, got %+v", c)
+	}
+	r.SetPayoutsPaused(false, "ops")
+	if c, _ = r.GetPayoutsControl(); c.Paused || c.RunRequested != ms {
+		t.Errorf("Must resume payouts and keep run request, got %+v", c)
+	}
+	if err := r.ControlPayouts("stop", "ops"); err == nil {
+		t.Error("Must reject unknown action")
+	}
+}
+
+func TestDonations(t *testing.T) {
+	reset()
+
+	r.SetDonation("0xa", 2.5)
+	r.SetDonation("0xb", 1)
+	r.WriteDonations(map[string]int64{"0xa": 10, "0xb": 5})
+	r.WriteDonations(map[string]int64{"0xa": 10})
+	r.SetDonation("0xb", 0)
+
+	if donations, _ := r.GetDonations(); !reflect.DeepEqual(donations, map[string]float64{"0xa": 2.5}) {
+		t.Errorf("Must stop donating at zero percent, got %v", donations)
+	}
+	if d, err := r.GetDonation("0xb"); err != nil || d.Percent != 0 || d.Total != 5 {
+		t.Errorf("Must keep total of past donations, got %v (%v)", d, err)
+	}
+	if d, _ := r.GetDonation("0xa"); d.Percent != 2.5 || d.Total != 20 {
+		t.Errorf("Must sum donations, got %+v", d)
+	}
+	if total, _ := r.GetDonationsTotal(); total != 25 {
+		t.Errorf("Must sum donations of all miners, got %v", total)
+	}
+}
+
+func TestNextStreamId(t *testing.T) {
+	if id := nextStreamId("1526-9"); id != "1526-10" {
+		t.Errorf("Must take next sequence of same ms, got %v", id)
+	}
+}
@@ -0,0 +1,303 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const defaultPruneInterval = time.Hour
+
+// Data classes pruned by retention
+const (
+	RetentionWorkers  = "workers"
+	RetentionBlocks   = "blocks"
+	RetentionPayments = "payments"
+	RetentionCharts   = "charts"
+	RetentionLedger   = "ledger"
+)
+
+// How long data of each class is kept, blank keeps it forever.
+// Balances and round shares are never pruned.
+type RetentionConfig struct {
+	Enabled bool `json:"enabled"`
+	// Pruning loop interval, 1h if not set
+	Interval string `json:"interval"`
+	// Hashrate samples and reported hashrate of workers
+	Workers string `json:"workers"`
+	// Matured blocks with their credits
+	Blocks string `json:"blocks"`
+	// Finished payments, they are no longer verified by payouts.verify then
+	Payments string `json:"payments"`
+	// Hourly worker history behind charts and CSV export
+	Charts string `json:"charts"`
+	// Ledger entries, balances are kept when they are pruned
+	Ledger string `json:"ledger"`
+}
+
+func (c *RetentionConfig) Validate(errs *util.ConfigErrors) {
+	errs.Duration("retention.interval", c.Interval, true)
+	errs.Duration("retention.workers", c.Workers, true)
+	errs.Duration("retention.blocks", c.Blocks, true)
+	errs.Duration("retention.payments", c.Payments, true)
+	errs.Duration("retention.charts", c.Charts, true)
+	errs.Duration("retention.ledger", c.Ledger, true)
+}
+
+// Cutoff timestamps in seconds by data class, classes kept forever are missing
+func (c *RetentionConfig) cutoffs(now time.Time) map[string]int64 {
+	result := make(map[string]int64)
+	for class, ttl := range map[string]string{RetentionWorkers: c.Workers, RetentionBlocks: c.Blocks,
+		RetentionPayments: c.Payments, RetentionCharts: c.Charts, RetentionLedger: c.Ledger} {
+		if len(ttl) > 0 {
+			result[class] = now.Add(-util.MustParseDuration(ttl)).Unix()
+		}
+	}
+	return result
+}
+
+type RetentionStorage interface {
+	// Removes data older than cutoff of its class, returns number of removed entries by class
+	Prune(cutoffs map[string]int64) (map[string]int64, error)
+}
+
+// Prunes storages of pool and tenants
+type Pruner struct {
+	config *RetentionConfig
+	stores []Storage
+}
+
+func NewPruner(cfg *RetentionConfig, stores ...Storage) *Pruner {
+	return &Pruner{config: cfg, stores: stores}
+}
+
+func (p *Pruner) Start() {
+	intv := defaultPruneInterval
+	if len(p.config.Interval) > 0 {
+		intv = util.MustParseDuration(p.config.Interval)
+	}
+	log.Printf("Set data pruning interval to %v", intv)
+	p.Prune()
+	for range time.Tick(intv) {
+		p.Prune()
+	}
+}
+
+// One pass over all storages, failure of one doesn't stop others
+func (p *Pruner) Prune() error {
+	cutoffs := p.config.cutoffs(time.Now())
+	if len(cutoffs) == 0 {
+		log.Println("Retention is not set for any data class, nothing to prune")
+		return nil
+	}
+	var failed error
+	for _, store := range p.stores {
+		start := time.Now()
+		removed, err := store.Prune(cutoffs)
+		name := store.Tenant()
+		if len(name) == 0 {
+			name = "pool"
+		}
+		if err != nil {
+			log.Printf("Failed to prune %v storage: %v", name, err)
+			failed = err
+			continue
+		}
+		log.Printf("Pruned %v storage in %v: %v", name, time.Since(start), removed)
+	}
+	return failed
+}
+
+func (r *RedisClient) Prune(cutoffs map[string]int64) (map[string]int64, error) {
+	removed := make(map[string]int64)
+	for _, class := range []string{RetentionWorkers, RetentionBlocks, RetentionPayments, RetentionCharts, RetentionLedger} {
+		cutoff, ok := cutoffs[class]
+		if !ok {
+			continue
+		}
+		var n int64
+		var err error
+		switch class {
+		case RetentionWorkers:
+			n, err = r.pruneWorkers(cutoff)
+		case RetentionBlocks:
+			n, err = r.pruneBlocks(cutoff)
+		case RetentionPayments:
+			n, err = r.prunePayments(cutoff)
+		case RetentionCharts:
+			n, err = r.pruneCharts(cutoff)
+		case RetentionLedger:
+			n, err = r.pruneLedger(cutoff)
+		}
+		removed[class] = n
+		if err != nil {
+			return removed, fmt.Errorf("%s: %v", class, err)
+		}
+	}
+	return removed, nil
+}
+
+// Calls fn with suffixes of keys matching <prefix>:*, where prefix is built from args
+func (r *RedisClient) scanKeys(fn func(key, suffix string) error, args ...interface{}) error {
+	prefix := r.formatKey(args...) + ":"
+	var c int64
+	for {
+		var keys []string
+		var err error
+		c, keys, err = r.client.Scan(c, prefix+"*", 100).Result()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := fn(key, strings.TrimPrefix(key, prefix)); err != nil {
+				return err
+			}
+		}
+		if c == 0 {
+			return nil
+		}
+	}
+}
+
+// Reported hashrate is kept per worker in hash which lives as long as any worker reports
+func (r *RedisClient) pruneWorkers(cutoff int64) (int64, error) {
+	max := fmt.Sprint("(", cutoff)
+	total, err := r.client.ZRemRangeByScore(r.formatKey("hashrate"), "-inf", max).Result()
+	if err != nil {
+		return total, err
+	}
+	err = r.scanKeys(func(key, login string) error {
+		n, err := r.client.ZRemRangeByScore(key, "-inf", max).Result()
+		total += n
+		return err
+	}, "hashrate")
+	if err != nil {
+		return total, err
+	}
+	err = r.scanKeys(func(key, login string) error {
+		workers, err := r.client.HGetAllMap(key).Result()
+		if err != nil {
+			return err
+		}
+		var stale []string
+		for id, value := range workers {
+			// hashrate:ts:clientId
+			fields := strings.SplitN(value, ":", 3)
+			if len(fields) < 2 {
+				continue
+			}
+			if ts, _ := strconv.ParseInt(fields[1], 10, 64); ts < cutoff {
+				stale = append(stale, id)
+			}
+		}
+		if len(stale) == 0 {
+			return nil
+		}
+		n, err := r.client.HDel(key, stale...).Result()
+		total += n
+		return err
+	}, "reported")
+	return total, err
+}
+
+// Heights of matured blocks grow with time, so blocks are taken from the lowest one until a recent block is met
+func (r *RedisClient) pruneBlocks(cutoff int64) (int64, error) {
+	const batch = 100
+	var total int64
+	for {
+		rows := r.client.ZRangeWithScores(r.formatKey("blocks", "matured"), 0, batch-1)
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		var members []string
+		var hashes []string
+		var nonces []string
+		var keys []string
+		maxHeight := int64(-1)
+		for _, block := range convertBlockResults(rows) {
+			if block.Timestamp >= cutoff {
+				break
+			}
+			members = append(members, block.immatureKey)
+			hashes = append(hashes, block.Hash)
+			nonces = append(nonces, block.Nonce)
+			keys = append(keys, r.formatKey("credits", block.Height, block.Hash))
+			maxHeight = block.Height
+		}
+		if len(members) == 0 {
+			return total, nil
+		}
+		tx := r.client.Multi()
+		_, err := tx.Exec(func() error {
+			tx.ZRem(r.formatKey("blocks", "matured"), members...)
+			tx.Del(keys...)
+			tx.HDel(r.formatKey("blocks", "extra"), hashes...)
+			tx.HDel(r.formatKey("blocks", "best"), nonces...)
+			tx.ZRemRangeByScore(r.formatKey("credits", "all"), "-inf", strconv.FormatInt(maxHeight, 10))
+			return nil
+		})
+		tx.Close()
+		if err != nil {
+			return total, err
+		}
+		total += int64(len(members))
+		if len(members) < batch {
+			return total, nil
+		}
+	}
+}
+
+// Entries are scored by time in ms, every entry is member of pool ledger and ledgers of its logins
+func (r *RedisClient) pruneLedger(cutoff int64) (int64, error) {
+	max := fmt.Sprint("(", cutoff*1000)
+	total, err := r.client.ZRemRangeByScore(r.formatKey("ledger"), "-inf", max).Result()
+	if err != nil {
+		return total, err
+	}
+	err = r.scanKeys(func(key, login string) error {
+		_, err := r.client.ZRemRangeByScore(key, "-inf", max).Result()
+		return err
+	}, "ledger")
+	return total, err
+}
+
+func (r *RedisClient) prunePayments(cutoff int64) (int64, error) {
+	max := fmt.Sprint("(", cutoff)
+	total, err := r.client.ZRemRangeByScore(r.formatKey("payments", "all"), "-inf", max).Result()
+	if err != nil {
+		return total, err
+	}
+	err = r.scanKeys(func(key, login string) error {
+		// Pending payments, lock and verification report share the prefix
+		if !strings.HasPrefix(login, "0x") {
+			return nil
+		}
+		n, err := r.client.ZRemRangeByScore(key, "-inf", max).Result()
+		total += n
+		return err
+	}, "payments")
+	return total, err
+}
+
+// Hourly history keys expire with workerHistory set when they were written, which may have been longer
+func (r *RedisClient) pruneCharts(cutoff int64) (int64, error) {
+	var stale []string
+	err := r.scanKeys(func(key, suffix string) error {
+		i := strings.LastIndexByte(suffix, ':')
+		if i < 0 {
+			return nil
+		}
+		if hour, err := strconv.ParseInt(suffix[i+1:], 10, 64); err == nil && hour+3600 <= cutoff {
+			stale = append(stale, key)
+		}
+		return nil
+	}, "history")
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+	return r.client.Del(stale...).Result()
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"encoding/json"
+)
+
+// Share interval statistics of a single stratum session, anonymized with random id
+type ShareSample struct {
+	Session   string `json:"session"`
+	Timestamp int64  `json:"ts"`
+	Shares    int64  `json:"shares"`
+	// Session difficulty at the end of sampling period
+	Diff int64 `json:"diff"`
+	// Hashrate estimated from intervals and coefficient of variation of intervals,
+	// which is 1 for miners submitting as Poisson process
+	Hashrate float64 `json:"hashrate"`
+	CV       float64 `json:"cv"`
+}
+
+// Keeps only the most recent samples
+func (r *RedisClient) WriteShareSamples(samples []*ShareSample, keep int64) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	key := r.formatRootKey("vardiff", "samples")
+	_, err := tx.Exec(func() error {
+		for _, s := range samples {
+			data, _ := json.Marshal(s)
+			tx.LPush(key, string(data))
+		}
+		tx.LTrim(key, 0, keep-1)
+		return nil
+	})
+	return err
+}
+
+func (r *RedisClient) GetShareSamples() ([]*ShareSample, error) {
+	raw, err := r.client.LRange(r.formatRootKey("vardiff", "samples"), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]*ShareSample, 0, len(raw))
+	for _, v := range raw {
+		var s ShareSample
+		if err := json.Unmarshal([]byte(v), &s); err == nil {
+			samples = append(samples, &s)
+		}
+	}
+	return samples, nil
+}
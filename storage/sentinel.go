@@ -0,0 +1,238 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often sentinels are asked for master, connections to old master are closed on change
+const sentinelCheckInterval = 2 * time.Second
+
+// Redis Sentinel deployment, endpoint is ignored and master is resolved by name
+type SentinelConfig struct {
+	MasterName string   `json:"masterName"`
+	Addrs      []string `json:"addrs"`
+	// Password of sentinels, password of master is redis.password
+	Password string `json:"password"`
+}
+
+func (c *SentinelConfig) Enabled() bool {
+	return len(c.MasterName) > 0
+}
+
+// Resolves master through sentinels on every dial and tracks connections to it.
+// Network errors make client drop connection, so next dial follows failover.
+type sentinelResolver struct {
+	config *SentinelConfig
+	mu     sync.Mutex
+	addr   string
+	conns  map[*sentinelConn]struct{}
+}
+
+type sentinelConn struct {
+	net.Conn
+	addr     string
+	resolver *sentinelResolver
+	once     sync.Once
+}
+
+func (c *sentinelConn) Close() error {
+	c.once.Do(func() {
+		c.resolver.mu.Lock()
+		delete(c.resolver.conns, c)
+		c.resolver.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+func newSentinelResolver(cfg *SentinelConfig) *sentinelResolver {
+	s := &sentinelResolver{config: cfg, conns: make(map[*sentinelConn]struct{})}
+	if addr, err := s.resolve(); err != nil {
+		log.Printf("Failed to resolve redis master %s: %v", cfg.MasterName, err)
+	} else {
+		log.Printf("Redis master %s is at %s", cfg.MasterName, addr)
+	}
+	return s
+}
+
+// Asks sentinels in turn, last known master is kept if none of them answers
+func (s *sentinelResolver) resolve() (string, error) {
+	var err error
+	for _, sentinel := range s.config.Addrs {
+		var addr string
+		if addr, err = s.queryMaster(sentinel); err == nil {
+			s.switchMaster(addr)
+			return addr, nil
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.addr) > 0 {
+		return s.addr, nil
+	}
+	return "", fmt.Errorf("no sentinel knows master: %v", err)
+}
+
+// Closes connections to previous master, commands in flight fail and pool dials again
+func (s *sentinelResolver) switchMaster(addr string) {
+	s.mu.Lock()
+	prev := s.addr
+	if prev == addr {
+		s.mu.Unlock()
+		return
+	}
+	s.addr = addr
+	var stale []*sentinelConn
+	for c := range s.conns {
+		if c.addr != addr {
+			stale = append(stale, c)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(prev) > 0 {
+		log.Printf("Redis master %s switched from %s to %s, closing %v connections", s.config.MasterName, prev, addr, len(stale))
+	}
+	for _, c := range stale {
+		c.Close()
+	}
+}
+
+func (s *sentinelResolver) queryMaster(sentinel string) (string, error) {
+	conn, err := net.DialTimeout("tcp", sentinel, dialTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	r := bufio.NewReader(conn)
+
+	if len(s.config.Password) > 0 {
+		reply, err := sendCommand(conn, r, "AUTH", s.config.Password)
+		if err != nil {
+			return "", err
+		}
+		if len(reply) == 0 || reply[0] != "OK" {
+			return "", errors.New("sentinel auth failed")
+		}
+	}
+	reply, err := sendCommand(conn, r, "SENTINEL", "get-master-addr-by-name", s.config.MasterName)
+	if err != nil {
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("master %s is unknown to sentinel %s", s.config.MasterName, sentinel)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+func (s *sentinelResolver) track(conn net.Conn, addr string) net.Conn {
+	c := &sentinelConn{Conn: conn, addr: addr, resolver: s}
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+	return c
+}
+
+// Polls sentinels, so connections to demoted master don't linger when it stays reachable
+func (s *sentinelResolver) watch() {
+	for range time.Tick(sentinelCheckInterval) {
+		s.resolve()
+	}
+}
+
+// Server name of TLS defaults to host of resolved master unless configured
+func sentinelTLS(tlsConfig *tls.Config, addr string) *tls.Config {
+	if tlsConfig == nil || len(tlsConfig.ServerName) > 0 {
+		return tlsConfig
+	}
+	cfg := tlsConfig.Clone()
+	cfg.ServerName, _, _ = net.SplitHostPort(addr)
+	return cfg
+}
+
+// Sends command as array of bulk strings and reads status, error, bulk or array of bulks reply
+func sendCommand(w io.Writer, r *bufio.Reader, args ...string) ([]string, error) {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(w, cmd.String()); err != nil {
+		return nil, err
+	}
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return []string{line[1:]}, nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case '$':
+		s, err := readBulk(r, line)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		return []string{*s}, nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		reply := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			if line, err = readLine(r); err != nil {
+				return nil, err
+			}
+			s, err := readBulk(r, line)
+			if err != nil {
+				return nil, err
+			}
+			if s != nil {
+				reply = append(reply, *s)
+			}
+		}
+		return reply, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return "", errors.New("empty reply")
+	}
+	return line, nil
+}
+
+// Nil bulk string is returned as nil
+func readBulk(r *bufio.Reader, line string) (*string, error) {
+	if line[0] != '$' {
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, err
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	s := string(buf[:n])
+	return &s, nil
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+type fakeSentinel struct {
+	mu     sync.Mutex
+	master string
+}
+
+func (f *fakeSentinel) setMaster(addr string) {
+	f.mu.Lock()
+	f.master = addr
+	f.mu.Unlock()
+}
+
+func startFakeSentinel(t *testing.T, f *fakeSentinel) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				// *3, then SENTINEL, get-master-addr-by-name and name as bulk strings
+				var args []string
+				for i := 0; i < 7; i++ {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					args = append(args, strings.TrimSpace(line))
+				}
+				f.mu.Lock()
+				master := f.master
+				f.mu.Unlock()
+				if args[6] != "mymaster" || len(master) == 0 {
+					fmt.Fprint(conn, "*-1\r\n")
+					return
+				}
+				host, port, _ := net.SplitHostPort(master)
+				fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSentinelFailover(t *testing.T) {
+	f := &fakeSentinel{master: "10.0.0.1:6379"}
+	addr := startFakeSentinel(t, f)
+	s := newSentinelResolver(&SentinelConfig{MasterName: "mymaster", Addrs: []string{"127.0.0.1:1", addr}})
+	if s.addr != "10.0.0.1:6379" {
+		t.Errorf("Must resolve master through available sentinel, got %q", s.addr)
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := s.track(client, s.addr)
+
+	f.setMaster("10.0.0.2:6379")
+	if master, err := s.resolve(); err != nil || master != "10.0.0.2:6379" {
+		t.Errorf("Must follow failover, got %q %v", master, err)
+	}
+	if _, err := conn.Write([]byte("PING")); err == nil {
+		t.Error("Must close connections to old master")
+	}
+	if len(s.conns) != 0 {
+		t.Error("Must forget closed connections")
+	}
+
+	f.setMaster("")
+	if master, err := s.resolve(); err != nil || master != "10.0.0.2:6379" {
+		t.Errorf("Must keep last known master if sentinels don't know it, got %q %v", master, err)
+	}
+	s = newSentinelResolver(&SentinelConfig{MasterName: "other", Addrs: []string{addr}})
+	if _, err := s.resolve(); err == nil {
+		t.Error("Must fail on unknown master")
+	}
+}
+
+func TestSentinelConfig(t *testing.T) {
+	cfg := &Config{Sentinel: SentinelConfig{MasterName: "mymaster"}}
+	var errs util.ConfigErrors
+	cfg.Validate(&errs)
+	if errs.Err() == nil {
+		t.Error("Must require sentinel addresses")
+	}
+	cfg = &Config{Sentinel: SentinelConfig{MasterName: "mymaster", Addrs: []string{"10.0.0.1:26379"}}}
+	errs = nil
+	cfg.Validate(&errs)
+	if err := errs.Err(); err != nil {
+		t.Errorf("Must accept sentinel config, got %v", err)
+	}
+}
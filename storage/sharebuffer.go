@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultShareBufferInterval = 50 * time.Millisecond
+	defaultShareBufferBatch    = 1000
+	// Buffer holds this many batches at most while redis is failing
+	shareBufferBatches = 100
+)
+
+// Accepted shares are credited in one transaction per interval instead of one per share.
+// Duplicate check is still done for every share right away.
+type ShareBufferConfig struct {
+	Enabled bool `json:"enabled"`
+	// Flush interval, 50ms if not set
+	Interval string `json:"interval"`
+	// Buffer is flushed early once it holds this many shares, 1000 if not set.
+	// While writes fail it keeps 100 times as many, dropping oldest ones.
+	MaxBatch int `json:"maxBatch"`
+}
+
+type bufferedShare struct {
+	// Client of tenant share is credited to
+	r          *RedisClient
+	ms         int64
+	login      string
+	id         string
+	params     []string
+	diff       int64
+	actualDiff int64
+	height     uint64
+	window     time.Duration
+	stale      bool
+}
+
+// Shared by pool and tenant clients, so one transaction covers all of them
+type shareBuffer struct {
+	sync.Mutex
+	pending  []*bufferedShare
+	maxBatch int
+	full     chan struct{}
+	// Serializes flushes, so shares are credited in order
+	flushMu sync.Mutex
+	// Oldest shares dropped since start because redis was failing
+	dropped int64
+}
+
+func newShareBuffer(cfg *ShareBufferConfig) (*shareBuffer, time.Duration) {
+	b := &shareBuffer{maxBatch: cfg.MaxBatch, full: make(chan struct{}, 1)}
+	if b.maxBatch <= 0 {
+		b.maxBatch = defaultShareBufferBatch
+	}
+	intv := defaultShareBufferInterval
+	if len(cfg.Interval) > 0 {
+		intv = util.MustParseDuration(cfg.Interval)
+	}
+	return b, intv
+}
+
+func (b *shareBuffer) add(share *bufferedShare) {
+	b.Lock()
+	b.pending = append(b.pending, share)
+	b.trim()
+	full := len(b.pending) >= b.maxBatch
+	b.Unlock()
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *shareBuffer) run(intv time.Duration) {
+	log.Printf("Buffering share writes for %v, up to %v shares", intv, b.maxBatch)
+	ticker := time.NewTicker(intv)
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.full:
+		}
+		if err := b.flush(); err != nil {
+			b.Lock()
+			dropped := b.dropped
+			b.Unlock()
+			log.Printf("Failed to flush buffered shares, will retry: %v, %v oldest shares dropped since start", err, dropped)
+		}
+	}
+}
+
+// Failed batch is put back in front of shares buffered meanwhile
+func (b *shareBuffer) flush() error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.Lock()
+	batch := b.pending
+	b.pending = nil
+	b.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	tx := batch[0].r.client.Multi()
+	defer tx.Close()
+	_, err := tx.Exec(func() error {
+		for _, s := range batch {
+			s.r.writeShare(tx, s.ms, s.ms/1000, s.login, s.id, s.diff, s.window)
+			s.r.writeBestShare(tx, s.login, s.actualDiff)
+			tx.HIncrBy(s.r.formatKey("stats"), "roundShares", s.diff)
+			if s.stale {
+				s.r.countStaleShare(tx, s.login, s.id, s.window)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Lock()
+		b.pending = append(batch, b.pending...)
+		b.trim()
+		b.Unlock()
+		return err
+	}
+	for _, s := range batch {
+		s.r.logEvent(&Event{Timestamp: s.ms, Type: EventShare, Login: s.login, Worker: s.id, Params: s.params,
+			Diff: s.diff, ActualDiff: s.actualDiff, Height: s.height, Window: s.window, Stale: s.stale})
+	}
+	return nil
+}
+
+// Drops oldest shares above limit, so failing redis can't exhaust memory. Must hold lock,
+// drops are logged by failed flush.
+func (b *shareBuffer) trim() {
+	excess := len(b.pending) - b.maxBatch*shareBufferBatches
+	if excess <= 0 {
+		return
+	}
+	b.pending = append([]*bufferedShare(nil), b.pending[excess:]...)
+	b.dropped += int64(excess)
+}
+
+// Writes buffered shares, proxy calls it on shutdown. No-op without share buffer.
+func (r *RedisClient) FlushShares() error {
+	if r.shares == nil {
+		return nil
+	}
+	return r.shares.flush()
+}
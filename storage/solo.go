@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Solo miners don't take part in pool rounds, their shares count for hashrate only
+// and whole reward of their block goes to its finder
+type SoloStorage interface {
+	WriteSoloShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error)
+	WriteSoloStaleShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error)
+	// Inserts block candidate with solver, round of pool stays open
+	WriteSoloBlock(login, id string, params []string, diff, actualDiff, roundDiff int64, height uint64, window time.Duration) (bool, error)
+}
+
+func (r *RedisClient) WriteSoloShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeSoloShare(login, id, params, diff, actualDiff, height, window, false)
+}
+
+func (r *RedisClient) WriteSoloStaleShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error) {
+	return r.writeSoloShare(login, id, params, diff, actualDiff, height, window, true)
+}
+
+func (r *RedisClient) writeSoloShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration, stale bool) (bool, error) {
+	exist, err := r.checkPoWExist(height, params)
+	if err != nil || exist {
+		return exist, err
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ms := r.timestamp()
+	_, err = tx.Exec(func() error {
+		r.writeHashrate(tx, ms, ms/1000, login, id, diff, window)
+		if stale {
+			r.countStaleShare(tx, login, id, window)
+		}
+		return nil
+	})
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventShare, Login: login, Worker: id, Params: params, Diff: diff, ActualDiff: actualDiff,
+			Height: height, Window: window, Solo: true, Stale: stale})
+	}
+	return false, err
+}
+
+// Effort of solo blocks is not tracked, miner has no round of its own
+func (r *RedisClient) WriteSoloBlock(login, id string, params []string, diff, actualDiff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	exist, err := r.checkPoWExist(height, params)
+	if err != nil || exist {
+		return exist, err
+	}
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	ms := r.timestamp()
+	ts := ms / 1000
+	candidate := &BlockData{Nonce: params[0], PowHash: params[1], MixDigest: params[2], Timestamp: ts,
+		Difficulty: roundDiff, Solver: login}
+	_, err = tx.Exec(func() error {
+		r.writeHashrate(tx, ms, ts, login, id, diff, window)
+		tx.ZIncrBy(r.formatKey("finders"), 1, login)
+		tx.HIncrBy(r.formatKey("miners", login), "blocksFound", 1)
+		tx.ZAdd(r.formatKey("blocks", "candidates"), redis.Z{Score: float64(height), Member: candidate.candidateMember()})
+		return nil
+	})
+	if err == nil {
+		r.logEvent(&Event{Timestamp: ms, Type: EventBlock, Login: login, Worker: id, Params: params, Diff: diff, ActualDiff: actualDiff,
+			RoundDiff: roundDiff, Height: height, Window: window, Solo: true})
+	}
+	return false, err
+}
+
+func (p *PostgresClient) WriteSoloShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error) {
+	return p.writeSoloShare(login, id, params, diff, height, false)
+}
+
+func (p *PostgresClient) WriteSoloStaleShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error) {
+	return p.writeSoloShare(login, id, params, diff, height, true)
+}
+
+func (p *PostgresClient) writeSoloShare(login, id string, params []string, diff int64, height uint64, stale bool) (bool, error) {
+	exist, err := p.checkPoWExist(height, params)
+	if err != nil || exist {
+		return exist, err
+	}
+	ts := util.MakeTimestamp() / 1000
+	return false, p.inTx(func(tx *sql.Tx) error {
+		stmts := p.hashrateStmts(ts, login, id, diff)
+		if stale {
+			stmts = append(stmts, p.staleShareStmts(login, id)...)
+		}
+		return execAll(tx, stmts)
+	})
+}
+
+func (p *PostgresClient) WriteSoloBlock(login, id string, params []string, diff, actualDiff, roundDiff int64, height uint64, window time.Duration) (bool, error) {
+	exist, err := p.checkPoWExist(height, params)
+	if err != nil || exist {
+		return exist, err
+	}
+	ts := util.MakeTimestamp() / 1000
+	return false, p.inTx(func(tx *sql.Tx) error {
+		stmts := append(p.hashrateStmts(ts, login, id, diff),
+			pgStmt{`UPDATE miners SET blocks_found = blocks_found + 1 WHERE pool = $1 AND login = $2`,
+				[]interface{}{p.pool, login}},
+			pgStmt{`INSERT INTO blocks (pool, state, height, round_height, nonce, pow_hash, mix_digest, ts, difficulty,
+				total_shares, solver) VALUES ($1, 'candidate', $2, $2, $3, $4, $5, $6, $7, 0, $8)`,
+				[]interface{}{p.pool, height, params[0], params[1], params[2], ts, roundDiff, login}})
+		return execAll(tx, stmts)
+	})
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Accounting storage of pool, redis unless another driver is configured.
+// Redis is still required for sessions, policy, charts and other volatile state.
+type StorageConfig struct {
+	// "redis" or "postgres"
+	Driver   string         `json:"driver"`
+	Postgres PostgresConfig `json:"postgres"`
+}
+
+func (c *StorageConfig) Validate(errs *util.ConfigErrors) {
+	switch c.Driver {
+	case "", "redis":
+	case "postgres":
+		if len(c.Postgres.DSN) == 0 {
+			errs.Addf("storage.postgres.dsn: required for postgres driver")
+		}
+	default:
+		errs.Addf("storage.driver: unknown driver %q", c.Driver)
+	}
+}
+
+// Shares, blocks, balances, payments and stats of pool or tenant
+type Storage interface {
+	ShareStorage
+	BlockStorage
+	BalanceStorage
+	PaymentStorage
+	StatsStorage
+	ParamsStorage
+	RetentionStorage
+	ArchiveStorage
+	PPSStorage
+	SoloStorage
+	PaymentTxStorage
+	DonationStorage
+	// Name of tenant, empty for pool itself
+	Tenant() string
+	// Storage of tenant sharing connections with this one
+	ForTenant(name string) Storage
+}
+
+// Shares are deduplicated by PoW across tenants, duplicates are reported with true
+type ShareStorage interface {
+	WriteShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error)
+	WriteBlock(login, id string, params []string, diff, actualDiff, roundDiff int64, height uint64, window time.Duration) (bool, error)
+	// Credits stale share as regular one and counts it per miner and worker in the same transaction
+	WriteStaleShare(login, id string, params []string, diff, actualDiff int64, height uint64, window time.Duration) (bool, error)
+	WriteReportedHashrate(login, id string, hashrate int64, clientId string, expire time.Duration) error
+	GetRoundShares(height int64, nonce string) (map[string]int64, error)
+}
+
+type BlockStorage interface {
+	GetCandidates(maxHeight int64) ([]*BlockData, error)
+	GetImmatureBlocks(maxHeight int64) ([]*BlockData, error)
+	WriteImmatureBlock(block *BlockData, roundRewards map[string]int64) error
+	WriteMaturedBlock(block *BlockData, roundRewards map[string]int64) error
+	WriteOrphan(block *BlockData) error
+	WritePendingOrphans(blocks []*BlockData) error
+}
+
+type BalanceStorage interface {
+	GetPayees() ([]string, error)
+	GetBalance(login string) (int64, error)
+	UpdateBalance(login string, amount int64) error
+	RollbackBalance(login string, amount int64) error
+	LockPayouts(login string, amount int64) error
+	UnlockPayouts() error
+	IsPayoutsLocked() (bool, error)
+	GetPendingPayments() []*PendingPayment
+}
+
+type PaymentStorage interface {
+	WritePayment(login, txHash string, amount int64) error
+	WriteBatchPayment(login, txHash string, amount int64) error
+	GetAllPayments() ([]*Payment, error)
+	WritePaymentReceipt(receipt *PaymentReceipt) error
+	GetPaymentReceipts(txHashes []string) (map[string]*PaymentReceipt, error)
+	WritePaymentsReport(report *PaymentsReport) error
+	GetPaymentsReport() (*PaymentsReport, error)
+	WriteHeldPayment(login string, amount int64) error
+	DeleteHeldPayment(login string) error
+	GetHeldPayments() ([]*HeldPayment, error)
+}
+
+// Parameters published for pool transparency
+type ParamsStorage interface {
+	WriteUnlockerParams(params *UnlockerParams) error
+	GetUnlockerParams() (*UnlockerParams, error)
+	GetFeeHistory() ([]*FeeChange, error)
+}
+
+type StatsStorage interface {
+	IsMinerExists(login string) (bool, error)
+	GetMinerStats(login string, maxPayments int64) (map[string]interface{}, error)
+	CollectStats(smallWindow time.Duration, maxBlocks, maxPayments int64) (map[string]interface{}, error)
+	CollectWorkersStats(sWindow, lWindow time.Duration, login string) (map[string]interface{}, error)
+	CollectLuckStats(windows []int) (map[string]interface{}, error)
+	GetDailyDifficulty(login string, from, to int64) ([]*DailyDifficulty, error)
+	FlushStaleStats(window, largeWindow time.Duration) (int64, error)
+	WriteJobMetrics(job string, values map[string]float64) error
+	GetJobMetrics() (map[string]map[string]float64, error)
+}
+
+var _ Storage = (*RedisClient)(nil)
+
+func (r *RedisClient) ForTenant(name string) Storage {
+	return r.Namespace(name)
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v3"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+// Admin live tail of login sessions. Logins are kept in sorted set scored by expiry,
+// proxies poll it and publish session traffic of these logins to per-login channel.
+func (r *RedisClient) StartTail(login string, expire time.Duration) error {
+	expireAt := util.MakeTimestamp() + int64(expire/time.Millisecond)
+	return r.client.ZAdd(r.formatRootKey("tails"), redis.Z{Score: float64(expireAt), Member: login}).Err()
+}
+
+// Logins with live tail which hasn't expired yet
+func (r *RedisClient) GetActiveTails() ([]string, error) {
+	key := r.formatRootKey("tails")
+	now := strconv.FormatInt(util.MakeTimestamp(), 10)
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	cmds, err := tx.Exec(func() error {
+		tx.ZRemRangeByScore(key, "-inf", "("+now)
+		tx.ZRange(key, 0, -1)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cmds[1].(*redis.StringSliceCmd).Val(), nil
+}
+
+func (r *RedisClient) PublishTail(login string, data []byte) error {
+	return r.client.Publish(r.formatRootKey("tail", login), string(data)).Err()
+}
+
+func (r *RedisClient) SubscribeTail(login string) (*redis.PubSub, error) {
+	return r.client.Subscribe(r.formatRootKey("tail", login))
+}
@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// Hourly sums of login shares are kept this long if worker history is disabled
+const defaultChartRetention = 30 * 24 * time.Hour
+
+type tsSample struct {
+	ms    int64
+	value int64
+}
+
+type tsSeries struct {
+	labels  map[string]string
+	samples []tsSample
+}
+
+// Point of hourly hashrate chart
+type ChartPoint struct {
+	Timestamp int64 `json:"ts"`
+	Hashrate  int64 `json:"hashrate"`
+}
+
+// Module replies "TSDB: the key does not exist" if loaded, unknown command otherwise
+func (r *RedisClient) detectTimeSeries() bool {
+	cmd := redis.NewCmd("TS.INFO", r.formatRootKey("ts", "probe"))
+	r.client.Process(cmd)
+	err := cmd.Err()
+	return err == nil || strings.Contains(err.Error(), "TSDB")
+}
+
+func (r *RedisClient) chartRetention() time.Duration {
+	if r.history > 0 {
+		return r.history
+	}
+	return defaultChartRetention
+}
+
+func (r *RedisClient) tsCreate(key string, retention time.Duration, labels ...interface{}) {
+	args := []interface{}{"TS.CREATE", key, "RETENTION", int64(retention / time.Millisecond), "DUPLICATE_POLICY", "SUM", "LABELS"}
+	cmd := redis.NewCmd(append(args, labels...)...)
+	r.client.Process(cmd)
+	if err := cmd.Err(); err != nil && !strings.Contains(err.Error(), "already exists") {
+		log.Printf("Failed to create time series %v: %v", key, err)
+	}
+}
+
+// Creates login series with hourly compaction for charts. Series expire with miners
+// which are gone, so it is repeated every hour per login.
+func (r *RedisClient) ensureChartSeries(login string, expire time.Duration) {
+	hour := time.Now().Unix() / 3600
+	if last, ok := r.tsSeries.Load(login); ok && last.(int64) == hour {
+		return
+	}
+	r.tsSeries.Store(login, hour)
+
+	src := r.formatKey("ts", "shares", login)
+	dst := r.formatKey("ts", "hourly", login)
+	r.tsCreate(src, expire, "pool", r.prefix, "kind", "login", "login", login)
+	r.tsCreate(dst, r.chartRetention(), "pool", r.prefix, "kind", "hourly", "login", login)
+	r.client.Expire(dst, r.chartRetention())
+	cmd := redis.NewCmd("TS.CREATERULE", src, dst, "AGGREGATION", "sum", int64(time.Hour/time.Millisecond))
+	r.client.Process(cmd)
+	if err := cmd.Err(); err != nil && !strings.Contains(err.Error(), "already") {
+		log.Printf("Failed to create compaction rule for %v: %v", login, err)
+	}
+}
+
+// Time series counterpart of hashrate sorted sets, samples of the same ms are summed
+func (r *RedisClient) writeShareTS(tx *redis.Multi, ms int64, login, id string, diff int64, expire time.Duration) {
+	r.ensureChartSeries(login, expire)
+	retention := int64(expire / time.Millisecond)
+	key := r.formatKey("ts", "shares", login, id)
+	tx.Process(redis.NewCmd("TS.ADD", key, ms, diff, "RETENTION", retention, "ON_DUPLICATE", "SUM",
+		"LABELS", "pool", r.prefix, "kind", "worker", "login", login, "worker", id))
+	tx.Expire(key, expire)
+	key = r.formatKey("ts", "shares", login)
+	tx.Process(redis.NewCmd("TS.ADD", key, ms, diff, "RETENTION", retention, "ON_DUPLICATE", "SUM"))
+	tx.Expire(key, expire)
+}
+
+// Worker share samples since given time in the same form as hashrate sorted set entries
+func (r *RedisClient) tsShares(from int64, member func(labels map[string]string, diff, ms int64) string, filters ...string) ([]redis.Z, error) {
+	args := []interface{}{"TS.MRANGE", from * 1000, "+", "WITHLABELS", "FILTER", "pool=" + r.prefix, "kind=worker"}
+	for _, f := range filters {
+		args = append(args, f)
+	}
+	cmd := redis.NewCmd(args...)
+	r.client.Process(cmd)
+	if err := cmd.Err(); err != nil {
+		return nil, err
+	}
+	var result []redis.Z
+	for _, series := range parseMRange(cmd.Val()) {
+		for _, s := range series.samples {
+			result = append(result, redis.Z{Score: float64(s.ms / 1000), Member: member(series.labels, s.value, s.ms)})
+		}
+	}
+	return result, nil
+}
+
+func (r *RedisClient) tsPoolShares(from int64) ([]redis.Z, error) {
+	return r.tsShares(from, func(labels map[string]string, diff, ms int64) string {
+		return join(diff, labels["login"], labels["worker"], ms)
+	})
+}
+
+func (r *RedisClient) tsLoginShares(from int64, login string) ([]redis.Z, error) {
+	return r.tsShares(from, func(labels map[string]string, diff, ms int64) string {
+		return join(diff, labels["worker"], ms)
+	}, "login="+login)
+}
+
+// Reply of TS.MRANGE WITHLABELS: [[key, [[name, value]...], [[ms, value]...]]...]
+func parseMRange(reply interface{}) []*tsSeries {
+	rows, _ := reply.([]interface{})
+	result := make([]*tsSeries, 0, len(rows))
+	for _, row := range rows {
+		fields, ok := row.([]interface{})
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		series := &tsSeries{labels: make(map[string]string)}
+		labels, _ := fields[1].([]interface{})
+		for _, l := range labels {
+			if pair, ok := l.([]interface{}); ok && len(pair) == 2 {
+				name, _ := pair[0].(string)
+				value, _ := pair[1].(string)
+				series.labels[name] = value
+			}
+		}
+		series.samples = parseSamples(fields[2])
+		result = append(result, series)
+	}
+	return result
+}
+
+// Values are returned as strings of floats
+func parseSamples(reply interface{}) []tsSample {
+	rows, _ := reply.([]interface{})
+	result := make([]tsSample, 0, len(rows))
+	for _, row := range rows {
+		pair, ok := row.([]interface{})
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		ms, _ := pair[0].(int64)
+		raw, _ := pair[1].(string)
+		value, _ := strconv.ParseFloat(raw, 64)
+		result = append(result, tsSample{ms: ms, value: int64(value)})
+	}
+	return result
+}
+
+// Hourly hashrate of login in [from, to] range, timestamps in seconds. Read from
+// compacted time series if available, from worker history otherwise.
+func (r *RedisClient) GetHashrateChart(login string, from, to int64) ([]*ChartPoint, error) {
+	if !r.timeSeries {
+		rows, err := r.GetWorkerHistory(login, from, to)
+		if err != nil {
+			return nil, err
+		}
+		var result []*ChartPoint
+		for _, row := range rows {
+			if n := len(result); n > 0 && result[n-1].Timestamp == row.Hour {
+				result[n-1].Hashrate += row.Hashrate()
+			} else {
+				result = append(result, &ChartPoint{Timestamp: row.Hour, Hashrate: row.Hashrate()})
+			}
+		}
+		return result, nil
+	}
+
+	cmd := redis.NewCmd("TS.RANGE", r.formatKey("ts", "hourly", login), from*1000, to*1000)
+	r.client.Process(cmd)
+	if err := cmd.Err(); err != nil {
+		if strings.Contains(err.Error(), "does not exist") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	samples := parseSamples(cmd.Val())
+	result := make([]*ChartPoint, len(samples))
+	for i, s := range samples {
+		result[i] = &ChartPoint{Timestamp: s.ms / 1000, Hashrate: s.value / 3600}
+	}
+	return result, nil
+}
+
+func (r *RedisClient) initTimeSeries(enabled bool) {
+	if !enabled {
+		return
+	}
+	if r.detectTimeSeries() {
+		r.timeSeries = true
+		r.tsSeries = &sync.Map{}
+		log.Println("Storing hashrate samples in RedisTimeSeries")
+	} else {
+		log.Println("RedisTimeSeries module is not loaded, hashrate samples stay in sorted sets")
+	}
+}
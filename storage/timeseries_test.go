@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestTimeSeriesFallback(t *testing.T) {
+	c := NewRedisClient(&Config{Endpoint: "127.0.0.1:6379", TimeSeries: true}, prefix)
+	if c.timeSeries {
+		t.Error("Must fall back to sorted sets without module")
+	}
+}
+
+func TestParseMRange(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			"test:ts:shares:0xa:rig1",
+			[]interface{}{
+				[]interface{}{"login", "0xa"},
+				[]interface{}{"worker", "rig1"},
+			},
+			[]interface{}{
+				[]interface{}{int64(1000), "10"},
+				[]interface{}{int64(2000), "20.5"},
+			},
+		},
+		"garbage",
+	}
+	series := parseMRange(reply)
+	if len(series) != 1 {
+		t.Fatalf("Must skip malformed rows, got %v", len(series))
+	}
+	if series[0].labels["login"] != "0xa" || series[0].labels["worker"] != "rig1" {
+		t.Errorf("Must parse labels, got %v", series[0].labels)
+	}
+	samples := series[0].samples
+	if len(samples) != 2 || samples[0] != (tsSample{1000, 10}) || samples[1] != (tsSample{2000, 20}) {
+		t.Errorf("Must parse samples, got %v", samples)
+	}
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"gopkg.in/redis.v3"
+)
+
+// Health of upstream node as seen by proxy instance
+type UpstreamStats struct {
+	Instance  string `json:"instance"`
+	Name      string `json:"name"`
+	Timestamp int64  `json:"ts"`
+	Height    uint64 `json:"height"`
+	// Blocks behind the highest upstream of instance
+	Lag    uint64 `json:"lag"`
+	Calls  int64  `json:"calls"`
+	Errors int64  `json:"errors"`
+	// Failed calls since previous sample
+	ErrorRate float64 `json:"errorRate"`
+	// Latency percentiles of recent calls in ms
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// Latest sample per upstream is kept in hash, samples of last window in sorted sets
+func (r *RedisClient) WriteUpstreamStats(stats []*UpstreamStats, window int64) error {
+	tx := r.client.Multi()
+	defer tx.Close()
+
+	_, err := tx.Exec(func() error {
+		for _, s := range stats {
+			data, _ := json.Marshal(s)
+			key := r.formatRootKey("upstreams", s.Instance, s.Name)
+			tx.HSet(r.formatRootKey("upstreams"), join(s.Instance, s.Name), string(data))
+			tx.ZAdd(key, redis.Z{Score: float64(s.Timestamp), Member: string(data)})
+			tx.ZRemRangeByScore(key, "-inf", "("+strconv.FormatInt(s.Timestamp-window, 10))
+		}
+		return nil
+	})
+	return err
+}
+
+// Latest samples and history since given timestamp keyed by "<instance>:<upstream>"
+func (r *RedisClient) GetUpstreamStats(from int64) ([]*UpstreamStats, map[string][]*UpstreamStats, error) {
+	latest, err := r.client.HGetAllMap(r.formatRootKey("upstreams")).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	stats := make([]*UpstreamStats, 0, len(latest))
+	history := make(map[string][]*UpstreamStats, len(latest))
+	for id, data := range latest {
+		var s UpstreamStats
+		// Upstreams removed from config or of stopped instances drop out after window
+		if json.Unmarshal([]byte(data), &s) != nil || s.Timestamp < from {
+			continue
+		}
+		stats = append(stats, &s)
+
+		key := r.formatRootKey("upstreams", s.Instance, s.Name)
+		rows, err := r.client.ZRangeByScore(key, redis.ZRangeByScore{Min: strconv.FormatInt(from, 10), Max: "+inf"}).Result()
+		if err != nil {
+			return nil, nil, err
+		}
+		samples := make([]*UpstreamStats, 0, len(rows))
+		for _, row := range rows {
+			var sample UpstreamStats
+			if json.Unmarshal([]byte(row), &sample) == nil {
+				samples = append(samples, &sample)
+			}
+		}
+		history[id] = samples
+	}
+	return stats, history, nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// Notifications waiting for delivery are capped, oldest are dropped
+const maxWatchQueue = 10000
+
+// Webhook registered for an address, it doesn't have to mine on pool
+type Watch struct {
+	Url string `json:"url"`
+	// Bearer token to manage watch, also signs deliveries
+	Token     string `json:"token"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
+// Notification about balance change or payment of watched address
+type WatchNotification struct {
+	Timestamp int64  `json:"ts"`
+	Type      string `json:"type"`
+	Login     string `json:"login"`
+	// Balance change in Shannon, sent amount for payment
+	Amount int64  `json:"amount"`
+	TxHash string `json:"txHash,omitempty"`
+	Block  string `json:"block,omitempty"`
+	// Block explorer link of transaction, filled on delivery
+	Url string `json:"url,omitempty"`
+	// Gas used by mined payment and fee in Wei paid by pool for it
+	GasUsed uint64 `json:"gasUsed,omitempty"`
+	Fee     string `json:"fee,omitempty"`
+}
+
+func (r *RedisClient) SetWatch(login string, w *Watch) error {
+	data, _ := json.Marshal(w)
+	return r.client.HSet(r.formatKey("watch"), login, string(data)).Err()
+}
+
+// Returns nil if address is not watched
+func (r *RedisClient) GetWatch(login string) (*Watch, error) {
+	data, err := r.client.HGet(r.formatKey("watch"), login).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var w Watch
+	err = json.Unmarshal([]byte(data), &w)
+	return &w, err
+}
+
+func (r *RedisClient) DeleteWatch(login string) error {
+	return r.client.HDel(r.formatKey("watch"), login).Err()
+}
+
+// Blocks up to timeout for next notification, nil if there is none
+func (r *RedisClient) PopWatchNotification(timeout time.Duration) (*WatchNotification, error) {
+	reply, err := r.client.BRPop(timeout, r.formatKey("watch", "queue")).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var n WatchNotification
+	err = json.Unmarshal([]byte(reply[1]), &n)
+	return &n, err
+}
+
+// Balance changes and payments carried by accounting event
+func watchNotifications(e *Event) []*WatchNotification {
+	var result []*WatchNotification
+	add := func(login string, amount int64) {
+		n := &WatchNotification{Timestamp: e.Timestamp, Type: e.Type, Login: login, Amount: amount, TxHash: e.TxHash}
+		if e.Block != nil {
+			n.Block = e.Block.Hash
+		}
+		result = append(result, n)
+	}
+	switch e.Type {
+	case EventMatured, EventPPSCredit:
+		for login, amount := range e.Rewards {
+			add(login, amount)
+		}
+	case EventPayout:
+		add(e.Login, -e.Amount)
+	case EventRefund, EventAdjustment, EventPayment:
+		add(e.Login, e.Amount)
+	}
+	return result
+}
+
+// Queues notifications for watched addresses, called after accounting write succeeded
+func (r *RedisClient) queueWatchNotifications(e *Event) {
+	if r.replayTs > 0 {
+		return
+	}
+	r.queueWatch(watchNotifications(e))
+}
+
+func (r *RedisClient) queueWatch(notifications []*WatchNotification) {
+	if len(notifications) == 0 {
+		return
+	}
+	logins := make([]string, len(notifications))
+	for i, n := range notifications {
+		logins[i] = n.Login
+	}
+	watched, err := r.client.HMGet(r.formatKey("watch"), logins...).Result()
+	if err != nil {
+		log.Printf("Failed to check watched addresses: %v", err)
+		return
+	}
+	queue := r.formatKey("watch", "queue")
+	for i, n := range notifications {
+		if watched[i] == nil {
+			continue
+		}
+		data, _ := json.Marshal(n)
+		if err := r.client.LPush(queue, string(data)).Err(); err != nil {
+			log.Printf("Failed to queue notification for %v: %v", n.Login, err)
+			return
+		}
+	}
+	r.client.LTrim(queue, 0, maxWatchQueue-1)
+}
@@ -0,0 +1,48 @@
+package util
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{"256": 256, "512B": 512, "64KB": 64 << 10, "10 MB": 10 << 20, "1GiB": 1 << 30, "2k": 2048} {
+		if n, err := ParseSize(s); err != nil || n != expected {
+			t.Errorf("Must parse %q as %v, got %v %v", s, expected, n, err)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1KB", "1.5MB", "10XB", "99999999999GB"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("Must reject %q", s)
+		}
+	}
+}
+
+func TestSizeUnmarshal(t *testing.T) {
+	var v struct {
+		A Size `json:"a"`
+		B Size `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a": 256, "b": "1KB"}`), &v); err != nil || v.A != 256 || v.B != 1024 {
+		t.Errorf("Must accept number and string sizes, got %v %v %v", v.A, v.B, err)
+	}
+	if err := json.Unmarshal([]byte(`{"a": "lots"}`), &v); err == nil {
+		t.Error("Must fail on invalid size")
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	var errs ConfigErrors
+	if errs.Err() != nil {
+		t.Error("Must return nil without errors")
+	}
+	errs.Duration("a", "10s", false)
+	errs.Duration("b", "", true)
+	errs.Duration("c", "", false)
+	errs.Duration("d", "10 parsecs", true)
+	err := errs.Err()
+	if err == nil || len(errs) != 2 || !strings.Contains(err.Error(), "c:") || !strings.Contains(err.Error(), "d:") {
+		t.Errorf("Must collect all invalid durations, got %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 17, 30, 0, time.UTC)
+	for expr, expected := range map[string]time.Time{
+		"0 */6 * * *":        time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC),
+		"*/30 2-3 * * *":     time.Date(2024, 3, 16, 2, 0, 0, 0, time.UTC),
+		"15,45 10 * * *":     time.Date(2024, 3, 15, 10, 45, 0, 0, time.UTC),
+		"0 0 1 * *":          time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+		"0 3 * * 0":          time.Date(2024, 3, 17, 3, 0, 0, 0, time.UTC),
+		"0 3 20 * 1":         time.Date(2024, 3, 18, 3, 0, 0, 0, time.UTC),
+		"0 0 29 2 *":         time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+		"* * * * *":          time.Date(2024, 3, 15, 10, 18, 0, 0, time.UTC),
+		"0 0 30 2 *":         {},
+		"59 23 31 12 *":      time.Date(2024, 12, 31, 23, 59, 0, 0, time.UTC),
+		"0 12-18/3 * * 1-5":  time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC),
+		"0 12-18/3 * * 6,0 ": time.Date(2024, 3, 16, 12, 0, 0, 0, time.UTC),
+	} {
+		c, err := ParseCron(expr)
+		if err != nil {
+			t.Errorf("Must parse %q, got %v", expr, err)
+			continue
+		}
+		if next := c.Next(now); !next.Equal(expected) {
+			t.Errorf("Must schedule %q at %v, got %v", expr, expected, next)
+		}
+	}
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("Must reject %q", expr)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+)
+
+var pow256 = math.BigPow(2, 256)
+
+// Largest 256-bit target, met by any hash
+var MaxTarget = new(big.Int).Sub(pow256, common.Big1)
+
+// Share meets difficulty if its hash is not above 2^256 / difficulty. Difficulty
+// below 1 is treated as 1, target of difficulty 1 is capped to fit 256 bits.
+func DiffToTarget(diff *big.Int) *big.Int {
+	if diff.Cmp(common.Big1) <= 0 {
+		return new(big.Int).Set(MaxTarget)
+	}
+	return new(big.Int).Div(pow256, diff)
+}
+
+// Inverse of DiffToTarget, zero target can't be met and has difficulty 2^256
+func TargetToDiff(target *big.Int) *big.Int {
+	if target.Sign() <= 0 {
+		return new(big.Int).Set(pow256)
+	}
+	return new(big.Int).Div(pow256, target)
+}
+
+// Hex of target padded to 32 bytes, as nodes return it from eth_getWork
+func TargetToHex(target *big.Int) string {
+	return fmt.Sprintf("0x%064x", target)
+}
+
+func GetTargetHex(diff int64) string {
+	return TargetToHex(DiffToTarget(big.NewInt(diff)))
+}
+
+func TargetHexToDiff(targetHex string) *big.Int {
+	return TargetToDiff(new(big.Int).SetBytes(common.FromHex(targetHex)))
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestGetTargetHex(t *testing.T) {
+	cases := map[int64]string{
+		1:          "0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		2:          "0x8000000000000000000000000000000000000000000000000000000000000000",
+		4000000000: "0x0000000112e0be826d694b2e62d01511f12a6061fbaec8bc02357593e70e52ba",
+	}
+	for diff, expected := range cases {
+		if target := GetTargetHex(diff); target != expected {
+			t.Errorf("Must encode target of %v as %v, got %v", diff, expected, target)
+		}
+	}
+	for _, diff := range []int64{0, -5} {
+		if target := GetTargetHex(diff); target != GetTargetHex(1) {
+			t.Errorf("Must treat difficulty %v as 1, got %v", diff, target)
+		}
+	}
+}
+
+func TestTargetRoundTrip(t *testing.T) {
+	for _, diff := range []int64{2, 3, 1000, 4000000000, 1 << 32, 1<<62 + 1, 1<<63 - 1} {
+		target := GetTargetHex(diff)
+		if len(target) != 66 {
+			t.Errorf("Must pad target of %v to 32 bytes, got %v", diff, target)
+		}
+		if d := TargetHexToDiff(target); d.Int64() != diff {
+			t.Errorf("Must decode difficulty %v from its target, got %v", diff, d)
+		}
+	}
+	// Capped target of difficulty 1 decodes to the lowest difficulty above it
+	if d := TargetHexToDiff(GetTargetHex(1)); d.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("Must decode max target as difficulty 1, got %v", d)
+	}
+}
+
+func TestTargetToDiff(t *testing.T) {
+	if d := TargetToDiff(new(big.Int)); d.Cmp(pow256) != 0 {
+		t.Errorf("Must return 2^256 for zero target, got %v", d)
+	}
+	if d := TargetHexToDiff("0x"); d.Cmp(pow256) != 0 {
+		t.Errorf("Must not panic on empty target, got %v", d)
+	}
+	// Hash equal to target meets the difficulty, one above it doesn't
+	for _, diff := range []int64{3, 7, 4000000000} {
+		target := DiffToTarget(big.NewInt(diff))
+		if TargetToDiff(target).Int64() < diff {
+			t.Errorf("Must meet difficulty %v with hash equal to target", diff)
+		}
+		if TargetToDiff(new(big.Int).Add(target, big.NewInt(1))).Int64() >= diff {
+			t.Errorf("Must not meet difficulty %v with hash above target", diff)
+		}
+	}
+	if DiffToTarget(big.NewInt(1)).Cmp(MaxTarget) != 0 {
+		t.Error("Must cap target of difficulty 1 at max target")
+	}
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"crypto/subtle"
+	"strings"
+)
+
+// Canonical forms of miner input are lowercase 0x-prefixed hex of exact length.
+// Handlers must use these instead of own patterns so the same value always
+// maps to the same redis key and duplicate check.
+
+const (
+	AddressLength = 20
+	NonceLength   = 8
+	HashLength    = 32
+)
+
+// Lowercase address, false for malformed or zero address
+func CanonicalAddress(s string) (string, bool) {
+	v, ok := canonicalHex(s, AddressLength)
+	if !ok || IsZeroHash(v) {
+		return "", false
+	}
+	return v, true
+}
+
+// Lowercase 8 byte PoW nonce
+func CanonicalNonce(s string) (string, bool) {
+	return canonicalHex(s, NonceLength)
+}
+
+// Lowercase 32 byte hash, used for header, mix digest and client id
+func CanonicalHash(s string) (string, bool) {
+	return canonicalHex(s, HashLength)
+}
+
+func canonicalHex(s string, size int) (string, bool) {
+	if len(s) != 2+size*2 || s[0] != '0' || (s[1] != 'x' && s[1] != 'X') {
+		return "", false
+	}
+	for i := 2; i < len(s); i++ {
+		if !isHexChar(s[i]) {
+			return "", false
+		}
+	}
+	return "0x" + strings.ToLower(s[2:]), true
+}
+
+func isHexChar(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+// Compares secrets without leaking common prefix length through timing
+func SecureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalAddress(t *testing.T) {
+	v, ok := CanonicalAddress("0xB85150EB365e7DF0941F0CF08235F987BA91506A")
+	if !ok || v != "0xb85150eb365e7df0941f0cf08235f987ba91506a" {
+		t.Errorf("Must lowercase checksummed address, got %v", v)
+	}
+	for _, s := range []string{
+		"",
+		"0x0000000000000000000000000000000000000000",
+		"b85150eb365e7df0941f0cf08235f987ba91506a",
+		"0xb85150eb365e7df0941f0cf08235f987ba91506",
+		"0xb85150eb365e7df0941f0cf08235f987ba91506g",
+		"0xb85150eb365e7df0941f0cf08235f987ba91506a ",
+	} {
+		if _, ok := CanonicalAddress(s); ok {
+			t.Errorf("Must reject %q", s)
+		}
+	}
+}
+
+func TestCanonicalNonceAndHash(t *testing.T) {
+	if v, ok := CanonicalNonce("0x1A2B3C4D5E6F7A8B"); !ok || v != "0x1a2b3c4d5e6f7a8b" {
+		t.Errorf("Must lowercase nonce, got %v", v)
+	}
+	if _, ok := CanonicalNonce("0x1a2b3c4d5e6f7a8"); ok {
+		t.Error("Must reject short nonce")
+	}
+	if _, ok := CanonicalHash("0x" + strings.Repeat("ab", 32)); !ok {
+		t.Error("Must accept 32 byte hash")
+	}
+	if _, ok := CanonicalHash("0x" + strings.Repeat("ab", 31) + "+1"); ok {
+		t.Error("Must reject non hex hash")
+	}
+}
+
+func TestSecureCompare(t *testing.T) {
+	if !SecureCompare("secret", "secret") || SecureCompare("secret", "secreT") || SecureCompare("secret", "secret1") {
+		t.Error("Must compare secrets exactly")
+	}
+}
+
+// Canonical form must be stable, strict and map case variants to one value
+func fuzzCanonical(f *testing.F, size int, canonical func(string) (string, bool)) {
+	f.Add("0x" + strings.Repeat("Ab", size))
+	f.Add("0X" + strings.Repeat("00", size))
+	f.Add("0x" + strings.Repeat("g0", size))
+	f.Add("")
+	f.Fuzz(func(t *testing.T, s string) {
+		v, ok := canonical(s)
+		if !ok {
+			return
+		}
+		if len(v) != 2+size*2 || v != strings.ToLower(v) || !strings.HasPrefix(v, "0x") {
+			t.Fatalf("Non canonical %q from %q", v, s)
+		}
+		if again, ok := canonical(v); !ok || again != v {
+			t.Fatalf("Canonical form %q is not stable", v)
+		}
+		if upper, ok := canonical("0x" + strings.ToUpper(s[2:])); !ok || upper != v {
+			t.Fatalf("Case variant of %q maps to %q", s, upper)
+		}
+	})
+}
+
+func FuzzCanonicalAddress(f *testing.F) { fuzzCanonical(f, AddressLength, CanonicalAddress) }
+func FuzzCanonicalNonce(f *testing.F)   { fuzzCanonical(f, NonceLength, CanonicalNonce) }
+func FuzzCanonicalHash(f *testing.F)    { fuzzCanonical(f, HashLength, CanonicalHash) }
@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	queueSize      = 1000
+	attempts       = 3
+)
+
+// Event types
+const (
+	// Payment transaction accepted by node
+	PaymentSent = "payment.sent"
+	// Payment mined with enough confirmations and recorded as paid
+	PaymentConfirmed = "payment.confirmed"
+	// Payment reverted, dropped or refused by contract, amount is credited back
+	PaymentFailed = "payment.failed"
+	// Payouts stopped on error and need operator
+	PayoutsError = "payouts.error"
+)
+
+var eventTypes = []string{PaymentSent, PaymentConfirmed, PaymentFailed, PayoutsError}
+
+// Events of payouts are POSTed as JSON to every hook subscribed to them
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	Timeout string `json:"timeout"`
+	Hooks   []Hook `json:"hooks"`
+}
+
+type Hook struct {
+	Url string `json:"url"`
+	// Body is signed with "X-Pool-Signature: sha256=<HMAC of body with secret>" header if set
+	Secret string `json:"secret"`
+	// Event types delivered to hook, all if empty
+	Events []string `json:"events"`
+}
+
+func (c *Config) Validate(errs *util.ConfigErrors) {
+	if !c.Enabled {
+		return
+	}
+	if len(c.Hooks) == 0 {
+		errs.Addf("webhooks.hooks: at least one hook is required")
+	}
+	for i, h := range c.Hooks {
+		if u, err := url.Parse(h.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errs.Addf("webhooks.hooks[%v].url: must be http or https url", i)
+		}
+		for _, t := range h.Events {
+			if !knownType(t) {
+				errs.Addf("webhooks.hooks[%v].events: unknown event type %q", i, t)
+			}
+		}
+	}
+	errs.Duration("webhooks.timeout", c.Timeout, true)
+}
+
+func knownType(t string) bool {
+	for _, known := range eventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Hook) subscribed(t string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == t {
+			return true
+		}
+	}
+	return false
+}
+
+type Event struct {
+	Type      string `json:"type"`
+	Timestamp int64  `json:"ts"`
+	// Empty for pool itself
+	Tenant string `json:"tenant,omitempty"`
+	// Miner paid, or multisend contract of batch
+	Login string `json:"login,omitempty"`
+	// In Shannon
+	Amount int64  `json:"amount,omitempty"`
+	TxHash string `json:"txHash,omitempty"`
+	// Miners paid by batch transaction, in Shannon
+	Recipients map[string]int64 `json:"recipients,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+func PaymentEvent(eventType, tenant, login, txHash string, shannon int64) *Event {
+	return &Event{Type: eventType, Tenant: tenant, Login: login, TxHash: txHash, Amount: shannon}
+}
+
+func ErrorEvent(tenant string, err error) *Event {
+	return &Event{Type: PayoutsError, Tenant: tenant, Error: err.Error()}
+}
+
+type Notifier struct {
+	config *Config
+	client *http.Client
+	events chan *Event
+}
+
+var notifier *Notifier
+
+func newNotifier(cfg *Config) *Notifier {
+	timeout := defaultTimeout
+	if len(cfg.Timeout) > 0 {
+		timeout = util.MustParseDuration(cfg.Timeout)
+	}
+	return &Notifier{config: cfg, client: &http.Client{Timeout: timeout}, events: make(chan *Event, queueSize)}
+}
+
+// Starts default notifier used by Send
+func Start(cfg *Config) {
+	n := newNotifier(cfg)
+	go n.run()
+	notifier = n
+	log.Printf("Sending payout events to %v webhooks", len(cfg.Hooks))
+}
+
+// Queues event for delivery, no-op unless notifier is started
+func Send(e *Event) {
+	if notifier != nil {
+		notifier.send(e)
+	}
+}
+
+func (n *Notifier) send(e *Event) {
+	if e.Timestamp == 0 {
+		e.Timestamp = util.MakeTimestamp()
+	}
+	select {
+	case n.events <- e:
+	default:
+		log.Printf("Webhook queue is full, dropping %s event", e.Type)
+	}
+}
+
+func (n *Notifier) run() {
+	for e := range n.events {
+		body, _ := json.Marshal(e)
+		for i := range n.config.Hooks {
+			if h := &n.config.Hooks[i]; h.subscribed(e.Type) {
+				n.deliver(h, e.Type, body)
+			}
+		}
+	}
+}
+
+func (n *Notifier) deliver(h *Hook, eventType string, body []byte) {
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err := n.post(h, body)
+		if err == nil {
+			return
+		}
+		log.Printf("Failed to deliver %s event to webhook %v (attempt %v/%v): %v", eventType, h.Url, attempt, attempts, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (n *Notifier) post(h *Hook, body []byte) error {
+	req, err := http.NewRequest("POST", h.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(h.Secret) > 0 {
+		req.Header.Set("X-Pool-Signature", Signature(h.Secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// Receivers check it against HMAC-SHA256 of raw body with hook secret
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,54 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etclabscore/open-etc-pool/util"
+)
+
+func TestDeliver(t *testing.T) {
+	var signature string
+	var events []*Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var e Event
+		json.Unmarshal(body, &e)
+		events = append(events, &e)
+		if signature = r.Header.Get("X-Pool-Signature"); len(signature) > 0 && signature != Signature("secret", body) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	n := newNotifier(&Config{Hooks: []Hook{
+		{Url: srv.URL, Secret: "secret", Events: []string{PaymentConfirmed}},
+		{Url: srv.URL + "/all"},
+	}})
+	e := PaymentEvent(PaymentConfirmed, "brand", "0xa", "0x1", 100)
+	body, _ := json.Marshal(e)
+	n.deliver(&n.config.Hooks[0], e.Type, body)
+	if len(events) != 1 || events[0].Login != "0xa" || events[0].Amount != 100 || events[0].Tenant != "brand" {
+		t.Fatalf("Must deliver event, got %v", events)
+	}
+	if signature != Signature("secret", body) {
+		t.Errorf("Must sign body with secret, got %v", signature)
+	}
+	if err := n.post(&n.config.Hooks[1], body); err != nil || len(signature) != 0 {
+		t.Errorf("Must not sign without secret, got %q (%v)", signature, err)
+	}
+
+	if n.config.Hooks[0].subscribed(PayoutsError) || !n.config.Hooks[1].subscribed(PayoutsError) {
+		t.Error("Must deliver only subscribed events, all by default")
+	}
+
+	var errs util.ConfigErrors
+	(&Config{Enabled: true}).Validate(&errs)
+	(&Config{Enabled: true, Hooks: []Hook{{Url: "ftp://x", Events: []string{"payment"}}}}).Validate(&errs)
+	if len(errs) != 3 {
+		t.Errorf("Must require hooks with http url and known events, got %v", errs)
+	}
+}